@@ -14,11 +14,14 @@
 package http
 
 import (
+	"context"
+	"crypto/sha256"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"mime"
 	"mime/multipart"
+	"net"
 	"net/http"
 	"net/url"
 	"strconv"
@@ -43,12 +46,32 @@ const (
 	DefaultDownloadLinkExpire = 15 * time.Minute
 
 	DefaultMaxMetaSize = 1024 * 1024 * 10
+
+	// maximum number of log lines included for each device in the report
+	MaxReportLogLines = 100
+
+	// header carrying the number of all items matching the query
+	hdrTotalCount = "X-Total-Count"
+
+	// relation of the link to the last page
+	linkLast = "last"
+
+	// headers of the conditional requests
+	hdrETag        = "ETag"
+	hdrIfNoneMatch = "If-None-Match"
+
+	// header carrying the addresses of the client and the proxies
+	hdrForwardedFor = "X-Forwarded-For"
+
+	// longest time (in seconds) a device may wait for the next deployment
+	MaxNextDeploymentWait = 60
 )
 
 // storage keys
 const (
 	GetDeploymentForDeviceQueryArtifact   = "artifact_name"
 	GetDeploymentForDeviceQueryDeviceType = "device_type"
+	GetDeploymentForDeviceQueryWait       = "wait"
 )
 
 // Errors
@@ -61,6 +84,10 @@ var (
 	ErrDeploymentAlreadyFinished  = errors.New("Deployment already finished")
 	ErrUnexpectedDeploymentStatus = errors.New("Unexpected deployment status")
 	ErrMissingIdentity            = errors.New("Missing identity data")
+	ErrInvalidReportFormat        = errors.New("Invalid report format, supported formats: csv, jsonl")
+	ErrInvalidReportLogLines      = errors.Errorf("Invalid log_lines parameter, must be between 0 and %d", MaxReportLogLines)
+	ErrInvalidLogAppend           = errors.New("Invalid append parameter, must be a boolean")
+	ErrInvalidWait                = errors.Errorf("Invalid wait parameter, must be between 0 and %d", MaxNextDeploymentWait)
 )
 
 type DeploymentsApiHandlers struct {
@@ -99,6 +126,60 @@ func (d *DeploymentsApiHandlers) GetReleases(w rest.ResponseWriter, r *rest.Requ
 	d.view.RenderSuccessGet(w, releases)
 }
 
+// GetInstalledBase counts devices running each release and each artifact
+// of the release
+func (d *DeploymentsApiHandlers) GetInstalledBase(w rest.ResponseWriter, r *rest.Request) {
+	l := requestlog.GetRequestLogger(r)
+
+	releases, err := d.app.GetInstalledBase(r.Context(),
+		r.URL.Query().Get("artifact_name"))
+	if err != nil {
+		d.view.RenderInternalError(w, r, err, l)
+		return
+	}
+
+	d.view.RenderSuccessGet(w, releases)
+}
+
+// GetInstalledBaseDevices lists devices running the artifact
+func (d *DeploymentsApiHandlers) GetInstalledBaseDevices(w rest.ResponseWriter, r *rest.Request) {
+	ctx := r.Context()
+	l := requestlog.GetRequestLogger(r)
+
+	query := model.InstalledBaseQuery{
+		ArtifactName: r.URL.Query().Get("artifact_name"),
+		DeviceType:   r.URL.Query().Get("device_type"),
+	}
+
+	if err := query.Validate(); err != nil {
+		d.view.RenderError(w, r, err, http.StatusBadRequest, l)
+		return
+	}
+
+	page, perPage, err := rest_utils.ParsePagination(r)
+	if err != nil {
+		d.view.RenderError(w, r, err, http.StatusBadRequest, l)
+		return
+	}
+	query.Skip = int((page - 1) * perPage)
+	query.Limit = int(perPage)
+
+	installed, total, err := d.app.GetInstalledArtifacts(ctx, query)
+	if err != nil {
+		d.view.RenderInternalError(w, r, err, l)
+		return
+	}
+
+	hasNext := query.Skip+len(installed) < total
+	links := rest_utils.MakePageLinkHdrs(r, page, perPage, hasNext)
+	for _, l := range links {
+		w.Header().Add("Link", l)
+	}
+	w.Header().Set(hdrTotalCount, strconv.Itoa(total))
+
+	d.view.RenderSuccessGet(w, installed)
+}
+
 type limitResponse struct {
 	Limit uint64 `json:"limit"`
 	Usage uint64 `json:"usage"`
@@ -128,6 +209,47 @@ func (d *DeploymentsApiHandlers) GetLimit(w rest.ResponseWriter, r *rest.Request
 	})
 }
 
+// settings
+
+func (d *DeploymentsApiHandlers) GetSettings(w rest.ResponseWriter, r *rest.Request) {
+	l := requestlog.GetRequestLogger(r)
+
+	settings, err := d.app.GetSettings(r.Context())
+	if err != nil {
+		d.view.RenderInternalError(w, r, err, l)
+		return
+	}
+
+	d.view.RenderSuccessGet(w, settings)
+}
+
+func (d *DeploymentsApiHandlers) PutSettings(w rest.ResponseWriter, r *rest.Request) {
+	l := requestlog.GetRequestLogger(r)
+
+	var settings model.Settings
+	if err := r.DecodeJsonPayload(&settings); err != nil {
+		d.view.RenderError(w, r, errors.Wrap(err, "Validating request body"), http.StatusBadRequest, l)
+		return
+	}
+
+	if err := settings.Validate(); err != nil {
+		d.view.RenderError(w, r, err, http.StatusBadRequest, l)
+		return
+	}
+
+	switch err := d.app.SaveSettings(r.Context(), &settings); err {
+	case nil:
+	case app.ErrRequireApprovalReadOnly:
+		d.view.RenderError(w, r, err, http.StatusForbidden, l)
+		return
+	default:
+		d.view.RenderInternalError(w, r, err, l)
+		return
+	}
+
+	d.view.RenderSuccessPut(w)
+}
+
 // images
 
 func (d *DeploymentsApiHandlers) GetImage(w rest.ResponseWriter, r *rest.Request) {
@@ -398,9 +520,10 @@ func (d *DeploymentsApiHandlers) PostDeployment(w rest.ResponseWriter, r *rest.R
 
 	id, err := d.app.CreateDeployment(ctx, constructor)
 	if err != nil {
-		if err == app.ErrNoArtifact {
+		switch err {
+		case app.ErrNoArtifact, app.ErrDependencyNotFound:
 			d.view.RenderError(w, r, err, http.StatusUnprocessableEntity, l)
-		} else {
+		default:
 			d.view.RenderInternalError(w, r, err, l)
 		}
 		return
@@ -422,6 +545,41 @@ func (d *DeploymentsApiHandlers) getDeploymentConstructorFromBody(r *rest.Reques
 	return constructor, nil
 }
 
+func (d *DeploymentsApiHandlers) AddDevicesToDeployment(w rest.ResponseWriter, r *rest.Request) {
+	ctx := r.Context()
+	l := requestlog.GetRequestLogger(r)
+
+	id := r.PathParam("id")
+
+	if !govalidator.IsUUIDv4(id) {
+		d.view.RenderError(w, r, ErrIDNotUUIDv4, http.StatusBadRequest, l)
+		return
+	}
+
+	var constructor *model.DeploymentDevicesConstructor
+	if err := r.DecodeJsonPayload(&constructor); err != nil {
+		d.view.RenderError(w, r, errors.Wrap(err, "Validating request body"), http.StatusBadRequest, l)
+		return
+	}
+
+	if err := constructor.Validate(); err != nil {
+		d.view.RenderError(w, r, errors.Wrap(err, "Validating request body"), http.StatusBadRequest, l)
+		return
+	}
+
+	err := d.app.AddDevicesToDeployment(ctx, id, constructor.Devices)
+	switch err {
+	case nil:
+		d.view.RenderEmptySuccessResponse(w)
+	case app.ErrModelDeploymentNotFound:
+		d.view.RenderErrorNotFound(w, r, l)
+	case app.ErrDeploymentFinished, app.ErrDeploymentAborted:
+		d.view.RenderError(w, r, err, http.StatusUnprocessableEntity, l)
+	default:
+		d.view.RenderInternalError(w, r, err, l)
+	}
+}
+
 func (d *DeploymentsApiHandlers) GetDeployment(w rest.ResponseWriter, r *rest.Request) {
 	ctx := r.Context()
 	l := requestlog.GetRequestLogger(r)
@@ -447,6 +605,42 @@ func (d *DeploymentsApiHandlers) GetDeployment(w rest.ResponseWriter, r *rest.Re
 	d.view.RenderSuccessGet(w, deployment)
 }
 
+func (d *DeploymentsApiHandlers) EditDeployment(w rest.ResponseWriter, r *rest.Request) {
+	ctx := r.Context()
+	l := requestlog.GetRequestLogger(r)
+
+	id := r.PathParam("id")
+
+	if !govalidator.IsUUIDv4(id) {
+		d.view.RenderError(w, r, ErrIDNotUUIDv4, http.StatusBadRequest, l)
+		return
+	}
+
+	var meta model.DeploymentMetadataConstructor
+	if err := r.DecodeJsonPayload(&meta); err != nil {
+		d.view.RenderError(w, r, errors.Wrap(err, "Validating request body"), http.StatusBadRequest, l)
+		return
+	}
+
+	if err := meta.Validate(); err != nil {
+		d.view.RenderError(w, r, errors.Wrap(err, "Validating request body"), http.StatusBadRequest, l)
+		return
+	}
+
+	found, err := d.app.EditDeployment(ctx, id, &meta)
+	if err != nil {
+		d.view.RenderInternalError(w, r, err, l)
+		return
+	}
+
+	if !found {
+		d.view.RenderErrorNotFound(w, r, l)
+		return
+	}
+
+	d.view.RenderSuccessPut(w)
+}
+
 func (d *DeploymentsApiHandlers) GetDeploymentStats(w rest.ResponseWriter, r *rest.Request) {
 	ctx := r.Context()
 	l := requestlog.GetRequestLogger(r)
@@ -472,6 +666,111 @@ func (d *DeploymentsApiHandlers) GetDeploymentStats(w rest.ResponseWriter, r *re
 	d.view.RenderSuccessGet(w, stats)
 }
 
+func (d *DeploymentsApiHandlers) GetDeploymentReport(w rest.ResponseWriter, r *rest.Request) {
+	ctx := r.Context()
+	l := requestlog.GetRequestLogger(r)
+
+	id := r.PathParam("id")
+
+	if !govalidator.IsUUIDv4(id) {
+		d.view.RenderError(w, r, ErrIDNotUUIDv4, http.StatusBadRequest, l)
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = model.DeploymentReportFormatCSV
+	}
+	if _, ok := reportContentTypes[format]; !ok {
+		d.view.RenderError(w, r, ErrInvalidReportFormat, http.StatusBadRequest, l)
+		return
+	}
+
+	logLines := 0
+	if v := r.URL.Query().Get("log_lines"); v != "" {
+		var err error
+		logLines, err = strconv.Atoi(v)
+		if err != nil || logLines < 0 || logLines > MaxReportLogLines {
+			d.view.RenderError(w, r, ErrInvalidReportLogLines, http.StatusBadRequest, l)
+			return
+		}
+	}
+
+	h, _ := w.(http.ResponseWriter)
+	report := newDeploymentReportWriter(h, format, id, logLines > 0)
+
+	err := d.app.GenerateDeploymentReport(ctx, id, logLines, report.WriteRow)
+	if err != nil {
+		if report.started {
+			// too late to report the error, the report is left truncated
+			l.Errorf("failed to generate report of deployment %s: %v", id, err)
+			return
+		}
+
+		switch err {
+		case app.ErrModelDeploymentNotFound:
+			d.view.RenderErrorNotFound(w, r, l)
+		default:
+			d.view.RenderInternalError(w, r, err, l)
+		}
+		return
+	}
+
+	if err := report.Close(); err != nil {
+		l.Errorf("failed to write report of deployment %s: %v", id, err)
+	}
+}
+
+func (d *DeploymentsApiHandlers) GetDeploymentDurationStats(w rest.ResponseWriter, r *rest.Request) {
+	ctx := r.Context()
+	l := requestlog.GetRequestLogger(r)
+
+	id := r.PathParam("id")
+
+	if !govalidator.IsUUIDv4(id) {
+		d.view.RenderError(w, r, ErrIDNotUUIDv4, http.StatusBadRequest, l)
+		return
+	}
+
+	stats, err := d.app.GetDeploymentDurationStats(ctx, id)
+	if err != nil {
+		d.view.RenderInternalError(w, r, err, l)
+		return
+	}
+
+	if stats == nil {
+		d.view.RenderErrorNotFound(w, r, l)
+		return
+	}
+
+	d.view.RenderSuccessGet(w, stats)
+}
+
+func (d *DeploymentsApiHandlers) GetDeploymentProgressStats(w rest.ResponseWriter, r *rest.Request) {
+	ctx := r.Context()
+	l := requestlog.GetRequestLogger(r)
+
+	id := r.PathParam("id")
+
+	if !govalidator.IsUUIDv4(id) {
+		d.view.RenderError(w, r, ErrIDNotUUIDv4, http.StatusBadRequest, l)
+		return
+	}
+
+	stats, err := d.app.GetDeploymentProgressStats(ctx, id)
+	if err != nil {
+		d.view.RenderInternalError(w, r, err, l)
+		return
+	}
+
+	if stats == nil {
+		d.view.RenderErrorNotFound(w, r, l)
+		return
+	}
+
+	d.view.RenderSuccessGet(w, stats)
+}
+
 func (d *DeploymentsApiHandlers) AbortDeployment(w rest.ResponseWriter, r *rest.Request) {
 	ctx := r.Context()
 	l := requestlog.GetRequestLogger(r)
@@ -519,56 +818,195 @@ func (d *DeploymentsApiHandlers) AbortDeployment(w rest.ResponseWriter, r *rest.
 	d.view.RenderEmptySuccessResponse(w)
 }
 
-func (d *DeploymentsApiHandlers) GetDeploymentForDevice(w rest.ResponseWriter, r *rest.Request) {
+// PutDeploymentApproval approves or rejects the deployment awaiting approval
+// on behalf of the requesting user
+func (d *DeploymentsApiHandlers) PutDeploymentApproval(w rest.ResponseWriter, r *rest.Request) {
 	ctx := r.Context()
 	l := requestlog.GetRequestLogger(r)
 
+	id := r.PathParam("id")
+
+	if !govalidator.IsUUIDv4(id) {
+		d.view.RenderError(w, r, ErrIDNotUUIDv4, http.StatusBadRequest, l)
+		return
+	}
+
 	idata := identity.FromContext(ctx)
 	if idata == nil {
 		d.view.RenderError(w, r, ErrMissingIdentity, http.StatusBadRequest, l)
 		return
 	}
 
-	q := r.URL.Query()
-	installed := model.InstalledDeviceDeployment{
-		Artifact:   q.Get(GetDeploymentForDeviceQueryArtifact),
-		DeviceType: q.Get(GetDeploymentForDeviceQueryDeviceType),
-	}
-
-	if err := installed.Validate(); err != nil {
-		d.view.RenderError(w, r, err, http.StatusBadRequest, l)
+	var decision model.DeploymentApprovalDecision
+	if err := r.DecodeJsonPayload(&decision); err != nil {
+		d.view.RenderError(w, r, errors.Wrap(err, "Validating request body"), http.StatusBadRequest, l)
 		return
 	}
 
-	deployment, err := d.app.GetDeploymentForDeviceWithCurrent(ctx, idata.Subject, installed)
-	if err != nil {
-		d.view.RenderInternalError(w, r, err, l)
+	if err := decision.Validate(); err != nil {
+		d.view.RenderError(w, r, errors.Wrap(err, "Validating request body"), http.StatusBadRequest, l)
 		return
 	}
 
-	if deployment == nil {
-		d.view.RenderNoUpdateForDevice(w)
-		return
-	}
+	l.Infof("Deployment %s %s by %s", id, decision.Status, idata.Subject)
 
-	d.view.RenderSuccessGet(w, deployment)
+	err := d.app.DecideDeploymentApproval(ctx, id, idata.Subject, decision.Status)
+	switch err {
+	case nil:
+		d.view.RenderEmptySuccessResponse(w)
+	case app.ErrModelDeploymentNotFound:
+		d.view.RenderError(w, r, err, http.StatusNotFound, l)
+	case app.ErrNotAwaitingApproval:
+		d.view.RenderError(w, r, err, http.StatusConflict, l)
+	case app.ErrApprovalBySameUser:
+		d.view.RenderError(w, r, err, http.StatusForbidden, l)
+	default:
+		d.view.RenderInternalError(w, r, err, l)
+	}
 }
 
-func (d *DeploymentsApiHandlers) PutDeploymentStatusForDevice(w rest.ResponseWriter, r *rest.Request) {
+func (d *DeploymentsApiHandlers) GetDeploymentForDevice(w rest.ResponseWriter, r *rest.Request) {
 	ctx := r.Context()
 	l := requestlog.GetRequestLogger(r)
 
-	did := r.PathParam("id")
-
 	idata := identity.FromContext(ctx)
 	if idata == nil {
 		d.view.RenderError(w, r, ErrMissingIdentity, http.StatusBadRequest, l)
 		return
 	}
 
-	// receive request body
-	var report model.StatusReport
-
+	q := r.URL.Query()
+	installed := model.InstalledDeviceDeployment{
+		Artifact:   q.Get(GetDeploymentForDeviceQueryArtifact),
+		DeviceType: q.Get(GetDeploymentForDeviceQueryDeviceType),
+		IP:         deviceIP(r),
+	}
+
+	if err := installed.Validate(); err != nil {
+		d.view.RenderError(w, r, err, http.StatusBadRequest, l)
+		return
+	}
+
+	wait := 0
+	if v := q.Get(GetDeploymentForDeviceQueryWait); v != "" {
+		var err error
+		wait, err = strconv.Atoi(v)
+		if err != nil || wait < 0 || wait > MaxNextDeploymentWait {
+			d.view.RenderError(w, r, ErrInvalidWait, http.StatusBadRequest, l)
+			return
+		}
+	}
+
+	// read before the deployments, so that the tag can only be outdated
+	generation, err := d.app.GetDeploymentGeneration(ctx)
+	if err != nil {
+		d.view.RenderInternalError(w, r, err, l)
+		return
+	}
+	etag := deploymentForDeviceETag(generation, idata, installed)
+	notModified := etagMatches(r.Header.Get(hdrIfNoneMatch), etag)
+
+	// nothing changed since the device was told there's no deployment
+	if notModified && wait == 0 {
+		w.Header().Set(hdrETag, etag)
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	// with a matching tag the device reported the same artifact and device
+	// type before, they were recorded then; the device is served even if
+	// its artifact can't be recorded, it reports the artifact again with
+	// the next request
+	if !notModified {
+		if err := d.app.UpdateInstalledArtifact(ctx, idata.Subject, installed); err != nil {
+			l.Errorf("failed to record artifact installed on device %s: %v",
+				idata.Subject, err)
+		}
+	}
+
+	var deployment *model.DeploymentInstructions
+	if wait > 0 {
+		deployment, err = d.app.WaitForDeploymentForDevice(ctx, idata.Subject,
+			installed, time.Duration(wait)*time.Second)
+	} else {
+		deployment, err = d.app.GetDeploymentForDeviceWithCurrent(ctx, idata.Subject, installed)
+	}
+	if err != nil {
+		d.view.RenderInternalError(w, r, err, l)
+		return
+	}
+
+	if deployment == nil {
+		// only the empty response is tagged, the instructions
+		// carry a download link which expires
+		w.Header().Set(hdrETag, etag)
+		if notModified {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		d.view.RenderNoUpdateForDevice(w)
+		return
+	}
+
+	d.view.RenderSuccessGet(w, deployment)
+}
+
+// deploymentForDeviceETag tags the answer to the device with the installed
+// artifact given the generation of deployments
+func deploymentForDeviceETag(generation int64, idata *identity.Identity,
+	installed model.InstalledDeviceDeployment) string {
+
+	h := sha256.New()
+	for _, v := range []string{idata.Tenant, idata.Subject,
+		installed.Artifact, installed.DeviceType} {
+		h.Write([]byte(v))
+		h.Write([]byte{0})
+	}
+
+	return fmt.Sprintf("\"%d-%x\"", generation, h.Sum(nil)[:8])
+}
+
+// deviceIP returns the address the device connects from, the first one
+// of the X-Forwarded-For header if the request went through proxies
+func deviceIP(r *rest.Request) string {
+	if fwd := r.Header.Get(hdrForwardedFor); fwd != "" {
+		return strings.TrimSpace(strings.Split(fwd, ",")[0])
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// etagMatches checks the If-None-Match header value against the entity tag,
+// weak comparison is used
+func etagMatches(header, etag string) bool {
+	for _, v := range strings.Split(header, ",") {
+		v = strings.TrimPrefix(strings.TrimSpace(v), "W/")
+		if v != "" && v == etag {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *DeploymentsApiHandlers) PutDeploymentStatusForDevice(w rest.ResponseWriter, r *rest.Request) {
+	ctx := r.Context()
+	l := requestlog.GetRequestLogger(r)
+
+	did := r.PathParam("id")
+
+	idata := identity.FromContext(ctx)
+	if idata == nil {
+		d.view.RenderError(w, r, ErrMissingIdentity, http.StatusBadRequest, l)
+		return
+	}
+
+	// receive request body
+	var report model.StatusReport
+
 	err := r.DecodeJsonPayload(&report)
 	if err != nil {
 		d.view.RenderError(w, r, err, http.StatusBadRequest, l)
@@ -590,9 +1028,79 @@ func (d *DeploymentsApiHandlers) PutDeploymentStatusForDevice(w rest.ResponseWri
 		return
 	}
 
+	if report.Progress != nil {
+		if err := d.app.UpdateDeviceDeploymentProgress(ctx, did,
+			idata.Subject, *report.Progress); err != nil {
+			d.view.RenderInternalError(w, r, err, l)
+			return
+		}
+	}
+
+	// the device waiting at a pause point learns whether to go on
+	if pausePoint, ok := model.PausePointForStatus(report.Status); ok {
+		cont, err := d.app.IsDeviceDeploymentContinued(ctx, did,
+			idata.Subject, pausePoint)
+		if err != nil {
+			d.view.RenderInternalError(w, r, err, l)
+			return
+		}
+		d.view.RenderSuccessGet(w, model.PauseState{Continue: cont})
+		return
+	}
+
 	d.view.RenderEmptySuccessResponse(w)
 }
 
+func (d *DeploymentsApiHandlers) ContinueDeployment(w rest.ResponseWriter, r *rest.Request) {
+	d.continuePausePoint(w, r, func(ctx context.Context, pausePoint string) error {
+		return d.app.ContinueDeployment(ctx, r.PathParam("id"), pausePoint)
+	})
+}
+
+func (d *DeploymentsApiHandlers) ContinueDeviceDeployment(w rest.ResponseWriter, r *rest.Request) {
+	d.continuePausePoint(w, r, func(ctx context.Context, pausePoint string) error {
+		return d.app.ContinueDeviceDeployment(ctx, r.PathParam("id"),
+			r.PathParam("devid"), pausePoint)
+	})
+}
+
+// continuePausePoint decodes the pause point and renders the outcome
+// of continuing it
+func (d *DeploymentsApiHandlers) continuePausePoint(w rest.ResponseWriter, r *rest.Request,
+	cont func(ctx context.Context, pausePoint string) error) {
+
+	ctx := r.Context()
+	l := requestlog.GetRequestLogger(r)
+
+	if !govalidator.IsUUIDv4(r.PathParam("id")) {
+		d.view.RenderError(w, r, ErrIDNotUUIDv4, http.StatusBadRequest, l)
+		return
+	}
+
+	var continuation model.PauseContinuation
+	if err := r.DecodeJsonPayload(&continuation); err != nil {
+		d.view.RenderError(w, r, errors.Wrap(err, "Validating request body"), http.StatusBadRequest, l)
+		return
+	}
+
+	if err := continuation.Validate(); err != nil {
+		d.view.RenderError(w, r, errors.Wrap(err, "Validating request body"), http.StatusBadRequest, l)
+		return
+	}
+
+	err := cont(ctx, continuation.PausePoint)
+	switch err {
+	case nil:
+		d.view.RenderEmptySuccessResponse(w)
+	case app.ErrModelDeploymentNotFound:
+		d.view.RenderError(w, r, err, http.StatusNotFound, l)
+	case app.ErrDeploymentFinished, app.ErrNotPausing:
+		d.view.RenderError(w, r, err, http.StatusConflict, l)
+	default:
+		d.view.RenderInternalError(w, r, err, l)
+	}
+}
+
 func (d *DeploymentsApiHandlers) GetDeviceStatusesForDeployment(w rest.ResponseWriter, r *rest.Request) {
 	ctx := r.Context()
 	l := requestlog.GetRequestLogger(r)
@@ -604,7 +1112,22 @@ func (d *DeploymentsApiHandlers) GetDeviceStatusesForDeployment(w rest.ResponseW
 		return
 	}
 
-	statuses, err := d.app.GetDeviceStatusesForDeployment(ctx, did)
+	query, err := ParseDeploymentDevicesQuery(r.URL.Query())
+	if err != nil {
+		d.view.RenderError(w, r, err, http.StatusBadRequest, l)
+		return
+	}
+	query.DeploymentID = did
+
+	page, perPage, err := rest_utils.ParsePagination(r)
+	if err != nil {
+		d.view.RenderError(w, r, err, http.StatusBadRequest, l)
+		return
+	}
+	query.Skip = int((page - 1) * perPage)
+	query.Limit = int(perPage)
+
+	statuses, total, err := d.app.GetDeviceStatusesForDeployment(ctx, query)
 	if err != nil {
 		switch err {
 		case app.ErrModelDeploymentNotFound:
@@ -616,9 +1139,72 @@ func (d *DeploymentsApiHandlers) GetDeviceStatusesForDeployment(w rest.ResponseW
 		}
 	}
 
+	hasNext := query.Skip+len(statuses) < total
+	links := rest_utils.MakePageLinkHdrs(r, page, perPage, hasNext)
+	for _, l := range links {
+		w.Header().Add("Link", l)
+	}
+	w.Header().Set(hdrTotalCount, strconv.Itoa(total))
+
 	d.view.RenderSuccessGet(w, statuses)
 }
 
+// ParseDeploymentDevicesQuery parses filters and sorting of the device
+// list of a deployment
+func ParseDeploymentDevicesQuery(vals url.Values) (model.DeploymentDevicesQuery, error) {
+	query := model.DeploymentDevicesQuery{
+		Status:          vals.Get("status"),
+		SubState:        vals.Get("substate"),
+		FailureCategory: vals.Get("failure_category"),
+		DeviceID:        vals.Get("device_id"),
+	}
+
+	if query.Status != "" && !model.IsValidDeviceDeploymentStatus(query.Status) {
+		return query, errors.Errorf("unknown status %s", query.Status)
+	}
+
+	sortBy, desc, err := parseSortParam(vals.Get("sort"),
+		model.DeploymentDevicesSortCreated,
+		model.DeploymentDevicesSortFinished)
+	if err != nil {
+		return query, err
+	}
+	query.SortBy = sortBy
+	query.SortDescending = desc
+
+	return query, nil
+}
+
+// parseSortParam parses sort parameter in the form of <key>[:asc|:desc],
+// the order defaults to ascending
+func parseSortParam(sort string, keys ...string) (string, bool, error) {
+	if sort == "" {
+		return "", false, nil
+	}
+
+	parts := strings.SplitN(sort, ":", 2)
+	key := parts[0]
+
+	desc := false
+	if len(parts) == 2 {
+		switch parts[1] {
+		case "asc":
+		case "desc":
+			desc = true
+		default:
+			return "", false, errors.Errorf("invalid sort order %s", parts[1])
+		}
+	}
+
+	for _, k := range keys {
+		if k == key {
+			return key, desc, nil
+		}
+	}
+
+	return "", false, errors.Errorf("invalid sort key %s", key)
+}
+
 func ParseLookupQuery(vals url.Values) (model.Query, error) {
 	query := model.Query{}
 
@@ -655,6 +1241,14 @@ func ParseLookupQuery(vals url.Values) (model.Query, error) {
 		query.Status = model.StatusQueryPending
 	case "aborted":
 		query.Status = model.StatusQueryAborted
+	case "succeeded":
+		query.Status = model.StatusQuerySucceeded
+	case "failed":
+		query.Status = model.StatusQueryFailed
+	case "partial":
+		query.Status = model.StatusQueryPartial
+	case "awaiting_approval":
+		query.Status = model.StatusQueryAwaitingApproval
 	case "":
 		query.Status = model.StatusQueryAny
 	default:
@@ -662,6 +1256,24 @@ func ParseLookupQuery(vals url.Values) (model.Query, error) {
 
 	}
 
+	for _, q := range vals["label"] {
+		label, err := model.ParseDeploymentLabelQuery(q)
+		if err != nil {
+			return query, errors.Wrapf(err, "invalid label %s", q)
+		}
+		query.Labels = append(query.Labels, label)
+	}
+
+	sortBy, desc, err := parseSortParam(vals.Get("sort"),
+		model.DeploymentsSortCreated,
+		model.DeploymentsSortName,
+		model.DeploymentsSortStatus)
+	if err != nil {
+		return query, err
+	}
+	query.SortBy = sortBy
+	query.SortDescending = desc
+
 	return query, nil
 }
 
@@ -683,33 +1295,42 @@ func (d *DeploymentsApiHandlers) LookupDeployment(w rest.ResponseWriter, r *rest
 		return
 	}
 
-	page, perPage, err := rest_utils.ParsePagination(r)
-	if err != nil {
-		d.view.RenderError(w, r, err, http.StatusBadRequest, l)
-		return
+	// without paging parameters all matching deployments are listed
+	vals := r.URL.Query()
+	paged := vals.Get(rest_utils.PageName) != "" ||
+		vals.Get(rest_utils.PerPageName) != ""
+
+	var page, perPage uint64
+	if paged {
+		page, perPage, err = rest_utils.ParsePagination(r)
+		if err != nil {
+			d.view.RenderError(w, r, err, http.StatusBadRequest, l)
+			return
+		}
+		query.Skip = int((page - 1) * perPage)
+		query.Limit = int(perPage)
 	}
-	query.Skip = int((page - 1) * perPage)
-	query.Limit = int(perPage + 1)
 
-	deps, err := d.app.LookupDeployment(ctx, query)
+	deps, total, err := d.app.LookupDeployment(ctx, query)
 	if err != nil {
 		d.view.RenderError(w, r, err, http.StatusBadRequest, l)
 		return
 	}
 
-	len := len(deps)
-	hasNext := false
-	if uint64(len) > perPage {
-		hasNext = true
-		len = int(perPage)
-	}
-
-	links := rest_utils.MakePageLinkHdrs(r, page, perPage, hasNext)
-	for _, l := range links {
-		w.Header().Add("Link", l)
+	if paged {
+		hasNext := query.Skip+len(deps) < total
+		links := rest_utils.MakePageLinkHdrs(r, page, perPage, hasNext)
+		if total > 0 {
+			lastPage := (uint64(total) + perPage - 1) / perPage
+			links = append(links, rest_utils.MakeLink(linkLast, r, lastPage, perPage))
+		}
+		for _, l := range links {
+			w.Header().Add("Link", l)
+		}
 	}
+	w.Header().Set(hdrTotalCount, strconv.Itoa(total))
 
-	d.view.RenderSuccessGet(w, deps[:len])
+	d.view.RenderSuccessGet(w, deps)
 }
 
 func (d *DeploymentsApiHandlers) PutDeploymentLogForDevice(w rest.ResponseWriter, r *rest.Request) {
@@ -724,6 +1345,19 @@ func (d *DeploymentsApiHandlers) PutDeploymentLogForDevice(w rest.ResponseWriter
 		return
 	}
 
+	// by default the uploaded log replaces the previous one
+	save := d.app.SaveDeviceDeploymentLog
+	if v := r.URL.Query().Get("append"); v != "" {
+		appendLog, err := strconv.ParseBool(v)
+		if err != nil {
+			d.view.RenderError(w, r, ErrInvalidLogAppend, http.StatusBadRequest, l)
+			return
+		}
+		if appendLog {
+			save = d.app.AppendDeviceDeploymentLog
+		}
+	}
+
 	// reuse DeploymentLog, device and deployment IDs are ignored when
 	// (un-)marshalling DeploymentLog to/from JSON
 	var log model.DeploymentLog
@@ -734,8 +1368,7 @@ func (d *DeploymentsApiHandlers) PutDeploymentLogForDevice(w rest.ResponseWriter
 		return
 	}
 
-	if err := d.app.SaveDeviceDeploymentLog(ctx, idata.Subject,
-		did, log.Messages); err != nil {
+	if err := save(ctx, idata.Subject, did, log.Messages); err != nil {
 
 		if err == app.ErrModelDeploymentNotFound {
 			d.view.RenderError(w, r, err, http.StatusNotFound, l)
@@ -755,6 +1388,32 @@ func (d *DeploymentsApiHandlers) GetDeploymentLogForDevice(w rest.ResponseWriter
 	did := r.PathParam("id")
 	devid := r.PathParam("devid")
 
+	vals := r.URL.Query()
+
+	// explicit format takes precedence over the Accept header
+	format := vals.Get("format")
+	switch format {
+	case model.DeploymentLogFormatText,
+		model.DeploymentLogFormatJSON,
+		model.DeploymentLogFormatJSONL:
+	case "":
+		var err error
+		format, err = negotiateDeploymentLogFormat(r.Header.Get("Accept"))
+		if err != nil {
+			d.view.RenderError(w, r, err, http.StatusNotAcceptable, l)
+			return
+		}
+	default:
+		d.view.RenderError(w, r, ErrInvalidLogFormat, http.StatusBadRequest, l)
+		return
+	}
+
+	filter, err := ParseDeploymentLogFilter(vals)
+	if err != nil {
+		d.view.RenderError(w, r, err, http.StatusBadRequest, l)
+		return
+	}
+
 	depl, err := d.app.GetDeviceDeploymentLog(ctx, devid, did)
 
 	if err != nil {
@@ -767,7 +1426,86 @@ func (d *DeploymentsApiHandlers) GetDeploymentLogForDevice(w rest.ResponseWriter
 		return
 	}
 
-	d.view.RenderDeploymentLog(w, *depl)
+	depl.Messages = filter.Apply(depl.Messages)
+
+	d.view.RenderDeploymentLog(w, *depl, format)
+}
+
+func (d *DeploymentsApiHandlers) SearchDeploymentLogs(w rest.ResponseWriter, r *rest.Request) {
+	ctx := r.Context()
+	l := requestlog.GetRequestLogger(r)
+
+	query, err := ParseDeploymentLogQuery(r.URL.Query())
+	if err != nil {
+		d.view.RenderError(w, r, err, http.StatusBadRequest, l)
+		return
+	}
+
+	page, perPage, err := rest_utils.ParsePagination(r)
+	if err != nil {
+		d.view.RenderError(w, r, err, http.StatusBadRequest, l)
+		return
+	}
+	query.Skip = int((page - 1) * perPage)
+	// one extra to see if there's a next page
+	query.Limit = int(perPage) + 1
+
+	matches, err := d.app.SearchDeploymentLogs(ctx, query)
+	if err != nil {
+		if err == app.ErrModelDeploymentNotFound {
+			d.view.RenderError(w, r, err, http.StatusNotFound, l)
+		} else {
+			d.view.RenderInternalError(w, r, err, l)
+		}
+		return
+	}
+
+	hasNext := len(matches) > int(perPage)
+	if hasNext {
+		matches = matches[:perPage]
+	}
+	links := rest_utils.MakePageLinkHdrs(r, page, perPage, hasNext)
+	for _, l := range links {
+		w.Header().Add("Link", l)
+	}
+
+	d.view.RenderSuccessGet(w, matches)
+}
+
+// ParseDeploymentLogQuery parses the search criteria of deployment logs
+func ParseDeploymentLogQuery(vals url.Values) (model.DeploymentLogQuery, error) {
+	query := model.DeploymentLogQuery{
+		DeploymentID: vals.Get("deployment_id"),
+		Text:         vals.Get("q"),
+		Regex:        vals.Get("regex"),
+		Level:        vals.Get("level"),
+	}
+
+	if query.DeploymentID != "" && !govalidator.IsUUIDv4(query.DeploymentID) {
+		return query, ErrIDNotUUIDv4
+	}
+
+	if from := vals.Get("from"); from != "" {
+		fromTime, err := parseEpochToTimestamp(from)
+		if err != nil {
+			return query, errors.Wrap(err, "timestamp parsing failed for from parameter")
+		}
+		query.From = &fromTime
+	}
+
+	if to := vals.Get("to"); to != "" {
+		toTime, err := parseEpochToTimestamp(to)
+		if err != nil {
+			return query, errors.Wrap(err, "timestamp parsing failed for to parameter")
+		}
+		query.To = &toTime
+	}
+
+	if err := query.Validate(); err != nil {
+		return query, err
+	}
+
+	return query, nil
 }
 
 func (d *DeploymentsApiHandlers) DecommissionDevice(w rest.ResponseWriter, r *rest.Request) {
@@ -788,6 +1526,52 @@ func (d *DeploymentsApiHandlers) DecommissionDevice(w rest.ResponseWriter, r *re
 	}
 }
 
+func (d *DeploymentsApiHandlers) GetDeviceDeploymentHistory(w rest.ResponseWriter, r *rest.Request) {
+	ctx := r.Context()
+	l := requestlog.GetRequestLogger(r)
+
+	query := model.DeviceDeploymentsQuery{
+		DeviceID: r.PathParam("id"),
+	}
+
+	for _, status := range r.URL.Query()["status"] {
+		if !model.IsValidDeviceDeploymentStatus(status) {
+			d.view.RenderError(w, r,
+				errors.Errorf("unknown status %s", status), http.StatusBadRequest, l)
+			return
+		}
+		query.Statuses = append(query.Statuses, status)
+	}
+
+	page, perPage, err := rest_utils.ParsePagination(r)
+	if err != nil {
+		d.view.RenderError(w, r, err, http.StatusBadRequest, l)
+		return
+	}
+	query.Skip = int((page - 1) * perPage)
+	query.Limit = int(perPage + 1)
+
+	history, err := d.app.GetDeviceDeploymentHistory(ctx, query)
+	if err != nil {
+		d.view.RenderInternalError(w, r, err, l)
+		return
+	}
+
+	len := len(history)
+	hasNext := false
+	if uint64(len) > perPage {
+		hasNext = true
+		len = int(perPage)
+	}
+
+	links := rest_utils.MakePageLinkHdrs(r, page, perPage, hasNext)
+	for _, l := range links {
+		w.Header().Add("Link", l)
+	}
+
+	d.view.RenderSuccessGet(w, history[:len])
+}
+
 // tenants
 
 func (d *DeploymentsApiHandlers) ProvisionTenantsHandler(w rest.ResponseWriter, r *rest.Request) {
@@ -831,13 +1615,50 @@ func (d *DeploymentsApiHandlers) DeploymentsPerTenantHandler(w rest.ResponseWrit
 	ident := &identity.Identity{Tenant: tenantID}
 	ctx = identity.WithContext(r.Context(), ident)
 
-	if deps, err := d.app.LookupDeployment(ctx, query); err != nil {
+	if deps, _, err := d.app.LookupDeployment(ctx, query); err != nil {
 		rest_utils.RestErrWithLog(w, r, l, err, http.StatusBadRequest)
 	} else {
 		w.WriteJson(deps)
 	}
 }
 
+type requireApprovalReq struct {
+	RequireApproval *bool `json:"require_approval"`
+}
+
+func (d *DeploymentsApiHandlers) PutTenantRequireApprovalHandler(w rest.ResponseWriter, r *rest.Request) {
+	l := requestlog.GetRequestLogger(r)
+
+	tenantID := r.PathParam("tenant")
+
+	if tenantID == "" {
+		rest_utils.RestErrWithLog(w, r, l, fmt.Errorf("missing tenant id in path"), http.StatusBadRequest)
+		return
+	}
+
+	var req requireApprovalReq
+	if err := r.DecodeJsonPayload(&req); err != nil {
+		rest_utils.RestErrWithLog(w, r, l, errors.Wrap(err, "Validating request body"),
+			http.StatusBadRequest)
+		return
+	}
+	if req.RequireApproval == nil {
+		rest_utils.RestErrWithLog(w, r, l, errors.New("require_approval must be provided"),
+			http.StatusBadRequest)
+		return
+	}
+
+	ident := &identity.Identity{Tenant: tenantID}
+	ctx := identity.WithContext(r.Context(), ident)
+
+	if err := d.app.SetRequireApproval(ctx, *req.RequireApproval); err != nil {
+		rest_utils.RestErrWithLogInternal(w, r, l, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
 func (d *DeploymentsApiHandlers) NewImageForTenantHandler(w rest.ResponseWriter, r *rest.Request) {
 	l := requestlog.GetRequestLogger(r)
 
@@ -0,0 +1,1434 @@
+// Copyright 2019 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package http
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ant0ine/go-json-rest/rest"
+	"github.com/ant0ine/go-json-rest/rest/test"
+	"github.com/mendersoftware/go-lib-micro/identity"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/mendersoftware/deployments/app"
+	app_mocks "github.com/mendersoftware/deployments/app/mocks"
+	"github.com/mendersoftware/deployments/model"
+	store_mocks "github.com/mendersoftware/deployments/store/mocks"
+	"github.com/mendersoftware/deployments/utils/restutil/view"
+)
+
+func TestParseDeploymentDevicesQuery(t *testing.T) {
+	testCases := map[string]struct {
+		vals url.Values
+
+		query model.DeploymentDevicesQuery
+		err   error
+	}{
+		"empty": {
+			vals: url.Values{},
+		},
+		"all": {
+			vals: url.Values{
+				"status":           []string{"failure"},
+				"substate":         []string{"timeout"},
+				"failure_category": []string{"disk_full"},
+				"device_id":        []string{"abc"},
+				"sort":             []string{"finished:desc"},
+			},
+			query: model.DeploymentDevicesQuery{
+				Status:          model.DeviceDeploymentStatusFailure,
+				SubState:        "timeout",
+				FailureCategory: model.FailureCategoryDiskFull,
+				DeviceID:        "abc",
+				SortBy:          model.DeploymentDevicesSortFinished,
+				SortDescending:  true,
+			},
+		},
+		"sort, default order": {
+			vals: url.Values{
+				"sort": []string{"created"},
+			},
+			query: model.DeploymentDevicesQuery{
+				SortBy: model.DeploymentDevicesSortCreated,
+			},
+		},
+		"error, status": {
+			vals: url.Values{
+				"status": []string{"inprogress"},
+			},
+			err: errors.New("unknown status inprogress"),
+		},
+		"error, sort key": {
+			vals: url.Values{
+				"sort": []string{"name"},
+			},
+			err: errors.New("invalid sort key name"),
+		},
+		"error, sort order": {
+			vals: url.Values{
+				"sort": []string{"created:up"},
+			},
+			err: errors.New("invalid sort order up"),
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			query, err := ParseDeploymentDevicesQuery(tc.vals)
+			if tc.err != nil {
+				assert.EqualError(t, err, tc.err.Error())
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.query, query)
+			}
+		})
+	}
+}
+
+func TestGetDeviceStatusesForDeployment(t *testing.T) {
+	deploymentID := "a108ae14-bb4e-455f-9b40-2ef4bab97bb7"
+
+	testCases := map[string]struct {
+		params string
+		query  model.DeploymentDevicesQuery
+
+		total int
+		err   error
+
+		code  int
+		count string
+	}{
+		"ok": {
+			params: "?page=2&per_page=10&status=failure",
+			query: model.DeploymentDevicesQuery{
+				DeploymentID: deploymentID,
+				Status:       model.DeviceDeploymentStatusFailure,
+				Skip:         10,
+				Limit:        10,
+			},
+			total: 42,
+			code:  http.StatusOK,
+			count: "42",
+		},
+		"not found": {
+			query: model.DeploymentDevicesQuery{
+				DeploymentID: deploymentID,
+				Limit:        20,
+			},
+			err:  app.ErrModelDeploymentNotFound,
+			code: http.StatusNotFound,
+		},
+		"bad sort": {
+			params: "?sort=foo",
+			code:   http.StatusBadRequest,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			mockApp := &app_mocks.App{}
+			d := NewDeploymentsApiHandlers(&store_mocks.DataStore{}, new(view.RESTView), mockApp)
+
+			api := setUpRestTest("/api/0.0.1/deployments/:id/devices", rest.Get,
+				d.GetDeviceStatusesForDeployment)
+
+			if tc.code != http.StatusBadRequest {
+				mockApp.On("GetDeviceStatusesForDeployment", contextMatcher(), tc.query).
+					Return([]model.DeviceDeployment{}, tc.total, tc.err)
+			}
+
+			recorded := test.RunRequest(t, api.MakeHandler(),
+				test.MakeSimpleRequest("GET",
+					"http://localhost/api/0.0.1/deployments/"+deploymentID+"/devices"+tc.params,
+					nil))
+			recorded.CodeIs(tc.code)
+			if tc.count != "" {
+				recorded.HeaderIs(hdrTotalCount, tc.count)
+			}
+
+			mockApp.AssertExpectations(t)
+		})
+	}
+}
+
+func TestParseLookupQuery(t *testing.T) {
+	testCases := map[string]struct {
+		vals url.Values
+
+		query model.Query
+		err   error
+	}{
+		"empty": {
+			vals: url.Values{},
+			query: model.Query{
+				Status: model.StatusQueryAny,
+			},
+		},
+		"sort by status": {
+			vals: url.Values{
+				"status": []string{"finished"},
+				"sort":   []string{"status:desc"},
+			},
+			query: model.Query{
+				Status:         model.StatusQueryFinished,
+				SortBy:         model.DeploymentsSortStatus,
+				SortDescending: true,
+			},
+		},
+		"finished status": {
+			vals: url.Values{
+				"status": []string{"partial"},
+			},
+			query: model.Query{
+				Status: model.StatusQueryPartial,
+			},
+		},
+		"error, status": {
+			vals: url.Values{
+				"status": []string{"success"},
+			},
+			err: errors.New("unknown status success"),
+		},
+		"labels": {
+			vals: url.Values{
+				"label": []string{"ticket:OPS-123", "team"},
+			},
+			query: model.Query{
+				Status: model.StatusQueryAny,
+				Labels: []model.DeploymentLabel{
+					{Key: "ticket", Value: "OPS-123"},
+					{Key: "team"},
+				},
+			},
+		},
+		"error, label": {
+			vals: url.Values{
+				"label": []string{":OPS-123"},
+			},
+			err: errors.New("invalid label :OPS-123: Invalid label query"),
+		},
+		"sort by name": {
+			vals: url.Values{
+				"sort": []string{"name"},
+			},
+			query: model.Query{
+				Status: model.StatusQueryAny,
+				SortBy: model.DeploymentsSortName,
+			},
+		},
+		"error, sort key": {
+			vals: url.Values{
+				"sort": []string{"finished"},
+			},
+			err: errors.New("invalid sort key finished"),
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			query, err := ParseLookupQuery(tc.vals)
+			if tc.err != nil {
+				assert.EqualError(t, err, tc.err.Error())
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.query, query)
+			}
+		})
+	}
+}
+
+func TestLookupDeployment(t *testing.T) {
+	testCases := map[string]struct {
+		params string
+		query  model.Query
+
+		deployments []*model.Deployment
+		total       int
+
+		code  int
+		links []string
+	}{
+		"ok, first page": {
+			params: "?per_page=1&sort=created:asc",
+			query: model.Query{
+				Status: model.StatusQueryAny,
+				SortBy: model.DeploymentsSortCreated,
+				Limit:  1,
+			},
+			deployments: []*model.Deployment{{}},
+			total:       3,
+			code:        http.StatusOK,
+			links: []string{
+				`<http://localhost/api/0.0.1/deployments?page=2&per_page=1&sort=created%3Aasc>; rel="next"`,
+				`<http://localhost/api/0.0.1/deployments?page=1&per_page=1&sort=created%3Aasc>; rel="first"`,
+				`<http://localhost/api/0.0.1/deployments?page=3&per_page=1&sort=created%3Aasc>; rel="last"`,
+			},
+		},
+		"ok, last page": {
+			params: "?page=3&per_page=1",
+			query: model.Query{
+				Status: model.StatusQueryAny,
+				Skip:   2,
+				Limit:  1,
+			},
+			deployments: []*model.Deployment{{}},
+			total:       3,
+			code:        http.StatusOK,
+			links: []string{
+				`<http://localhost/api/0.0.1/deployments?page=2&per_page=1>; rel="prev"`,
+				`<http://localhost/api/0.0.1/deployments?page=1&per_page=1>; rel="first"`,
+				`<http://localhost/api/0.0.1/deployments?page=3&per_page=1>; rel="last"`,
+			},
+		},
+		"ok, empty": {
+			params: "?page=1",
+			query: model.Query{
+				Status: model.StatusQueryAny,
+				Limit:  20,
+			},
+			deployments: []*model.Deployment{},
+			code:        http.StatusOK,
+			links: []string{
+				`<http://localhost/api/0.0.1/deployments?page=1&per_page=20>; rel="first"`,
+			},
+		},
+		"ok, not paged": {
+			query: model.Query{
+				Status: model.StatusQueryAny,
+			},
+			deployments: []*model.Deployment{{}, {}, {}},
+			total:       3,
+			code:        http.StatusOK,
+		},
+		"bad sort": {
+			params: "?sort=created:up",
+			code:   http.StatusBadRequest,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			mockApp := &app_mocks.App{}
+			d := NewDeploymentsApiHandlers(&store_mocks.DataStore{}, new(view.RESTView), mockApp)
+
+			api := setUpRestTest("/api/0.0.1/deployments", rest.Get, d.LookupDeployment)
+
+			if tc.code != http.StatusBadRequest {
+				mockApp.On("LookupDeployment", contextMatcher(), tc.query).
+					Return(tc.deployments, tc.total, nil)
+			}
+
+			recorded := test.RunRequest(t, api.MakeHandler(),
+				test.MakeSimpleRequest("GET",
+					"http://localhost/api/0.0.1/deployments"+tc.params, nil))
+			recorded.CodeIs(tc.code)
+			if tc.code == http.StatusOK {
+				recorded.HeaderIs(hdrTotalCount, strconv.Itoa(tc.total))
+				assert.Equal(t, tc.links, recorded.Recorder.HeaderMap["Link"])
+			}
+
+			mockApp.AssertExpectations(t)
+		})
+	}
+}
+
+func TestEditDeployment(t *testing.T) {
+	deploymentID := "a108ae14-bb4e-455f-9b40-2ef4bab97bb7"
+
+	meta := &model.DeploymentMetadataConstructor{
+		Description: "monthly update",
+		Labels: []model.DeploymentLabel{
+			{Key: "ticket", Value: "OPS-123"},
+		},
+	}
+
+	testCases := map[string]struct {
+		id   string
+		body interface{}
+
+		found bool
+		err   error
+
+		code int
+	}{
+		"ok": {
+			id:    deploymentID,
+			body:  meta,
+			found: true,
+			code:  http.StatusNoContent,
+		},
+		"not found": {
+			id:   deploymentID,
+			body: meta,
+			code: http.StatusNotFound,
+		},
+		"error, app": {
+			id:   deploymentID,
+			body: meta,
+			err:  errors.New("db error"),
+			code: http.StatusInternalServerError,
+		},
+		"error, invalid id": {
+			id:   "foo",
+			body: meta,
+			code: http.StatusBadRequest,
+		},
+		"error, invalid label": {
+			id: deploymentID,
+			body: map[string]interface{}{
+				"labels": []map[string]string{{"key": "", "value": "OPS-123"}},
+			},
+			code: http.StatusBadRequest,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			mockApp := &app_mocks.App{}
+			d := NewDeploymentsApiHandlers(&store_mocks.DataStore{}, new(view.RESTView), mockApp)
+
+			api := setUpRestTest("/api/0.0.1/deployments/:id", rest.Put, d.EditDeployment)
+
+			if tc.code != http.StatusBadRequest {
+				mockApp.On("EditDeployment", contextMatcher(), tc.id, meta).
+					Return(tc.found, tc.err)
+			}
+
+			recorded := test.RunRequest(t, api.MakeHandler(),
+				test.MakeSimpleRequest("PUT",
+					"http://localhost/api/0.0.1/deployments/"+tc.id, tc.body))
+			recorded.CodeIs(tc.code)
+
+			mockApp.AssertExpectations(t)
+		})
+	}
+}
+
+func TestPutSettings(t *testing.T) {
+	settings := &model.Settings{
+		Retention: model.RetentionSettings{LogDays: 30},
+	}
+
+	testCases := map[string]struct {
+		body interface{}
+		err  error
+
+		code int
+	}{
+		"ok": {
+			body: settings,
+			code: http.StatusNoContent,
+		},
+		"error, approval requirement changed": {
+			body: settings,
+			err:  app.ErrRequireApprovalReadOnly,
+			code: http.StatusForbidden,
+		},
+		"error, app": {
+			body: settings,
+			err:  errors.New("db error"),
+			code: http.StatusInternalServerError,
+		},
+		"error, invalid retention": {
+			body: &model.Settings{
+				Retention: model.RetentionSettings{LogDays: -1},
+			},
+			code: http.StatusBadRequest,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			mockApp := &app_mocks.App{}
+			d := NewDeploymentsApiHandlers(&store_mocks.DataStore{}, new(view.RESTView), mockApp)
+
+			api := setUpRestTest("/api/0.0.1/settings", rest.Put, d.PutSettings)
+
+			if tc.code != http.StatusBadRequest {
+				mockApp.On("SaveSettings", contextMatcher(), settings).
+					Return(tc.err)
+			}
+
+			recorded := test.RunRequest(t, api.MakeHandler(),
+				test.MakeSimpleRequest("PUT", "http://localhost/api/0.0.1/settings", tc.body))
+			recorded.CodeIs(tc.code)
+
+			mockApp.AssertExpectations(t)
+		})
+	}
+}
+
+func TestPutTenantRequireApproval(t *testing.T) {
+	testCases := map[string]struct {
+		body interface{}
+
+		callApp bool
+		err     error
+
+		code int
+	}{
+		"ok": {
+			body:    map[string]bool{"require_approval": true},
+			callApp: true,
+			code:    http.StatusNoContent,
+		},
+		"error, app": {
+			body:    map[string]bool{"require_approval": true},
+			callApp: true,
+			err:     errors.New("db error"),
+			code:    http.StatusInternalServerError,
+		},
+		"error, missing value": {
+			body: map[string]string{},
+			code: http.StatusBadRequest,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			mockApp := &app_mocks.App{}
+			d := NewDeploymentsApiHandlers(&store_mocks.DataStore{}, new(view.RESTView), mockApp)
+
+			api := setUpRestTest("/api/internal/v1/deployments/tenants/:tenant/settings/require_approval",
+				rest.Put, d.PutTenantRequireApprovalHandler)
+
+			if tc.callApp {
+				mockApp.On("SetRequireApproval",
+					mock.MatchedBy(func(ctx context.Context) bool {
+						id := identity.FromContext(ctx)
+						return id != nil && id.Tenant == "tenant-1"
+					}), true).
+					Return(tc.err)
+			}
+
+			recorded := test.RunRequest(t, api.MakeHandler(),
+				test.MakeSimpleRequest("PUT",
+					"http://localhost/api/internal/v1/deployments/tenants/tenant-1/settings/require_approval",
+					tc.body))
+			recorded.CodeIs(tc.code)
+
+			mockApp.AssertExpectations(t)
+		})
+	}
+}
+
+func TestPutDeploymentApproval(t *testing.T) {
+	deploymentID := "a108ae14-bb4e-455f-9b40-2ef4bab97bb7"
+
+	testCases := map[string]struct {
+		identity *identity.Identity
+		body     interface{}
+
+		callApp bool
+		err     error
+
+		code int
+	}{
+		"approved": {
+			identity: &identity.Identity{Subject: "user-2"},
+			body:     map[string]string{"status": "approved"},
+			callApp:  true,
+			code:     http.StatusNoContent,
+		},
+		"rejected": {
+			identity: &identity.Identity{Subject: "user-2"},
+			body:     map[string]string{"status": "rejected"},
+			callApp:  true,
+			code:     http.StatusNoContent,
+		},
+		"error, invalid status": {
+			identity: &identity.Identity{Subject: "user-2"},
+			body:     map[string]string{"status": "awaiting_approval"},
+			code:     http.StatusBadRequest,
+		},
+		"error, no identity": {
+			body: map[string]string{"status": "approved"},
+			code: http.StatusBadRequest,
+		},
+		"error, not found": {
+			identity: &identity.Identity{Subject: "user-2"},
+			body:     map[string]string{"status": "approved"},
+			callApp:  true,
+			err:      app.ErrModelDeploymentNotFound,
+			code:     http.StatusNotFound,
+		},
+		"error, same user": {
+			identity: &identity.Identity{Subject: "user-2"},
+			body:     map[string]string{"status": "approved"},
+			callApp:  true,
+			err:      app.ErrApprovalBySameUser,
+			code:     http.StatusForbidden,
+		},
+		"error, not awaiting approval": {
+			identity: &identity.Identity{Subject: "user-2"},
+			body:     map[string]string{"status": "approved"},
+			callApp:  true,
+			err:      app.ErrNotAwaitingApproval,
+			code:     http.StatusConflict,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			mockApp := &app_mocks.App{}
+			d := NewDeploymentsApiHandlers(&store_mocks.DataStore{}, new(view.RESTView), mockApp)
+
+			api := setUpRestTest("/api/0.0.1/deployments/:id/approval", rest.Put,
+				d.PutDeploymentApproval)
+			if tc.identity != nil {
+				api.Use(rest.MiddlewareSimple(func(h rest.HandlerFunc) rest.HandlerFunc {
+					return func(w rest.ResponseWriter, r *rest.Request) {
+						r.Request = r.WithContext(identity.WithContext(r.Context(), tc.identity))
+						h(w, r)
+					}
+				}))
+			}
+
+			if tc.callApp {
+				mockApp.On("DecideDeploymentApproval", contextMatcher(), deploymentID,
+					tc.identity.Subject, tc.body.(map[string]string)["status"]).
+					Return(tc.err)
+			}
+
+			recorded := test.RunRequest(t, api.MakeHandler(),
+				test.MakeSimpleRequest("PUT",
+					"http://localhost/api/0.0.1/deployments/"+deploymentID+"/approval",
+					tc.body))
+			recorded.CodeIs(tc.code)
+
+			mockApp.AssertExpectations(t)
+		})
+	}
+}
+
+func TestContinueDeployment(t *testing.T) {
+	deploymentID := "a108ae14-bb4e-455f-9b40-2ef4bab97bb7"
+
+	testCases := map[string]struct {
+		deploymentID string
+		deviceID     string
+		body         interface{}
+
+		callApp bool
+		err     error
+
+		code int
+	}{
+		"ok": {
+			deploymentID: deploymentID,
+			body:         map[string]string{"pause_point": "reboot"},
+			callApp:      true,
+			code:         http.StatusNoContent,
+		},
+		"ok, device": {
+			deploymentID: deploymentID,
+			deviceID:     "device-1",
+			body:         map[string]string{"pause_point": "commit"},
+			callApp:      true,
+			code:         http.StatusNoContent,
+		},
+		"error, invalid id": {
+			deploymentID: "foo",
+			body:         map[string]string{"pause_point": "reboot"},
+			code:         http.StatusBadRequest,
+		},
+		"error, invalid pause point": {
+			deploymentID: deploymentID,
+			body:         map[string]string{"pause_point": "download"},
+			code:         http.StatusBadRequest,
+		},
+		"error, not found": {
+			deploymentID: deploymentID,
+			deviceID:     "device-1",
+			body:         map[string]string{"pause_point": "reboot"},
+			callApp:      true,
+			err:          app.ErrModelDeploymentNotFound,
+			code:         http.StatusNotFound,
+		},
+		"error, not pausing": {
+			deploymentID: deploymentID,
+			body:         map[string]string{"pause_point": "install"},
+			callApp:      true,
+			err:          app.ErrNotPausing,
+			code:         http.StatusConflict,
+		},
+		"error, finished": {
+			deploymentID: deploymentID,
+			body:         map[string]string{"pause_point": "install"},
+			callApp:      true,
+			err:          app.ErrDeploymentFinished,
+			code:         http.StatusConflict,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			mockApp := &app_mocks.App{}
+			d := NewDeploymentsApiHandlers(&store_mocks.DataStore{}, new(view.RESTView), mockApp)
+
+			url := "http://localhost/api/0.0.1/deployments/" + tc.deploymentID
+			var api *rest.Api
+			if tc.deviceID != "" {
+				api = setUpRestTest("/api/0.0.1/deployments/:id/devices/:devid/continue",
+					rest.Post, d.ContinueDeviceDeployment)
+				url += "/devices/" + tc.deviceID + "/continue"
+			} else {
+				api = setUpRestTest("/api/0.0.1/deployments/:id/continue",
+					rest.Post, d.ContinueDeployment)
+				url += "/continue"
+			}
+
+			if tc.callApp {
+				pausePoint := tc.body.(map[string]string)["pause_point"]
+				if tc.deviceID != "" {
+					mockApp.On("ContinueDeviceDeployment", contextMatcher(),
+						tc.deploymentID, tc.deviceID, pausePoint).Return(tc.err)
+				} else {
+					mockApp.On("ContinueDeployment", contextMatcher(),
+						tc.deploymentID, pausePoint).Return(tc.err)
+				}
+			}
+
+			recorded := test.RunRequest(t, api.MakeHandler(),
+				test.MakeSimpleRequest("POST", url, tc.body))
+			recorded.CodeIs(tc.code)
+
+			mockApp.AssertExpectations(t)
+		})
+	}
+}
+
+func TestPutDeploymentStatusForDevicePaused(t *testing.T) {
+	deploymentID := "a108ae14-bb4e-455f-9b40-2ef4bab97bb7"
+
+	testCases := map[string]struct {
+		status string
+
+		pausePoint string
+		continued  bool
+
+		code int
+		body string
+	}{
+		"waiting": {
+			status:     "pause-before-rebooting",
+			pausePoint: "reboot",
+			code:       http.StatusOK,
+			body:       `{"continue":false}`,
+		},
+		"continued": {
+			status:     "pause-before-committing",
+			pausePoint: "commit",
+			continued:  true,
+			code:       http.StatusOK,
+			body:       `{"continue":true}`,
+		},
+		"not paused": {
+			status: "rebooting",
+			code:   http.StatusNoContent,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			mockApp := &app_mocks.App{}
+			d := NewDeploymentsApiHandlers(&store_mocks.DataStore{}, new(view.RESTView), mockApp)
+
+			api := setUpRestTest("/api/0.0.1/device/deployments/:id/status", rest.Put,
+				d.PutDeploymentStatusForDevice)
+			api.Use(rest.MiddlewareSimple(func(h rest.HandlerFunc) rest.HandlerFunc {
+				return func(w rest.ResponseWriter, r *rest.Request) {
+					r.Request = r.WithContext(identity.WithContext(r.Context(),
+						&identity.Identity{Subject: "device-1", IsDevice: true}))
+					h(w, r)
+				}
+			}))
+
+			mockApp.On("UpdateDeviceDeploymentStatus", contextMatcher(),
+				deploymentID, "device-1",
+				mock.MatchedBy(func(s model.DeviceDeploymentStatus) bool {
+					return s.Status == tc.status
+				})).Return(nil)
+			if tc.pausePoint != "" {
+				mockApp.On("IsDeviceDeploymentContinued", contextMatcher(),
+					deploymentID, "device-1", tc.pausePoint).Return(tc.continued, nil)
+			}
+
+			recorded := test.RunRequest(t, api.MakeHandler(),
+				test.MakeSimpleRequest("PUT",
+					"http://localhost/api/0.0.1/device/deployments/"+deploymentID+"/status",
+					map[string]string{"status": tc.status}))
+			recorded.CodeIs(tc.code)
+			if tc.body != "" {
+				recorded.BodyIs(tc.body)
+			}
+
+			mockApp.AssertExpectations(t)
+		})
+	}
+}
+
+func TestGetDeploymentForDevice(t *testing.T) {
+	installed := model.InstalledDeviceDeployment{
+		Artifact:   "foo",
+		DeviceType: "hammer",
+	}
+	idata := &identity.Identity{Subject: "device-1", IsDevice: true}
+	etag := deploymentForDeviceETag(7, idata, installed)
+
+	testCases := map[string]struct {
+		wait        string
+		ifNoneMatch string
+
+		appMethod string
+		appWait   time.Duration
+
+		// error recording the installed artifact
+		recordErr error
+		// the installed artifact is not recorded again
+		notRecorded bool
+
+		code int
+		etag string
+	}{
+		"ok, no wait": {
+			appMethod: "GetDeploymentForDeviceWithCurrent",
+			code:      http.StatusNoContent,
+			etag:      etag,
+		},
+		"ok, installed artifact not recorded": {
+			appMethod: "GetDeploymentForDeviceWithCurrent",
+			recordErr: errors.New("db error"),
+			code:      http.StatusNoContent,
+			etag:      etag,
+		},
+		"ok, zero wait": {
+			wait:      "0",
+			appMethod: "GetDeploymentForDeviceWithCurrent",
+			code:      http.StatusNoContent,
+			etag:      etag,
+		},
+		"ok, wait": {
+			wait:      "30",
+			appMethod: "WaitForDeploymentForDevice",
+			appWait:   30 * time.Second,
+			code:      http.StatusNoContent,
+			etag:      etag,
+		},
+		"ok, not modified": {
+			notRecorded: true,
+			ifNoneMatch: etag,
+			code:        http.StatusNotModified,
+			etag:        etag,
+		},
+		"ok, not modified, weak tag in list": {
+			notRecorded: true,
+			ifNoneMatch: `"0-abc", W/` + etag,
+			code:        http.StatusNotModified,
+			etag:        etag,
+		},
+		"ok, not modified, wait": {
+			notRecorded: true,
+			wait:        "30",
+			ifNoneMatch: etag,
+			appMethod:   "WaitForDeploymentForDevice",
+			appWait:     30 * time.Second,
+			code:        http.StatusNotModified,
+			etag:        etag,
+		},
+		"ok, outdated tag": {
+			ifNoneMatch: deploymentForDeviceETag(6, idata, installed),
+			appMethod:   "GetDeploymentForDeviceWithCurrent",
+			code:        http.StatusNoContent,
+			etag:        etag,
+		},
+		"error, invalid wait": {
+			wait: "soon",
+			code: http.StatusBadRequest,
+		},
+		"error, wait too long": {
+			wait: "61",
+			code: http.StatusBadRequest,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			mockApp := &app_mocks.App{}
+			d := NewDeploymentsApiHandlers(&store_mocks.DataStore{}, new(view.RESTView), mockApp)
+
+			api := setUpRestTest("/api/0.0.1/device/deployments/next", rest.Get,
+				d.GetDeploymentForDevice)
+			api.Use(rest.MiddlewareSimple(func(h rest.HandlerFunc) rest.HandlerFunc {
+				return func(w rest.ResponseWriter, r *rest.Request) {
+					r.Request = r.WithContext(identity.WithContext(r.Context(), idata))
+					h(w, r)
+				}
+			}))
+
+			if tc.code != http.StatusBadRequest {
+				mockApp.On("GetDeploymentGeneration", contextMatcher()).
+					Return(int64(7), nil)
+			}
+			if tc.code != http.StatusBadRequest && !tc.notRecorded {
+				mockApp.On("UpdateInstalledArtifact", contextMatcher(),
+					"device-1", installed).Return(tc.recordErr)
+			}
+
+			switch tc.appMethod {
+			case "GetDeploymentForDeviceWithCurrent":
+				mockApp.On(tc.appMethod, contextMatcher(), "device-1", installed).
+					Return(nil, nil)
+			case "WaitForDeploymentForDevice":
+				mockApp.On(tc.appMethod, contextMatcher(), "device-1", installed,
+					tc.appWait).Return(nil, nil)
+			}
+
+			url := "http://localhost/api/0.0.1/device/deployments/next" +
+				"?artifact_name=foo&device_type=hammer"
+			if tc.wait != "" {
+				url += "&wait=" + tc.wait
+			}
+			req := test.MakeSimpleRequest("GET", url, nil)
+			if tc.ifNoneMatch != "" {
+				req.Header.Set("If-None-Match", tc.ifNoneMatch)
+			}
+			recorded := test.RunRequest(t, api.MakeHandler(), req)
+			recorded.CodeIs(tc.code)
+			assert.Equal(t, tc.etag, recorded.Recorder.Header().Get("ETag"))
+
+			mockApp.AssertExpectations(t)
+		})
+	}
+}
+
+func TestDeviceIP(t *testing.T) {
+	testCases := map[string]struct {
+		remoteAddr   string
+		forwardedFor string
+
+		ip string
+	}{
+		"remote address": {
+			remoteAddr: "10.1.2.3:52000",
+			ip:         "10.1.2.3",
+		},
+		"remote address, ipv6": {
+			remoteAddr: "[fd00::1]:52000",
+			ip:         "fd00::1",
+		},
+		"forwarded": {
+			remoteAddr:   "172.17.0.5:52000",
+			forwardedFor: "10.1.2.3, 172.17.0.4",
+			ip:           "10.1.2.3",
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			req := test.MakeSimpleRequest("GET", "http://localhost/", nil)
+			req.RemoteAddr = tc.remoteAddr
+			if tc.forwardedFor != "" {
+				req.Header.Set("X-Forwarded-For", tc.forwardedFor)
+			}
+
+			assert.Equal(t, tc.ip, deviceIP(&rest.Request{Request: req}))
+		})
+	}
+}
+
+func TestPutDeploymentLogForDevice(t *testing.T) {
+	deploymentID := "a108ae14-bb4e-455f-9b40-2ef4bab97bb7"
+	now := time.Now().UTC().Round(time.Second)
+	messages := []model.LogMessage{
+		{Timestamp: &now, Level: "info", Message: "foo"},
+	}
+
+	testCases := map[string]struct {
+		append string
+
+		appMethod string
+		err       error
+
+		code int
+	}{
+		"ok, replace": {
+			appMethod: "SaveDeviceDeploymentLog",
+			code:      http.StatusNoContent,
+		},
+		"ok, explicit replace": {
+			append:    "false",
+			appMethod: "SaveDeviceDeploymentLog",
+			code:      http.StatusNoContent,
+		},
+		"ok, append": {
+			append:    "true",
+			appMethod: "AppendDeviceDeploymentLog",
+			code:      http.StatusNoContent,
+		},
+		"error, invalid append": {
+			append: "sometimes",
+			code:   http.StatusBadRequest,
+		},
+		"error, deployment not found": {
+			append:    "true",
+			appMethod: "AppendDeviceDeploymentLog",
+			err:       app.ErrModelDeploymentNotFound,
+			code:      http.StatusNotFound,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			mockApp := &app_mocks.App{}
+			d := NewDeploymentsApiHandlers(&store_mocks.DataStore{}, new(view.RESTView), mockApp)
+
+			api := setUpRestTest("/api/0.0.1/device/deployments/:id/log", rest.Put,
+				d.PutDeploymentLogForDevice)
+			api.Use(rest.MiddlewareSimple(func(h rest.HandlerFunc) rest.HandlerFunc {
+				return func(w rest.ResponseWriter, r *rest.Request) {
+					r.Request = r.WithContext(identity.WithContext(r.Context(),
+						&identity.Identity{Subject: "device-1", IsDevice: true}))
+					h(w, r)
+				}
+			}))
+
+			if tc.appMethod != "" {
+				mockApp.On(tc.appMethod, contextMatcher(), "device-1", deploymentID,
+					mock.AnythingOfType("[]model.LogMessage")).
+					Return(tc.err)
+			}
+
+			url := "http://localhost/api/0.0.1/device/deployments/" + deploymentID + "/log"
+			if tc.append != "" {
+				url += "?append=" + tc.append
+			}
+			recorded := test.RunRequest(t, api.MakeHandler(),
+				test.MakeSimpleRequest("PUT", url,
+					map[string]interface{}{"messages": messages}))
+			recorded.CodeIs(tc.code)
+
+			mockApp.AssertExpectations(t)
+		})
+	}
+}
+
+func TestSearchDeploymentLogs(t *testing.T) {
+	deploymentID := "a108ae14-bb4e-455f-9b40-2ef4bab97bb7"
+	now := time.Unix(1546300800, 0).UTC()
+
+	match := model.DeploymentLogMatch{
+		DeviceID:     "device-1",
+		DeploymentID: deploymentID,
+		Messages: []model.LogMessage{
+			{Timestamp: &now, Level: "error", Message: "no space left"},
+		},
+	}
+
+	testCases := map[string]struct {
+		params string
+
+		query   *model.DeploymentLogQuery
+		matches []model.DeploymentLogMatch
+		err     error
+
+		code int
+		next bool
+	}{
+		"ok, deployment": {
+			params: "deployment_id=" + deploymentID + "&q=space&level=error&per_page=1",
+			query: &model.DeploymentLogQuery{
+				DeploymentID: deploymentID,
+				Text:         "space",
+				Level:        "error",
+				Limit:        2,
+			},
+			matches: []model.DeploymentLogMatch{match},
+			code:    http.StatusOK,
+		},
+		"ok, time range, next page": {
+			params: "from=1546300800&regex=^no&per_page=1",
+			query: &model.DeploymentLogQuery{
+				Regex: "^no",
+				From:  &now,
+				Limit: 2,
+			},
+			matches: []model.DeploymentLogMatch{match, match},
+			code:    http.StatusOK,
+			next:    true,
+		},
+		"error, no scope": {
+			params: "q=space",
+			code:   http.StatusBadRequest,
+		},
+		"error, invalid deployment ID": {
+			params: "deployment_id=foo",
+			code:   http.StatusBadRequest,
+		},
+		"error, invalid time": {
+			params: "from=yesterday",
+			code:   http.StatusBadRequest,
+		},
+		"error, invalid regex": {
+			params: "from=1546300800&regex=(",
+			code:   http.StatusBadRequest,
+		},
+		"error, deployment not found": {
+			params: "deployment_id=" + deploymentID,
+			query: &model.DeploymentLogQuery{
+				DeploymentID: deploymentID,
+				Limit:        21,
+			},
+			err:  app.ErrModelDeploymentNotFound,
+			code: http.StatusNotFound,
+		},
+		"error, internal": {
+			params: "deployment_id=" + deploymentID,
+			query: &model.DeploymentLogQuery{
+				DeploymentID: deploymentID,
+				Limit:        21,
+			},
+			err:  errors.New("db failed"),
+			code: http.StatusInternalServerError,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			mockApp := &app_mocks.App{}
+			d := NewDeploymentsApiHandlers(&store_mocks.DataStore{}, new(view.RESTView), mockApp)
+
+			api := setUpRestTest("/api/0.0.1/deployments/logs", rest.Get,
+				d.SearchDeploymentLogs)
+
+			if tc.query != nil {
+				mockApp.On("SearchDeploymentLogs", contextMatcher(), *tc.query).
+					Return(tc.matches, tc.err)
+			}
+
+			recorded := test.RunRequest(t, api.MakeHandler(),
+				test.MakeSimpleRequest("GET",
+					"http://localhost/api/0.0.1/deployments/logs?"+tc.params, nil))
+			recorded.CodeIs(tc.code)
+
+			if tc.code == http.StatusOK {
+				var res []model.DeploymentLogMatch
+				assert.NoError(t, recorded.DecodeJsonPayload(&res))
+				assert.Len(t, res, 1)
+
+				hasNext := false
+				for _, link := range recorded.Recorder.HeaderMap["Link"] {
+					if strings.Contains(link, `rel="next"`) {
+						hasNext = true
+					}
+				}
+				assert.Equal(t, tc.next, hasNext)
+			}
+
+			mockApp.AssertExpectations(t)
+		})
+	}
+}
+
+func TestSettings(t *testing.T) {
+	mockApp := &app_mocks.App{}
+	d := NewDeploymentsApiHandlers(&store_mocks.DataStore{}, new(view.RESTView), mockApp)
+
+	settings := &model.Settings{RequireApproval: true}
+
+	mockApp.On("GetSettings", contextMatcher()).Return(settings, nil)
+	mockApp.On("SaveSettings", contextMatcher(), settings).Return(nil)
+
+	api := setUpRestTest("/api/0.0.1/settings", rest.Get, d.GetSettings)
+	recorded := test.RunRequest(t, api.MakeHandler(),
+		test.MakeSimpleRequest("GET", "http://localhost/api/0.0.1/settings", nil))
+	recorded.CodeIs(http.StatusOK)
+	recorded.BodyIs(`{"require_approval":true,` +
+		`"retention":{"log_days":0,"device_deployment_days":0,"archive":false}}`)
+
+	api = setUpRestTest("/api/0.0.1/settings", rest.Put, d.PutSettings)
+	recorded = test.RunRequest(t, api.MakeHandler(),
+		test.MakeSimpleRequest("PUT", "http://localhost/api/0.0.1/settings", settings))
+	recorded.CodeIs(http.StatusNoContent)
+
+	invalid := &model.Settings{
+		Retention: model.RetentionSettings{LogDays: -1},
+	}
+	recorded = test.RunRequest(t, api.MakeHandler(),
+		test.MakeSimpleRequest("PUT", "http://localhost/api/0.0.1/settings", invalid))
+	recorded.CodeIs(http.StatusBadRequest)
+
+	mockApp.AssertExpectations(t)
+}
+
+func TestGetDeploymentReport(t *testing.T) {
+	deploymentID := "a108ae14-bb4e-455f-9b40-2ef4bab97bb7"
+	created := time.Date(2019, 5, 1, 10, 0, 0, 0, time.UTC)
+
+	rows := []*model.DeploymentReportRow{
+		{
+			DeviceID: "device0001",
+			Status:   model.DeviceDeploymentStatusSuccess,
+			Created:  &created,
+			Log:      []string{"foo"},
+		},
+		{
+			DeviceID: "device0002",
+			Status:   model.DeviceDeploymentStatusPending,
+			Created:  &created,
+		},
+	}
+
+	testCases := map[string]struct {
+		params   string
+		logLines int
+		rows     []*model.DeploymentReportRow
+		err      error
+
+		code        int
+		contentType string
+		body        string
+	}{
+		"csv": {
+			rows:        rows[1:],
+			code:        http.StatusOK,
+			contentType: "text/csv",
+			body: "device_id,device_type,artifact_name,status,substate,created,finished,duration\n" +
+				"device0002,,,pending,,2019-05-01T10:00:00Z,,\n",
+		},
+		"csv, with log": {
+			params:      "?format=csv&log_lines=10",
+			logLines:    10,
+			rows:        rows[:1],
+			code:        http.StatusOK,
+			contentType: "text/csv",
+			body: "device_id,device_type,artifact_name,status,substate,created,finished,duration,log\n" +
+				"device0001,,,success,,2019-05-01T10:00:00Z,,,foo\n",
+		},
+		"csv, empty": {
+			code:        http.StatusOK,
+			contentType: "text/csv",
+			body:        "device_id,device_type,artifact_name,status,substate,created,finished,duration\n",
+		},
+		"jsonl": {
+			params:      "?format=jsonl",
+			rows:        rows,
+			code:        http.StatusOK,
+			contentType: "application/x-ndjson",
+			body: `{"device_id":"device0001","device_type":"","artifact_name":"","status":"success",` +
+				`"substate":"","created":"2019-05-01T10:00:00Z","log":["foo"]}` + "\n" +
+				`{"device_id":"device0002","device_type":"","artifact_name":"","status":"pending",` +
+				`"substate":"","created":"2019-05-01T10:00:00Z"}` + "\n",
+		},
+		"error, interrupted": {
+			params:      "?format=jsonl",
+			rows:        rows[1:],
+			err:         errors.New("db error"),
+			code:        http.StatusOK,
+			contentType: "application/x-ndjson",
+			body: `{"device_id":"device0002","device_type":"","artifact_name":"","status":"pending",` +
+				`"substate":"","created":"2019-05-01T10:00:00Z"}` + "\n",
+		},
+		"error, not found": {
+			err:  app.ErrModelDeploymentNotFound,
+			code: http.StatusNotFound,
+		},
+		"error, internal": {
+			err:  errors.New("db error"),
+			code: http.StatusInternalServerError,
+		},
+		"error, format": {
+			params: "?format=xml",
+			code:   http.StatusBadRequest,
+		},
+		"error, log lines": {
+			params: "?log_lines=1000",
+			code:   http.StatusBadRequest,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			mockApp := &app_mocks.App{}
+			d := NewDeploymentsApiHandlers(&store_mocks.DataStore{}, new(view.RESTView), mockApp)
+
+			api := setUpRestTest("/api/0.0.1/deployments/:id/report", rest.Get,
+				d.GetDeploymentReport)
+
+			if tc.code != http.StatusBadRequest {
+				mockApp.On("GenerateDeploymentReport", contextMatcher(), deploymentID,
+					tc.logLines, mock.AnythingOfType("func(*model.DeploymentReportRow) error")).
+					Return(func(_ context.Context, _ string, _ int,
+						fn func(*model.DeploymentReportRow) error) error {
+						for _, row := range tc.rows {
+							if err := fn(row); err != nil {
+								return err
+							}
+						}
+						return tc.err
+					})
+			}
+
+			recorded := test.RunRequest(t, api.MakeHandler(),
+				test.MakeSimpleRequest("GET",
+					"http://localhost/api/0.0.1/deployments/"+deploymentID+"/report"+tc.params,
+					nil))
+			recorded.CodeIs(tc.code)
+			if tc.code == http.StatusOK {
+				recorded.HeaderIs("Content-Type", tc.contentType)
+				assert.Equal(t, tc.body, recorded.Recorder.Body.String())
+			}
+
+			mockApp.AssertExpectations(t)
+		})
+	}
+}
+
+func TestGetInstalledBase(t *testing.T) {
+	releases := []model.InstalledBaseRelease{{
+		ArtifactName: "release-1",
+		Count:        3,
+		Artifacts: []model.InstalledBaseArtifact{
+			{DeviceType: "drill", Count: 1},
+			{DeviceType: "hammer", Count: 2},
+		},
+	}}
+
+	testCases := map[string]struct {
+		params       string
+		artifactName string
+
+		err error
+
+		code int
+		body string
+	}{
+		"ok": {
+			code: http.StatusOK,
+			body: `[{"artifact_name":"release-1","count":3,"artifacts":[` +
+				`{"device_type":"drill","count":1},{"device_type":"hammer","count":2}]}]`,
+		},
+		"ok, release": {
+			params:       "?artifact_name=release-1",
+			artifactName: "release-1",
+			code:         http.StatusOK,
+		},
+		"error": {
+			err:  errors.New("db error"),
+			code: http.StatusInternalServerError,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			mockApp := &app_mocks.App{}
+			d := NewDeploymentsApiHandlers(&store_mocks.DataStore{}, new(view.RESTView), mockApp)
+
+			api := setUpRestTest("/api/0.0.1/deployments/installed_base", rest.Get,
+				d.GetInstalledBase)
+
+			if tc.err != nil {
+				mockApp.On("GetInstalledBase", contextMatcher(), tc.artifactName).
+					Return(nil, tc.err)
+			} else {
+				mockApp.On("GetInstalledBase", contextMatcher(), tc.artifactName).
+					Return(releases, nil)
+			}
+
+			recorded := test.RunRequest(t, api.MakeHandler(),
+				test.MakeSimpleRequest("GET",
+					"http://localhost/api/0.0.1/deployments/installed_base"+tc.params,
+					nil))
+			recorded.CodeIs(tc.code)
+			if tc.body != "" {
+				recorded.BodyIs(tc.body)
+			}
+
+			mockApp.AssertExpectations(t)
+		})
+	}
+}
+
+func TestGetInstalledBaseDevices(t *testing.T) {
+	testCases := map[string]struct {
+		params string
+		query  model.InstalledBaseQuery
+
+		total int
+		err   error
+
+		code  int
+		count string
+	}{
+		"ok": {
+			params: "?artifact_name=release-1&device_type=hammer&page=2&per_page=10",
+			query: model.InstalledBaseQuery{
+				ArtifactName: "release-1",
+				DeviceType:   "hammer",
+				Skip:         10,
+				Limit:        10,
+			},
+			total: 42,
+			code:  http.StatusOK,
+			count: "42",
+		},
+		"error, no artifact name": {
+			params: "?device_type=hammer",
+			code:   http.StatusBadRequest,
+		},
+		"error": {
+			params: "?artifact_name=release-1",
+			query: model.InstalledBaseQuery{
+				ArtifactName: "release-1",
+				Limit:        20,
+			},
+			err:  errors.New("db error"),
+			code: http.StatusInternalServerError,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			mockApp := &app_mocks.App{}
+			d := NewDeploymentsApiHandlers(&store_mocks.DataStore{}, new(view.RESTView), mockApp)
+
+			api := setUpRestTest("/api/0.0.1/deployments/installed_base/devices", rest.Get,
+				d.GetInstalledBaseDevices)
+
+			if tc.code != http.StatusBadRequest {
+				mockApp.On("GetInstalledArtifacts", contextMatcher(), tc.query).
+					Return([]model.InstalledArtifact{}, tc.total, tc.err)
+			}
+
+			recorded := test.RunRequest(t, api.MakeHandler(),
+				test.MakeSimpleRequest("GET",
+					"http://localhost/api/0.0.1/deployments/installed_base/devices"+tc.params,
+					nil))
+			recorded.CodeIs(tc.code)
+			if tc.count != "" {
+				recorded.HeaderIs(hdrTotalCount, tc.count)
+			}
+
+			mockApp.AssertExpectations(t)
+		})
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright 2019 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package http
+
+import (
+	"mime"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/deployments/model"
+)
+
+var (
+	ErrInvalidLogFormat = errors.New("Invalid log format, supported formats: text, json, jsonl")
+	ErrInvalidLogTail   = errors.New("Invalid tail parameter, must be a positive number")
+	ErrLogNotAcceptable = errors.New("None of the accepted media types is supported, supported: text/plain, application/json, application/x-ndjson")
+)
+
+// media types accepted by the log endpoint, including wildcards
+var deploymentLogMediaTypes = map[string]string{
+	"text/plain":           model.DeploymentLogFormatText,
+	"application/json":     model.DeploymentLogFormatJSON,
+	"application/x-ndjson": model.DeploymentLogFormatJSONL,
+	"text/*":               model.DeploymentLogFormatText,
+	"application/*":        model.DeploymentLogFormatJSON,
+	"*/*":                  model.DeploymentLogFormatText,
+}
+
+// negotiateDeploymentLogFormat picks the log format from the Accept header,
+// the media type with the highest quality wins, earlier ones win ties.
+// Plain text is used if there's no Accept header.
+func negotiateDeploymentLogFormat(accept string) (string, error) {
+	if strings.TrimSpace(accept) == "" {
+		return model.DeploymentLogFormatText, nil
+	}
+
+	format := ""
+	bestQuality := 0.0
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+
+		f, ok := deploymentLogMediaTypes[mediaType]
+		if !ok {
+			continue
+		}
+
+		quality := 1.0
+		if q, ok := params["q"]; ok {
+			quality, err = strconv.ParseFloat(q, 64)
+			if err != nil {
+				continue
+			}
+		}
+
+		if quality > bestQuality {
+			format = f
+			bestQuality = quality
+		}
+	}
+
+	if format == "" {
+		return "", ErrLogNotAcceptable
+	}
+
+	return format, nil
+}
+
+// ParseDeploymentLogFilter parses the message filters of the deployment log
+func ParseDeploymentLogFilter(vals url.Values) (model.DeploymentLogFilter, error) {
+	filter := model.DeploymentLogFilter{
+		Level: vals.Get("level"),
+	}
+
+	if from := vals.Get("from"); from != "" {
+		fromTime, err := parseEpochToTimestamp(from)
+		if err != nil {
+			return filter, errors.Wrap(err, "timestamp parsing failed for from parameter")
+		}
+		filter.From = &fromTime
+	}
+
+	if to := vals.Get("to"); to != "" {
+		toTime, err := parseEpochToTimestamp(to)
+		if err != nil {
+			return filter, errors.Wrap(err, "timestamp parsing failed for to parameter")
+		}
+		filter.To = &toTime
+	}
+
+	if tail := vals.Get("tail"); tail != "" {
+		n, err := strconv.Atoi(tail)
+		if err != nil || n <= 0 {
+			return filter, ErrInvalidLogTail
+		}
+		filter.Tail = n
+	}
+
+	if err := filter.Validate(); err != nil {
+		return filter, err
+	}
+
+	return filter, nil
+}
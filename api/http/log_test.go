@@ -0,0 +1,234 @@
+// Copyright 2019 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package http
+
+import (
+	"compress/gzip"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/ant0ine/go-json-rest/rest"
+	"github.com/ant0ine/go-json-rest/rest/test"
+	"github.com/stretchr/testify/assert"
+
+	app_mocks "github.com/mendersoftware/deployments/app/mocks"
+	"github.com/mendersoftware/deployments/model"
+	store_mocks "github.com/mendersoftware/deployments/store/mocks"
+	"github.com/mendersoftware/deployments/utils/restutil/view"
+)
+
+func TestNegotiateDeploymentLogFormat(t *testing.T) {
+	testCases := map[string]struct {
+		accept string
+
+		format string
+		err    error
+	}{
+		"no header": {
+			format: model.DeploymentLogFormatText,
+		},
+		"any": {
+			accept: "*/*",
+			format: model.DeploymentLogFormatText,
+		},
+		"json": {
+			accept: "application/json",
+			format: model.DeploymentLogFormatJSON,
+		},
+		"json lines": {
+			accept: "application/x-ndjson",
+			format: model.DeploymentLogFormatJSONL,
+		},
+		"first of equal quality": {
+			accept: "application/x-ndjson, application/json",
+			format: model.DeploymentLogFormatJSONL,
+		},
+		"highest quality": {
+			accept: "text/plain;q=0.5, application/json;q=0.9, text/html",
+			format: model.DeploymentLogFormatJSON,
+		},
+		"wildcard with lower quality": {
+			accept: "*/*;q=0.1, application/x-ndjson",
+			format: model.DeploymentLogFormatJSONL,
+		},
+		"not acceptable": {
+			accept: "text/html, application/xml",
+			err:    ErrLogNotAcceptable,
+		},
+		"excluded": {
+			accept: "application/json;q=0",
+			err:    ErrLogNotAcceptable,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			format, err := negotiateDeploymentLogFormat(tc.accept)
+			if tc.err != nil {
+				assert.EqualError(t, err, tc.err.Error())
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.format, format)
+			}
+		})
+	}
+}
+
+func TestGetDeploymentLogForDevice(t *testing.T) {
+	deploymentID := "a108ae14-bb4e-455f-9b40-2ef4bab97bb7"
+	t1 := time.Unix(1546300800, 0).UTC()
+	t2 := t1.Add(time.Hour)
+
+	dlog := func() *model.DeploymentLog {
+		return &model.DeploymentLog{
+			DeviceID:     "device-1",
+			DeploymentID: deploymentID,
+			Messages: []model.LogMessage{
+				{Timestamp: &t1, Level: "info", Message: "installing"},
+				{Timestamp: &t1, Level: "error", Message: "no space left"},
+				{Timestamp: &t2, Level: "error", Message: "rollback failed"},
+			},
+		}
+	}
+
+	testCases := map[string]struct {
+		params         string
+		accept         string
+		acceptEncoding string
+
+		callApp bool
+		log     *model.DeploymentLog
+		err     error
+
+		code        int
+		contentType string
+		body        string
+	}{
+		"ok, text": {
+			callApp:     true,
+			log:         dlog(),
+			code:        http.StatusOK,
+			contentType: "text/plain",
+			body: "2019-01-01 00:00:00 +0000 UTC info: installing\n" +
+				"2019-01-01 00:00:00 +0000 UTC error: no space left\n" +
+				"2019-01-01 01:00:00 +0000 UTC error: rollback failed\n",
+		},
+		"ok, json lines, level and tail": {
+			params:      "level=error&tail=1",
+			accept:      "application/x-ndjson",
+			callApp:     true,
+			log:         dlog(),
+			code:        http.StatusOK,
+			contentType: "application/x-ndjson",
+			body:        `{"timestamp":"2019-01-01T01:00:00Z","level":"error","message":"rollback failed"}` + "\n",
+		},
+		"ok, json format over accept, time range": {
+			params:      "format=json&to=1546300800",
+			accept:      "text/plain",
+			callApp:     true,
+			log:         dlog(),
+			code:        http.StatusOK,
+			contentType: "application/json",
+			body: `{"messages":[` +
+				`{"timestamp":"2019-01-01T00:00:00Z","level":"info","message":"installing"},` +
+				`{"timestamp":"2019-01-01T00:00:00Z","level":"error","message":"no space left"}]}` + "\n",
+		},
+		"ok, gzip": {
+			acceptEncoding: "gzip",
+			callApp:        true,
+			log:            dlog(),
+			code:           http.StatusOK,
+			contentType:    "text/plain",
+			body: "2019-01-01 00:00:00 +0000 UTC info: installing\n" +
+				"2019-01-01 00:00:00 +0000 UTC error: no space left\n" +
+				"2019-01-01 01:00:00 +0000 UTC error: rollback failed\n",
+		},
+		"error, not acceptable": {
+			accept: "text/html",
+			code:   http.StatusNotAcceptable,
+		},
+		"error, invalid format": {
+			params: "format=xml",
+			code:   http.StatusBadRequest,
+		},
+		"error, invalid tail": {
+			params: "tail=-1",
+			code:   http.StatusBadRequest,
+		},
+		"error, invalid time range": {
+			params: "from=1546304400&to=1546300800",
+			code:   http.StatusBadRequest,
+		},
+		"error, not found": {
+			callApp: true,
+			code:    http.StatusNotFound,
+		},
+		"error, internal": {
+			callApp: true,
+			err:     errors.New("db failed"),
+			code:    http.StatusInternalServerError,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			mockApp := &app_mocks.App{}
+			d := NewDeploymentsApiHandlers(&store_mocks.DataStore{}, new(view.RESTView), mockApp)
+
+			api := setUpRestTest("/api/0.0.1/deployments/:id/devices/:devid/log", rest.Get,
+				d.GetDeploymentLogForDevice)
+			api.Use(&rest.GzipMiddleware{})
+
+			if tc.callApp {
+				mockApp.On("GetDeviceDeploymentLog", contextMatcher(), "device-1", deploymentID).
+					Return(tc.log, tc.err)
+			}
+
+			req := test.MakeSimpleRequest("GET",
+				"http://localhost/api/0.0.1/deployments/"+deploymentID+
+					"/devices/device-1/log?"+tc.params, nil)
+			req.Header.Del("Accept")
+			if tc.accept != "" {
+				req.Header.Set("Accept", tc.accept)
+			}
+			req.Header.Del("Accept-Encoding")
+			if tc.acceptEncoding != "" {
+				req.Header.Set("Accept-Encoding", tc.acceptEncoding)
+			}
+
+			recorded := test.RunRequest(t, api.MakeHandler(), req)
+			recorded.CodeIs(tc.code)
+			if tc.contentType != "" {
+				recorded.HeaderIs("Content-Type", tc.contentType)
+			}
+			if tc.body != "" {
+				body := recorded.Recorder.Body.String()
+				if tc.acceptEncoding != "" {
+					recorded.HeaderIs("Content-Encoding", "gzip")
+					gz, err := gzip.NewReader(recorded.Recorder.Body)
+					assert.NoError(t, err)
+					data, err := ioutil.ReadAll(gz)
+					assert.NoError(t, err)
+					body = string(data)
+				}
+				assert.Equal(t, tc.body, body)
+			}
+
+			mockApp.AssertExpectations(t)
+		})
+	}
+}
@@ -0,0 +1,136 @@
+// Copyright 2019 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package http
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/mendersoftware/deployments/model"
+)
+
+const (
+	// number of rows written between flushes of the response
+	reportFlushInterval = 100
+)
+
+var reportContentTypes = map[string]string{
+	model.DeploymentReportFormatCSV:   "text/csv",
+	model.DeploymentReportFormatJSONL: "application/x-ndjson",
+}
+
+// deploymentReportWriter streams rows of the deployment report to the
+// response. Response headers are written together with the first row,
+// so errors occurring before can still be rendered as usual.
+type deploymentReportWriter struct {
+	w            http.ResponseWriter
+	format       string
+	deploymentID string
+	withLog      bool
+
+	csv  *csv.Writer
+	json *json.Encoder
+
+	started bool
+	rows    int
+}
+
+func newDeploymentReportWriter(w http.ResponseWriter, format string,
+	deploymentID string, withLog bool) *deploymentReportWriter {
+
+	rw := &deploymentReportWriter{
+		w:            w,
+		format:       format,
+		deploymentID: deploymentID,
+		withLog:      withLog,
+	}
+
+	switch format {
+	case model.DeploymentReportFormatCSV:
+		rw.csv = csv.NewWriter(w)
+	case model.DeploymentReportFormatJSONL:
+		rw.json = json.NewEncoder(w)
+	}
+
+	return rw
+}
+
+func (rw *deploymentReportWriter) start() error {
+	rw.started = true
+
+	rw.w.Header().Set("Content-Type", reportContentTypes[rw.format])
+	rw.w.Header().Set("Content-Disposition",
+		fmt.Sprintf("attachment; filename=\"deployment-%s.%s\"", rw.deploymentID, rw.format))
+	rw.w.WriteHeader(http.StatusOK)
+
+	if rw.csv != nil {
+		return rw.csv.Write(model.DeploymentReportCSVHeader(rw.withLog))
+	}
+
+	return nil
+}
+
+// WriteRow writes a single row of the report
+func (rw *deploymentReportWriter) WriteRow(row *model.DeploymentReportRow) error {
+	if !rw.started {
+		if err := rw.start(); err != nil {
+			return err
+		}
+	}
+
+	var err error
+	if rw.csv != nil {
+		err = rw.csv.Write(row.CSVRecord(rw.withLog))
+	} else {
+		err = rw.json.Encode(row)
+	}
+	if err != nil {
+		return err
+	}
+
+	rw.rows++
+	if rw.rows%reportFlushInterval == 0 {
+		return rw.flush()
+	}
+
+	return nil
+}
+
+// Close finishes the report, an empty report is written if there were no rows
+func (rw *deploymentReportWriter) Close() error {
+	if !rw.started {
+		if err := rw.start(); err != nil {
+			return err
+		}
+	}
+
+	return rw.flush()
+}
+
+func (rw *deploymentReportWriter) flush() error {
+	if rw.csv != nil {
+		rw.csv.Flush()
+		if err := rw.csv.Error(); err != nil {
+			return err
+		}
+	}
+
+	if f, ok := rw.w.(http.Flusher); ok {
+		f.Flush()
+	}
+
+	return nil
+}
@@ -15,6 +15,9 @@
 package http
 
 import (
+	"context"
+	"time"
+
 	"github.com/ant0ine/go-json-rest/rest"
 
 	"github.com/mendersoftware/go-lib-micro/config"
@@ -39,15 +42,27 @@ const (
 	ApiUrlManagementDeployments           = ApiUrlManagement + "/deployments"
 	ApiUrlManagementDeploymentsId         = ApiUrlManagement + "/deployments/:id"
 	ApiUrlManagementDeploymentsStatistics = ApiUrlManagement + "/deployments/:id/statistics"
+	ApiUrlManagementDeploymentsDurations  = ApiUrlManagement + "/deployments/:id/statistics/durations"
+	ApiUrlManagementDeploymentsProgress   = ApiUrlManagement + "/deployments/:id/statistics/progress"
+	ApiUrlManagementDeploymentsReport     = ApiUrlManagement + "/deployments/:id/report"
 	ApiUrlManagementDeploymentsStatus     = ApiUrlManagement + "/deployments/:id/status"
+	ApiUrlManagementDeploymentsApproval   = ApiUrlManagement + "/deployments/:id/approval"
 	ApiUrlManagementDeploymentsDevices    = ApiUrlManagement + "/deployments/:id/devices"
 	ApiUrlManagementDeploymentsLog        = ApiUrlManagement + "/deployments/:id/devices/:devid/log"
+	ApiUrlManagementDeploymentsContinue   = ApiUrlManagement + "/deployments/:id/continue"
+	ApiUrlManagementDeploymentsDeviceCont = ApiUrlManagement + "/deployments/:id/devices/:devid/continue"
 	ApiUrlManagementDeploymentsDeviceId   = ApiUrlManagement + "/deployments/devices/:id"
+	ApiUrlManagementDeploymentsLogs       = ApiUrlManagement + "/deployments/logs"
 
 	ApiUrlManagementReleases = ApiUrlManagement + "/deployments/releases"
 
+	ApiUrlManagementInstalledBase        = ApiUrlManagement + "/deployments/installed_base"
+	ApiUrlManagementInstalledBaseDevices = ApiUrlManagement + "/deployments/installed_base/devices"
+
 	ApiUrlManagementLimitsName = ApiUrlManagement + "/limits/:name"
 
+	ApiUrlManagementSettings = ApiUrlManagement + "/settings"
+
 	ApiUrlDevicesDeploymentsNext  = ApiUrlDevices + "/device/deployments/next"
 	ApiUrlDevicesDeploymentStatus = ApiUrlDevices + "/device/deployments/:id/status"
 	ApiUrlDevicesDeploymentsLog   = ApiUrlDevices + "/device/deployments/:id/log"
@@ -55,6 +70,7 @@ const (
 	ApiUrlInternalTenants           = ApiUrlInternal + "/tenants"
 	ApiUrlInternalTenantDeployments = ApiUrlInternal + "/tenants/:tenant/deployments"
 	ApiUrlInternalTenantArtifacts   = ApiUrlInternal + "/tenants/:tenant/artifacts"
+	ApiUrlInternalTenantApproval    = ApiUrlInternal + "/tenants/:tenant/settings/require_approval"
 )
 
 func SetupS3(c config.Reader) (s3.FileStorage, error) {
@@ -94,21 +110,45 @@ func NewRouter(c config.Reader) (rest.App, error) {
 
 	app := app.NewDeployments(mongoStorage, fileStorage, app.ArtifactContentType)
 
+	// Fail device deployments exceeding state timeouts in the background
+	if interval := c.GetInt(dconfig.SettingTimeoutSweepInterval); interval > 0 {
+		go app.RunTimeoutSweeper(context.Background(),
+			time.Duration(interval)*time.Second)
+	}
+
+	// Remove deployment data older than the retention settings in the background
+	if interval := c.GetInt(dconfig.SettingRetentionSweepInterval); interval > 0 {
+		go app.RunRetentionSweeper(context.Background(),
+			time.Duration(interval)*time.Second)
+	}
+
+	// Wake up devices waiting for deployments created by other instances
+	if c.GetBool(dconfig.SettingDeviceNotifications) {
+		go app.RunDeviceNotificationsWatcher(context.Background(), 5*time.Second)
+	}
+
 	deploymentsHandlers := NewDeploymentsApiHandlers(mongoStorage, new(view.RESTView), app)
 
-	// Routing
+	return rest.MakeRouter(restutil.AutogenOptionsRoutes(restutil.NewOptionsHandler,
+		NewRoutes(deploymentsHandlers)...)...)
+}
+
+// NewRoutes returns all REST API routes in the order they are matched
+func NewRoutes(deploymentsHandlers *DeploymentsApiHandlers) []*rest.Route {
 	imageRoutes := NewImagesResourceRoutes(deploymentsHandlers)
 	deploymentsRoutes := NewDeploymentsResourceRoutes(deploymentsHandlers)
 	limitsRoutes := NewLimitsResourceRoutes(deploymentsHandlers)
+	settingsRoutes := NewSettingsResourceRoutes(deploymentsHandlers)
 	tenantsRoutes := TenantRoutes(deploymentsHandlers)
 	releasesRoutes := ReleasesRoutes(deploymentsHandlers)
 
 	routes := append(releasesRoutes, deploymentsRoutes...)
 	routes = append(routes, limitsRoutes...)
+	routes = append(routes, settingsRoutes...)
 	routes = append(routes, tenantsRoutes...)
 	routes = append(routes, imageRoutes...)
 
-	return rest.MakeRouter(restutil.AutogenOptionsRoutes(restutil.NewOptionsHandler, routes...)...)
+	return routes
 }
 
 func NewImagesResourceRoutes(controller *DeploymentsApiHandlers) []*rest.Route {
@@ -140,13 +180,29 @@ func NewDeploymentsResourceRoutes(controller *DeploymentsApiHandlers) []*rest.Ro
 		// Deployments
 		rest.Post(ApiUrlManagementDeployments, controller.PostDeployment),
 		rest.Get(ApiUrlManagementDeployments, controller.LookupDeployment),
+		// the first matching route is used, so it has to go before :id
+		rest.Get(ApiUrlManagementDeploymentsLogs,
+			controller.SearchDeploymentLogs),
 		rest.Get(ApiUrlManagementDeploymentsId, controller.GetDeployment),
+		rest.Put(ApiUrlManagementDeploymentsId, controller.EditDeployment),
 		rest.Get(ApiUrlManagementDeploymentsStatistics, controller.GetDeploymentStats),
+		rest.Get(ApiUrlManagementDeploymentsDurations, controller.GetDeploymentDurationStats),
+		rest.Get(ApiUrlManagementDeploymentsProgress, controller.GetDeploymentProgressStats),
+		rest.Get(ApiUrlManagementDeploymentsReport, controller.GetDeploymentReport),
 		rest.Put(ApiUrlManagementDeploymentsStatus, controller.AbortDeployment),
+		rest.Put(ApiUrlManagementDeploymentsApproval, controller.PutDeploymentApproval),
 		rest.Get(ApiUrlManagementDeploymentsDevices,
 			controller.GetDeviceStatusesForDeployment),
+		rest.Post(ApiUrlManagementDeploymentsDevices,
+			controller.AddDevicesToDeployment),
 		rest.Get(ApiUrlManagementDeploymentsLog,
 			controller.GetDeploymentLogForDevice),
+		rest.Post(ApiUrlManagementDeploymentsContinue,
+			controller.ContinueDeployment),
+		rest.Post(ApiUrlManagementDeploymentsDeviceCont,
+			controller.ContinueDeviceDeployment),
+		rest.Get(ApiUrlManagementDeploymentsDeviceId,
+			controller.GetDeviceDeploymentHistory),
 		rest.Delete(ApiUrlManagementDeploymentsDeviceId,
 			controller.DecommissionDevice),
 
@@ -171,6 +227,18 @@ func NewLimitsResourceRoutes(controller *DeploymentsApiHandlers) []*rest.Route {
 	}
 }
 
+func NewSettingsResourceRoutes(controller *DeploymentsApiHandlers) []*rest.Route {
+
+	if controller == nil {
+		return []*rest.Route{}
+	}
+
+	return []*rest.Route{
+		rest.Get(ApiUrlManagementSettings, controller.GetSettings),
+		rest.Put(ApiUrlManagementSettings, controller.PutSettings),
+	}
+}
+
 func TenantRoutes(controller *DeploymentsApiHandlers) []*rest.Route {
 	if controller == nil {
 		return []*rest.Route{}
@@ -180,6 +248,7 @@ func TenantRoutes(controller *DeploymentsApiHandlers) []*rest.Route {
 		rest.Post(ApiUrlInternalTenants, controller.ProvisionTenantsHandler),
 		rest.Get(ApiUrlInternalTenantDeployments, controller.DeploymentsPerTenantHandler),
 		rest.Post(ApiUrlInternalTenantArtifacts, controller.NewImageForTenantHandler),
+		rest.Put(ApiUrlInternalTenantApproval, controller.PutTenantRequireApprovalHandler),
 	}
 }
 
@@ -190,5 +259,7 @@ func ReleasesRoutes(controller *DeploymentsApiHandlers) []*rest.Route {
 
 	return []*rest.Route{
 		rest.Get(ApiUrlManagementReleases, controller.GetReleases),
+		rest.Get(ApiUrlManagementInstalledBase, controller.GetInstalledBase),
+		rest.Get(ApiUrlManagementInstalledBaseDevices, controller.GetInstalledBaseDevices),
 	}
 }
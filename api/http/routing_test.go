@@ -0,0 +1,116 @@
+// Copyright 2019 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package http
+
+import (
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/ant0ine/go-json-rest/rest"
+	"github.com/ant0ine/go-json-rest/rest/test"
+	"github.com/mendersoftware/go-lib-micro/requestid"
+	"github.com/mendersoftware/go-lib-micro/requestlog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	app_mocks "github.com/mendersoftware/deployments/app/mocks"
+	"github.com/mendersoftware/deployments/model"
+	store_mocks "github.com/mendersoftware/deployments/store/mocks"
+	"github.com/mendersoftware/deployments/utils/restutil/view"
+)
+
+// TestRoutes checks requests reach the right handler through the router
+// of the service, where the first matching route is used
+func TestRoutes(t *testing.T) {
+	deploymentID := "a108ae14-bb4e-455f-9b40-2ef4bab97bb7"
+
+	testCases := map[string]struct {
+		url string
+
+		appMethod string
+		appArgs   []interface{}
+		appReturn []interface{}
+
+		code int
+	}{
+		"deployment": {
+			url:       ApiUrlManagement + "/deployments/" + deploymentID,
+			appMethod: "GetDeployment",
+			appArgs:   []interface{}{contextMatcher(), deploymentID},
+			appReturn: []interface{}{&model.Deployment{}, nil},
+			code:      http.StatusOK,
+		},
+		"deployment log search": {
+			url:       ApiUrlManagement + "/deployments/logs?deployment_id=" + deploymentID,
+			appMethod: "SearchDeploymentLogs",
+			appArgs:   []interface{}{contextMatcher(), mock.AnythingOfType("model.DeploymentLogQuery")},
+			appReturn: []interface{}{[]model.DeploymentLogMatch{}, nil},
+			code:      http.StatusOK,
+		},
+		"device deployment history": {
+			url:       ApiUrlManagement + "/deployments/devices/device-1",
+			appMethod: "GetDeviceDeploymentHistory",
+			appArgs:   []interface{}{contextMatcher(), mock.AnythingOfType("model.DeviceDeploymentsQuery")},
+			appReturn: []interface{}{[]model.DeviceDeploymentHistoryEntry{}, nil},
+			code:      http.StatusOK,
+		},
+		"releases": {
+			url:  ApiUrlManagementReleases,
+			code: http.StatusOK,
+		},
+		"installed base": {
+			url:       ApiUrlManagementInstalledBase,
+			appMethod: "GetInstalledBase",
+			appArgs:   []interface{}{contextMatcher(), ""},
+			appReturn: []interface{}{[]model.InstalledBaseRelease{}, nil},
+			code:      http.StatusOK,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			mockApp := &app_mocks.App{}
+			mockStore := &store_mocks.DataStore{}
+			d := NewDeploymentsApiHandlers(mockStore, new(view.RESTView), mockApp)
+
+			if tc.appMethod != "" {
+				mockApp.On(tc.appMethod, tc.appArgs...).Return(tc.appReturn...)
+			} else {
+				mockStore.On("GetReleases", contextMatcher(),
+					(*model.ReleaseFilter)(nil)).Return([]model.Release{}, nil)
+			}
+
+			router, err := rest.MakeRouter(NewRoutes(d)...)
+			assert.NoError(t, err)
+			api := rest.NewApi()
+			api.Use(
+				&requestlog.RequestLogMiddleware{
+					BaseLogger: &logrus.Logger{Out: ioutil.Discard},
+				},
+				&requestid.RequestIdMiddleware{},
+			)
+			api.SetApp(router)
+
+			recorded := test.RunRequest(t, api.MakeHandler(),
+				test.MakeSimpleRequest("GET", "http://localhost"+tc.url, nil))
+			recorded.CodeIs(tc.code)
+
+			mockApp.AssertExpectations(t)
+			mockStore.AssertExpectations(t)
+		})
+	}
+}
@@ -15,14 +15,19 @@
 package app
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
+	"encoding/json"
 	"io"
 	"io/ioutil"
+	"sync/atomic"
 	"time"
 
 	"github.com/pkg/errors"
 	"github.com/satori/go.uuid"
 
+	"github.com/mendersoftware/go-lib-micro/identity"
 	"github.com/mendersoftware/go-lib-micro/log"
 	"github.com/mendersoftware/mender-artifact/areader"
 	"github.com/mendersoftware/mender-artifact/artifact"
@@ -38,6 +43,9 @@ const (
 	ArtifactContentType = "application/vnd.mender-artifact"
 
 	DefaultUpdateDownloadLinkExpire = 24 * time.Hour
+
+	// number of expired items processed at once by the retention sweeper
+	retentionBatchSize = 100
 )
 
 // Errors expected from App interface
@@ -62,8 +70,16 @@ var (
 	ErrStorageInvalidLog       = errors.New("Invalid deployment log")
 	ErrStorageNotFound         = errors.New("Not found")
 	ErrDeploymentAborted       = errors.New("Deployment aborted")
+	ErrDeploymentFinished      = errors.New("Deployment finished")
 	ErrDeviceDecommissioned    = errors.New("Device decommissioned")
 	ErrNoArtifact              = errors.New("No artifact for the deployment")
+	ErrDependencyNotFound      = errors.New("Deployment dependency not found")
+	ErrNotAwaitingApproval     = errors.New("Deployment is not awaiting approval")
+	ErrApprovalBySameUser      = errors.New("Deployment has to be approved by another user")
+	ErrNotPausing              = errors.New("Deployment doesn't pause at the point")
+
+	// settings
+	ErrRequireApprovalReadOnly = errors.New("The approval requirement can only be changed by an administrator")
 )
 
 //deployments
@@ -73,6 +89,11 @@ type App interface {
 	GetLimit(ctx context.Context, name string) (*model.Limit, error)
 	ProvisionTenant(ctx context.Context, tenant_id string) error
 
+	// settings
+	GetSettings(ctx context.Context) (*model.Settings, error)
+	SaveSettings(ctx context.Context, settings *model.Settings) error
+	SetRequireApproval(ctx context.Context, require bool) error
+
 	// images
 	ListImages(ctx context.Context,
 		filters map[string]string) ([]*model.SoftwareImage, error)
@@ -88,31 +109,76 @@ type App interface {
 	// deployments
 	CreateDeployment(ctx context.Context,
 		constructor *model.DeploymentConstructor) (string, error)
+	AddDevicesToDeployment(ctx context.Context, deploymentID string,
+		devices []string) error
 	GetDeployment(ctx context.Context, deploymentID string) (*model.Deployment, error)
+	EditDeployment(ctx context.Context, deploymentID string,
+		meta *model.DeploymentMetadataConstructor) (bool, error)
+	DecideDeploymentApproval(ctx context.Context, deploymentID string,
+		userID string, status string) error
+	ContinueDeployment(ctx context.Context, deploymentID string,
+		pausePoint string) error
+	ContinueDeviceDeployment(ctx context.Context, deploymentID string,
+		deviceID string, pausePoint string) error
+	IsDeviceDeploymentContinued(ctx context.Context, deploymentID string,
+		deviceID string, pausePoint string) (bool, error)
 	IsDeploymentFinished(ctx context.Context, deploymentID string) (bool, error)
 	AbortDeployment(ctx context.Context, deploymentID string) error
 	GetDeploymentStats(ctx context.Context, deploymentID string) (model.Stats, error)
+	GetDeploymentProgressStats(ctx context.Context,
+		deploymentID string) (*model.DeploymentProgressStats, error)
+	GetDeploymentDurationStats(ctx context.Context,
+		deploymentID string) (*model.DeploymentDurationStats, error)
+	GetDeploymentGeneration(ctx context.Context) (int64, error)
+	WaitForDeploymentForDevice(ctx context.Context, deviceID string,
+		installed model.InstalledDeviceDeployment,
+		wait time.Duration) (*model.DeploymentInstructions, error)
 	GetDeploymentForDeviceWithCurrent(ctx context.Context, deviceID string,
 		current model.InstalledDeviceDeployment) (*model.DeploymentInstructions, error)
 	HasDeploymentForDevice(ctx context.Context, deploymentID string,
 		deviceID string) (bool, error)
 	UpdateDeviceDeploymentStatus(ctx context.Context, deploymentID string,
 		deviceID string, status model.DeviceDeploymentStatus) error
+	UpdateDeviceDeploymentProgress(ctx context.Context, deploymentID string,
+		deviceID string, progress model.DownloadProgress) error
 	GetDeviceStatusesForDeployment(ctx context.Context,
-		deploymentID string) ([]model.DeviceDeployment, error)
+		query model.DeploymentDevicesQuery) ([]model.DeviceDeployment, int, error)
+	GenerateDeploymentReport(ctx context.Context, deploymentID string, logLines int,
+		fn func(row *model.DeploymentReportRow) error) error
 	LookupDeployment(ctx context.Context,
-		query model.Query) ([]*model.Deployment, error)
+		query model.Query) ([]*model.Deployment, int, error)
 	SaveDeviceDeploymentLog(ctx context.Context, deviceID string,
 		deploymentID string, logs []model.LogMessage) error
+	AppendDeviceDeploymentLog(ctx context.Context, deviceID string,
+		deploymentID string, logs []model.LogMessage) error
 	GetDeviceDeploymentLog(ctx context.Context,
 		deviceID, deploymentID string) (*model.DeploymentLog, error)
+	SearchDeploymentLogs(ctx context.Context,
+		query model.DeploymentLogQuery) ([]model.DeploymentLogMatch, error)
 	DecommissionDevice(ctx context.Context, deviceID string) error
+	GetDeviceDeploymentHistory(ctx context.Context,
+		query model.DeviceDeploymentsQuery) ([]model.DeviceDeploymentHistoryEntry, error)
+
+	// installed base
+	UpdateInstalledArtifact(ctx context.Context, deviceID string,
+		installed model.InstalledDeviceDeployment) error
+	GetInstalledBase(ctx context.Context,
+		artifactName string) ([]model.InstalledBaseRelease, error)
+	GetInstalledArtifacts(ctx context.Context,
+		query model.InstalledBaseQuery) ([]model.InstalledArtifact, int, error)
 }
 
 type Deployments struct {
 	db               store.DataStore
 	fileStorage      s3.FileStorage
 	imageContentType string
+
+	// wakes up devices waiting for a new deployment
+	notifier *deviceNotifier
+	// identifies notifications sent by this instance
+	instanceID string
+	// set once notifications are exchanged with other instances
+	notificationsWatched int32
 }
 
 func NewDeployments(storage store.DataStore, fileStorage s3.FileStorage, imageContentType string) *Deployments {
@@ -120,6 +186,8 @@ func NewDeployments(storage store.DataStore, fileStorage s3.FileStorage, imageCo
 		db:               storage,
 		fileStorage:      fileStorage,
 		imageContentType: imageContentType,
+		notifier:         newDeviceNotifier(),
+		instanceID:       uuid.Must(uuid.NewV4()).String(),
 	}
 }
 
@@ -137,6 +205,40 @@ func (d *Deployments) GetLimit(ctx context.Context, name string) (*model.Limit,
 	return limit, nil
 }
 
+func (d *Deployments) GetSettings(ctx context.Context) (*model.Settings, error) {
+	settings, err := d.db.GetSettings(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to obtain settings from storage")
+	}
+	return settings, nil
+}
+
+// SaveSettings replaces the settings, except for the approval requirement
+// which has to be kept as is; see SetRequireApproval
+func (d *Deployments) SaveSettings(ctx context.Context, settings *model.Settings) error {
+	current, err := d.db.GetSettings(ctx)
+	if err != nil {
+		return errors.Wrap(err, "failed to obtain settings from storage")
+	}
+	if settings.RequireApproval != current.RequireApproval {
+		return ErrRequireApprovalReadOnly
+	}
+
+	if err := d.db.SaveSettings(ctx, settings); err != nil {
+		return errors.Wrap(err, "failed to save settings")
+	}
+	return nil
+}
+
+// SetRequireApproval changes whether new deployments have to be approved,
+// it's exposed on the internal API only
+func (d *Deployments) SetRequireApproval(ctx context.Context, require bool) error {
+	if err := d.db.SetRequireApproval(ctx, require); err != nil {
+		return errors.Wrap(err, "failed to save settings")
+	}
+	return nil
+}
+
 func (d *Deployments) ProvisionTenant(ctx context.Context, tenant_id string) error {
 	if err := d.db.ProvisionTenant(ctx, tenant_id); err != nil {
 		return errors.Wrap(err, "failed to provision tenant")
@@ -496,6 +598,18 @@ func (d *Deployments) CreateDeployment(ctx context.Context,
 		return "", errors.Wrap(err, "Validating deployment")
 	}
 
+	// Predecessors have to exist at the moment of deployment creation,
+	// which also guarantees the dependency graph has no cycles.
+	for _, id := range constructor.DependsOn {
+		predecessor, err := d.db.FindDeploymentByID(ctx, id)
+		if err != nil {
+			return "", errors.Wrap(err, "Searching for deployment dependency")
+		}
+		if predecessor == nil {
+			return "", ErrDependencyNotFound
+		}
+	}
+
 	deployment, err := model.NewDeploymentFromConstructor(constructor)
 	if err != nil {
 		return "", errors.Wrap(err, "failed to create deployment")
@@ -515,6 +629,21 @@ func (d *Deployments) CreateDeployment(ctx context.Context,
 
 	deployment.Artifacts = getArtifactIDs(artifacts)
 
+	settings, err := d.db.GetSettings(ctx)
+	if err != nil {
+		return "", errors.Wrap(err, "Searching for settings")
+	}
+
+	if settings.RequireApproval {
+		// devices are held back until another user approves the deployment
+		deployment.Approval = &model.DeploymentApproval{
+			Status: model.ApprovalStatusAwaiting,
+		}
+		if id := identity.FromContext(ctx); id != nil {
+			deployment.Approval.RequestedBy = id.Subject
+		}
+	}
+
 	// Generate deployment for each specified device.
 	// Do not assign artifacts to the particular device deployment.
 	// Artifacts will be assigned on device update request handling, based on
@@ -527,6 +656,8 @@ func (d *Deployments) CreateDeployment(ctx context.Context,
 		}
 
 		deviceDeployment.Created = deployment.Created
+		deviceDeployment.Priority = deployment.Priority
+		deviceDeployment.DeploymentCreated = deployment.Created
 		deviceDeployments = append(deviceDeployments, deviceDeployment)
 	}
 
@@ -545,9 +676,97 @@ func (d *Deployments) CreateDeployment(ctx context.Context,
 		return "", errors.Wrap(err, "Storing assigned deployments to devices")
 	}
 
+	if !deployment.IsAwaitingApproval() {
+		d.incrementDeploymentGeneration(ctx)
+		d.notifyDevices(ctx, constructor.Devices)
+	}
+
 	return *deployment.Id, nil
 }
 
+// AddDevicesToDeployment schedules an existing, unfinished deployment for
+// additional devices. Devices already targeted by the deployment are skipped.
+func (d *Deployments) AddDevicesToDeployment(ctx context.Context,
+	deploymentID string, devices []string) error {
+
+	deployment, err := d.db.FindDeploymentByID(ctx, deploymentID)
+	if err != nil {
+		return errors.Wrap(err, "Searching for deployment by ID")
+	}
+
+	if deployment == nil {
+		return ErrModelDeploymentNotFound
+	}
+
+	if deployment.IsAborted() {
+		return ErrDeploymentAborted
+	}
+
+	if deployment.Finished != nil {
+		return ErrDeploymentFinished
+	}
+
+	seen := make(map[string]bool, len(devices))
+	deviceDeployments := make([]*model.DeviceDeployment, 0, len(devices))
+	for _, id := range devices {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+
+		has, err := d.db.HasDeploymentForDevice(ctx, deploymentID, id)
+		if err != nil {
+			return errors.Wrap(err, "Checking device deployment")
+		}
+		if has {
+			continue
+		}
+
+		deviceDeployment, err := model.NewDeviceDeployment(id, deploymentID)
+		if err != nil {
+			return errors.Wrap(err, "failed to create device deployment")
+		}
+
+		// keep the position of the deployment in the device's queue
+		deviceDeployment.Priority = deployment.Priority
+		deviceDeployment.DeploymentCreated = deployment.Created
+		deviceDeployments = append(deviceDeployments, deviceDeployment)
+	}
+
+	if len(deviceDeployments) == 0 {
+		return nil
+	}
+
+	// Update statistics cache first, this fails if the deployment has been
+	// finished in the meantime.
+	err = d.db.IncrementPendingStats(ctx, deploymentID, len(deviceDeployments))
+	if err == mongo.ErrStorageNotFound {
+		return ErrDeploymentFinished
+	} else if err != nil {
+		return errors.Wrap(err, "Updating deployment statistics")
+	}
+
+	if err := d.db.InsertMany(ctx, deviceDeployments...); err != nil {
+		if errCleanup := d.db.IncrementPendingStats(ctx,
+			deploymentID, -len(deviceDeployments)); errCleanup != nil {
+			err = errors.Wrap(err, errCleanup.Error())
+		}
+
+		return errors.Wrap(err, "Storing assigned deployments to devices")
+	}
+
+	if !deployment.IsAwaitingApproval() {
+		d.incrementDeploymentGeneration(ctx)
+		devices := make([]string, 0, len(deviceDeployments))
+		for _, dd := range deviceDeployments {
+			devices = append(devices, *dd.DeviceId)
+		}
+		d.notifyDevices(ctx, devices)
+	}
+
+	return nil
+}
+
 // IsDeploymentFinished checks if there is unfinished deployment with given ID
 func (d *Deployments) IsDeploymentFinished(ctx context.Context, deploymentID string) (bool, error) {
 
@@ -571,9 +790,263 @@ func (d *Deployments) GetDeployment(ctx context.Context,
 		return nil, errors.Wrap(err, "Searching for deployment by ID")
 	}
 
+	if deployment == nil {
+		return nil, nil
+	}
+
+	if err := d.resolveDependencyGraph(ctx, deployment); err != nil {
+		return nil, err
+	}
+
+	return deployment, nil
+}
+
+// EditDeployment replaces the description and labels of the deployment.
+// Returns false if the deployment does not exist.
+func (d *Deployments) EditDeployment(ctx context.Context, deploymentID string,
+	meta *model.DeploymentMetadataConstructor) (bool, error) {
+
+	if err := meta.Validate(); err != nil {
+		return false, errors.Wrap(err, "Validating deployment metadata")
+	}
+
+	err := d.db.UpdateDeploymentMetadata(ctx, deploymentID, *meta)
+	if err == mongo.ErrStorageNotFound {
+		return false, nil
+	} else if err != nil {
+		return false, errors.Wrap(err, "Updating deployment metadata")
+	}
+
+	return true, nil
+}
+
+// DecideDeploymentApproval approves or rejects the deployment awaiting
+// approval on behalf of given user, who has to be different from the one
+// who created the deployment. Rejected deployments are aborted.
+func (d *Deployments) DecideDeploymentApproval(ctx context.Context, deploymentID string,
+	userID string, status string) error {
+
+	decision := model.DeploymentApprovalDecision{Status: status}
+	if err := decision.Validate(); err != nil {
+		return err
+	}
+
+	deployment, err := d.db.FindDeploymentByID(ctx, deploymentID)
+	if err != nil {
+		return errors.Wrap(err, "Searching for deployment by ID")
+	}
+
+	if deployment == nil {
+		return ErrModelDeploymentNotFound
+	}
+
+	if !deployment.IsAwaitingApproval() || deployment.Finished != nil {
+		return ErrNotAwaitingApproval
+	}
+
+	if userID == "" || userID == deployment.Approval.RequestedBy {
+		return ErrApprovalBySameUser
+	}
+
+	now := time.Now()
+	approval := *deployment.Approval
+	approval.Status = status
+	approval.DecidedBy = userID
+	approval.Decided = &now
+
+	err = d.db.UpdateDeploymentApproval(ctx, deploymentID, approval)
+	if err == mongo.ErrStorageNotFound {
+		// decided in the meantime
+		return ErrNotAwaitingApproval
+	} else if err != nil {
+		return errors.Wrap(err, "Updating deployment approval")
+	}
+
+	if status == model.ApprovalStatusRejected {
+		if err := d.AbortDeployment(ctx, deploymentID); err != nil {
+			return errors.Wrap(err, "Aborting rejected deployment")
+		}
+		return nil
+	}
+
+	d.incrementDeploymentGeneration(ctx)
+
+	devices := []string{}
+	err = d.db.IterateDeviceDeploymentsForDeployment(ctx, deploymentID,
+		func(dd *model.DeviceDeployment) error {
+			devices = append(devices, *dd.DeviceId)
+			return nil
+		})
+	if err != nil {
+		return errors.Wrap(err, "Searching for devices of the deployment")
+	}
+	d.notifyDevices(ctx, devices)
+
+	return nil
+}
+
+// ContinueDeployment lets all devices of the deployment, current and future
+// ones, go on past the pause point
+func (d *Deployments) ContinueDeployment(ctx context.Context, deploymentID string,
+	pausePoint string) error {
+
+	if _, err := d.findPausingDeployment(ctx, deploymentID, pausePoint); err != nil {
+		return err
+	}
+
+	err := d.db.AddDeploymentContinued(ctx, deploymentID, pausePoint)
+	if err == mongo.ErrStorageNotFound {
+		return ErrModelDeploymentNotFound
+	} else if err != nil {
+		return errors.Wrap(err, "Continuing deployment")
+	}
+
+	return nil
+}
+
+// ContinueDeviceDeployment lets the device go on past the pause point
+// of the deployment
+func (d *Deployments) ContinueDeviceDeployment(ctx context.Context, deploymentID string,
+	deviceID string, pausePoint string) error {
+
+	if _, err := d.findPausingDeployment(ctx, deploymentID, pausePoint); err != nil {
+		return err
+	}
+
+	dd, err := d.db.FindDeviceDeployment(ctx, deploymentID, deviceID)
+	if err != nil {
+		return errors.Wrap(err, "Searching for device deployment")
+	}
+	if dd == nil {
+		return ErrModelDeploymentNotFound
+	}
+	if model.IsDeviceDeploymentStatusFinished(*dd.Status) {
+		return ErrDeploymentFinished
+	}
+
+	err = d.db.AddDeviceDeploymentContinued(ctx, deploymentID, deviceID, pausePoint)
+	if err == mongo.ErrStorageNotFound {
+		return ErrModelDeploymentNotFound
+	} else if err != nil {
+		return errors.Wrap(err, "Continuing device deployment")
+	}
+
+	return nil
+}
+
+// findPausingDeployment returns the unfinished deployment if its devices
+// wait at the pause point
+func (d *Deployments) findPausingDeployment(ctx context.Context, deploymentID string,
+	pausePoint string) (*model.Deployment, error) {
+
+	if err := (model.PauseContinuation{PausePoint: pausePoint}).Validate(); err != nil {
+		return nil, err
+	}
+
+	deployment, err := d.db.FindDeploymentByID(ctx, deploymentID)
+	if err != nil {
+		return nil, errors.Wrap(err, "Searching for deployment by ID")
+	}
+	if deployment == nil {
+		return nil, ErrModelDeploymentNotFound
+	}
+	if deployment.Finished != nil {
+		return nil, ErrDeploymentFinished
+	}
+	if !deployment.PausesAt(pausePoint) {
+		return nil, ErrNotPausing
+	}
+
 	return deployment, nil
 }
 
+// IsDeviceDeploymentContinued checks if the device may go on past the pause
+// point, either continued on its own or with the whole deployment; devices
+// never wait at points the deployment doesn't pause at
+func (d *Deployments) IsDeviceDeploymentContinued(ctx context.Context, deploymentID string,
+	deviceID string, pausePoint string) (bool, error) {
+
+	deployment, err := d.db.FindDeploymentByID(ctx, deploymentID)
+	if err != nil {
+		return false, errors.Wrap(err, "Searching for deployment by ID")
+	}
+	if deployment == nil {
+		return false, ErrModelDeploymentNotFound
+	}
+	if !deployment.PausesAt(pausePoint) || deployment.IsContinued(pausePoint) {
+		return true, nil
+	}
+
+	dd, err := d.db.FindDeviceDeployment(ctx, deploymentID, deviceID)
+	if err != nil {
+		return false, errors.Wrap(err, "Searching for device deployment")
+	}
+	if dd == nil {
+		return false, ErrModelDeploymentNotFound
+	}
+
+	return dd.IsContinued(pausePoint), nil
+}
+
+// resolveDependencyGraph fills in the direct predecessors and dependents
+// of the deployment
+func (d *Deployments) resolveDependencyGraph(ctx context.Context,
+	deployment *model.Deployment) error {
+
+	for _, id := range deployment.DependsOn {
+		predecessor, err := d.db.FindDeploymentByID(ctx, id)
+		if err != nil {
+			return errors.Wrap(err, "Searching for deployment dependency")
+		}
+		if predecessor == nil {
+			continue
+		}
+
+		deployment.Dependencies = append(deployment.Dependencies,
+			model.NewDeploymentDependency(predecessor, deployment))
+	}
+
+	dependents, err := d.db.FindDependentDeployments(ctx, *deployment.Id)
+	if err != nil {
+		return errors.Wrap(err, "Searching for dependent deployments")
+	}
+
+	for _, dependent := range dependents {
+		deployment.Dependents = append(deployment.Dependents,
+			model.NewDeploymentDependency(deployment, dependent))
+	}
+
+	return nil
+}
+
+// isDeploymentEligible checks if all predecessors of the deployment have
+// finished with the required success ratio. Also returns the ID of the first
+// predecessor which can't satisfy the dependency anymore, because it has
+// finished below the success ratio or doesn't exist.
+func (d *Deployments) isDeploymentEligible(ctx context.Context,
+	deployment *model.Deployment) (bool, string, error) {
+
+	eligible := true
+	for _, id := range deployment.DependsOn {
+		predecessor, err := d.db.FindDeploymentByID(ctx, id)
+		if err != nil {
+			return false, "", errors.Wrap(err, "Searching for deployment dependency")
+		}
+		if predecessor == nil {
+			return false, id, nil
+		}
+		if !deployment.IsDependencySatisfied(predecessor) {
+			// the success ratio of a finished predecessor won't change
+			if predecessor.Finished != nil {
+				return false, id, nil
+			}
+			eligible = false
+		}
+	}
+
+	return eligible, "", nil
+}
+
 // ImageUsedInActiveDeployment checks if specified image is in use by deployments
 // Image is considered to be in use if it's participating in at lest one non success/error deployment.
 func (d *Deployments) ImageUsedInActiveDeployment(ctx context.Context,
@@ -680,76 +1153,412 @@ func (d *Deployments) assignArtifact(
 	return nil
 }
 
-// GetDeploymentForDeviceWithCurrent returns deployment for the device
-func (d *Deployments) GetDeploymentForDeviceWithCurrent(ctx context.Context, deviceID string,
-	installed model.InstalledDeviceDeployment) (*model.DeploymentInstructions, error) {
+// WaitForDeploymentForDevice returns deployment for the device like
+// GetDeploymentForDeviceWithCurrent, if there's none it waits up to the given
+// time for a new deployment to be created for the device
+func (d *Deployments) WaitForDeploymentForDevice(ctx context.Context, deviceID string,
+	installed model.InstalledDeviceDeployment,
+	wait time.Duration) (*model.DeploymentInstructions, error) {
 
-	deviceDeployment, err := d.db.FindOldestDeploymentForDeviceIDWithStatuses(
-		ctx,
-		deviceID,
-		model.ActiveDeploymentStatuses()...)
+	tenant := ""
+	if id := identity.FromContext(ctx); id != nil {
+		tenant = id.Tenant
+	}
 
-	if err != nil {
-		return nil, errors.Wrap(err, "Searching for oldest active deployment for the device")
+	// subscribe first, not to miss deployments created during the check
+	notifications, cancel := d.notifier.subscribe(tenant, deviceID)
+	defer cancel()
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	for {
+		deployment, err := d.GetDeploymentForDeviceWithCurrent(ctx, deviceID, installed)
+		if err != nil || deployment != nil {
+			return deployment, err
+		}
+
+		select {
+		case <-notifications:
+		case <-timer.C:
+			return nil, nil
+		case <-ctx.Done():
+			return nil, nil
+		}
 	}
+}
 
-	if deviceDeployment == nil {
-		return nil, nil
+// GetDeploymentGeneration returns the generation of deployments; deployments
+// offered to devices don't change unless the generation changes
+func (d *Deployments) GetDeploymentGeneration(ctx context.Context) (int64, error) {
+	return d.db.GetDeploymentGeneration(ctx)
+}
+
+// incrementDeploymentGeneration invalidates the generation of deployments
+// known to devices, after deployments offered to devices might have changed
+func (d *Deployments) incrementDeploymentGeneration(ctx context.Context) {
+	// the change is already stored, devices with an outdated generation
+	// are served normally after the next change
+	if err := d.db.IncrementDeploymentGeneration(ctx); err != nil {
+		log.FromContext(ctx).Errorf("failed to increment deployment generation: %v", err)
 	}
+}
 
-	deployment, err := d.db.FindDeploymentByID(ctx, *deviceDeployment.DeploymentId)
-	if err != nil {
-		return nil, ErrModelInternal
+// notifyDevices wakes up the devices waiting for a new deployment,
+// in this and other instances of the service
+func (d *Deployments) notifyDevices(ctx context.Context, deviceIDs []string) {
+	if len(deviceIDs) == 0 {
+		return
 	}
 
-	if deployment == nil {
-		return nil, nil
+	tenant := ""
+	if id := identity.FromContext(ctx); id != nil {
+		tenant = id.Tenant
 	}
 
-	if installed.Artifact != "" && *deployment.ArtifactName == installed.Artifact {
-		// pretend there is no deployment for this device, but update
-		// its status to already installed first
+	d.notifier.notify(tenant, deviceIDs...)
 
-		if err := d.UpdateDeviceDeploymentStatus(ctx, *deviceDeployment.DeploymentId, deviceID,
-			model.DeviceDeploymentStatus{
-				Status: model.DeviceDeploymentStatusAlreadyInst,
-			}); err != nil {
+	if atomic.LoadInt32(&d.notificationsWatched) == 0 {
+		return
+	}
 
-			return nil, errors.Wrap(err, "Failed to update deployment status")
+	// devices are woken up at the end of the wait if the notification
+	// doesn't get through, the deployment is already stored
+	for start := 0; start < len(deviceIDs); start += model.MaxDeviceNotificationDevices {
+		end := start + model.MaxDeviceNotificationDevices
+		if end > len(deviceIDs) {
+			end = len(deviceIDs)
 		}
 
-		return nil, nil
+		err := d.db.InsertDeviceNotification(ctx, model.DeviceNotification{
+			Origin:    d.instanceID,
+			Tenant:    tenant,
+			DeviceIDs: deviceIDs[start:end],
+		})
+		if err != nil {
+			log.FromContext(ctx).Errorf("failed to send device notification: %v", err)
+			return
+		}
 	}
+}
 
-	// assign artifact only if the artifact was not assigned previously or the device type has changed
-	if deviceDeployment.Image == nil || deviceDeployment.DeviceType == nil || *deviceDeployment.DeviceType != installed.DeviceType {
-		if err := d.assignArtifact(ctx, deployment, deviceDeployment, installed); err != nil {
-			return nil, err
+// RunDeviceNotificationsWatcher exchanges notifications of new deployments
+// with other instances of the service, until the context is cancelled.
+// Devices waiting for a deployment in this instance are woken up when another
+// instance creates one for them.
+func (d *Deployments) RunDeviceNotificationsWatcher(ctx context.Context, retryInterval time.Duration) {
+	l := log.FromContext(ctx)
+
+	for {
+		err := d.db.EnsureDeviceNotificationsCollection(ctx)
+		if err == nil {
+			break
 		}
-	}
+		l.Errorf("failed to set up device notifications: %v", err)
 
-	if deviceDeployment.Image == nil {
-		return nil, nil
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(retryInterval):
+		}
 	}
 
-	link, err := d.fileStorage.GetRequest(ctx, deviceDeployment.Image.Id,
-		DefaultUpdateDownloadLinkExpire, d.imageContentType)
-	if err != nil {
+	atomic.StoreInt32(&d.notificationsWatched, 1)
+
+	for {
+		err := d.db.WatchDeviceNotifications(ctx, d.handleDeviceNotification)
+		if err != nil {
+			l.Errorf("failed to watch device notifications: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(retryInterval):
+		}
+	}
+}
+
+func (d *Deployments) handleDeviceNotification(notification model.DeviceNotification) {
+	// local devices were woken up already
+	if notification.Origin == d.instanceID {
+		return
+	}
+
+	d.notifier.notify(notification.Tenant, notification.DeviceIDs...)
+}
+
+// GetDeploymentForDeviceWithCurrent returns deployment for the device
+func (d *Deployments) GetDeploymentForDeviceWithCurrent(ctx context.Context, deviceID string,
+	installed model.InstalledDeviceDeployment) (*model.DeploymentInstructions, error) {
+
+	deviceDeployment, deployment, err := d.findNextDeviceDeployment(ctx, deviceID)
+	if err != nil {
+		return nil, err
+	}
+
+	if deviceDeployment == nil || deployment == nil {
+		return nil, nil
+	}
+
+	if deployment.Supersede {
+		if err := d.supersedeDeviceDeployments(ctx, deployment, deviceID); err != nil {
+			return nil, err
+		}
+	}
+
+	if !deployment.Force &&
+		installed.Artifact != "" && *deployment.ArtifactName == installed.Artifact {
+		// pretend there is no deployment for this device, but update
+		// its status to already installed first
+
+		if err := d.UpdateDeviceDeploymentStatus(ctx, *deviceDeployment.DeploymentId, deviceID,
+			model.DeviceDeploymentStatus{
+				Status: model.DeviceDeploymentStatusAlreadyInst,
+			}); err != nil {
+
+			return nil, errors.Wrap(err, "Failed to update deployment status")
+		}
+
+		return nil, nil
+	}
+
+	// assign artifact only if the artifact was not assigned previously or the device type has changed
+	if deviceDeployment.Image == nil || deviceDeployment.DeviceType == nil || *deviceDeployment.DeviceType != installed.DeviceType {
+		if err := d.assignArtifact(ctx, deployment, deviceDeployment, installed); err != nil {
+			return nil, err
+		}
+	}
+
+	if deviceDeployment.Image == nil {
+		return nil, nil
+	}
+
+	if *deviceDeployment.Status == model.DeviceDeploymentStatusPending &&
+		deployment.MaxDevicesInFlight > 0 {
+		// the device can start only if there is a free slot in the deployment
+		claimed, err := d.claimDeviceSlot(ctx, deployment, deviceID)
+		if err != nil {
+			return nil, err
+		}
+		if !claimed {
+			return nil, nil
+		}
+	}
+
+	link, err := d.fileStorage.GetRequest(ctx, deviceDeployment.Image.Id,
+		DefaultUpdateDownloadLinkExpire, d.imageContentType)
+	if err != nil {
 		return nil, errors.Wrap(err, "Generating download link for the device")
 	}
 
+	sources, err := d.getArtifactSources(ctx, deviceDeployment.Image.Id, link, installed)
+	if err != nil {
+		return nil, err
+	}
+
 	instructions := &model.DeploymentInstructions{
 		ID: *deviceDeployment.DeploymentId,
 		Artifact: model.ArtifactDeploymentInstructions{
 			ArtifactName:          deviceDeployment.Image.Name,
 			Source:                *link,
 			DeviceTypesCompatible: deviceDeployment.Image.DeviceTypesCompatible,
+			Sources:               sources,
 		},
+		PauseBefore: deployment.PauseBefore,
 	}
 
 	return instructions, nil
 }
 
+// findNextDeviceDeployment returns the device deployment the device should
+// work on with its deployment: the one in flight, otherwise the first pending
+// one which can be started.
+func (d *Deployments) findNextDeviceDeployment(ctx context.Context,
+	deviceID string) (*model.DeviceDeployment, *model.Deployment, error) {
+
+	deviceDeployment, err := d.db.FindOldestDeploymentForDeviceIDWithStatuses(
+		ctx,
+		deviceID,
+		model.ActiveDeploymentStatuses()...)
+
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "Searching for oldest active deployment for the device")
+	}
+
+	if deviceDeployment == nil {
+		return nil, nil, nil
+	}
+
+	if *deviceDeployment.Status != model.DeviceDeploymentStatusPending {
+		deployment, err := d.db.FindDeploymentByID(ctx, *deviceDeployment.DeploymentId)
+		if err != nil {
+			return nil, nil, ErrModelInternal
+		}
+		return deviceDeployment, deployment, nil
+	}
+
+	deployment, err := d.startablePendingDeployment(ctx, deviceDeployment)
+	if err != nil || deployment != nil {
+		return deviceDeployment, deployment, err
+	}
+
+	// the deployment is held back, later deployments of the device
+	// can go ahead in the meantime
+	pending, err := d.db.FindAllDeploymentsForDeviceIDWithStatuses(ctx,
+		deviceID, model.DeviceDeploymentStatusPending)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "Searching for pending deployments for the device")
+	}
+
+	for i := range pending {
+		if *pending[i].DeploymentId == *deviceDeployment.DeploymentId {
+			continue
+		}
+
+		deployment, err := d.startablePendingDeployment(ctx, &pending[i])
+		if err != nil || deployment != nil {
+			return &pending[i], deployment, err
+		}
+	}
+
+	return nil, nil, nil
+}
+
+// startablePendingDeployment returns the deployment of the pending device
+// deployment, or nil if the deployment is held back until it is approved
+// or its predecessors are done. The device deployment is aborted if one of
+// the predecessors can't satisfy the dependency anymore.
+func (d *Deployments) startablePendingDeployment(ctx context.Context,
+	deviceDeployment *model.DeviceDeployment) (*model.Deployment, error) {
+
+	deployment, err := d.db.FindDeploymentByID(ctx, *deviceDeployment.DeploymentId)
+	if err != nil {
+		return nil, ErrModelInternal
+	}
+
+	if deployment == nil || deployment.IsAwaitingApproval() {
+		return nil, nil
+	}
+
+	eligible, unsatisfiable, err := d.isDeploymentEligible(ctx, deployment)
+	if err != nil {
+		return nil, err
+	}
+
+	if unsatisfiable != "" {
+		subState := "dependency on deployment " + unsatisfiable + " can't be satisfied"
+		err := d.UpdateDeviceDeploymentStatus(ctx, *deployment.Id,
+			*deviceDeployment.DeviceId, model.DeviceDeploymentStatus{
+				Status:   model.DeviceDeploymentStatusAborted,
+				SubState: &subState,
+			})
+		// the deployment might have been aborted in the meantime
+		if err != nil && err != ErrDeploymentAborted && err != ErrDeviceDecommissioned {
+			return nil, errors.Wrap(err, "Aborting device deployment with unsatisfiable dependency")
+		}
+		return nil, nil
+	}
+
+	if !eligible {
+		return nil, nil
+	}
+
+	return deployment, nil
+}
+
+// getArtifactSources returns the links to the artifact on the mirrors matching
+// the device followed by the primary link
+func (d *Deployments) getArtifactSources(ctx context.Context, artifactID string,
+	primary *model.Link, installed model.InstalledDeviceDeployment) ([]model.Link, error) {
+
+	settings, err := d.db.GetSettings(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "Getting mirrors")
+	}
+
+	sources := []model.Link{}
+	for _, m := range settings.Mirrors {
+		if m.Matches(installed) {
+			sources = append(sources, *m.Link(artifactID, primary.Expire))
+		}
+	}
+
+	return append(sources, *primary), nil
+}
+
+// supersedeDeviceDeployments aborts pending deployments for the device
+// with priority lower than the one of given deployment.
+func (d *Deployments) supersedeDeviceDeployments(ctx context.Context,
+	deployment *model.Deployment, deviceID string) error {
+
+	pending, err := d.db.FindAllDeploymentsForDeviceIDWithStatuses(ctx,
+		deviceID, model.DeviceDeploymentStatusPending)
+	if err != nil {
+		return errors.Wrap(err, "Searching for pending deployments for the device")
+	}
+
+	subState := "superseded by deployment " + *deployment.Id
+	for _, dd := range pending {
+		if dd.Priority >= deployment.Priority {
+			continue
+		}
+
+		err := d.UpdateDeviceDeploymentStatus(ctx, *dd.DeploymentId, deviceID,
+			model.DeviceDeploymentStatus{
+				Status:   model.DeviceDeploymentStatusAborted,
+				SubState: &subState,
+			})
+		// the deployment might have been aborted in the meantime
+		if err != nil && err != ErrDeploymentAborted && err != ErrDeviceDecommissioned {
+			return errors.Wrap(err, "Superseding device deployment")
+		}
+	}
+
+	return nil
+}
+
+// claimDeviceSlot moves a pending device deployment to downloading if the
+// number of devices in flight is below the deployment limit.
+// Returns false if the limit has been reached, or the device deployment
+// is not pending anymore.
+func (d *Deployments) claimDeviceSlot(ctx context.Context,
+	deployment *model.Deployment, deviceID string) (bool, error) {
+
+	// the slot is reserved first, so that the limit holds for concurrent
+	// claims; both updates are conditional
+	claimed, err := d.db.UpdateStatsWithinLimit(ctx, *deployment.Id,
+		model.DeviceDeploymentStatusPending,
+		model.DeviceDeploymentStatusDownloading,
+		deployment.MaxDevicesInFlight)
+	if err != nil {
+		return false, errors.Wrap(err, "Claiming deployment slot for the device")
+	}
+	if !claimed {
+		return false, nil
+	}
+
+	moved, err := d.db.UpdateDeviceDeploymentStatusFrom(ctx, deviceID, *deployment.Id,
+		model.DeviceDeploymentStatusPending,
+		model.DeviceDeploymentStatus{
+			Status: model.DeviceDeploymentStatusDownloading,
+		})
+	if err != nil || !moved {
+		// the device left the pending state in the meantime (concurrent
+		// request, status report, abort or decommission), give the slot back
+		if err := d.db.UpdateStats(ctx, *deployment.Id,
+			model.DeviceDeploymentStatusDownloading,
+			model.DeviceDeploymentStatusPending); err != nil {
+			return false, errors.Wrap(err, "Releasing deployment slot")
+		}
+	}
+	if err != nil {
+		return false, errors.Wrap(err, "Updating device deployment status")
+	}
+
+	return moved, nil
+}
+
 // UpdateDeviceDeploymentStatus will update the deployment status for device of
 // ID `deviceID`. Returns nil if update was successful.
 func (d *Deployments) UpdateDeviceDeploymentStatus(ctx context.Context, deploymentID string,
@@ -793,7 +1602,18 @@ func (d *Deployments) UpdateDeviceDeploymentStatus(ctx context.Context, deployme
 		return err
 	}
 
-	if err = d.db.UpdateStats(ctx, deploymentID, old, ddStatus.Status); err != nil {
+	return d.deviceDeploymentStatusUpdated(ctx, deploymentID, deviceID, old, ddStatus)
+}
+
+// deviceDeploymentStatusUpdated updates the deployment after the status of
+// the device deployment changed from the old one
+func (d *Deployments) deviceDeploymentStatusUpdated(ctx context.Context,
+	deploymentID string, deviceID string, old string,
+	ddStatus model.DeviceDeploymentStatus) error {
+
+	l := log.FromContext(ctx)
+
+	if err := d.db.UpdateStats(ctx, deploymentID, old, ddStatus.Status); err != nil {
 		return err
 	}
 
@@ -812,9 +1632,153 @@ func (d *Deployments) UpdateDeviceDeploymentStatus(ctx context.Context, deployme
 		}
 	}
 
+	// the device might get its next deployment, or free a slot
+	// for other devices of the deployment
+	if model.IsDeviceDeploymentStatusFinished(ddStatus.Status) {
+		d.incrementDeploymentGeneration(ctx)
+	}
+
+	if ddStatus.Status == model.DeviceDeploymentStatusSuccess {
+		// the status is already stored, the installed base is updated
+		// with the next report of the device anyway
+		if err := d.recordInstalledDeployment(ctx, deviceID, deploymentID); err != nil {
+			l.Errorf("failed to record artifact installed on device %s in deployment %s: %v",
+				deviceID, deploymentID, err)
+		}
+	}
+
+	if ddStatus.Status == model.DeviceDeploymentStatusFailure {
+		// the status is already stored, the category is best effort
+		if err := d.classifyFailure(ctx, deviceID, deploymentID, ddStatus.SubState); err != nil {
+			l.Errorf("failed to classify failure of device %s in deployment %s: %v",
+				deviceID, deploymentID, err)
+		}
+	}
+
 	return nil
 }
 
+// classifyFailure assigns the failure category to the failed device
+// deployment, using the substate and the deployment log
+func (d *Deployments) classifyFailure(ctx context.Context, deviceID string,
+	deploymentID string, subState *string) error {
+
+	settings, err := d.db.GetSettings(ctx)
+	if err != nil {
+		return errors.Wrap(err, "getting settings")
+	}
+
+	classifier, err := model.NewFailureClassifier(settings.FailureRules)
+	if err != nil {
+		return err
+	}
+
+	dlog, err := d.db.GetDeviceDeploymentLog(ctx, deviceID, deploymentID)
+	if err != nil {
+		return errors.Wrap(err, "getting deployment log")
+	}
+
+	return d.db.UpdateDeviceDeploymentFailureCategory(ctx, deviceID, deploymentID,
+		classifier.Classify(subState, dlog))
+}
+
+// recordInstalledDeployment stores the artifact of the successful device
+// deployment as installed on the device
+func (d *Deployments) recordInstalledDeployment(ctx context.Context, deviceID string,
+	deploymentID string) error {
+
+	dd, err := d.db.FindDeviceDeployment(ctx, deploymentID, deviceID)
+	if err != nil {
+		return errors.Wrap(err, "searching for device deployment")
+	}
+
+	// the artifact is assigned when the device asks for the deployment
+	if dd == nil || dd.Image == nil || dd.DeviceType == nil {
+		return nil
+	}
+
+	return d.db.UpsertInstalledArtifact(ctx, &model.InstalledArtifact{
+		DeviceID:     deviceID,
+		ArtifactName: dd.Image.Name,
+		DeviceType:   *dd.DeviceType,
+		Updated:      time.Now(),
+	})
+}
+
+// UpdateInstalledArtifact stores the artifact the device reports as installed,
+// if it isn't the one known already
+func (d *Deployments) UpdateInstalledArtifact(ctx context.Context, deviceID string,
+	installed model.InstalledDeviceDeployment) error {
+
+	// devices report the artifact with every poll, it rarely changes
+	known, err := d.db.FindInstalledArtifact(ctx, deviceID)
+	if err != nil {
+		return errors.Wrap(err, "searching for installed artifact")
+	}
+	if known != nil && known.ArtifactName == installed.Artifact &&
+		known.DeviceType == installed.DeviceType {
+		return nil
+	}
+
+	err = d.db.UpsertInstalledArtifact(ctx, &model.InstalledArtifact{
+		DeviceID:     deviceID,
+		ArtifactName: installed.Artifact,
+		DeviceType:   installed.DeviceType,
+		Updated:      time.Now(),
+	})
+	if err != nil {
+		return errors.Wrap(err, "storing installed artifact")
+	}
+
+	return nil
+}
+
+// GetInstalledBase counts devices running each release and each artifact
+// of the release, only the given release if the name is not empty
+func (d *Deployments) GetInstalledBase(ctx context.Context,
+	artifactName string) ([]model.InstalledBaseRelease, error) {
+
+	releases, err := d.db.AggregateInstalledBase(ctx, artifactName)
+	if err != nil {
+		return nil, errors.Wrap(err, "counting installed artifacts")
+	}
+
+	return releases, nil
+}
+
+// GetInstalledArtifacts returns a page of devices running the artifact,
+// along with the number of all such devices
+func (d *Deployments) GetInstalledArtifacts(ctx context.Context,
+	query model.InstalledBaseQuery) ([]model.InstalledArtifact, int, error) {
+
+	if err := query.Validate(); err != nil {
+		return nil, 0, err
+	}
+
+	installed, total, err := d.db.GetInstalledArtifacts(ctx, query)
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "searching for installed artifacts")
+	}
+
+	return installed, total, nil
+}
+
+// UpdateDeviceDeploymentProgress stores the download progress reported by
+// the device. Progress of finished device deployments is ignored.
+func (d *Deployments) UpdateDeviceDeploymentProgress(ctx context.Context, deploymentID string,
+	deviceID string, progress model.DownloadProgress) error {
+
+	now := time.Now()
+	progress.Updated = &now
+
+	err := d.db.UpdateDeviceDeploymentProgress(ctx, deviceID, deploymentID, progress)
+	if err == mongo.ErrStorageNotFound {
+		return nil
+	}
+
+	return err
+}
+
 func (d *Deployments) GetDeploymentStats(ctx context.Context,
 	deploymentID string) (model.Stats, error) {
 
@@ -831,49 +1795,136 @@ func (d *Deployments) GetDeploymentStats(ctx context.Context,
 	return d.db.AggregateDeviceDeploymentByStatus(ctx, deploymentID)
 }
 
+// GetDeploymentProgressStats sums up the download progress reported by
+// devices of the deployment and counts failed devices per failure category.
+func (d *Deployments) GetDeploymentProgressStats(ctx context.Context,
+	deploymentID string) (*model.DeploymentProgressStats, error) {
+
+	deployment, err := d.db.FindDeploymentByID(ctx, deploymentID)
+	if err != nil {
+		return nil, errors.Wrap(err, "checking deployment id")
+	}
+
+	if deployment == nil {
+		return nil, nil
+	}
+
+	progress, err := d.db.AggregateDeviceDeploymentProgress(ctx, deploymentID,
+		time.Now())
+	if err != nil {
+		return nil, errors.Wrap(err, "retrieving download progress")
+	}
+
+	out := &model.DeploymentProgressStats{
+		Download: *progress,
+		Failures: map[string]int{},
+	}
+
+	if deployment.Stats[model.DeviceDeploymentStatusFailure] > 0 {
+		out.Failures, err = d.db.AggregateDeviceDeploymentByFailureCategory(ctx,
+			deploymentID)
+		if err != nil {
+			return nil, errors.Wrap(err, "counting failure categories")
+		}
+	}
+
+	return out, nil
+}
+
+// GetDeploymentDurationStats computes statistics of time devices spent
+// downloading, installing and rebooting in the given deployment.
+func (d *Deployments) GetDeploymentDurationStats(ctx context.Context,
+	deploymentID string) (*model.DeploymentDurationStats, error) {
+
+	deployment, err := d.db.FindDeploymentByID(ctx, deploymentID)
+	if err != nil {
+		return nil, errors.Wrap(err, "checking deployment id")
+	}
+
+	if deployment == nil {
+		return nil, nil
+	}
+
+	durations, err := d.db.AggregateDeviceDeploymentStateDurations(ctx, deploymentID)
+	if err != nil {
+		return nil, errors.Wrap(err, "aggregating state durations")
+	}
+
+	return model.NewDeploymentDurationStats(durations), nil
+}
+
 //GetDeviceStatusesForDeployment retrieve device deployment statuses for a given deployment.
+// Returns a page of devices matching the query and the number of all matching devices.
 func (d *Deployments) GetDeviceStatusesForDeployment(ctx context.Context,
-	deploymentID string) ([]model.DeviceDeployment, error) {
+	query model.DeploymentDevicesQuery) ([]model.DeviceDeployment, int, error) {
 
-	deployment, err := d.db.FindDeploymentByID(ctx, deploymentID)
+	deployment, err := d.db.FindDeploymentByID(ctx, query.DeploymentID)
 	if err != nil {
-		return nil, ErrModelInternal
+		return nil, 0, ErrModelInternal
 	}
 
 	if deployment == nil {
-		return nil, ErrModelDeploymentNotFound
+		return nil, 0, ErrModelDeploymentNotFound
 	}
 
-	statuses, err := d.db.GetDeviceStatusesForDeployment(ctx, deploymentID)
+	statuses, total, err := d.db.GetDevicesListForDeployment(ctx, query)
 	if err != nil {
-		return nil, ErrModelInternal
+		return nil, 0, ErrModelInternal
 	}
 
-	return statuses, nil
+	return statuses, total, nil
+}
+
+// GenerateDeploymentReport calls fn with a report row for each device of
+// the deployment, including up to logLines last lines of the device's
+// deployment log. Returns ErrModelDeploymentNotFound before producing any
+// rows if the deployment does not exist.
+func (d *Deployments) GenerateDeploymentReport(ctx context.Context, deploymentID string,
+	logLines int, fn func(row *model.DeploymentReportRow) error) error {
+
+	deployment, err := d.db.FindDeploymentByID(ctx, deploymentID)
+	if err != nil {
+		return errors.Wrap(err, "checking deployment id")
+	}
+
+	if deployment == nil {
+		return ErrModelDeploymentNotFound
+	}
+
+	return d.db.IterateDeviceDeploymentsForDeployment(ctx, deploymentID,
+		func(dd *model.DeviceDeployment) error {
+			row := model.NewDeploymentReportRow(dd)
+
+			if logLines > 0 && dd.IsLogAvailable {
+				log, err := d.db.GetDeviceDeploymentLog(ctx, *dd.DeviceId, deploymentID)
+				if err != nil {
+					return errors.Wrap(err, "retrieving deployment log")
+				}
+				row.SetLog(log, logLines)
+			}
+
+			return fn(row)
+		})
 }
 
 func (d *Deployments) LookupDeployment(ctx context.Context,
-	query model.Query) ([]*model.Deployment, error) {
+	query model.Query) ([]*model.Deployment, int, error) {
 	list, err := d.db.Find(ctx, query)
 
 	if err != nil {
-		return nil, errors.Wrap(err, "searching for deployments")
+		return nil, 0, errors.Wrap(err, "searching for deployments")
 	}
 
 	if list == nil {
-		return make([]*model.Deployment, 0), nil
+		list = make([]*model.Deployment, 0)
 	}
 
-	for _, deployment := range list {
-		if deviceCount, err := d.db.DeviceCountByDeployment(ctx,
-			*deployment.Id); err != nil {
-			return nil, errors.Wrap(err, "counting device deployments")
-		} else {
-			deployment.DeviceCount = deviceCount
-		}
+	total, err := d.db.CountDeployments(ctx, query)
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "counting deployments")
 	}
 
-	return list, nil
+	return list, total, nil
 }
 
 // SaveDeviceDeploymentLog will save the deployment log for device of
@@ -881,6 +1932,23 @@ func (d *Deployments) LookupDeployment(ctx context.Context,
 func (d *Deployments) SaveDeviceDeploymentLog(ctx context.Context, deviceID string,
 	deploymentID string, logs []model.LogMessage) error {
 
+	return d.storeDeviceDeploymentLog(ctx, deviceID, deploymentID, logs,
+		d.db.SaveDeviceDeploymentLog)
+}
+
+// AppendDeviceDeploymentLog will add the messages to the deployment log for
+// device of ID `deviceID`, keeping the messages uploaded before.
+func (d *Deployments) AppendDeviceDeploymentLog(ctx context.Context, deviceID string,
+	deploymentID string, logs []model.LogMessage) error {
+
+	return d.storeDeviceDeploymentLog(ctx, deviceID, deploymentID, logs,
+		d.db.AppendDeviceDeploymentLog)
+}
+
+func (d *Deployments) storeDeviceDeploymentLog(ctx context.Context, deviceID string,
+	deploymentID string, logs []model.LogMessage,
+	save func(context.Context, model.DeploymentLog) error) error {
+
 	// repack to temporary deployment log and validate
 	dlog := model.DeploymentLog{
 		DeviceID:     deviceID,
@@ -899,12 +1967,30 @@ func (d *Deployments) SaveDeviceDeploymentLog(ctx context.Context, deviceID stri
 		}
 	}
 
-	if err := d.db.SaveDeviceDeploymentLog(ctx, dlog); err != nil {
+	if err := save(ctx, dlog); err != nil {
 		return err
 	}
 
-	return d.db.UpdateDeviceDeploymentLogAvailability(ctx,
+	err := d.db.UpdateDeviceDeploymentLogAvailability(ctx,
 		deviceID, deploymentID, true)
+	if err != nil {
+		return err
+	}
+
+	// the log might arrive after the failure was reported
+	dd, err := d.db.FindDeviceDeployment(ctx, deploymentID, deviceID)
+	if err != nil {
+		return errors.Wrap(err, "searching for device deployment")
+	}
+	if dd != nil && dd.Status != nil &&
+		*dd.Status == model.DeviceDeploymentStatusFailure {
+		if err := d.classifyFailure(ctx, deviceID, deploymentID, dd.SubState); err != nil {
+			log.FromContext(ctx).Errorf("failed to classify failure of device %s "+
+				"in deployment %s: %v", deviceID, deploymentID, err)
+		}
+	}
+
+	return nil
 }
 
 func (d *Deployments) GetDeviceDeploymentLog(ctx context.Context,
@@ -914,6 +2000,29 @@ func (d *Deployments) GetDeviceDeploymentLog(ctx context.Context,
 		deviceID, deploymentID)
 }
 
+// SearchDeploymentLogs finds messages matching the query in the logs
+// of all devices
+func (d *Deployments) SearchDeploymentLogs(ctx context.Context,
+	query model.DeploymentLogQuery) ([]model.DeploymentLogMatch, error) {
+
+	if query.DeploymentID != "" {
+		deployment, err := d.db.FindDeploymentByID(ctx, query.DeploymentID)
+		if err != nil {
+			return nil, errors.Wrap(err, "checking deployment")
+		}
+		if deployment == nil {
+			return nil, ErrModelDeploymentNotFound
+		}
+	}
+
+	matches, err := d.db.SearchDeviceDeploymentLogs(ctx, query)
+	if err != nil {
+		return nil, errors.Wrap(err, "searching deployment logs")
+	}
+
+	return matches, nil
+}
+
 func (d *Deployments) HasDeploymentForDevice(ctx context.Context,
 	deploymentID string, deviceID string) (bool, error) {
 	return d.db.HasDeploymentForDevice(ctx, deploymentID, deviceID)
@@ -922,6 +2031,11 @@ func (d *Deployments) HasDeploymentForDevice(ctx context.Context,
 // AbortDeployment aborts deployment for devices and updates deployment stats
 func (d *Deployments) AbortDeployment(ctx context.Context, deploymentID string) error {
 
+	// recorded first, the deployment may finish while devices are aborted
+	if err := d.db.SetDeploymentAborted(ctx, deploymentID); err != nil {
+		return err
+	}
+
 	if err := d.db.AbortDeviceDeployments(ctx, deploymentID); err != nil {
 		return err
 	}
@@ -935,8 +2049,14 @@ func (d *Deployments) AbortDeployment(ctx context.Context, deploymentID string)
 	// Update deployment stats and finish deployment (set finished timestamp to current time)
 	// Aborted deployment is considered to be finished even if some devices are
 	// still processing this deployment.
-	return d.db.UpdateStatsAndFinishDeployment(ctx,
-		deploymentID, stats)
+	if err := d.db.UpdateStatsAndFinishDeployment(ctx,
+		deploymentID, stats); err != nil {
+		return err
+	}
+
+	d.incrementDeploymentGeneration(ctx)
+
+	return nil
 }
 
 func (d *Deployments) DecommissionDevice(ctx context.Context, deviceId string) error {
@@ -969,5 +2089,411 @@ func (d *Deployments) DecommissionDevice(ctx context.Context, deviceId string) e
 		}
 	}
 
+	// the device has no deployment anymore, and slots of the deployments
+	// might have been freed for other devices
+	d.incrementDeploymentGeneration(ctx)
+
+	// the device doesn't count to the installed base anymore
+	if err := d.db.DeleteInstalledArtifact(ctx, deviceId); err != nil {
+		return errors.Wrap(err, "removing installed artifact")
+	}
+
 	return nil
 }
+
+// GetDeviceDeploymentHistory returns deployments the device took part in,
+// newest first
+func (d *Deployments) GetDeviceDeploymentHistory(ctx context.Context,
+	query model.DeviceDeploymentsQuery) ([]model.DeviceDeploymentHistoryEntry, error) {
+
+	deviceDeployments, err := d.db.FindDeviceDeployments(ctx, query)
+	if err != nil {
+		return nil, errors.Wrap(err, "searching for device deployments")
+	}
+
+	ids := make([]string, 0, len(deviceDeployments))
+	for _, dd := range deviceDeployments {
+		if dd.DeploymentId != nil {
+			ids = append(ids, *dd.DeploymentId)
+		}
+	}
+
+	deployments, err := d.db.FindDeploymentsByIDs(ctx, ids)
+	if err != nil {
+		return nil, errors.Wrap(err, "searching for deployments")
+	}
+
+	byID := make(map[string]*model.Deployment, len(deployments))
+	for _, deployment := range deployments {
+		byID[*deployment.Id] = deployment
+	}
+
+	history := make([]model.DeviceDeploymentHistoryEntry, 0, len(deviceDeployments))
+	for i := range deviceDeployments {
+		dd := &deviceDeployments[i]
+
+		var deployment *model.Deployment
+		if dd.DeploymentId != nil {
+			deployment = byID[*dd.DeploymentId]
+		}
+
+		history = append(history, model.NewDeviceDeploymentHistoryEntry(dd, deployment))
+	}
+
+	return history, nil
+}
+
+// RunTimeoutSweeper periodically fails device deployments which exceeded
+// the state timeouts of their deployments, until the context is cancelled.
+func (d *Deployments) RunTimeoutSweeper(ctx context.Context, interval time.Duration) {
+	l := log.FromContext(ctx)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := d.SweepTimeouts(ctx); err != nil {
+				l.Errorf("failed to sweep device deployment timeouts: %v", err)
+			}
+		}
+	}
+}
+
+// SweepTimeouts fails device deployments which exceeded the state timeouts
+// of their deployments, for all tenants. Errors are logged and the sweep goes
+// on, the returned error counts them.
+func (d *Deployments) SweepTimeouts(ctx context.Context) error {
+	tenants, err := d.db.ListTenants(ctx)
+	if err != nil {
+		return errors.Wrap(err, "Listing tenants")
+	}
+
+	if len(tenants) == 0 {
+		return d.sweepTenantTimeouts(ctx)
+	}
+
+	var errs sweepErrors
+	for _, tenant := range tenants {
+		tctx := identity.WithContext(ctx, &identity.Identity{
+			Tenant: tenant,
+		})
+		if err := d.sweepTenantTimeouts(tctx); err != nil {
+			errs.add(ctx, errors.Wrapf(err, "Sweeping timeouts of tenant %s", tenant))
+		}
+	}
+
+	return errs.err()
+}
+
+func (d *Deployments) sweepTenantTimeouts(ctx context.Context) error {
+	l := log.FromContext(ctx)
+
+	deployments, err := d.db.FindUnfinishedWithTimeouts(ctx)
+	if err != nil {
+		return errors.Wrap(err, "Searching for deployments with timeouts")
+	}
+
+	now := time.Now()
+	subState := model.DeviceDeploymentSubStateTimeout
+
+	var errs sweepErrors
+	for _, deployment := range deployments {
+		for _, status := range model.InFlightDeploymentStatuses() {
+			timeout := deployment.Timeouts.ForStatus(status)
+			if timeout == 0 {
+				continue
+			}
+
+			stale, err := d.db.FindStaleDeviceDeployments(ctx,
+				*deployment.Id, status, now.Add(-timeout))
+			if err != nil {
+				errs.add(ctx, errors.Wrap(err, "Searching for stale device deployments"))
+				continue
+			}
+
+			for _, dd := range stale {
+				ddStatus := model.DeviceDeploymentStatus{
+					Status:     model.DeviceDeploymentStatusFailure,
+					SubState:   &subState,
+					FinishTime: &now,
+				}
+
+				// the device might have reported a new status, or the
+				// deployment might have been aborted in the meantime
+				failed, err := d.db.UpdateDeviceDeploymentStatusFrom(ctx,
+					*dd.DeviceId, *deployment.Id, status, ddStatus)
+				if err != nil {
+					errs.add(ctx, errors.Wrap(err, "Failing stale device deployment"))
+					continue
+				}
+				if !failed {
+					continue
+				}
+
+				l.Infof("Device %s exceeded %s timeout in deployment %s",
+					*dd.DeviceId, status, *deployment.Id)
+
+				// updates deployment stats and finishes it if needed
+				err = d.deviceDeploymentStatusUpdated(ctx, *deployment.Id,
+					*dd.DeviceId, status, ddStatus)
+				if err != nil {
+					errs.add(ctx, errors.Wrap(err, "Updating deployment of stale device"))
+				}
+			}
+		}
+	}
+
+	return errs.err()
+}
+
+// sweepErrors collects the errors of a sweep which goes on after failures
+type sweepErrors struct {
+	first error
+	count int
+}
+
+// add logs the error and keeps it if it's the first one
+func (e *sweepErrors) add(ctx context.Context, err error) {
+	log.FromContext(ctx).Error(err.Error())
+
+	if e.first == nil {
+		e.first = err
+	}
+	e.count++
+}
+
+// err returns nil if there were no errors, the first one with the
+// number of errors otherwise
+func (e *sweepErrors) err() error {
+	if e.first == nil {
+		return nil
+	}
+	if e.count == 1 {
+		return e.first
+	}
+	return errors.Wrapf(e.first, "%d errors, the first one", e.count)
+}
+
+// RunRetentionSweeper periodically removes deployment logs and device
+// deployments older than the retention settings of the tenants,
+// until the context is cancelled.
+func (d *Deployments) RunRetentionSweeper(ctx context.Context, interval time.Duration) {
+	l := log.FromContext(ctx)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := d.SweepRetention(ctx); err != nil {
+				l.Errorf("failed to sweep expired deployment data: %v", err)
+			}
+		}
+	}
+}
+
+// SweepRetention removes deployment logs and device deployments older than
+// the retention settings, archiving them first if configured, for all tenants.
+// Errors are logged and the sweep goes on, the returned error counts them.
+func (d *Deployments) SweepRetention(ctx context.Context) error {
+	tenants, err := d.db.ListTenants(ctx)
+	if err != nil {
+		return errors.Wrap(err, "Listing tenants")
+	}
+
+	if len(tenants) == 0 {
+		return d.sweepTenantRetention(ctx)
+	}
+
+	var errs sweepErrors
+	for _, tenant := range tenants {
+		tctx := identity.WithContext(ctx, &identity.Identity{
+			Tenant: tenant,
+		})
+		if err := d.sweepTenantRetention(tctx); err != nil {
+			errs.add(ctx, errors.Wrapf(err, "Sweeping expired data of tenant %s", tenant))
+		}
+	}
+
+	return errs.err()
+}
+
+func (d *Deployments) sweepTenantRetention(ctx context.Context) error {
+	settings, err := d.db.GetSettings(ctx)
+	if err != nil {
+		return errors.Wrap(err, "Getting settings")
+	}
+
+	retention := settings.Retention
+	now := time.Now()
+
+	var errs sweepErrors
+	if retention.LogDays > 0 {
+		err := d.expireDeviceDeploymentLogs(ctx,
+			now.AddDate(0, 0, -retention.LogDays), retention.Archive)
+		if err != nil {
+			errs.add(ctx, err)
+		}
+	}
+
+	if retention.DeviceDeploymentDays > 0 {
+		err := d.expireDeviceDeployments(ctx,
+			now.AddDate(0, 0, -retention.DeviceDeploymentDays), retention.Archive)
+		if err != nil {
+			errs.add(ctx, err)
+		}
+	}
+
+	return errs.err()
+}
+
+func (d *Deployments) expireDeviceDeploymentLogs(ctx context.Context,
+	before time.Time, archive bool) error {
+
+	l := log.FromContext(ctx)
+
+	for {
+		logs, err := d.db.FindExpiredDeviceDeploymentLogs(ctx, before, retentionBatchSize)
+		if err != nil {
+			return errors.Wrap(err, "Searching for expired deployment logs")
+		}
+
+		var errs sweepErrors
+		for _, dlog := range logs {
+			if err := d.expireDeviceDeploymentLog(ctx, dlog, archive); err != nil {
+				errs.add(ctx, errors.Wrapf(err, "Expiring log of device %s in deployment %s",
+					dlog.DeviceID, dlog.DeploymentID))
+				continue
+			}
+
+			l.Infof("Removed expired log of device %s in deployment %s",
+				dlog.DeviceID, dlog.DeploymentID)
+		}
+
+		// logs which failed would be found again, they are retried
+		// with the next sweep
+		if errs.count > 0 || len(logs) < retentionBatchSize {
+			return errs.err()
+		}
+	}
+}
+
+func (d *Deployments) expireDeviceDeploymentLog(ctx context.Context,
+	dlog model.DeploymentLog, archive bool) error {
+
+	if archive {
+		objectID := "archive/logs/" + dlog.DeploymentID + "/" + dlog.DeviceID + ".json.gz"
+		err := d.archive(ctx, objectID, model.ArchivedDeploymentLog{
+			DeviceID:     dlog.DeviceID,
+			DeploymentID: dlog.DeploymentID,
+			Messages:     dlog.Messages,
+		})
+		if err != nil {
+			return errors.Wrap(err, "Archiving deployment log")
+		}
+	}
+
+	// the device deployment might have been removed already
+	err := d.db.UpdateDeviceDeploymentLogAvailability(ctx,
+		dlog.DeviceID, dlog.DeploymentID, false)
+	if err != nil && err != mongo.ErrStorageNotFound {
+		return errors.Wrap(err, "Updating log availability")
+	}
+
+	err = d.db.DeleteDeviceDeploymentLog(ctx, dlog.DeviceID, dlog.DeploymentID)
+	if err != nil {
+		return errors.Wrap(err, "Removing deployment log")
+	}
+
+	return nil
+}
+
+func (d *Deployments) expireDeviceDeployments(ctx context.Context,
+	before time.Time, archive bool) error {
+
+	l := log.FromContext(ctx)
+
+	for {
+		deployments, err := d.db.FindDeploymentsWithExpiredDevices(ctx,
+			before, retentionBatchSize)
+		if err != nil {
+			return errors.Wrap(err, "Searching for deployments with expired devices")
+		}
+
+		var errs sweepErrors
+		removed := 0
+		for _, deployment := range deployments {
+			if err := d.expireDeploymentDevices(ctx, deployment, archive); err != nil {
+				errs.add(ctx, errors.Wrapf(err,
+					"Expiring device deployments of deployment %s", *deployment.Id))
+				continue
+			}
+
+			removed++
+			l.Infof("Removed expired device deployments of deployment %s",
+				*deployment.Id)
+		}
+
+		// tags computed from the removed device deployments are outdated
+		if removed > 0 {
+			d.incrementDeploymentGeneration(ctx)
+		}
+
+		// deployments which failed would be found again, they are
+		// retried with the next sweep
+		if errs.count > 0 || len(deployments) < retentionBatchSize {
+			return errs.err()
+		}
+	}
+}
+
+func (d *Deployments) expireDeploymentDevices(ctx context.Context,
+	deployment *model.Deployment, archive bool) error {
+
+	if archive {
+		devices := []*model.DeviceDeployment{}
+		err := d.db.IterateDeviceDeploymentsForDeployment(ctx, *deployment.Id,
+			func(dd *model.DeviceDeployment) error {
+				devices = append(devices, dd)
+				return nil
+			})
+		if err != nil {
+			return errors.Wrap(err, "Searching for device deployments")
+		}
+
+		objectID := "archive/deployments/" + *deployment.Id + "/devices.json.gz"
+		if err := d.archive(ctx, objectID, devices); err != nil {
+			return errors.Wrap(err, "Archiving device deployments")
+		}
+	}
+
+	err := d.db.DeleteDeviceDeploymentsForDeployment(ctx, *deployment.Id)
+	if err != nil && err != mongo.ErrStorageNotFound {
+		return errors.Wrap(err, "Removing device deployments")
+	}
+
+	return nil
+}
+
+// archive uploads the value as gzip compressed JSON to the file storage
+func (d *Deployments) archive(ctx context.Context, objectID string, v interface{}) error {
+	var buf bytes.Buffer
+
+	gz := gzip.NewWriter(&buf)
+	if err := json.NewEncoder(gz).Encode(v); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+
+	return d.fileStorage.UploadArtifact(ctx, objectID,
+		int64(buf.Len()), &buf, "application/gzip")
+}
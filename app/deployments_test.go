@@ -0,0 +1,2779 @@
+// Copyright 2019 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package app
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/mendersoftware/go-lib-micro/identity"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/mendersoftware/deployments/model"
+	fs_mocks "github.com/mendersoftware/deployments/s3/mocks"
+	"github.com/mendersoftware/deployments/store/mocks"
+	"github.com/mendersoftware/deployments/store/mongo"
+	. "github.com/mendersoftware/deployments/utils/pointers"
+)
+
+func contextMatcher() interface{} {
+	return mock.MatchedBy(func(_ context.Context) bool {
+		return true
+	})
+}
+
+func TestAddDevicesToDeployment(t *testing.T) {
+	deploymentID := "a108ae14-bb4e-455f-9b40-2ef4bab97bb7"
+	created := time.Now()
+
+	unfinished := &model.Deployment{
+		DeploymentConstructor: &model.DeploymentConstructor{},
+		Id:                    StringToPointer(deploymentID),
+		Created:               &created,
+		Stats:                 model.NewDeviceDeploymentStats(),
+	}
+
+	finished := &model.Deployment{
+		Id:       StringToPointer(deploymentID),
+		Created:  &created,
+		Finished: &created,
+		Stats:    model.NewDeviceDeploymentStats(),
+	}
+
+	abortedStats := model.NewDeviceDeploymentStats()
+	abortedStats[model.DeviceDeploymentStatusAborted] = 1
+	aborted := &model.Deployment{
+		Id:       StringToPointer(deploymentID),
+		Created:  &created,
+		Finished: &created,
+		Stats:    abortedStats,
+		Aborted:  true,
+	}
+
+	// a superseded device doesn't abort the deployment
+	withAbortedDevice := &model.Deployment{
+		DeploymentConstructor: &model.DeploymentConstructor{},
+		Id:                    StringToPointer(deploymentID),
+		Created:               &created,
+		Stats:                 abortedStats,
+	}
+
+	testCases := map[string]struct {
+		devices []string
+
+		deployment    *model.Deployment
+		deploymentErr error
+
+		hasDeployment map[string]bool
+
+		incrementCount int
+		incrementErr   error
+		insertErr      error
+
+		err error
+	}{
+		"ok": {
+			devices:        []string{"foo", "bar", "foo", "baz"},
+			deployment:     unfinished,
+			hasDeployment:  map[string]bool{"foo": false, "bar": true, "baz": false},
+			incrementCount: 2,
+		},
+		"ok, device of deployment aborted": {
+			devices:        []string{"foo"},
+			deployment:     withAbortedDevice,
+			hasDeployment:  map[string]bool{"foo": false},
+			incrementCount: 1,
+		},
+		"ok, all devices already in deployment": {
+			devices:       []string{"foo"},
+			deployment:    unfinished,
+			hasDeployment: map[string]bool{"foo": true},
+		},
+		"error, deployment not found": {
+			devices: []string{"foo"},
+			err:     ErrModelDeploymentNotFound,
+		},
+		"error, deployment lookup failed": {
+			devices:       []string{"foo"},
+			deploymentErr: errors.New("db error"),
+			err:           errors.New("Searching for deployment by ID: db error"),
+		},
+		"error, deployment finished": {
+			devices:    []string{"foo"},
+			deployment: finished,
+			err:        ErrDeploymentFinished,
+		},
+		"error, deployment aborted": {
+			devices:    []string{"foo"},
+			deployment: aborted,
+			err:        ErrDeploymentAborted,
+		},
+		"error, deployment finished concurrently": {
+			devices:        []string{"foo"},
+			deployment:     unfinished,
+			hasDeployment:  map[string]bool{"foo": false},
+			incrementCount: 1,
+			incrementErr:   mongo.ErrStorageNotFound,
+			err:            ErrDeploymentFinished,
+		},
+		"error, insert failed": {
+			devices:        []string{"foo"},
+			deployment:     unfinished,
+			hasDeployment:  map[string]bool{"foo": false},
+			incrementCount: 1,
+			insertErr:      errors.New("db error"),
+			err:            errors.New("Storing assigned deployments to devices: db error"),
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			db := mocks.DataStore{}
+
+			db.On("FindDeploymentByID", contextMatcher(), deploymentID).
+				Return(tc.deployment, tc.deploymentErr)
+
+			for device, has := range tc.hasDeployment {
+				db.On("HasDeploymentForDevice", contextMatcher(),
+					deploymentID, device).Return(has, nil)
+			}
+
+			if tc.incrementCount > 0 {
+				db.On("IncrementPendingStats", contextMatcher(),
+					deploymentID, tc.incrementCount).Return(tc.incrementErr)
+
+				if tc.incrementErr == nil {
+					db.On("InsertMany", contextMatcher(),
+						mock.MatchedBy(func(dds []*model.DeviceDeployment) bool {
+							for _, dd := range dds {
+								if *dd.DeploymentId != deploymentID ||
+									*dd.Status != model.DeviceDeploymentStatusPending ||
+									!dd.DeploymentCreated.Equal(created) ||
+									dd.Created.Before(created) {
+									return false
+								}
+							}
+							return len(dds) == tc.incrementCount
+						})).Return(tc.insertErr)
+				}
+
+				if tc.insertErr != nil {
+					db.On("IncrementPendingStats", contextMatcher(),
+						deploymentID, -tc.incrementCount).Return(nil)
+				} else if tc.incrementErr == nil {
+					db.On("IncrementDeploymentGeneration", contextMatcher()).
+						Return(nil)
+				}
+			}
+
+			fs := &fs_mocks.FileStorage{}
+
+			d := NewDeployments(&db, fs, ArtifactContentType)
+
+			err := d.AddDevicesToDeployment(context.Background(),
+				deploymentID, tc.devices)
+			if tc.err != nil {
+				assert.EqualError(t, err, tc.err.Error())
+			} else {
+				assert.NoError(t, err)
+			}
+
+			db.AssertExpectations(t)
+		})
+	}
+}
+
+func TestGetDeploymentForDeviceWithCurrentMaxDevicesInFlight(t *testing.T) {
+	deploymentID := "a108ae14-bb4e-455f-9b40-2ef4bab97bb7"
+	deviceID := "device-1"
+	imageID := "0b63b5e6-6e1a-4dbb-9e5a-57bbfd7ee6f5"
+
+	testCases := map[string]struct {
+		status             string
+		maxDevicesInFlight int
+
+		claim    bool
+		claimed  bool
+		claimErr error
+
+		moved   bool
+		moveErr error
+
+		instructions bool
+		err          error
+	}{
+		"ok, no limit": {
+			status:       model.DeviceDeploymentStatusPending,
+			instructions: true,
+		},
+		"ok, slot claimed": {
+			status:             model.DeviceDeploymentStatusPending,
+			maxDevicesInFlight: 2,
+			claim:              true,
+			claimed:            true,
+			moved:              true,
+			instructions:       true,
+		},
+		"ok, device not pending anymore": {
+			status:             model.DeviceDeploymentStatusPending,
+			maxDevicesInFlight: 2,
+			claim:              true,
+			claimed:            true,
+		},
+		"error, moving device": {
+			status:             model.DeviceDeploymentStatusPending,
+			maxDevicesInFlight: 2,
+			claim:              true,
+			claimed:            true,
+			moveErr:            errors.New("db error"),
+			err:                errors.New("Updating device deployment status: db error"),
+		},
+		"ok, device already in flight": {
+			status:             model.DeviceDeploymentStatusDownloading,
+			maxDevicesInFlight: 2,
+			instructions:       true,
+		},
+		"ok, limit reached": {
+			status:             model.DeviceDeploymentStatusPending,
+			maxDevicesInFlight: 2,
+			claim:              true,
+		},
+		"error, claiming slot": {
+			status:             model.DeviceDeploymentStatusPending,
+			maxDevicesInFlight: 2,
+			claim:              true,
+			claimErr:           errors.New("db error"),
+			err:                errors.New("Claiming deployment slot for the device: db error"),
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			db := mocks.DataStore{}
+			fs := &fs_mocks.FileStorage{}
+
+			status := tc.status
+			deviceDeployment := &model.DeviceDeployment{
+				DeploymentId: StringToPointer(deploymentID),
+				DeviceId:     StringToPointer(deviceID),
+				Status:       &status,
+				DeviceType:   StringToPointer("hammer"),
+				Image: &model.SoftwareImage{
+					Id: imageID,
+				},
+			}
+
+			deployment := &model.Deployment{
+				Id: StringToPointer(deploymentID),
+				DeploymentConstructor: &model.DeploymentConstructor{
+					ArtifactName:       StringToPointer("foo"),
+					MaxDevicesInFlight: tc.maxDevicesInFlight,
+				},
+				Stats: model.NewDeviceDeploymentStats(),
+			}
+
+			db.On("FindOldestDeploymentForDeviceIDWithStatuses", contextMatcher(),
+				deviceID, model.ActiveDeploymentStatuses()).
+				Return(deviceDeployment, nil)
+			db.On("FindDeploymentByID", contextMatcher(), deploymentID).
+				Return(deployment, nil)
+
+			if tc.claim {
+				db.On("UpdateStatsWithinLimit", contextMatcher(), deploymentID,
+					model.DeviceDeploymentStatusPending,
+					model.DeviceDeploymentStatusDownloading,
+					tc.maxDevicesInFlight).Return(tc.claimed, tc.claimErr)
+			}
+
+			if tc.claimed {
+				db.On("UpdateDeviceDeploymentStatusFrom", contextMatcher(),
+					deviceID, deploymentID, model.DeviceDeploymentStatusPending,
+					mock.MatchedBy(func(s model.DeviceDeploymentStatus) bool {
+						return s.Status == model.DeviceDeploymentStatusDownloading
+					})).Return(tc.moved, tc.moveErr)
+
+				if !tc.moved {
+					db.On("UpdateStats", contextMatcher(), deploymentID,
+						model.DeviceDeploymentStatusDownloading,
+						model.DeviceDeploymentStatusPending).Return(nil)
+				}
+			}
+
+			if tc.instructions {
+				db.On("GetSettings", contextMatcher()).Return(&model.Settings{}, nil)
+				fs.On("GetRequest", contextMatcher(), imageID,
+					DefaultUpdateDownloadLinkExpire, ArtifactContentType).
+					Return(&model.Link{Uri: "http://localhost/foo"}, nil)
+			}
+
+			d := NewDeployments(&db, fs, ArtifactContentType)
+
+			instructions, err := d.GetDeploymentForDeviceWithCurrent(
+				context.Background(), deviceID,
+				model.InstalledDeviceDeployment{
+					Artifact:   "bar",
+					DeviceType: "hammer",
+				})
+			if tc.err != nil {
+				assert.EqualError(t, err, tc.err.Error())
+			} else {
+				assert.NoError(t, err)
+			}
+
+			if tc.instructions {
+				assert.NotNil(t, instructions)
+			} else {
+				assert.Nil(t, instructions)
+			}
+
+			db.AssertExpectations(t)
+			fs.AssertExpectations(t)
+		})
+	}
+}
+
+func TestGetDeploymentForDeviceWithCurrentDependencies(t *testing.T) {
+	deploymentID := "a108ae14-bb4e-455f-9b40-2ef4bab97bb7"
+	predecessorID := "5b5b1a5e-b2e9-4b8c-8b4f-0bc1ef0b9d0f"
+	deviceID := "device-1"
+	imageID := "0b63b5e6-6e1a-4dbb-9e5a-57bbfd7ee6f5"
+	now := time.Now()
+
+	testCases := map[string]struct {
+		predecessor    *model.Deployment
+		predecessorErr error
+
+		instructions bool
+		// the dependency can't be satisfied anymore
+		aborted bool
+		err     error
+	}{
+		"ok, predecessor succeeded": {
+			predecessor: &model.Deployment{
+				Id:       StringToPointer(predecessorID),
+				Finished: &now,
+				Stats: model.Stats{
+					model.DeviceDeploymentStatusSuccess: 2,
+				},
+			},
+			instructions: true,
+		},
+		"ok, predecessor in progress": {
+			predecessor: &model.Deployment{
+				Id: StringToPointer(predecessorID),
+				Stats: model.Stats{
+					model.DeviceDeploymentStatusSuccess:     1,
+					model.DeviceDeploymentStatusDownloading: 1,
+				},
+			},
+		},
+		"ok, predecessor success ratio too low": {
+			predecessor: &model.Deployment{
+				Id:       StringToPointer(predecessorID),
+				Finished: &now,
+				Stats: model.Stats{
+					model.DeviceDeploymentStatusSuccess: 1,
+					model.DeviceDeploymentStatusFailure: 1,
+				},
+			},
+			aborted: true,
+		},
+		"ok, predecessor not found": {
+			aborted: true,
+		},
+		"error, predecessor lookup failed": {
+			predecessorErr: errors.New("db error"),
+			err:            errors.New("Searching for deployment dependency: db error"),
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			db := mocks.DataStore{}
+			fs := &fs_mocks.FileStorage{}
+
+			status := model.DeviceDeploymentStatusPending
+			deviceDeployment := &model.DeviceDeployment{
+				DeploymentId: StringToPointer(deploymentID),
+				DeviceId:     StringToPointer(deviceID),
+				Status:       &status,
+				DeviceType:   StringToPointer("hammer"),
+				Image: &model.SoftwareImage{
+					Id: imageID,
+				},
+			}
+
+			deployment := &model.Deployment{
+				Id: StringToPointer(deploymentID),
+				DeploymentConstructor: &model.DeploymentConstructor{
+					ArtifactName: StringToPointer("foo"),
+					DependsOn:    []string{predecessorID},
+				},
+				Stats: model.Stats{
+					model.DeviceDeploymentStatusPending: 2,
+				},
+			}
+
+			db.On("FindOldestDeploymentForDeviceIDWithStatuses", contextMatcher(),
+				deviceID, model.ActiveDeploymentStatuses()).
+				Return(deviceDeployment, nil)
+			db.On("FindDeploymentByID", contextMatcher(), deploymentID).
+				Return(deployment, nil)
+			db.On("FindDeploymentByID", contextMatcher(), predecessorID).
+				Return(tc.predecessor, tc.predecessorErr)
+
+			if !tc.instructions && tc.err == nil {
+				db.On("FindAllDeploymentsForDeviceIDWithStatuses", contextMatcher(),
+					deviceID, []string{model.DeviceDeploymentStatusPending}).
+					Return([]model.DeviceDeployment{*deviceDeployment}, nil)
+			}
+
+			if tc.aborted {
+				db.On("GetDeviceDeploymentStatus", contextMatcher(),
+					deploymentID, deviceID).
+					Return(model.DeviceDeploymentStatusPending, nil)
+				db.On("UpdateDeviceDeploymentStatus", contextMatcher(),
+					deviceID, deploymentID,
+					mock.MatchedBy(func(status model.DeviceDeploymentStatus) bool {
+						return status.Status == model.DeviceDeploymentStatusAborted &&
+							*status.SubState == "dependency on deployment "+
+								predecessorID+" can't be satisfied"
+					})).
+					Return(model.DeviceDeploymentStatusPending, nil)
+				db.On("UpdateStats", contextMatcher(), deploymentID,
+					model.DeviceDeploymentStatusPending,
+					model.DeviceDeploymentStatusAborted).
+					Return(nil)
+				db.On("IncrementDeploymentGeneration", contextMatcher()).Return(nil)
+			}
+
+			if tc.instructions {
+				db.On("GetSettings", contextMatcher()).Return(&model.Settings{}, nil)
+				fs.On("GetRequest", contextMatcher(), imageID,
+					DefaultUpdateDownloadLinkExpire, ArtifactContentType).
+					Return(&model.Link{Uri: "http://localhost/foo"}, nil)
+			}
+
+			d := NewDeployments(&db, fs, ArtifactContentType)
+
+			instructions, err := d.GetDeploymentForDeviceWithCurrent(
+				context.Background(), deviceID,
+				model.InstalledDeviceDeployment{
+					Artifact:   "bar",
+					DeviceType: "hammer",
+				})
+			if tc.err != nil {
+				assert.EqualError(t, err, tc.err.Error())
+			} else {
+				assert.NoError(t, err)
+			}
+
+			if tc.instructions {
+				assert.NotNil(t, instructions)
+			} else {
+				assert.Nil(t, instructions)
+			}
+
+			db.AssertExpectations(t)
+			fs.AssertExpectations(t)
+		})
+	}
+}
+
+func TestGetDeploymentDependencyGraph(t *testing.T) {
+	deploymentID := "a108ae14-bb4e-455f-9b40-2ef4bab97bb7"
+	predecessorID := "5b5b1a5e-b2e9-4b8c-8b4f-0bc1ef0b9d0f"
+	dependentID := "9ec6a9d2-6c5c-4d8e-9d3e-50c1b0b2fbd3"
+	now := time.Now()
+
+	predecessor := &model.Deployment{
+		Id: StringToPointer(predecessorID),
+		DeploymentConstructor: &model.DeploymentConstructor{
+			Name: StringToPointer("bootloader"),
+		},
+		Finished: &now,
+		Stats: model.Stats{
+			model.DeviceDeploymentStatusSuccess: 1,
+		},
+	}
+
+	deployment := &model.Deployment{
+		Id: StringToPointer(deploymentID),
+		DeploymentConstructor: &model.DeploymentConstructor{
+			Name:      StringToPointer("os"),
+			DependsOn: []string{predecessorID},
+		},
+		Stats: model.Stats{
+			model.DeviceDeploymentStatusSuccess: 1,
+			model.DeviceDeploymentStatusPending: 1,
+		},
+	}
+
+	dependent := &model.Deployment{
+		Id: StringToPointer(dependentID),
+		DeploymentConstructor: &model.DeploymentConstructor{
+			Name:      StringToPointer("application"),
+			DependsOn: []string{deploymentID},
+		},
+		Stats: model.Stats{
+			model.DeviceDeploymentStatusPending: 2,
+		},
+	}
+
+	db := mocks.DataStore{}
+	db.On("FindDeploymentByID", contextMatcher(), deploymentID).
+		Return(deployment, nil)
+	db.On("FindDeploymentByID", contextMatcher(), predecessorID).
+		Return(predecessor, nil)
+	db.On("FindDependentDeployments", contextMatcher(), deploymentID).
+		Return([]*model.Deployment{dependent}, nil)
+
+	d := NewDeployments(&db, &fs_mocks.FileStorage{}, ArtifactContentType)
+
+	out, err := d.GetDeployment(context.Background(), deploymentID)
+	assert.NoError(t, err)
+
+	assert.Equal(t, []model.DeploymentDependency{
+		{
+			Id:           predecessorID,
+			Name:         "bootloader",
+			Status:       "finished",
+			SuccessRatio: 1,
+			Satisfied:    true,
+		},
+	}, out.Dependencies)
+	assert.Equal(t, []model.DeploymentDependency{
+		{
+			Id:           deploymentID,
+			Name:         "os",
+			Status:       "inprogress",
+			SuccessRatio: 0.5,
+			Satisfied:    false,
+		},
+	}, out.Dependents)
+
+	db.AssertExpectations(t)
+}
+
+func TestCreateDeploymentDependencyNotFound(t *testing.T) {
+	predecessorID := "5b5b1a5e-b2e9-4b8c-8b4f-0bc1ef0b9d0f"
+
+	db := mocks.DataStore{}
+	db.On("FindDeploymentByID", contextMatcher(), predecessorID).
+		Return(nil, nil)
+
+	d := NewDeployments(&db, &fs_mocks.FileStorage{}, ArtifactContentType)
+
+	_, err := d.CreateDeployment(context.Background(),
+		&model.DeploymentConstructor{
+			Name:         StringToPointer("application"),
+			ArtifactName: StringToPointer("foo"),
+			Devices:      []string{"device-1"},
+			DependsOn:    []string{predecessorID},
+		})
+	assert.EqualError(t, err, ErrDependencyNotFound.Error())
+
+	db.AssertExpectations(t)
+}
+
+func TestCreateDeploymentRequiringApproval(t *testing.T) {
+	testCases := map[string]struct {
+		settings *model.Settings
+		identity *identity.Identity
+
+		approval *model.DeploymentApproval
+	}{
+		"approval not required": {
+			settings: &model.Settings{},
+			identity: &identity.Identity{Subject: "user-1"},
+		},
+		"approval required": {
+			settings: &model.Settings{RequireApproval: true},
+			identity: &identity.Identity{Subject: "user-1"},
+			approval: &model.DeploymentApproval{
+				Status:      model.ApprovalStatusAwaiting,
+				RequestedBy: "user-1",
+			},
+		},
+		"approval required, no identity": {
+			settings: &model.Settings{RequireApproval: true},
+			approval: &model.DeploymentApproval{
+				Status: model.ApprovalStatusAwaiting,
+			},
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			db := mocks.DataStore{}
+			db.On("ImagesByName", contextMatcher(), "foo").
+				Return([]*model.SoftwareImage{{Id: "0b63b5e6-6e1a-4dbb-9e5a-57bbfd7ee6f5"}}, nil)
+			db.On("GetSettings", contextMatcher()).Return(tc.settings, nil)
+			db.On("InsertDeployment", contextMatcher(),
+				mock.MatchedBy(func(d *model.Deployment) bool {
+					return assert.Equal(t, tc.approval, d.Approval)
+				})).Return(nil)
+			db.On("InsertMany", contextMatcher(),
+				mock.AnythingOfType("[]*model.DeviceDeployment")).Return(nil)
+			if tc.approval == nil {
+				db.On("IncrementDeploymentGeneration", contextMatcher()).
+					Return(nil)
+			}
+
+			d := NewDeployments(&db, &fs_mocks.FileStorage{}, ArtifactContentType)
+
+			ctx := context.Background()
+			if tc.identity != nil {
+				ctx = identity.WithContext(ctx, tc.identity)
+			}
+
+			_, err := d.CreateDeployment(ctx,
+				&model.DeploymentConstructor{
+					Name:         StringToPointer("application"),
+					ArtifactName: StringToPointer("foo"),
+					Devices:      []string{"device-1"},
+				})
+			assert.NoError(t, err)
+
+			db.AssertExpectations(t)
+		})
+	}
+}
+
+func TestSaveSettings(t *testing.T) {
+	testCases := map[string]struct {
+		current  *model.Settings
+		settings *model.Settings
+
+		save bool
+		err  error
+	}{
+		"ok": {
+			current: &model.Settings{RequireApproval: true},
+			settings: &model.Settings{
+				RequireApproval: true,
+				Retention:       model.RetentionSettings{LogDays: 30},
+			},
+			save: true,
+		},
+		"error, approval requirement turned off": {
+			current:  &model.Settings{RequireApproval: true},
+			settings: &model.Settings{},
+			err:      ErrRequireApprovalReadOnly,
+		},
+		"error, approval requirement turned on": {
+			current:  &model.Settings{},
+			settings: &model.Settings{RequireApproval: true},
+			err:      ErrRequireApprovalReadOnly,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			db := mocks.DataStore{}
+
+			db.On("GetSettings", contextMatcher()).Return(tc.current, nil)
+			if tc.save {
+				db.On("SaveSettings", contextMatcher(), tc.settings).Return(nil)
+			}
+
+			d := NewDeployments(&db, &fs_mocks.FileStorage{}, ArtifactContentType)
+
+			err := d.SaveSettings(context.Background(), tc.settings)
+			if tc.err != nil {
+				assert.EqualError(t, err, tc.err.Error())
+			} else {
+				assert.NoError(t, err)
+			}
+
+			db.AssertExpectations(t)
+		})
+	}
+}
+
+func TestDecideDeploymentApproval(t *testing.T) {
+	deploymentID := "a108ae14-bb4e-455f-9b40-2ef4bab97bb7"
+
+	newDeployment := func(status string) *model.Deployment {
+		return &model.Deployment{
+			Id: StringToPointer(deploymentID),
+			Approval: &model.DeploymentApproval{
+				Status:      status,
+				RequestedBy: "user-1",
+			},
+			Stats: model.Stats{model.DeviceDeploymentStatusPending: 1},
+		}
+	}
+
+	testCases := map[string]struct {
+		userID     string
+		status     string
+		deployment *model.Deployment
+		updateErr  error
+
+		update bool
+		abort  bool
+		err    error
+	}{
+		"approved": {
+			userID:     "user-2",
+			status:     model.ApprovalStatusApproved,
+			deployment: newDeployment(model.ApprovalStatusAwaiting),
+			update:     true,
+		},
+		"rejected": {
+			userID:     "user-2",
+			status:     model.ApprovalStatusRejected,
+			deployment: newDeployment(model.ApprovalStatusAwaiting),
+			update:     true,
+			abort:      true,
+		},
+		"error, invalid status": {
+			userID: "user-2",
+			status: model.ApprovalStatusAwaiting,
+			err:    model.ErrInvalidApprovalStatus,
+		},
+		"error, not found": {
+			userID: "user-2",
+			status: model.ApprovalStatusApproved,
+			err:    ErrModelDeploymentNotFound,
+		},
+		"error, same user": {
+			userID:     "user-1",
+			status:     model.ApprovalStatusApproved,
+			deployment: newDeployment(model.ApprovalStatusAwaiting),
+			err:        ErrApprovalBySameUser,
+		},
+		"error, no user": {
+			status:     model.ApprovalStatusApproved,
+			deployment: newDeployment(model.ApprovalStatusAwaiting),
+			err:        ErrApprovalBySameUser,
+		},
+		"error, already approved": {
+			userID:     "user-2",
+			status:     model.ApprovalStatusRejected,
+			deployment: newDeployment(model.ApprovalStatusApproved),
+			err:        ErrNotAwaitingApproval,
+		},
+		"error, no approval required": {
+			userID:     "user-2",
+			status:     model.ApprovalStatusApproved,
+			deployment: &model.Deployment{Id: StringToPointer(deploymentID)},
+			err:        ErrNotAwaitingApproval,
+		},
+		"error, decided concurrently": {
+			userID:     "user-2",
+			status:     model.ApprovalStatusApproved,
+			deployment: newDeployment(model.ApprovalStatusAwaiting),
+			update:     true,
+			updateErr:  mongo.ErrStorageNotFound,
+			err:        ErrNotAwaitingApproval,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			db := mocks.DataStore{}
+
+			if tc.err != model.ErrInvalidApprovalStatus {
+				db.On("FindDeploymentByID", contextMatcher(), deploymentID).
+					Return(tc.deployment, nil)
+			}
+
+			if tc.update {
+				db.On("UpdateDeploymentApproval", contextMatcher(), deploymentID,
+					mock.MatchedBy(func(a model.DeploymentApproval) bool {
+						return a.Status == tc.status &&
+							a.RequestedBy == "user-1" &&
+							a.DecidedBy == tc.userID &&
+							a.Decided != nil
+					})).Return(tc.updateErr)
+			}
+
+			if tc.update && tc.updateErr == nil {
+				db.On("IncrementDeploymentGeneration", contextMatcher()).
+					Return(nil)
+			}
+
+			if tc.abort {
+				stats := model.Stats{model.DeviceDeploymentStatusAborted: 1}
+				db.On("SetDeploymentAborted", contextMatcher(), deploymentID).
+					Return(nil)
+				db.On("AbortDeviceDeployments", contextMatcher(), deploymentID).
+					Return(nil)
+				db.On("AggregateDeviceDeploymentByStatus", contextMatcher(), deploymentID).
+					Return(stats, nil)
+				db.On("UpdateStatsAndFinishDeployment", contextMatcher(), deploymentID, stats).
+					Return(nil)
+			} else if tc.update && tc.updateErr == nil {
+				// devices waiting for the deployment are woken up
+				db.On("IterateDeviceDeploymentsForDeployment", contextMatcher(), deploymentID,
+					mock.AnythingOfType("func(*model.DeviceDeployment) error")).
+					Return(nil)
+			}
+
+			d := NewDeployments(&db, &fs_mocks.FileStorage{}, ArtifactContentType)
+
+			err := d.DecideDeploymentApproval(context.Background(),
+				deploymentID, tc.userID, tc.status)
+			if tc.err != nil {
+				assert.EqualError(t, err, tc.err.Error())
+			} else {
+				assert.NoError(t, err)
+			}
+
+			db.AssertExpectations(t)
+		})
+	}
+}
+
+func TestGetDeploymentForDeviceAwaitingApproval(t *testing.T) {
+	deploymentID := "a108ae14-bb4e-455f-9b40-2ef4bab97bb7"
+	nextDeploymentID := "b4db7c1c-0f4e-4bf5-9c5e-8e1dd0d2a0f1"
+	deviceID := "device-1"
+	imageID := "0b63b5e6-6e1a-4dbb-9e5a-57bbfd7ee6f5"
+	link := model.Link{Uri: "http://localhost/foo", Expire: time.Now().Add(time.Hour)}
+
+	newDeviceDeployment := func(deploymentID string) *model.DeviceDeployment {
+		return &model.DeviceDeployment{
+			DeploymentId: StringToPointer(deploymentID),
+			DeviceId:     StringToPointer(deviceID),
+			Status:       StringToPointer(model.DeviceDeploymentStatusPending),
+			DeviceType:   StringToPointer("hammer"),
+			Image:        &model.SoftwareImage{Id: imageID},
+		}
+	}
+	newDeployment := func(deploymentID string, approval string) *model.Deployment {
+		return &model.Deployment{
+			Id: StringToPointer(deploymentID),
+			DeploymentConstructor: &model.DeploymentConstructor{
+				ArtifactName: StringToPointer("foo"),
+			},
+			Approval: &model.DeploymentApproval{Status: approval},
+		}
+	}
+
+	testCases := map[string]struct {
+		next *model.Deployment
+
+		deploymentID string
+	}{
+		"ok, no other deployment": {},
+		"ok, next deployment awaiting approval too": {
+			next: newDeployment(nextDeploymentID, model.ApprovalStatusAwaiting),
+		},
+		"ok, next deployment goes ahead": {
+			next:         newDeployment(nextDeploymentID, model.ApprovalStatusApproved),
+			deploymentID: nextDeploymentID,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			db := mocks.DataStore{}
+			fs := &fs_mocks.FileStorage{}
+
+			db.On("FindOldestDeploymentForDeviceIDWithStatuses", contextMatcher(), deviceID,
+				mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+				Return(newDeviceDeployment(deploymentID), nil)
+			db.On("FindDeploymentByID", contextMatcher(), deploymentID).
+				Return(newDeployment(deploymentID, model.ApprovalStatusAwaiting), nil)
+
+			pending := []model.DeviceDeployment{*newDeviceDeployment(deploymentID)}
+			if tc.next != nil {
+				pending = append(pending, *newDeviceDeployment(nextDeploymentID))
+				db.On("FindDeploymentByID", contextMatcher(), nextDeploymentID).
+					Return(tc.next, nil)
+			}
+			db.On("FindAllDeploymentsForDeviceIDWithStatuses", contextMatcher(), deviceID,
+				[]string{model.DeviceDeploymentStatusPending}).
+				Return(pending, nil)
+
+			if tc.deploymentID != "" {
+				db.On("GetSettings", contextMatcher()).
+					Return(&model.Settings{}, nil)
+				fs.On("GetRequest", contextMatcher(), imageID,
+					DefaultUpdateDownloadLinkExpire, ArtifactContentType).
+					Return(&link, nil)
+			}
+
+			d := NewDeployments(&db, fs, ArtifactContentType)
+
+			instructions, err := d.GetDeploymentForDeviceWithCurrent(context.Background(),
+				deviceID, model.InstalledDeviceDeployment{Artifact: "bar", DeviceType: "hammer"})
+			assert.NoError(t, err)
+			if tc.deploymentID == "" {
+				assert.Nil(t, instructions)
+			} else if assert.NotNil(t, instructions) {
+				assert.Equal(t, tc.deploymentID, instructions.ID)
+			}
+
+			db.AssertExpectations(t)
+			fs.AssertExpectations(t)
+		})
+	}
+}
+
+func TestContinueDeployment(t *testing.T) {
+	deploymentID := "a108ae14-bb4e-455f-9b40-2ef4bab97bb7"
+	deviceID := "device-1"
+	now := time.Now()
+
+	newDeployment := func(finished *time.Time) *model.Deployment {
+		return &model.Deployment{
+			Id: StringToPointer(deploymentID),
+			DeploymentConstructor: &model.DeploymentConstructor{
+				PauseBefore: []string{model.PausePointReboot},
+			},
+			Finished: finished,
+		}
+	}
+	newDeviceDeployment := func(status string) *model.DeviceDeployment {
+		return &model.DeviceDeployment{Status: &status}
+	}
+
+	testCases := map[string]struct {
+		deviceID   string
+		pausePoint string
+
+		deployment       *model.Deployment
+		deviceDeployment *model.DeviceDeployment
+
+		err error
+	}{
+		"ok": {
+			pausePoint: model.PausePointReboot,
+			deployment: newDeployment(nil),
+		},
+		"ok, device": {
+			deviceID:   deviceID,
+			pausePoint: model.PausePointReboot,
+			deployment: newDeployment(nil),
+			deviceDeployment: newDeviceDeployment(
+				model.DeviceDeploymentStatusPauseBeforeReboot),
+		},
+		"error, invalid pause point": {
+			pausePoint: "download",
+			err:        model.ErrInvalidPausePoint,
+		},
+		"error, not found": {
+			pausePoint: model.PausePointReboot,
+			err:        ErrModelDeploymentNotFound,
+		},
+		"error, finished": {
+			pausePoint: model.PausePointReboot,
+			deployment: newDeployment(&now),
+			err:        ErrDeploymentFinished,
+		},
+		"error, not pausing": {
+			pausePoint: model.PausePointInstall,
+			deployment: newDeployment(nil),
+			err:        ErrNotPausing,
+		},
+		"error, device not found": {
+			deviceID:   deviceID,
+			pausePoint: model.PausePointReboot,
+			deployment: newDeployment(nil),
+			err:        ErrModelDeploymentNotFound,
+		},
+		"error, device finished": {
+			deviceID:   deviceID,
+			pausePoint: model.PausePointReboot,
+			deployment: newDeployment(nil),
+			deviceDeployment: newDeviceDeployment(
+				model.DeviceDeploymentStatusAborted),
+			err: ErrDeploymentFinished,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			db := mocks.DataStore{}
+
+			if tc.err != model.ErrInvalidPausePoint {
+				db.On("FindDeploymentByID", contextMatcher(), deploymentID).
+					Return(tc.deployment, nil)
+			}
+
+			if tc.deviceID != "" && tc.err != ErrNotPausing {
+				db.On("FindDeviceDeployment", contextMatcher(), deploymentID, tc.deviceID).
+					Return(tc.deviceDeployment, nil)
+			}
+
+			if tc.err == nil {
+				if tc.deviceID != "" {
+					db.On("AddDeviceDeploymentContinued", contextMatcher(),
+						deploymentID, tc.deviceID, tc.pausePoint).Return(nil)
+				} else {
+					db.On("AddDeploymentContinued", contextMatcher(),
+						deploymentID, tc.pausePoint).Return(nil)
+				}
+			}
+
+			d := NewDeployments(&db, &fs_mocks.FileStorage{}, ArtifactContentType)
+
+			var err error
+			if tc.deviceID != "" {
+				err = d.ContinueDeviceDeployment(context.Background(),
+					deploymentID, tc.deviceID, tc.pausePoint)
+			} else {
+				err = d.ContinueDeployment(context.Background(),
+					deploymentID, tc.pausePoint)
+			}
+			assert.Equal(t, tc.err, err)
+
+			db.AssertExpectations(t)
+		})
+	}
+}
+
+func TestIsDeviceDeploymentContinued(t *testing.T) {
+	deploymentID := "a108ae14-bb4e-455f-9b40-2ef4bab97bb7"
+	deviceID := "device-1"
+
+	testCases := map[string]struct {
+		pauseBefore []string
+		continued   []string
+
+		deviceContinued []string
+
+		result bool
+	}{
+		"waiting": {
+			pauseBefore: []string{model.PausePointReboot},
+		},
+		"deployment continued": {
+			pauseBefore: []string{model.PausePointReboot},
+			continued:   []string{model.PausePointReboot},
+			result:      true,
+		},
+		"device continued": {
+			pauseBefore:     []string{model.PausePointReboot},
+			deviceContinued: []string{model.PausePointReboot},
+			result:          true,
+		},
+		"device continued at other point": {
+			pauseBefore:     []string{model.PausePointInstall, model.PausePointReboot},
+			deviceContinued: []string{model.PausePointInstall},
+		},
+		"not pausing": {
+			result: true,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			db := mocks.DataStore{}
+
+			db.On("FindDeploymentByID", contextMatcher(), deploymentID).
+				Return(&model.Deployment{
+					Id: StringToPointer(deploymentID),
+					DeploymentConstructor: &model.DeploymentConstructor{
+						PauseBefore: tc.pauseBefore,
+					},
+					Continued: tc.continued,
+				}, nil)
+			if len(tc.pauseBefore) > 0 && len(tc.continued) == 0 {
+				db.On("FindDeviceDeployment", contextMatcher(), deploymentID, deviceID).
+					Return(&model.DeviceDeployment{Continued: tc.deviceContinued}, nil)
+			}
+
+			d := NewDeployments(&db, &fs_mocks.FileStorage{}, ArtifactContentType)
+
+			result, err := d.IsDeviceDeploymentContinued(context.Background(),
+				deploymentID, deviceID, model.PausePointReboot)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.result, result)
+
+			db.AssertExpectations(t)
+		})
+	}
+}
+
+func TestGetDeploymentForDeviceWithCurrentSupersede(t *testing.T) {
+	deploymentID := "a108ae14-bb4e-455f-9b40-2ef4bab97bb7"
+	lowerID := "5b5b1a5e-b2e9-4b8c-8b4f-0bc1ef0b9d0f"
+	equalID := "9ec6a9d2-6c5c-4d8e-9d3e-50c1b0b2fbd3"
+	deviceID := "device-1"
+	imageID := "0b63b5e6-6e1a-4dbb-9e5a-57bbfd7ee6f5"
+
+	newDeviceDeployment := func(deploymentID string, priority int) *model.DeviceDeployment {
+		status := model.DeviceDeploymentStatusPending
+		return &model.DeviceDeployment{
+			DeploymentId: StringToPointer(deploymentID),
+			DeviceId:     StringToPointer(deviceID),
+			Status:       &status,
+			DeviceType:   StringToPointer("hammer"),
+			Image: &model.SoftwareImage{
+				Id: imageID,
+			},
+			Priority: priority,
+		}
+	}
+
+	deviceDeployment := newDeviceDeployment(deploymentID, 10)
+
+	deployment := &model.Deployment{
+		Id: StringToPointer(deploymentID),
+		DeploymentConstructor: &model.DeploymentConstructor{
+			ArtifactName: StringToPointer("foo"),
+			Priority:     10,
+			Supersede:    true,
+		},
+		Stats: model.NewDeviceDeploymentStats(),
+	}
+
+	lowerStats := model.NewDeviceDeploymentStats()
+	lowerStats[model.DeviceDeploymentStatusPending] = 1
+	lower := &model.Deployment{
+		Id:    StringToPointer(lowerID),
+		Stats: lowerStats,
+	}
+
+	db := mocks.DataStore{}
+	fs := &fs_mocks.FileStorage{}
+
+	db.On("FindOldestDeploymentForDeviceIDWithStatuses", contextMatcher(),
+		deviceID, model.ActiveDeploymentStatuses()).
+		Return(deviceDeployment, nil)
+	db.On("FindDeploymentByID", contextMatcher(), deploymentID).
+		Return(deployment, nil)
+	db.On("FindAllDeploymentsForDeviceIDWithStatuses", contextMatcher(),
+		deviceID, []string{model.DeviceDeploymentStatusPending}).
+		Return([]model.DeviceDeployment{
+			*newDeviceDeployment(lowerID, 0),
+			*newDeviceDeployment(equalID, 10),
+			*deviceDeployment,
+		}, nil)
+
+	// only the lower priority deployment is aborted
+	db.On("GetDeviceDeploymentStatus", contextMatcher(), lowerID, deviceID).
+		Return(model.DeviceDeploymentStatusPending, nil)
+	db.On("UpdateDeviceDeploymentStatus", contextMatcher(), deviceID, lowerID,
+		mock.MatchedBy(func(s model.DeviceDeploymentStatus) bool {
+			return s.Status == model.DeviceDeploymentStatusAborted &&
+				*s.SubState == "superseded by deployment "+deploymentID
+		})).Return(model.DeviceDeploymentStatusPending, nil)
+	db.On("UpdateStats", contextMatcher(), lowerID,
+		model.DeviceDeploymentStatusPending,
+		model.DeviceDeploymentStatusAborted).Return(nil)
+	db.On("FindDeploymentByID", contextMatcher(), lowerID).
+		Return(lower, nil)
+	db.On("IncrementDeploymentGeneration", contextMatcher()).Return(nil)
+
+	db.On("GetSettings", contextMatcher()).Return(&model.Settings{}, nil)
+	fs.On("GetRequest", contextMatcher(), imageID,
+		DefaultUpdateDownloadLinkExpire, ArtifactContentType).
+		Return(&model.Link{Uri: "http://localhost/foo"}, nil)
+
+	d := NewDeployments(&db, fs, ArtifactContentType)
+
+	instructions, err := d.GetDeploymentForDeviceWithCurrent(
+		context.Background(), deviceID,
+		model.InstalledDeviceDeployment{
+			Artifact:   "bar",
+			DeviceType: "hammer",
+		})
+	assert.NoError(t, err)
+	assert.NotNil(t, instructions)
+	assert.Equal(t, deploymentID, instructions.ID)
+
+	db.AssertExpectations(t)
+	fs.AssertExpectations(t)
+}
+
+func TestGetDeploymentForDeviceWithCurrentForce(t *testing.T) {
+	deploymentID := "a108ae14-bb4e-455f-9b40-2ef4bab97bb7"
+	deviceID := "device-1"
+	imageID := "0b63b5e6-6e1a-4dbb-9e5a-57bbfd7ee6f5"
+
+	testCases := map[string]struct {
+		force bool
+
+		instructions bool
+	}{
+		"ok, force": {
+			force:        true,
+			instructions: true,
+		},
+		"ok, already installed": {
+			force: false,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			db := mocks.DataStore{}
+			fs := &fs_mocks.FileStorage{}
+
+			status := model.DeviceDeploymentStatusPending
+			deviceDeployment := &model.DeviceDeployment{
+				DeploymentId: StringToPointer(deploymentID),
+				DeviceId:     StringToPointer(deviceID),
+				Status:       &status,
+				DeviceType:   StringToPointer("hammer"),
+				Image: &model.SoftwareImage{
+					Id: imageID,
+				},
+			}
+
+			deployment := &model.Deployment{
+				Id: StringToPointer(deploymentID),
+				DeploymentConstructor: &model.DeploymentConstructor{
+					ArtifactName: StringToPointer("foo"),
+					Force:        tc.force,
+				},
+				Stats: model.Stats{
+					model.DeviceDeploymentStatusPending: 2,
+				},
+			}
+
+			db.On("FindOldestDeploymentForDeviceIDWithStatuses", contextMatcher(),
+				deviceID, model.ActiveDeploymentStatuses()).
+				Return(deviceDeployment, nil)
+			db.On("FindDeploymentByID", contextMatcher(), deploymentID).
+				Return(deployment, nil)
+
+			if tc.instructions {
+				db.On("GetSettings", contextMatcher()).Return(&model.Settings{}, nil)
+				fs.On("GetRequest", contextMatcher(), imageID,
+					DefaultUpdateDownloadLinkExpire, ArtifactContentType).
+					Return(&model.Link{Uri: "http://localhost/foo"}, nil)
+			} else {
+				db.On("GetDeviceDeploymentStatus", contextMatcher(),
+					deploymentID, deviceID).
+					Return(model.DeviceDeploymentStatusPending, nil)
+				db.On("UpdateDeviceDeploymentStatus", contextMatcher(),
+					deviceID, deploymentID,
+					mock.MatchedBy(func(s model.DeviceDeploymentStatus) bool {
+						return s.Status == model.DeviceDeploymentStatusAlreadyInst
+					})).Return(model.DeviceDeploymentStatusPending, nil)
+				db.On("UpdateStats", contextMatcher(), deploymentID,
+					model.DeviceDeploymentStatusPending,
+					model.DeviceDeploymentStatusAlreadyInst).Return(nil)
+				db.On("IncrementDeploymentGeneration", contextMatcher()).
+					Return(nil)
+			}
+
+			d := NewDeployments(&db, fs, ArtifactContentType)
+
+			instructions, err := d.GetDeploymentForDeviceWithCurrent(
+				context.Background(), deviceID,
+				model.InstalledDeviceDeployment{
+					Artifact:   "foo",
+					DeviceType: "hammer",
+				})
+			assert.NoError(t, err)
+
+			if tc.instructions {
+				assert.NotNil(t, instructions)
+			} else {
+				assert.Nil(t, instructions)
+			}
+
+			db.AssertExpectations(t)
+			fs.AssertExpectations(t)
+		})
+	}
+}
+
+func TestGetDeploymentForDeviceWithCurrentMirrors(t *testing.T) {
+	deploymentID := "a108ae14-bb4e-455f-9b40-2ef4bab97bb7"
+	deviceID := "device-1"
+	imageID := "0b63b5e6-6e1a-4dbb-9e5a-57bbfd7ee6f5"
+	expire := time.Now().Add(time.Hour)
+
+	primary := model.Link{Uri: "http://localhost/foo", Expire: expire}
+	mirrors := []model.Mirror{
+		{
+			Name:     "factory",
+			URI:      "http://cache.factory/{artifact_id}",
+			IPRanges: []string{"10.1.0.0/16"},
+		},
+		{
+			Name:       "hammers",
+			URI:        "http://hammers.example.com/{artifact_id}",
+			Attributes: map[string]string{model.MirrorAttributeDeviceType: "hammer"},
+		},
+		{
+			Name: "bucket",
+			URI:  "https://bucket.example.com/{artifact_id}",
+		},
+	}
+
+	testCases := map[string]struct {
+		ip         string
+		deviceType string
+
+		sources []model.Link
+	}{
+		"all mirrors": {
+			ip:         "10.1.2.3",
+			deviceType: "hammer",
+			sources: []model.Link{
+				{Uri: "http://cache.factory/" + imageID, Expire: expire},
+				{Uri: "http://hammers.example.com/" + imageID, Expire: expire},
+				{Uri: "https://bucket.example.com/" + imageID, Expire: expire},
+				primary,
+			},
+		},
+		"other range and device type": {
+			ip:         "192.168.1.10",
+			deviceType: "drill",
+			sources: []model.Link{
+				{Uri: "https://bucket.example.com/" + imageID, Expire: expire},
+				primary,
+			},
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			db := mocks.DataStore{}
+			fs := &fs_mocks.FileStorage{}
+
+			status := model.DeviceDeploymentStatusPending
+			deviceDeployment := &model.DeviceDeployment{
+				DeploymentId: StringToPointer(deploymentID),
+				DeviceId:     StringToPointer(deviceID),
+				Status:       &status,
+				DeviceType:   StringToPointer(tc.deviceType),
+				Image: &model.SoftwareImage{
+					Id: imageID,
+				},
+			}
+
+			deployment := &model.Deployment{
+				Id: StringToPointer(deploymentID),
+				DeploymentConstructor: &model.DeploymentConstructor{
+					ArtifactName: StringToPointer("foo"),
+					PauseBefore:  []string{model.PausePointReboot},
+				},
+			}
+
+			db.On("FindOldestDeploymentForDeviceIDWithStatuses", contextMatcher(),
+				deviceID, model.ActiveDeploymentStatuses()).
+				Return(deviceDeployment, nil)
+			db.On("FindDeploymentByID", contextMatcher(), deploymentID).
+				Return(deployment, nil)
+			db.On("GetSettings", contextMatcher()).
+				Return(&model.Settings{Mirrors: mirrors}, nil)
+			fs.On("GetRequest", contextMatcher(), imageID,
+				DefaultUpdateDownloadLinkExpire, ArtifactContentType).
+				Return(&primary, nil)
+
+			d := NewDeployments(&db, fs, ArtifactContentType)
+
+			instructions, err := d.GetDeploymentForDeviceWithCurrent(
+				context.Background(), deviceID,
+				model.InstalledDeviceDeployment{
+					Artifact:   "bar",
+					DeviceType: tc.deviceType,
+					IP:         tc.ip,
+				})
+			assert.NoError(t, err)
+			assert.NotNil(t, instructions)
+			assert.Equal(t, primary, instructions.Artifact.Source)
+			assert.Equal(t, tc.sources, instructions.Artifact.Sources)
+			assert.Equal(t, []string{model.PausePointReboot}, instructions.PauseBefore)
+
+			db.AssertExpectations(t)
+			fs.AssertExpectations(t)
+		})
+	}
+}
+
+func TestSweepTimeouts(t *testing.T) {
+	deploymentID := "a108ae14-bb4e-455f-9b40-2ef4bab97bb7"
+	deviceID := "device-1"
+
+	deployment := &model.Deployment{
+		Id: StringToPointer(deploymentID),
+		DeploymentConstructor: &model.DeploymentConstructor{
+			Timeouts: &model.DeploymentTimeouts{
+				Downloading: 3600,
+				Rebooting:   600,
+			},
+		},
+		Stats: model.Stats{
+			model.DeviceDeploymentStatusDownloading: 1,
+			model.DeviceDeploymentStatusRebooting:   1,
+		},
+	}
+
+	status := model.DeviceDeploymentStatusDownloading
+	stale := model.DeviceDeployment{
+		DeploymentId: StringToPointer(deploymentID),
+		DeviceId:     StringToPointer(deviceID),
+		Status:       &status,
+	}
+
+	testCases := map[string]struct {
+		tenants []string
+
+		// the device is still downloading
+		stillStale bool
+		staleErr   error
+
+		err string
+	}{
+		"ok, single tenant": {
+			stillStale: true,
+		},
+		"ok, multiple tenants": {
+			tenants:    []string{"acme"},
+			stillStale: true,
+		},
+		"ok, device reported a new status in the meantime": {},
+		"error, searching goes on with other statuses": {
+			tenants:  []string{"acme"},
+			staleErr: errors.New("db error"),
+			err: "Sweeping timeouts of tenant acme: " +
+				"Searching for stale device deployments: db error",
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			db := mocks.DataStore{}
+
+			tenantMatcher := mock.MatchedBy(func(ctx context.Context) bool {
+				id := identity.FromContext(ctx)
+				if len(tc.tenants) == 0 {
+					return id == nil
+				}
+				return id != nil && id.Tenant == tc.tenants[0]
+			})
+
+			db.On("ListTenants", contextMatcher()).Return(tc.tenants, nil)
+			db.On("FindUnfinishedWithTimeouts", tenantMatcher).
+				Return([]*model.Deployment{deployment}, nil)
+
+			db.On("FindStaleDeviceDeployments", tenantMatcher, deploymentID,
+				model.DeviceDeploymentStatusDownloading,
+				mock.MatchedBy(func(before time.Time) bool {
+					return time.Since(before) >= time.Hour
+				})).
+				Return([]model.DeviceDeployment{stale}, tc.staleErr)
+			db.On("FindStaleDeviceDeployments", tenantMatcher, deploymentID,
+				model.DeviceDeploymentStatusRebooting,
+				mock.MatchedBy(func(before time.Time) bool {
+					return time.Since(before) >= 10*time.Minute
+				})).
+				Return(nil, nil)
+
+			if tc.staleErr == nil {
+				db.On("UpdateDeviceDeploymentStatusFrom", tenantMatcher,
+					deviceID, deploymentID, model.DeviceDeploymentStatusDownloading,
+					mock.MatchedBy(func(s model.DeviceDeploymentStatus) bool {
+						return s.Status == model.DeviceDeploymentStatusFailure &&
+							*s.SubState == model.DeviceDeploymentSubStateTimeout &&
+							s.FinishTime != nil
+					})).Return(tc.stillStale, nil)
+			}
+
+			if tc.stillStale {
+				db.On("UpdateStats", tenantMatcher, deploymentID,
+					model.DeviceDeploymentStatusDownloading,
+					model.DeviceDeploymentStatusFailure).Return(nil)
+				db.On("FindDeploymentByID", tenantMatcher, deploymentID).
+					Return(deployment, nil)
+				db.On("GetSettings", tenantMatcher).Return(&model.Settings{}, nil)
+				db.On("GetDeviceDeploymentLog", tenantMatcher, deviceID, deploymentID).
+					Return(nil, nil)
+				db.On("UpdateDeviceDeploymentFailureCategory", tenantMatcher,
+					deviceID, deploymentID, model.FailureCategoryTimeout).Return(nil)
+				db.On("IncrementDeploymentGeneration", tenantMatcher).Return(nil)
+			}
+
+			d := NewDeployments(&db, &fs_mocks.FileStorage{}, ArtifactContentType)
+
+			err := d.SweepTimeouts(context.Background())
+			if tc.err != "" {
+				assert.EqualError(t, err, tc.err)
+			} else {
+				assert.NoError(t, err)
+			}
+
+			db.AssertExpectations(t)
+		})
+	}
+}
+
+func TestSweepRetention(t *testing.T) {
+	deploymentID := "a108ae14-bb4e-455f-9b40-2ef4bab97bb7"
+	deviceID := "device-1"
+
+	deployment := &model.Deployment{
+		Id: StringToPointer(deploymentID),
+	}
+
+	dlog := model.DeploymentLog{
+		DeviceID:     deviceID,
+		DeploymentID: deploymentID,
+		Messages: []model.LogMessage{
+			{Level: "info", Message: "foo"},
+		},
+	}
+
+	testCases := map[string]struct {
+		retention model.RetentionSettings
+		// archiving the log fails
+		archiveErr error
+
+		err string
+	}{
+		"ok, disabled": {},
+		"ok, logs": {
+			retention: model.RetentionSettings{LogDays: 30},
+		},
+		"ok, device deployments": {
+			retention: model.RetentionSettings{DeviceDeploymentDays: 90},
+		},
+		"ok, archive": {
+			retention: model.RetentionSettings{
+				LogDays:              30,
+				DeviceDeploymentDays: 90,
+				Archive:              true,
+			},
+		},
+		"error, archiving log goes on with device deployments": {
+			retention: model.RetentionSettings{
+				LogDays:              30,
+				DeviceDeploymentDays: 90,
+				Archive:              true,
+			},
+			archiveErr: errors.New("storage error"),
+			err: "Expiring log of device " + deviceID + " in deployment " + deploymentID +
+				": Archiving deployment log: storage error",
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			db := mocks.DataStore{}
+			fs := &fs_mocks.FileStorage{}
+
+			db.On("ListTenants", contextMatcher()).Return([]string{}, nil)
+			db.On("GetSettings", contextMatcher()).
+				Return(&model.Settings{Retention: tc.retention}, nil)
+
+			if tc.retention.LogDays > 0 {
+				db.On("FindExpiredDeviceDeploymentLogs", contextMatcher(),
+					mock.MatchedBy(func(before time.Time) bool {
+						return time.Since(before) >= 30*24*time.Hour
+					}), retentionBatchSize).
+					Return([]model.DeploymentLog{dlog}, nil)
+				if tc.archiveErr == nil {
+					db.On("UpdateDeviceDeploymentLogAvailability", contextMatcher(),
+						deviceID, deploymentID, false).
+						Return(mongo.ErrStorageNotFound)
+					db.On("DeleteDeviceDeploymentLog", contextMatcher(),
+						deviceID, deploymentID).Return(nil)
+				}
+
+				if tc.retention.Archive {
+					fs.On("UploadArtifact", contextMatcher(),
+						"archive/logs/"+deploymentID+"/"+deviceID+".json.gz",
+						mock.AnythingOfType("int64"), mock.Anything, "application/gzip").
+						Return(tc.archiveErr)
+				}
+			}
+
+			if tc.retention.DeviceDeploymentDays > 0 {
+				db.On("FindDeploymentsWithExpiredDevices", contextMatcher(),
+					mock.MatchedBy(func(before time.Time) bool {
+						return time.Since(before) >= 90*24*time.Hour
+					}), retentionBatchSize).
+					Return([]*model.Deployment{deployment}, nil)
+				db.On("DeleteDeviceDeploymentsForDeployment", contextMatcher(),
+					deploymentID).Return(nil)
+				db.On("IncrementDeploymentGeneration", contextMatcher()).Return(nil)
+
+				if tc.retention.Archive {
+					db.On("IterateDeviceDeploymentsForDeployment", contextMatcher(),
+						deploymentID, mock.AnythingOfType("func(*model.DeviceDeployment) error")).
+						Return(nil)
+					fs.On("UploadArtifact", contextMatcher(),
+						"archive/deployments/"+deploymentID+"/devices.json.gz",
+						mock.AnythingOfType("int64"), mock.Anything, "application/gzip").
+						Return(nil)
+				}
+			}
+
+			d := NewDeployments(&db, fs, ArtifactContentType)
+
+			err := d.SweepRetention(context.Background())
+			if tc.err != "" {
+				assert.EqualError(t, err, tc.err)
+			} else {
+				assert.NoError(t, err)
+			}
+
+			db.AssertExpectations(t)
+			fs.AssertExpectations(t)
+		})
+	}
+}
+
+func TestGetDeploymentDurationStats(t *testing.T) {
+	deploymentID := "a108ae14-bb4e-455f-9b40-2ef4bab97bb7"
+
+	deployment := &model.Deployment{
+		Id:    StringToPointer(deploymentID),
+		Stats: model.NewDeviceDeploymentStats(),
+	}
+
+	durations := []model.StateDurationGroup{
+		{
+			DeviceType: "hammer",
+			Status:     model.DeviceDeploymentStatusDownloading,
+			Durations:  []float64{60},
+		},
+	}
+
+	testCases := map[string]struct {
+		deployment     *model.Deployment
+		deploymentErr  error
+		aggregationErr error
+
+		stats *model.DeploymentDurationStats
+		err   error
+	}{
+		"ok": {
+			deployment: deployment,
+			stats:      model.NewDeploymentDurationStats(durations),
+		},
+		"ok, deployment not found": {},
+		"error, deployment lookup failed": {
+			deploymentErr: errors.New("db error"),
+			err:           errors.New("checking deployment id: db error"),
+		},
+		"error, aggregation failed": {
+			deployment:     deployment,
+			aggregationErr: errors.New("db error"),
+			err:            errors.New("aggregating state durations: db error"),
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			db := mocks.DataStore{}
+
+			db.On("FindDeploymentByID", contextMatcher(), deploymentID).
+				Return(tc.deployment, tc.deploymentErr)
+
+			if tc.deployment != nil {
+				db.On("AggregateDeviceDeploymentStateDurations", contextMatcher(),
+					deploymentID).Return(durations, tc.aggregationErr)
+			}
+
+			d := NewDeployments(&db, &fs_mocks.FileStorage{}, ArtifactContentType)
+
+			stats, err := d.GetDeploymentDurationStats(context.Background(), deploymentID)
+			if tc.err != nil {
+				assert.EqualError(t, err, tc.err.Error())
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.stats, stats)
+			}
+
+			db.AssertExpectations(t)
+		})
+	}
+}
+
+func TestGetDeploymentProgressStats(t *testing.T) {
+	deploymentID := "a108ae14-bb4e-455f-9b40-2ef4bab97bb7"
+
+	deployment := &model.Deployment{
+		Id:    StringToPointer(deploymentID),
+		Stats: model.NewDeviceDeploymentStats(),
+	}
+
+	failedStats := model.NewDeviceDeploymentStats()
+	failedStats[model.DeviceDeploymentStatusFailure] = 3
+	failedDeployment := &model.Deployment{
+		Id:    StringToPointer(deploymentID),
+		Stats: failedStats,
+	}
+
+	eta := int64(30)
+	progress := &model.DownloadProgressStats{
+		BytesTransferred: 10,
+		BytesTotal:       100,
+		ETA:              &eta,
+	}
+
+	testCases := map[string]struct {
+		deployment    *model.Deployment
+		deploymentErr error
+		progressErr   error
+		failures      map[string]int
+
+		stats *model.DeploymentProgressStats
+		err   error
+	}{
+		"ok": {
+			deployment: deployment,
+			stats: &model.DeploymentProgressStats{
+				Download: *progress,
+				Failures: map[string]int{},
+			},
+		},
+		"ok, failures": {
+			deployment: failedDeployment,
+			failures: map[string]int{
+				model.FailureCategoryDiskFull: 2,
+				model.FailureCategoryOther:    1,
+			},
+			stats: &model.DeploymentProgressStats{
+				Download: *progress,
+				Failures: map[string]int{
+					model.FailureCategoryDiskFull: 2,
+					model.FailureCategoryOther:    1,
+				},
+			},
+		},
+		"ok, deployment not found": {},
+		"error, deployment lookup failed": {
+			deploymentErr: errors.New("db error"),
+			err:           errors.New("checking deployment id: db error"),
+		},
+		"error, progress lookup failed": {
+			deployment:  deployment,
+			progressErr: errors.New("db error"),
+			err:         errors.New("retrieving download progress: db error"),
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			db := mocks.DataStore{}
+
+			db.On("FindDeploymentByID", contextMatcher(), deploymentID).
+				Return(tc.deployment, tc.deploymentErr)
+
+			if tc.deployment != nil {
+				db.On("AggregateDeviceDeploymentProgress", contextMatcher(),
+					deploymentID, mock.AnythingOfType("time.Time")).
+					Return(progress, tc.progressErr)
+			}
+			if tc.failures != nil {
+				db.On("AggregateDeviceDeploymentByFailureCategory", contextMatcher(),
+					deploymentID).Return(tc.failures, nil)
+			}
+
+			d := NewDeployments(&db, &fs_mocks.FileStorage{}, ArtifactContentType)
+
+			out, err := d.GetDeploymentProgressStats(context.Background(), deploymentID)
+			if tc.err != nil {
+				assert.EqualError(t, err, tc.err.Error())
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.stats, out)
+			}
+
+			db.AssertExpectations(t)
+		})
+	}
+}
+
+func TestUpdateDeviceDeploymentProgress(t *testing.T) {
+	deploymentID := "a108ae14-bb4e-455f-9b40-2ef4bab97bb7"
+	deviceID := "device0001"
+
+	testCases := map[string]struct {
+		dbErr error
+		err   error
+	}{
+		"ok": {},
+		"ok, device deployment finished": {
+			dbErr: mongo.ErrStorageNotFound,
+		},
+		"error": {
+			dbErr: errors.New("db error"),
+			err:   errors.New("db error"),
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			db := mocks.DataStore{}
+
+			db.On("UpdateDeviceDeploymentProgress", contextMatcher(),
+				deviceID, deploymentID,
+				mock.MatchedBy(func(p model.DownloadProgress) bool {
+					return p.BytesDownloaded == 10 && p.Updated != nil
+				})).Return(tc.dbErr)
+
+			d := NewDeployments(&db, &fs_mocks.FileStorage{}, ArtifactContentType)
+
+			err := d.UpdateDeviceDeploymentProgress(context.Background(),
+				deploymentID, deviceID, model.DownloadProgress{
+					BytesDownloaded: 10,
+					TotalBytes:      100,
+				})
+			if tc.err != nil {
+				assert.EqualError(t, err, tc.err.Error())
+			} else {
+				assert.NoError(t, err)
+			}
+
+			db.AssertExpectations(t)
+		})
+	}
+}
+
+func TestUpdateDeviceDeploymentStatusInstalledBase(t *testing.T) {
+	deploymentID := "a108ae14-bb4e-455f-9b40-2ef4bab97bb7"
+	deviceID := "device0001"
+	deviceType := "hammer"
+
+	deployment := &model.Deployment{
+		Id: StringToPointer(deploymentID),
+		Stats: model.Stats{
+			model.DeviceDeploymentStatusInstalling: 1,
+			model.DeviceDeploymentStatusPending:    1,
+		},
+	}
+
+	testCases := map[string]struct {
+		deviceDeployment *model.DeviceDeployment
+		findErr          error
+
+		installed bool
+	}{
+		"ok": {
+			deviceDeployment: &model.DeviceDeployment{
+				DeviceType: &deviceType,
+				Image: &model.SoftwareImage{
+					SoftwareImageMetaArtifactConstructor: model.SoftwareImageMetaArtifactConstructor{
+						Name: "release-2",
+					},
+				},
+			},
+			installed: true,
+		},
+		"ok, no artifact assigned": {
+			deviceDeployment: &model.DeviceDeployment{},
+		},
+		"ok, installed base not updated": {
+			findErr: errors.New("db error"),
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			db := mocks.DataStore{}
+
+			db.On("GetDeviceDeploymentStatus", contextMatcher(),
+				deploymentID, deviceID).
+				Return(model.DeviceDeploymentStatusInstalling, nil)
+			db.On("UpdateDeviceDeploymentStatus", contextMatcher(),
+				deviceID, deploymentID,
+				mock.MatchedBy(func(s model.DeviceDeploymentStatus) bool {
+					return s.Status == model.DeviceDeploymentStatusSuccess
+				})).Return(model.DeviceDeploymentStatusInstalling, nil)
+			db.On("UpdateStats", contextMatcher(), deploymentID,
+				model.DeviceDeploymentStatusInstalling,
+				model.DeviceDeploymentStatusSuccess).Return(nil)
+			db.On("FindDeploymentByID", contextMatcher(), deploymentID).
+				Return(deployment, nil)
+			db.On("IncrementDeploymentGeneration", contextMatcher()).Return(nil)
+			db.On("FindDeviceDeployment", contextMatcher(), deploymentID, deviceID).
+				Return(tc.deviceDeployment, tc.findErr)
+			if tc.installed {
+				db.On("UpsertInstalledArtifact", contextMatcher(),
+					mock.MatchedBy(func(i *model.InstalledArtifact) bool {
+						return i.DeviceID == deviceID &&
+							i.ArtifactName == "release-2" &&
+							i.DeviceType == deviceType &&
+							!i.Updated.IsZero()
+					})).Return(nil)
+			}
+
+			d := NewDeployments(&db, &fs_mocks.FileStorage{}, ArtifactContentType)
+
+			err := d.UpdateDeviceDeploymentStatus(context.Background(),
+				deploymentID, deviceID, model.DeviceDeploymentStatus{
+					Status: model.DeviceDeploymentStatusSuccess,
+				})
+			assert.NoError(t, err)
+
+			db.AssertExpectations(t)
+		})
+	}
+}
+
+func TestUpdateInstalledArtifact(t *testing.T) {
+	testCases := map[string]struct {
+		known     *model.InstalledArtifact
+		findErr   error
+		upsert    bool
+		upsertErr error
+
+		err string
+	}{
+		"ok, first report": {
+			upsert: true,
+		},
+		"ok, artifact changed": {
+			known: &model.InstalledArtifact{
+				DeviceID:     "device-1",
+				ArtifactName: "release-0",
+				DeviceType:   "hammer",
+			},
+			upsert: true,
+		},
+		"ok, artifact known": {
+			known: &model.InstalledArtifact{
+				DeviceID:     "device-1",
+				ArtifactName: "release-1",
+				DeviceType:   "hammer",
+			},
+		},
+		"error, searching": {
+			findErr: errors.New("db error"),
+			err:     "searching for installed artifact: db error",
+		},
+		"error, storing": {
+			upsert:    true,
+			upsertErr: errors.New("db error"),
+			err:       "storing installed artifact: db error",
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			db := mocks.DataStore{}
+
+			db.On("FindInstalledArtifact", contextMatcher(), "device-1").
+				Return(tc.known, tc.findErr)
+			if tc.upsert {
+				db.On("UpsertInstalledArtifact", contextMatcher(),
+					mock.MatchedBy(func(i *model.InstalledArtifact) bool {
+						return i.DeviceID == "device-1" &&
+							i.ArtifactName == "release-1" &&
+							i.DeviceType == "hammer" &&
+							!i.Updated.IsZero()
+					})).Return(tc.upsertErr)
+			}
+
+			d := NewDeployments(&db, &fs_mocks.FileStorage{}, ArtifactContentType)
+
+			err := d.UpdateInstalledArtifact(context.Background(), "device-1",
+				model.InstalledDeviceDeployment{
+					Artifact:   "release-1",
+					DeviceType: "hammer",
+					IP:         "10.1.2.3",
+				})
+			if tc.err != "" {
+				assert.EqualError(t, err, tc.err)
+			} else {
+				assert.NoError(t, err)
+			}
+
+			db.AssertExpectations(t)
+		})
+	}
+}
+
+func TestGetInstalledArtifacts(t *testing.T) {
+	installed := []model.InstalledArtifact{{
+		DeviceID:     "device-1",
+		ArtifactName: "release-1",
+		DeviceType:   "hammer",
+		Updated:      time.Now(),
+	}}
+
+	testCases := map[string]struct {
+		query model.InstalledBaseQuery
+
+		callDb bool
+		dbErr  error
+
+		err string
+	}{
+		"ok": {
+			query:  model.InstalledBaseQuery{ArtifactName: "release-1", Limit: 20},
+			callDb: true,
+		},
+		"error, no artifact name": {
+			query: model.InstalledBaseQuery{Limit: 20},
+			err:   model.ErrInstalledBaseArtifactNameRequired.Error(),
+		},
+		"error, db": {
+			query:  model.InstalledBaseQuery{ArtifactName: "release-1", Limit: 20},
+			callDb: true,
+			dbErr:  errors.New("db error"),
+			err:    "searching for installed artifacts: db error",
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			db := mocks.DataStore{}
+
+			if tc.callDb {
+				db.On("GetInstalledArtifacts", contextMatcher(), tc.query).
+					Return(installed, 1, tc.dbErr)
+			}
+
+			d := NewDeployments(&db, &fs_mocks.FileStorage{}, ArtifactContentType)
+
+			out, total, err := d.GetInstalledArtifacts(context.Background(), tc.query)
+			if tc.err != "" {
+				assert.EqualError(t, err, tc.err)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, installed, out)
+				assert.Equal(t, 1, total)
+			}
+
+			db.AssertExpectations(t)
+		})
+	}
+}
+
+func TestDecommissionDevice(t *testing.T) {
+	deploymentID := "a108ae14-bb4e-455f-9b40-2ef4bab97bb7"
+	deviceID := "device-1"
+
+	stats := model.Stats{model.DeviceDeploymentStatusDecommissioned: 1}
+
+	db := mocks.DataStore{}
+	db.On("DecommissionDeviceDeployments", contextMatcher(), deviceID).Return(nil)
+	db.On("FindAllDeploymentsForDeviceIDWithStatuses", contextMatcher(), deviceID,
+		[]string{model.DeviceDeploymentStatusDecommissioned}).
+		Return([]model.DeviceDeployment{{
+			DeploymentId: StringToPointer(deploymentID),
+			DeviceId:     StringToPointer(deviceID),
+		}}, nil)
+	db.On("AggregateDeviceDeploymentByStatus", contextMatcher(), deploymentID).
+		Return(stats, nil)
+	db.On("UpdateStatsAndFinishDeployment", contextMatcher(), deploymentID, stats).
+		Return(nil)
+	db.On("IncrementDeploymentGeneration", contextMatcher()).Return(nil)
+	db.On("DeleteInstalledArtifact", contextMatcher(), deviceID).Return(nil)
+
+	d := NewDeployments(&db, &fs_mocks.FileStorage{}, ArtifactContentType)
+
+	assert.NoError(t, d.DecommissionDevice(context.Background(), deviceID))
+
+	db.AssertExpectations(t)
+}
+
+func TestGetDeviceDeploymentHistory(t *testing.T) {
+	deploymentID := "a108ae14-bb4e-455f-9b40-2ef4bab97bb7"
+	removedID := "30b3e62c-9ec2-4312-a7fa-cff24cc7397a"
+	created := time.Now()
+
+	query := model.DeviceDeploymentsQuery{
+		DeviceID: "device0001",
+		Statuses: []string{model.DeviceDeploymentStatusSuccess},
+		Limit:    21,
+	}
+
+	deviceDeployments := []model.DeviceDeployment{
+		{
+			Created:      &created,
+			Status:       StringToPointer(model.DeviceDeploymentStatusSuccess),
+			DeviceId:     StringToPointer("device0001"),
+			DeploymentId: StringToPointer(deploymentID),
+		},
+		{
+			Created:      &created,
+			Status:       StringToPointer(model.DeviceDeploymentStatusSuccess),
+			DeviceId:     StringToPointer("device0001"),
+			DeploymentId: StringToPointer(removedID),
+		},
+	}
+
+	deployments := []*model.Deployment{
+		{
+			DeploymentConstructor: &model.DeploymentConstructor{
+				Name:         StringToPointer("foo"),
+				ArtifactName: StringToPointer("bar"),
+			},
+			Id: StringToPointer(deploymentID),
+		},
+	}
+
+	testCases := map[string]struct {
+		deviceDeploymentsErr error
+		deploymentsErr       error
+
+		history []model.DeviceDeploymentHistoryEntry
+		err     error
+	}{
+		"ok": {
+			history: []model.DeviceDeploymentHistoryEntry{
+				model.NewDeviceDeploymentHistoryEntry(&deviceDeployments[0],
+					deployments[0]),
+				model.NewDeviceDeploymentHistoryEntry(&deviceDeployments[1], nil),
+			},
+		},
+		"error, device deployments": {
+			deviceDeploymentsErr: errors.New("db error"),
+			err:                  errors.New("searching for device deployments: db error"),
+		},
+		"error, deployments": {
+			deploymentsErr: errors.New("db error"),
+			err:            errors.New("searching for deployments: db error"),
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			db := mocks.DataStore{}
+
+			db.On("FindDeviceDeployments", contextMatcher(), query).
+				Return(deviceDeployments, tc.deviceDeploymentsErr)
+
+			if tc.deviceDeploymentsErr == nil {
+				db.On("FindDeploymentsByIDs", contextMatcher(),
+					[]string{deploymentID, removedID}).
+					Return(deployments, tc.deploymentsErr)
+			}
+
+			d := NewDeployments(&db, &fs_mocks.FileStorage{}, ArtifactContentType)
+
+			history, err := d.GetDeviceDeploymentHistory(context.Background(), query)
+			if tc.err != nil {
+				assert.EqualError(t, err, tc.err.Error())
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.history, history)
+			}
+
+			db.AssertExpectations(t)
+		})
+	}
+}
+
+func TestGetDeviceStatusesForDeployment(t *testing.T) {
+	deploymentID := "a108ae14-bb4e-455f-9b40-2ef4bab97bb7"
+
+	query := model.DeploymentDevicesQuery{
+		DeploymentID: deploymentID,
+		Status:       model.DeviceDeploymentStatusFailure,
+		Limit:        20,
+	}
+
+	statuses := []model.DeviceDeployment{
+		{
+			DeviceId: StringToPointer("device0001"),
+			Status:   StringToPointer(model.DeviceDeploymentStatusFailure),
+		},
+	}
+
+	testCases := map[string]struct {
+		deployment    *model.Deployment
+		deploymentErr error
+		devicesErr    error
+
+		statuses []model.DeviceDeployment
+		total    int
+		err      error
+	}{
+		"ok": {
+			deployment: &model.Deployment{Id: StringToPointer(deploymentID)},
+			statuses:   statuses,
+			total:      42,
+		},
+		"error, deployment not found": {
+			err: ErrModelDeploymentNotFound,
+		},
+		"error, deployment lookup failed": {
+			deploymentErr: errors.New("db error"),
+			err:           ErrModelInternal,
+		},
+		"error, devices lookup failed": {
+			deployment: &model.Deployment{Id: StringToPointer(deploymentID)},
+			devicesErr: errors.New("db error"),
+			err:        ErrModelInternal,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			db := mocks.DataStore{}
+
+			db.On("FindDeploymentByID", contextMatcher(), deploymentID).
+				Return(tc.deployment, tc.deploymentErr)
+
+			if tc.deployment != nil {
+				db.On("GetDevicesListForDeployment", contextMatcher(), query).
+					Return(statuses, 42, tc.devicesErr)
+			}
+
+			d := NewDeployments(&db, &fs_mocks.FileStorage{}, ArtifactContentType)
+
+			out, total, err := d.GetDeviceStatusesForDeployment(context.Background(), query)
+			if tc.err != nil {
+				assert.EqualError(t, err, tc.err.Error())
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.statuses, out)
+				assert.Equal(t, tc.total, total)
+			}
+
+			db.AssertExpectations(t)
+		})
+	}
+}
+
+func TestLookupDeployment(t *testing.T) {
+	query := model.Query{
+		Status: model.StatusQueryAny,
+		SortBy: model.DeploymentsSortName,
+		Limit:  10,
+	}
+
+	deployments := []*model.Deployment{
+		{Id: StringToPointer("a108ae14-bb4e-455f-9b40-2ef4bab97bb7"), DeviceCount: 3},
+	}
+
+	testCases := map[string]struct {
+		found    []*model.Deployment
+		findErr  error
+		countErr error
+
+		deployments []*model.Deployment
+		total       int
+		err         error
+	}{
+		"ok": {
+			found:       deployments,
+			deployments: deployments,
+			total:       11,
+		},
+		"ok, nothing found": {
+			deployments: []*model.Deployment{},
+		},
+		"error, find": {
+			findErr: errors.New("db error"),
+			err:     errors.New("searching for deployments: db error"),
+		},
+		"error, count": {
+			found:    deployments,
+			countErr: errors.New("db error"),
+			err:      errors.New("counting deployments: db error"),
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			db := mocks.DataStore{}
+
+			db.On("Find", contextMatcher(), query).Return(tc.found, tc.findErr)
+			if tc.findErr == nil {
+				db.On("CountDeployments", contextMatcher(), query).
+					Return(tc.total, tc.countErr)
+			}
+
+			d := NewDeployments(&db, &fs_mocks.FileStorage{}, ArtifactContentType)
+
+			out, total, err := d.LookupDeployment(context.Background(), query)
+			if tc.err != nil {
+				assert.EqualError(t, err, tc.err.Error())
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.deployments, out)
+				assert.Equal(t, tc.total, total)
+			}
+
+			db.AssertExpectations(t)
+		})
+	}
+}
+
+func TestEditDeployment(t *testing.T) {
+	deploymentID := "a108ae14-bb4e-455f-9b40-2ef4bab97bb7"
+
+	meta := model.DeploymentMetadataConstructor{
+		Description: "monthly update",
+		Labels: []model.DeploymentLabel{
+			{Key: "ticket", Value: "OPS-123"},
+		},
+	}
+
+	testCases := map[string]struct {
+		meta     model.DeploymentMetadataConstructor
+		dbErr    error
+		noUpdate bool
+
+		found bool
+		err   error
+	}{
+		"ok": {
+			meta:  meta,
+			found: true,
+		},
+		"not found": {
+			meta:  meta,
+			dbErr: mongo.ErrStorageNotFound,
+		},
+		"error, invalid label": {
+			meta: model.DeploymentMetadataConstructor{
+				Labels: []model.DeploymentLabel{{Key: "a:b"}},
+			},
+			noUpdate: true,
+			err:      errors.New("Validating deployment metadata: Invalid label"),
+		},
+		"error, db": {
+			meta:  meta,
+			dbErr: errors.New("db error"),
+			err:   errors.New("Updating deployment metadata: db error"),
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			db := mocks.DataStore{}
+
+			if !tc.noUpdate {
+				db.On("UpdateDeploymentMetadata", contextMatcher(), deploymentID, tc.meta).
+					Return(tc.dbErr)
+			}
+
+			d := NewDeployments(&db, &fs_mocks.FileStorage{}, ArtifactContentType)
+
+			found, err := d.EditDeployment(context.Background(), deploymentID, &tc.meta)
+			if tc.err != nil {
+				assert.EqualError(t, err, tc.err.Error())
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.found, found)
+			}
+
+			db.AssertExpectations(t)
+		})
+	}
+}
+
+func TestGenerateDeploymentReport(t *testing.T) {
+	deploymentID := "a108ae14-bb4e-455f-9b40-2ef4bab97bb7"
+	created := time.Now()
+
+	deviceDeployments := []model.DeviceDeployment{
+		{
+			Created:        &created,
+			Status:         StringToPointer(model.DeviceDeploymentStatusFailure),
+			DeviceId:       StringToPointer("device0001"),
+			IsLogAvailable: true,
+		},
+		{
+			Created:  &created,
+			Status:   StringToPointer(model.DeviceDeploymentStatusPending),
+			DeviceId: StringToPointer("device0002"),
+		},
+	}
+
+	deploymentLog := &model.DeploymentLog{
+		Messages: []model.LogMessage{
+			{Timestamp: &created, Level: "info", Message: "foo"},
+			{Timestamp: &created, Level: "error", Message: "bar"},
+		},
+	}
+
+	testCases := map[string]struct {
+		deployment    *model.Deployment
+		deploymentErr error
+		logLines      int
+		logErr        error
+		fnErr         error
+
+		rows []*model.DeploymentReportRow
+		err  error
+	}{
+		"ok": {
+			deployment: &model.Deployment{Id: StringToPointer(deploymentID)},
+			rows: []*model.DeploymentReportRow{
+				model.NewDeploymentReportRow(&deviceDeployments[0]),
+				model.NewDeploymentReportRow(&deviceDeployments[1]),
+			},
+		},
+		"ok, with log": {
+			deployment: &model.Deployment{Id: StringToPointer(deploymentID)},
+			logLines:   1,
+			rows: func() []*model.DeploymentReportRow {
+				row := model.NewDeploymentReportRow(&deviceDeployments[0])
+				row.SetLog(deploymentLog, 1)
+				return []*model.DeploymentReportRow{
+					row,
+					model.NewDeploymentReportRow(&deviceDeployments[1]),
+				}
+			}(),
+		},
+		"error, deployment not found": {
+			err: ErrModelDeploymentNotFound,
+		},
+		"error, deployment lookup failed": {
+			deploymentErr: errors.New("db error"),
+			err:           errors.New("checking deployment id: db error"),
+		},
+		"error, log": {
+			deployment: &model.Deployment{Id: StringToPointer(deploymentID)},
+			logLines:   1,
+			logErr:     errors.New("db error"),
+			err:        errors.New("retrieving deployment log: db error"),
+		},
+		"error, writing row": {
+			deployment: &model.Deployment{Id: StringToPointer(deploymentID)},
+			fnErr:      errors.New("broken pipe"),
+			rows: []*model.DeploymentReportRow{
+				model.NewDeploymentReportRow(&deviceDeployments[0]),
+			},
+			err: errors.New("broken pipe"),
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			db := mocks.DataStore{}
+
+			db.On("FindDeploymentByID", contextMatcher(), deploymentID).
+				Return(tc.deployment, tc.deploymentErr)
+
+			if tc.deployment != nil {
+				db.On("IterateDeviceDeploymentsForDeployment", contextMatcher(),
+					deploymentID, mock.AnythingOfType("func(*model.DeviceDeployment) error")).
+					Return(func(_ context.Context, _ string,
+						fn func(*model.DeviceDeployment) error) error {
+						for i := range deviceDeployments {
+							if err := fn(&deviceDeployments[i]); err != nil {
+								return err
+							}
+						}
+						return nil
+					})
+			}
+
+			if tc.logLines > 0 {
+				db.On("GetDeviceDeploymentLog", contextMatcher(),
+					"device0001", deploymentID).
+					Return(deploymentLog, tc.logErr)
+			}
+
+			d := NewDeployments(&db, &fs_mocks.FileStorage{}, ArtifactContentType)
+
+			var rows []*model.DeploymentReportRow
+			err := d.GenerateDeploymentReport(context.Background(), deploymentID,
+				tc.logLines, func(row *model.DeploymentReportRow) error {
+					rows = append(rows, row)
+					return tc.fnErr
+				})
+			if tc.err != nil {
+				assert.EqualError(t, err, tc.err.Error())
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tc.rows, rows)
+
+			db.AssertExpectations(t)
+		})
+	}
+}
+
+func TestAppendDeviceDeploymentLog(t *testing.T) {
+	t.Parallel()
+
+	deviceID := "device-1"
+	deploymentID := "30b3e62c-9ec2-4312-a7fa-cff24cc7397a"
+	now := time.Now()
+	messages := []model.LogMessage{
+		{Timestamp: &now, Level: "info", Message: "foo"},
+	}
+	dlog := model.DeploymentLog{
+		DeviceID:     deviceID,
+		DeploymentID: deploymentID,
+		Messages:     messages,
+	}
+
+	testCases := map[string]struct {
+		hasDeployment bool
+		appendErr     error
+		status        string
+
+		category string
+		err      error
+	}{
+		"ok": {
+			hasDeployment: true,
+			status:        model.DeviceDeploymentStatusInstalling,
+		},
+		"ok, failed device": {
+			hasDeployment: true,
+			status:        model.DeviceDeploymentStatusFailure,
+			category:      "flash",
+		},
+		"error, no deployment": {
+			err: ErrModelDeploymentNotFound,
+		},
+		"error, db": {
+			hasDeployment: true,
+			appendErr:     errors.New("db failed"),
+			err:           errors.New("db failed"),
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			db := mocks.DataStore{}
+			db.On("HasDeploymentForDevice", contextMatcher(), deploymentID, deviceID).
+				Return(tc.hasDeployment, nil)
+			if tc.hasDeployment {
+				db.On("AppendDeviceDeploymentLog", contextMatcher(), dlog).
+					Return(tc.appendErr)
+			}
+			if tc.hasDeployment && tc.appendErr == nil {
+				db.On("UpdateDeviceDeploymentLogAvailability", contextMatcher(),
+					deviceID, deploymentID, true).Return(nil)
+				db.On("FindDeviceDeployment", contextMatcher(), deploymentID, deviceID).
+					Return(&model.DeviceDeployment{Status: &tc.status}, nil)
+			}
+			if tc.category != "" {
+				db.On("GetSettings", contextMatcher()).Return(&model.Settings{
+					FailureRules: []model.FailureRule{
+						{Category: "flash", Pattern: "flash"},
+					},
+				}, nil)
+				db.On("GetDeviceDeploymentLog", contextMatcher(), deviceID, deploymentID).
+					Return(&model.DeploymentLog{
+						Messages: []model.LogMessage{
+							{Timestamp: &now, Level: "error", Message: "flash write failed"},
+						},
+					}, nil)
+				db.On("UpdateDeviceDeploymentFailureCategory", contextMatcher(),
+					deviceID, deploymentID, tc.category).Return(nil)
+			}
+
+			ds := NewDeployments(&db, &fs_mocks.FileStorage{}, ArtifactContentType)
+			err := ds.AppendDeviceDeploymentLog(context.Background(),
+				deviceID, deploymentID, messages)
+			if tc.err != nil {
+				assert.EqualError(t, err, tc.err.Error())
+			} else {
+				assert.NoError(t, err)
+			}
+
+			db.AssertExpectations(t)
+		})
+	}
+}
+
+func TestSearchDeploymentLogs(t *testing.T) {
+	t.Parallel()
+
+	deploymentID := "30b3e62c-9ec2-4312-a7fa-cff24cc7397a"
+	now := time.Now()
+
+	matches := []model.DeploymentLogMatch{
+		{
+			DeviceID:     "device-1",
+			DeploymentID: deploymentID,
+			Messages: []model.LogMessage{
+				{Timestamp: &now, Level: "error", Message: "no space left"},
+			},
+		},
+	}
+
+	testCases := map[string]struct {
+		query model.DeploymentLogQuery
+
+		deployment *model.Deployment
+		searchErr  error
+
+		matches []model.DeploymentLogMatch
+		err     error
+	}{
+		"ok, deployment": {
+			query:      model.DeploymentLogQuery{DeploymentID: deploymentID, Text: "space"},
+			deployment: &model.Deployment{Id: &deploymentID},
+			matches:    matches,
+		},
+		"ok, time range": {
+			query:   model.DeploymentLogQuery{From: &now, Level: "error"},
+			matches: matches,
+		},
+		"error, deployment not found": {
+			query: model.DeploymentLogQuery{DeploymentID: deploymentID},
+			err:   ErrModelDeploymentNotFound,
+		},
+		"error, db": {
+			query:     model.DeploymentLogQuery{From: &now},
+			searchErr: errors.New("db failed"),
+			err:       errors.New("searching deployment logs: db failed"),
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			db := mocks.DataStore{}
+			if tc.query.DeploymentID != "" {
+				db.On("FindDeploymentByID", contextMatcher(), tc.query.DeploymentID).
+					Return(tc.deployment, nil)
+			}
+			if tc.query.DeploymentID == "" || tc.deployment != nil {
+				db.On("SearchDeviceDeploymentLogs", contextMatcher(), tc.query).
+					Return(tc.matches, tc.searchErr)
+			}
+
+			ds := NewDeployments(&db, &fs_mocks.FileStorage{}, ArtifactContentType)
+			res, err := ds.SearchDeploymentLogs(context.Background(), tc.query)
+			if tc.err != nil {
+				assert.EqualError(t, err, tc.err.Error())
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.matches, res)
+			}
+
+			db.AssertExpectations(t)
+		})
+	}
+}
+
+func TestWaitForDeploymentForDevice(t *testing.T) {
+	deviceID := "device-1"
+	installed := model.InstalledDeviceDeployment{
+		Artifact:   "foo",
+		DeviceType: "hammer",
+	}
+
+	t.Run("ok, no deployment", func(t *testing.T) {
+		db := mocks.DataStore{}
+		db.On("FindOldestDeploymentForDeviceIDWithStatuses", contextMatcher(),
+			deviceID, mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+			Return(nil, nil)
+
+		d := NewDeployments(&db, &fs_mocks.FileStorage{}, ArtifactContentType)
+
+		start := time.Now()
+		out, err := d.WaitForDeploymentForDevice(context.Background(),
+			deviceID, installed, 50*time.Millisecond)
+		assert.NoError(t, err)
+		assert.Nil(t, out)
+		assert.True(t, time.Since(start) >= 50*time.Millisecond)
+
+		db.AssertExpectations(t)
+	})
+
+	t.Run("ok, woken up by new deployment", func(t *testing.T) {
+		db := mocks.DataStore{}
+		// the second lookup happens only after the notification
+		db.On("FindOldestDeploymentForDeviceIDWithStatuses", contextMatcher(),
+			deviceID, mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+			Return(nil, nil).Once()
+		db.On("FindOldestDeploymentForDeviceIDWithStatuses", contextMatcher(),
+			deviceID, mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+			Return(nil, errors.New("db failed")).Once()
+
+		d := NewDeployments(&db, &fs_mocks.FileStorage{}, ArtifactContentType)
+
+		go func() {
+			time.Sleep(20 * time.Millisecond)
+			d.notifyDevices(context.Background(), []string{"device-0", deviceID})
+		}()
+
+		start := time.Now()
+		_, err := d.WaitForDeploymentForDevice(context.Background(),
+			deviceID, installed, time.Minute)
+		assert.EqualError(t, err,
+			"Searching for oldest active deployment for the device: db failed")
+		assert.True(t, time.Since(start) < time.Minute)
+
+		db.AssertExpectations(t)
+	})
+
+	t.Run("ok, request cancelled", func(t *testing.T) {
+		db := mocks.DataStore{}
+		db.On("FindOldestDeploymentForDeviceIDWithStatuses", contextMatcher(),
+			deviceID, mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+			Return(nil, nil)
+
+		d := NewDeployments(&db, &fs_mocks.FileStorage{}, ArtifactContentType)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+
+		out, err := d.WaitForDeploymentForDevice(ctx, deviceID, installed, time.Minute)
+		assert.NoError(t, err)
+		assert.Nil(t, out)
+	})
+}
+
+func TestNotifyDevices(t *testing.T) {
+	devices := make([]string, model.MaxDeviceNotificationDevices+1)
+	for i := range devices {
+		devices[i] = fmt.Sprintf("device-%d", i)
+	}
+
+	db := mocks.DataStore{}
+	d := NewDeployments(&db, &fs_mocks.FileStorage{}, ArtifactContentType)
+
+	ctx := identity.WithContext(context.Background(), &identity.Identity{
+		Tenant: "acme",
+	})
+
+	// notifications are not published until the watcher runs
+	d.notifyDevices(ctx, devices)
+
+	d.notificationsWatched = 1
+	db.On("InsertDeviceNotification", contextMatcher(), model.DeviceNotification{
+		Origin:    d.instanceID,
+		Tenant:    "acme",
+		DeviceIDs: devices[:model.MaxDeviceNotificationDevices],
+	}).Return(nil)
+	db.On("InsertDeviceNotification", contextMatcher(), model.DeviceNotification{
+		Origin:    d.instanceID,
+		Tenant:    "acme",
+		DeviceIDs: devices[model.MaxDeviceNotificationDevices:],
+	}).Return(nil)
+	d.notifyDevices(ctx, devices)
+
+	db.AssertExpectations(t)
+
+	// notifications of other instances wake up local devices
+	ch, cancel := d.notifier.subscribe("acme", "device-1")
+	defer cancel()
+
+	d.handleDeviceNotification(model.DeviceNotification{
+		Origin:    d.instanceID,
+		Tenant:    "acme",
+		DeviceIDs: []string{"device-1"},
+	})
+	select {
+	case <-ch:
+		t.Error("own notification should be skipped")
+	default:
+	}
+
+	d.handleDeviceNotification(model.DeviceNotification{
+		Origin:    "other",
+		Tenant:    "acme",
+		DeviceIDs: []string{"device-1"},
+	})
+	select {
+	case <-ch:
+	default:
+		t.Error("device not woken up")
+	}
+}
@@ -37,6 +37,62 @@ func (_m *App) AbortDeployment(ctx context.Context, deploymentID string) error {
 	return r0
 }
 
+// AddDevicesToDeployment provides a mock function with given fields: ctx, deploymentID, devices
+func (_m *App) AddDevicesToDeployment(ctx context.Context, deploymentID string, devices []string) error {
+	ret := _m.Called(ctx, deploymentID, devices)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, []string) error); ok {
+		r0 = rf(ctx, deploymentID, devices)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// AppendDeviceDeploymentLog provides a mock function with given fields: ctx, deviceID, deploymentID, logs
+func (_m *App) AppendDeviceDeploymentLog(ctx context.Context, deviceID string, deploymentID string, logs []model.LogMessage) error {
+	ret := _m.Called(ctx, deviceID, deploymentID, logs)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, string, []model.LogMessage) error); ok {
+		r0 = rf(ctx, deviceID, deploymentID, logs)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// ContinueDeployment provides a mock function with given fields: ctx, deploymentID, pausePoint
+func (_m *App) ContinueDeployment(ctx context.Context, deploymentID string, pausePoint string) error {
+	ret := _m.Called(ctx, deploymentID, pausePoint)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) error); ok {
+		r0 = rf(ctx, deploymentID, pausePoint)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// ContinueDeviceDeployment provides a mock function with given fields: ctx, deploymentID, deviceID, pausePoint
+func (_m *App) ContinueDeviceDeployment(ctx context.Context, deploymentID string, deviceID string, pausePoint string) error {
+	ret := _m.Called(ctx, deploymentID, deviceID, pausePoint)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, string, string) error); ok {
+		r0 = rf(ctx, deploymentID, deviceID, pausePoint)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // CreateDeployment provides a mock function with given fields: ctx, constructor
 func (_m *App) CreateDeployment(ctx context.Context, constructor *model.DeploymentConstructor) (string, error) {
 	ret := _m.Called(ctx, constructor)
@@ -79,6 +135,20 @@ func (_m *App) CreateImage(ctx context.Context, multipartUploadMsg *model.Multip
 	return r0, r1
 }
 
+// DecideDeploymentApproval provides a mock function with given fields: ctx, deploymentID, userID, status
+func (_m *App) DecideDeploymentApproval(ctx context.Context, deploymentID string, userID string, status string) error {
+	ret := _m.Called(ctx, deploymentID, userID, status)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, string, string) error); ok {
+		r0 = rf(ctx, deploymentID, userID, status)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // DecommissionDevice provides a mock function with given fields: ctx, deviceID
 func (_m *App) DecommissionDevice(ctx context.Context, deviceID string) error {
 	ret := _m.Called(ctx, deviceID)
@@ -130,6 +200,27 @@ func (_m *App) DownloadLink(ctx context.Context, imageID string, expire time.Dur
 	return r0, r1
 }
 
+// EditDeployment provides a mock function with given fields: ctx, deploymentID, meta
+func (_m *App) EditDeployment(ctx context.Context, deploymentID string, meta *model.DeploymentMetadataConstructor) (bool, error) {
+	ret := _m.Called(ctx, deploymentID, meta)
+
+	var r0 bool
+	if rf, ok := ret.Get(0).(func(context.Context, string, *model.DeploymentMetadataConstructor) bool); ok {
+		r0 = rf(ctx, deploymentID, meta)
+	} else {
+		r0 = ret.Get(0).(bool)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string, *model.DeploymentMetadataConstructor) error); ok {
+		r1 = rf(ctx, deploymentID, meta)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // EditImage provides a mock function with given fields: ctx, id, constructorData
 func (_m *App) EditImage(ctx context.Context, id string, constructorData *model.SoftwareImageMetaConstructor) (bool, error) {
 	ret := _m.Called(ctx, id, constructorData)
@@ -151,6 +242,20 @@ func (_m *App) EditImage(ctx context.Context, id string, constructorData *model.
 	return r0, r1
 }
 
+// GenerateDeploymentReport provides a mock function with given fields: ctx, deploymentID, logLines, fn
+func (_m *App) GenerateDeploymentReport(ctx context.Context, deploymentID string, logLines int, fn func(*model.DeploymentReportRow) error) error {
+	ret := _m.Called(ctx, deploymentID, logLines, fn)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, int, func(*model.DeploymentReportRow) error) error); ok {
+		r0 = rf(ctx, deploymentID, logLines, fn)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // GetDeployment provides a mock function with given fields: ctx, deploymentID
 func (_m *App) GetDeployment(ctx context.Context, deploymentID string) (*model.Deployment, error) {
 	ret := _m.Called(ctx, deploymentID)
@@ -174,6 +279,29 @@ func (_m *App) GetDeployment(ctx context.Context, deploymentID string) (*model.D
 	return r0, r1
 }
 
+// GetDeploymentDurationStats provides a mock function with given fields: ctx, deploymentID
+func (_m *App) GetDeploymentDurationStats(ctx context.Context, deploymentID string) (*model.DeploymentDurationStats, error) {
+	ret := _m.Called(ctx, deploymentID)
+
+	var r0 *model.DeploymentDurationStats
+	if rf, ok := ret.Get(0).(func(context.Context, string) *model.DeploymentDurationStats); ok {
+		r0 = rf(ctx, deploymentID)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.DeploymentDurationStats)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, deploymentID)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // GetDeploymentForDeviceWithCurrent provides a mock function with given fields: ctx, deviceID, current
 func (_m *App) GetDeploymentForDeviceWithCurrent(ctx context.Context, deviceID string, current model.InstalledDeviceDeployment) (*model.DeploymentInstructions, error) {
 	ret := _m.Called(ctx, deviceID, current)
@@ -197,6 +325,50 @@ func (_m *App) GetDeploymentForDeviceWithCurrent(ctx context.Context, deviceID s
 	return r0, r1
 }
 
+// GetDeploymentGeneration provides a mock function with given fields: ctx
+func (_m *App) GetDeploymentGeneration(ctx context.Context) (int64, error) {
+	ret := _m.Called(ctx)
+
+	var r0 int64
+	if rf, ok := ret.Get(0).(func(context.Context) int64); ok {
+		r0 = rf(ctx)
+	} else {
+		r0 = ret.Get(0).(int64)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetDeploymentProgressStats provides a mock function with given fields: ctx, deploymentID
+func (_m *App) GetDeploymentProgressStats(ctx context.Context, deploymentID string) (*model.DeploymentProgressStats, error) {
+	ret := _m.Called(ctx, deploymentID)
+
+	var r0 *model.DeploymentProgressStats
+	if rf, ok := ret.Get(0).(func(context.Context, string) *model.DeploymentProgressStats); ok {
+		r0 = rf(ctx, deploymentID)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.DeploymentProgressStats)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, deploymentID)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // GetDeploymentStats provides a mock function with given fields: ctx, deploymentID
 func (_m *App) GetDeploymentStats(ctx context.Context, deploymentID string) (model.Stats, error) {
 	ret := _m.Called(ctx, deploymentID)
@@ -220,6 +392,29 @@ func (_m *App) GetDeploymentStats(ctx context.Context, deploymentID string) (mod
 	return r0, r1
 }
 
+// GetDeviceDeploymentHistory provides a mock function with given fields: ctx, query
+func (_m *App) GetDeviceDeploymentHistory(ctx context.Context, query model.DeviceDeploymentsQuery) ([]model.DeviceDeploymentHistoryEntry, error) {
+	ret := _m.Called(ctx, query)
+
+	var r0 []model.DeviceDeploymentHistoryEntry
+	if rf, ok := ret.Get(0).(func(context.Context, model.DeviceDeploymentsQuery) []model.DeviceDeploymentHistoryEntry); ok {
+		r0 = rf(ctx, query)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]model.DeviceDeploymentHistoryEntry)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, model.DeviceDeploymentsQuery) error); ok {
+		r1 = rf(ctx, query)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // GetDeviceDeploymentLog provides a mock function with given fields: ctx, deviceID, deploymentID
 func (_m *App) GetDeviceDeploymentLog(ctx context.Context, deviceID string, deploymentID string) (*model.DeploymentLog, error) {
 	ret := _m.Called(ctx, deviceID, deploymentID)
@@ -243,27 +438,34 @@ func (_m *App) GetDeviceDeploymentLog(ctx context.Context, deviceID string, depl
 	return r0, r1
 }
 
-// GetDeviceStatusesForDeployment provides a mock function with given fields: ctx, deploymentID
-func (_m *App) GetDeviceStatusesForDeployment(ctx context.Context, deploymentID string) ([]model.DeviceDeployment, error) {
-	ret := _m.Called(ctx, deploymentID)
+// GetDeviceStatusesForDeployment provides a mock function with given fields: ctx, query
+func (_m *App) GetDeviceStatusesForDeployment(ctx context.Context, query model.DeploymentDevicesQuery) ([]model.DeviceDeployment, int, error) {
+	ret := _m.Called(ctx, query)
 
 	var r0 []model.DeviceDeployment
-	if rf, ok := ret.Get(0).(func(context.Context, string) []model.DeviceDeployment); ok {
-		r0 = rf(ctx, deploymentID)
+	if rf, ok := ret.Get(0).(func(context.Context, model.DeploymentDevicesQuery) []model.DeviceDeployment); ok {
+		r0 = rf(ctx, query)
 	} else {
 		if ret.Get(0) != nil {
 			r0 = ret.Get(0).([]model.DeviceDeployment)
 		}
 	}
 
-	var r1 error
-	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
-		r1 = rf(ctx, deploymentID)
+	var r1 int
+	if rf, ok := ret.Get(1).(func(context.Context, model.DeploymentDevicesQuery) int); ok {
+		r1 = rf(ctx, query)
 	} else {
-		r1 = ret.Error(1)
+		r1 = ret.Get(1).(int)
 	}
 
-	return r0, r1
+	var r2 error
+	if rf, ok := ret.Get(2).(func(context.Context, model.DeploymentDevicesQuery) error); ok {
+		r2 = rf(ctx, query)
+	} else {
+		r2 = ret.Error(2)
+	}
+
+	return r0, r1, r2
 }
 
 // GetImage provides a mock function with given fields: ctx, id
@@ -289,6 +491,59 @@ func (_m *App) GetImage(ctx context.Context, id string) (*model.SoftwareImage, e
 	return r0, r1
 }
 
+// GetInstalledArtifacts provides a mock function with given fields: ctx, query
+func (_m *App) GetInstalledArtifacts(ctx context.Context, query model.InstalledBaseQuery) ([]model.InstalledArtifact, int, error) {
+	ret := _m.Called(ctx, query)
+
+	var r0 []model.InstalledArtifact
+	if rf, ok := ret.Get(0).(func(context.Context, model.InstalledBaseQuery) []model.InstalledArtifact); ok {
+		r0 = rf(ctx, query)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]model.InstalledArtifact)
+		}
+	}
+
+	var r1 int
+	if rf, ok := ret.Get(1).(func(context.Context, model.InstalledBaseQuery) int); ok {
+		r1 = rf(ctx, query)
+	} else {
+		r1 = ret.Get(1).(int)
+	}
+
+	var r2 error
+	if rf, ok := ret.Get(2).(func(context.Context, model.InstalledBaseQuery) error); ok {
+		r2 = rf(ctx, query)
+	} else {
+		r2 = ret.Error(2)
+	}
+
+	return r0, r1, r2
+}
+
+// GetInstalledBase provides a mock function with given fields: ctx, artifactName
+func (_m *App) GetInstalledBase(ctx context.Context, artifactName string) ([]model.InstalledBaseRelease, error) {
+	ret := _m.Called(ctx, artifactName)
+
+	var r0 []model.InstalledBaseRelease
+	if rf, ok := ret.Get(0).(func(context.Context, string) []model.InstalledBaseRelease); ok {
+		r0 = rf(ctx, artifactName)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]model.InstalledBaseRelease)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, artifactName)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // GetLimit provides a mock function with given fields: ctx, name
 func (_m *App) GetLimit(ctx context.Context, name string) (*model.Limit, error) {
 	ret := _m.Called(ctx, name)
@@ -312,6 +567,29 @@ func (_m *App) GetLimit(ctx context.Context, name string) (*model.Limit, error)
 	return r0, r1
 }
 
+// GetSettings provides a mock function with given fields: ctx
+func (_m *App) GetSettings(ctx context.Context) (*model.Settings, error) {
+	ret := _m.Called(ctx)
+
+	var r0 *model.Settings
+	if rf, ok := ret.Get(0).(func(context.Context) *model.Settings); ok {
+		r0 = rf(ctx)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.Settings)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // HasDeploymentForDevice provides a mock function with given fields: ctx, deploymentID, deviceID
 func (_m *App) HasDeploymentForDevice(ctx context.Context, deploymentID string, deviceID string) (bool, error) {
 	ret := _m.Called(ctx, deploymentID, deviceID)
@@ -354,6 +632,27 @@ func (_m *App) IsDeploymentFinished(ctx context.Context, deploymentID string) (b
 	return r0, r1
 }
 
+// IsDeviceDeploymentContinued provides a mock function with given fields: ctx, deploymentID, deviceID, pausePoint
+func (_m *App) IsDeviceDeploymentContinued(ctx context.Context, deploymentID string, deviceID string, pausePoint string) (bool, error) {
+	ret := _m.Called(ctx, deploymentID, deviceID, pausePoint)
+
+	var r0 bool
+	if rf, ok := ret.Get(0).(func(context.Context, string, string, string) bool); ok {
+		r0 = rf(ctx, deploymentID, deviceID, pausePoint)
+	} else {
+		r0 = ret.Get(0).(bool)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string, string, string) error); ok {
+		r1 = rf(ctx, deploymentID, deviceID, pausePoint)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // ListImages provides a mock function with given fields: ctx, filters
 func (_m *App) ListImages(ctx context.Context, filters map[string]string) ([]*model.SoftwareImage, error) {
 	ret := _m.Called(ctx, filters)
@@ -378,7 +677,7 @@ func (_m *App) ListImages(ctx context.Context, filters map[string]string) ([]*mo
 }
 
 // LookupDeployment provides a mock function with given fields: ctx, query
-func (_m *App) LookupDeployment(ctx context.Context, query model.Query) ([]*model.Deployment, error) {
+func (_m *App) LookupDeployment(ctx context.Context, query model.Query) ([]*model.Deployment, int, error) {
 	ret := _m.Called(ctx, query)
 
 	var r0 []*model.Deployment
@@ -390,14 +689,21 @@ func (_m *App) LookupDeployment(ctx context.Context, query model.Query) ([]*mode
 		}
 	}
 
-	var r1 error
-	if rf, ok := ret.Get(1).(func(context.Context, model.Query) error); ok {
+	var r1 int
+	if rf, ok := ret.Get(1).(func(context.Context, model.Query) int); ok {
 		r1 = rf(ctx, query)
 	} else {
-		r1 = ret.Error(1)
+		r1 = ret.Get(1).(int)
 	}
 
-	return r0, r1
+	var r2 error
+	if rf, ok := ret.Get(2).(func(context.Context, model.Query) error); ok {
+		r2 = rf(ctx, query)
+	} else {
+		r2 = ret.Error(2)
+	}
+
+	return r0, r1, r2
 }
 
 // ProvisionTenant provides a mock function with given fields: ctx, tenant_id
@@ -428,6 +734,71 @@ func (_m *App) SaveDeviceDeploymentLog(ctx context.Context, deviceID string, dep
 	return r0
 }
 
+// SaveSettings provides a mock function with given fields: ctx, settings
+func (_m *App) SaveSettings(ctx context.Context, settings *model.Settings) error {
+	ret := _m.Called(ctx, settings)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, *model.Settings) error); ok {
+		r0 = rf(ctx, settings)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// SearchDeploymentLogs provides a mock function with given fields: ctx, query
+func (_m *App) SearchDeploymentLogs(ctx context.Context, query model.DeploymentLogQuery) ([]model.DeploymentLogMatch, error) {
+	ret := _m.Called(ctx, query)
+
+	var r0 []model.DeploymentLogMatch
+	if rf, ok := ret.Get(0).(func(context.Context, model.DeploymentLogQuery) []model.DeploymentLogMatch); ok {
+		r0 = rf(ctx, query)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]model.DeploymentLogMatch)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, model.DeploymentLogQuery) error); ok {
+		r1 = rf(ctx, query)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// SetRequireApproval provides a mock function with given fields: ctx, require
+func (_m *App) SetRequireApproval(ctx context.Context, require bool) error {
+	ret := _m.Called(ctx, require)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, bool) error); ok {
+		r0 = rf(ctx, require)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// UpdateDeviceDeploymentProgress provides a mock function with given fields: ctx, deploymentID, deviceID, progress
+func (_m *App) UpdateDeviceDeploymentProgress(ctx context.Context, deploymentID string, deviceID string, progress model.DownloadProgress) error {
+	ret := _m.Called(ctx, deploymentID, deviceID, progress)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, string, model.DownloadProgress) error); ok {
+		r0 = rf(ctx, deploymentID, deviceID, progress)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // UpdateDeviceDeploymentStatus provides a mock function with given fields: ctx, deploymentID, deviceID, status
 func (_m *App) UpdateDeviceDeploymentStatus(ctx context.Context, deploymentID string, deviceID string, status model.DeviceDeploymentStatus) error {
 	ret := _m.Called(ctx, deploymentID, deviceID, status)
@@ -441,3 +812,40 @@ func (_m *App) UpdateDeviceDeploymentStatus(ctx context.Context, deploymentID st
 
 	return r0
 }
+
+// UpdateInstalledArtifact provides a mock function with given fields: ctx, deviceID, installed
+func (_m *App) UpdateInstalledArtifact(ctx context.Context, deviceID string, installed model.InstalledDeviceDeployment) error {
+	ret := _m.Called(ctx, deviceID, installed)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, model.InstalledDeviceDeployment) error); ok {
+		r0 = rf(ctx, deviceID, installed)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// WaitForDeploymentForDevice provides a mock function with given fields: ctx, deviceID, installed, wait
+func (_m *App) WaitForDeploymentForDevice(ctx context.Context, deviceID string, installed model.InstalledDeviceDeployment, wait time.Duration) (*model.DeploymentInstructions, error) {
+	ret := _m.Called(ctx, deviceID, installed, wait)
+
+	var r0 *model.DeploymentInstructions
+	if rf, ok := ret.Get(0).(func(context.Context, string, model.InstalledDeviceDeployment, time.Duration) *model.DeploymentInstructions); ok {
+		r0 = rf(ctx, deviceID, installed, wait)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.DeploymentInstructions)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string, model.InstalledDeviceDeployment, time.Duration) error); ok {
+		r1 = rf(ctx, deviceID, installed, wait)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
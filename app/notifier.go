@@ -0,0 +1,78 @@
+// Copyright 2019 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package app
+
+import (
+	"sync"
+)
+
+// deviceNotifier wakes up requests waiting for a new deployment
+// of a device within this instance of the service
+type deviceNotifier struct {
+	mutex       sync.Mutex
+	subscribers map[string]map[chan struct{}]struct{}
+}
+
+func newDeviceNotifier() *deviceNotifier {
+	return &deviceNotifier{
+		subscribers: make(map[string]map[chan struct{}]struct{}),
+	}
+}
+
+func deviceNotifierKey(tenant, deviceID string) string {
+	return tenant + "/" + deviceID
+}
+
+// subscribe returns the channel receiving notifications of the device,
+// the cancel function has to be called once the notifications are not needed
+func (n *deviceNotifier) subscribe(tenant, deviceID string) (<-chan struct{}, func()) {
+	key := deviceNotifierKey(tenant, deviceID)
+
+	// notifications are coalesced, the subscriber re-checks the state anyway
+	ch := make(chan struct{}, 1)
+
+	n.mutex.Lock()
+	if n.subscribers[key] == nil {
+		n.subscribers[key] = make(map[chan struct{}]struct{})
+	}
+	n.subscribers[key][ch] = struct{}{}
+	n.mutex.Unlock()
+
+	cancel := func() {
+		n.mutex.Lock()
+		delete(n.subscribers[key], ch)
+		if len(n.subscribers[key]) == 0 {
+			delete(n.subscribers, key)
+		}
+		n.mutex.Unlock()
+	}
+
+	return ch, cancel
+}
+
+// notify wakes up all subscribers of the devices
+func (n *deviceNotifier) notify(tenant string, deviceIDs ...string) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	for _, deviceID := range deviceIDs {
+		for ch := range n.subscribers[deviceNotifierKey(tenant, deviceID)] {
+			select {
+			case ch <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
@@ -0,0 +1,57 @@
+// Copyright 2019 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package app
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeviceNotifier(t *testing.T) {
+	n := newDeviceNotifier()
+
+	received := func(ch <-chan struct{}) bool {
+		select {
+		case <-ch:
+			return true
+		default:
+			return false
+		}
+	}
+
+	ch1, cancel1 := n.subscribe("acme", "device-1")
+	ch2, cancel2 := n.subscribe("acme", "device-1")
+	other, cancelOther := n.subscribe("", "device-1")
+	defer cancelOther()
+
+	// notifications are coalesced
+	n.notify("acme", "device-1", "device-2")
+	n.notify("acme", "device-1")
+	assert.True(t, received(ch1))
+	assert.False(t, received(ch1))
+	assert.True(t, received(ch2))
+
+	// devices of other tenants are not woken up
+	assert.False(t, received(other))
+
+	cancel1()
+	n.notify("acme", "device-1")
+	assert.False(t, received(ch1))
+	assert.True(t, received(ch2))
+
+	cancel2()
+	assert.Len(t, n.subscribers, 1)
+}
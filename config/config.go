@@ -62,6 +62,15 @@ const (
 
 	SettingMiddleware        = "middleware"
 	SettingMiddlewareDefault = EnvProd
+
+	SettingTimeoutSweepInterval        = "timeout_sweep_interval"
+	SettingTimeoutSweepIntervalDefault = 60
+
+	SettingRetentionSweepInterval        = "retention_sweep_interval"
+	SettingRetentionSweepIntervalDefault = 3600
+
+	SettingDeviceNotifications        = "device_notifications"
+	SettingDeviceNotificationsDefault = true
 )
 
 // ValidateAwsAuth validates configuration of SettingsAwsAuth section if provided.
@@ -123,5 +132,8 @@ var (
 		{Key: SettingDbSSLSkipVerify, Value: SettingDbSSLSkipVerifyDefault},
 		{Key: SettingGateway, Value: SettingGatewayDefault},
 		{Key: SettingsAwsTagArtifact, Value: SettingsAwsTagArtifactDefault},
+		{Key: SettingTimeoutSweepInterval, Value: SettingTimeoutSweepIntervalDefault},
+		{Key: SettingRetentionSweepInterval, Value: SettingRetentionSweepIntervalDefault},
+		{Key: SettingDeviceNotifications, Value: SettingDeviceNotificationsDefault},
 	}
 )
@@ -0,0 +1,58 @@
+// Copyright 2019 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package model
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Statuses of the deployment approval
+const (
+	ApprovalStatusAwaiting = "awaiting_approval"
+	ApprovalStatusApproved = "approved"
+	ApprovalStatusRejected = "rejected"
+)
+
+var (
+	ErrInvalidApprovalStatus = errors.New("Invalid approval status")
+)
+
+// DeploymentApproval records the approval of a deployment created under
+// the approval policy
+type DeploymentApproval struct {
+	Status string `json:"status" bson:"status"`
+
+	// Subject of the identity which created the deployment
+	RequestedBy string `json:"requested_by" bson:"requestedby"`
+
+	// Subject of the identity which approved or rejected the deployment
+	DecidedBy string     `json:"decided_by,omitempty" bson:"decidedby,omitempty"`
+	Decided   *time.Time `json:"decided,omitempty" bson:"decided,omitempty"`
+}
+
+// DeploymentApprovalDecision is the request to approve or reject a deployment
+type DeploymentApprovalDecision struct {
+	Status string `json:"status"`
+}
+
+// Validate checks that the deployment is either approved or rejected
+func (d *DeploymentApprovalDecision) Validate() error {
+	if d.Status != ApprovalStatusApproved && d.Status != ApprovalStatusRejected {
+		return ErrInvalidApprovalStatus
+	}
+	return nil
+}
@@ -25,7 +25,22 @@ import (
 
 // Errors
 var (
-	ErrInvalidDeviceID = errors.New("Invalid device ID")
+	ErrInvalidDeviceID           = errors.New("Invalid device ID")
+	ErrInvalidMaxDevicesInFlight = errors.New("Invalid maximum number of devices in flight")
+	ErrInvalidDependency         = errors.New("Invalid deployment dependency")
+	ErrInvalidMinSuccessRatio    = errors.New("Invalid minimum success ratio")
+	ErrInvalidTimeout            = errors.New("Invalid timeout")
+)
+
+// Success ratio predecessors have to finish with, if not set explicitly
+const DefaultMinSuccessRatio = 1.0
+
+// Statuses of finished deployments
+const (
+	DeploymentStatusSucceeded = "succeeded"
+	DeploymentStatusFailed    = "failed"
+	DeploymentStatusPartial   = "partial"
+	DeploymentStatusAborted   = "aborted"
 )
 
 // DeploymentConstructor represent input data needed for creating new Deployment (they differ in fields)
@@ -36,8 +51,77 @@ type DeploymentConstructor struct {
 	// Artifact name to be installed required, associated with image
 	ArtifactName *string `json:"artifact_name,omitempty" valid:"length(1|4096),required"`
 
+	// Free-form description, optional
+	Description string `json:"description,omitempty" valid:"length(1|4096),optional"`
+
+	// User defined labels with unique keys, optional
+	Labels []DeploymentLabel `json:"labels,omitempty" valid:"-"`
+
 	// List of device id's targeted for deployments, required
 	Devices []string `json:"devices,omitempty" valid:"required" bson:"-"`
+
+	// Maximum number of devices downloading, installing or rebooting
+	// at the same time, optional; 0 means no limit
+	MaxDevicesInFlight int `json:"max_devices_in_flight,omitempty" valid:"-"`
+
+	// List of deployment id's which have to finish before devices
+	// are offered this deployment, optional
+	DependsOn []string `json:"depends_on,omitempty" valid:"-"`
+
+	// Minimal ratio (0-1) of successful devices each of the predecessors
+	// has to finish with, optional; defaults to DefaultMinSuccessRatio
+	MinSuccessRatio *float64 `json:"min_success_ratio,omitempty" valid:"-"`
+
+	// Deployment priority, optional; devices are offered deployments
+	// with higher priority first, then the oldest ones
+	Priority int `json:"priority,omitempty" valid:"-"`
+
+	// Abort pending deployments with lower priority for the same device
+	// once the device is offered this deployment, optional
+	Supersede bool `json:"supersede,omitempty" valid:"-"`
+
+	// Deliver the artifact even if the device reports it as installed,
+	// optional
+	Force bool `json:"force,omitempty" valid:"-"`
+
+	// Maximum time devices may spend in each of the states, optional
+	Timeouts *DeploymentTimeouts `json:"timeouts,omitempty" valid:"-"`
+
+	// Points devices wait at until they are continued, optional;
+	// see PausePointInstall, PausePointReboot and PausePointCommit
+	PauseBefore []string `json:"pause_before,omitempty" valid:"-"`
+}
+
+// DeploymentTimeouts limits the time (in seconds) a device may spend
+// in a single state before its deployment is considered failed;
+// 0 means no limit
+type DeploymentTimeouts struct {
+	Downloading int `json:"downloading,omitempty" valid:"-"`
+	Installing  int `json:"installing,omitempty" valid:"-"`
+	Rebooting   int `json:"rebooting,omitempty" valid:"-"`
+}
+
+// Validate checks that none of the timeouts is negative
+func (t *DeploymentTimeouts) Validate() error {
+	if t.Downloading < 0 || t.Installing < 0 || t.Rebooting < 0 {
+		return ErrInvalidTimeout
+	}
+	return nil
+}
+
+// ForStatus returns the timeout for given device deployment status,
+// 0 if there is none
+func (t *DeploymentTimeouts) ForStatus(status string) time.Duration {
+	var seconds int
+	switch status {
+	case DeviceDeploymentStatusDownloading:
+		seconds = t.Downloading
+	case DeviceDeploymentStatusInstalling:
+		seconds = t.Installing
+	case DeviceDeploymentStatusRebooting:
+		seconds = t.Rebooting
+	}
+	return time.Duration(seconds) * time.Second
 }
 
 // Validate checkes structure according to valid tags
@@ -53,6 +137,65 @@ func (c *DeploymentConstructor) Validate() error {
 		}
 	}
 
+	if c.MaxDevicesInFlight < 0 {
+		return ErrInvalidMaxDevicesInFlight
+	}
+
+	for _, id := range c.DependsOn {
+		if !govalidator.IsUUIDv4(id) {
+			return ErrInvalidDependency
+		}
+	}
+
+	if c.MinSuccessRatio != nil &&
+		(*c.MinSuccessRatio < 0 || *c.MinSuccessRatio > 1) {
+		return ErrInvalidMinSuccessRatio
+	}
+
+	if c.Timeouts != nil {
+		if err := c.Timeouts.Validate(); err != nil {
+			return err
+		}
+	}
+
+	if err := ValidateDeploymentLabels(c.Labels); err != nil {
+		return err
+	}
+
+	if err := ValidatePausePoints(c.PauseBefore); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GetMinSuccessRatio returns the success ratio required from predecessors
+func (c *DeploymentConstructor) GetMinSuccessRatio() float64 {
+	if c.MinSuccessRatio == nil {
+		return DefaultMinSuccessRatio
+	}
+	return *c.MinSuccessRatio
+}
+
+// DeploymentDevicesConstructor represent input data needed for adding devices
+// to an existing deployment
+type DeploymentDevicesConstructor struct {
+	// List of device id's to be added to the deployment, required
+	Devices []string `json:"devices,omitempty" valid:"required"`
+}
+
+// Validate checkes structure according to valid tags
+func (c *DeploymentDevicesConstructor) Validate() error {
+	if _, err := govalidator.ValidateStruct(c); err != nil {
+		return err
+	}
+
+	for _, id := range c.Devices {
+		if govalidator.IsNull(id) {
+			return ErrInvalidDeviceID
+		}
+	}
+
 	return nil
 }
 
@@ -78,7 +221,52 @@ type Deployment struct {
 	Stats map[string]int `json:"-"`
 
 	// Total number of devices targeted
-	DeviceCount int `json:"device_count" bson:"-"`
+	DeviceCount int `json:"device_count" bson:"devicecount"`
+
+	// Outcome of the deployment, set once finished
+	FinishedStatus string `json:"finished_status,omitempty" bson:"finishedstatus,omitempty"`
+
+	// Set once the deployment was aborted by the user; single device
+	// deployments can be aborted without aborting the whole deployment
+	Aborted bool `json:"-" bson:"aborted,omitempty"`
+
+	// Approval of the deployment, set if created under the approval policy
+	Approval *DeploymentApproval `json:"approval,omitempty" bson:"approval,omitempty"`
+
+	// Set once the device deployments were removed by the retention policy
+	DevicesRemoved bool `json:"devices_removed,omitempty" bson:"devicesremoved,omitempty"`
+
+	// Pause points all devices of the deployment were continued at
+	Continued []string `json:"continued,omitempty" bson:"continued,omitempty"`
+
+	// Deployments this deployment depends on, resolved on request
+	Dependencies []DeploymentDependency `json:"dependencies,omitempty" bson:"-"`
+
+	// Deployments depending on this deployment, resolved on request
+	Dependents []DeploymentDependency `json:"dependents,omitempty" bson:"-"`
+}
+
+// DeploymentDependency describes a single edge of the deployment
+// dependency graph
+type DeploymentDependency struct {
+	Id           string  `json:"id"`
+	Name         string  `json:"name"`
+	Status       string  `json:"status"`
+	SuccessRatio float64 `json:"success_ratio"`
+
+	// Whether the predecessor has finished with the required success ratio
+	Satisfied bool `json:"satisfied"`
+}
+
+// NewDeploymentDependency creates the edge between predecessor and dependent
+func NewDeploymentDependency(predecessor, dependent *Deployment) DeploymentDependency {
+	return DeploymentDependency{
+		Id:           *predecessor.Id,
+		Name:         *predecessor.Name,
+		Status:       predecessor.GetStatus(),
+		SuccessRatio: predecessor.SuccessRatio(),
+		Satisfied:    dependent.IsDependencySatisfied(predecessor),
+	}
 }
 
 // NewDeployment creates new deployment object, sets create data by default.
@@ -109,6 +297,9 @@ func NewDeploymentFromConstructor(constructor *DeploymentConstructor) (*Deployme
 	}
 
 	deployment.DeploymentConstructor = constructor
+	if constructor != nil {
+		deployment.DeviceCount = len(constructor.Devices)
+	}
 
 	return deployment, nil
 }
@@ -139,14 +330,8 @@ func (d *Deployment) MarshalJSON() ([]byte, error) {
 }
 
 func (d *Deployment) IsInProgress() bool {
-	active := []string{
-		DeviceDeploymentStatusRebooting,
-		DeviceDeploymentStatusInstalling,
-		DeviceDeploymentStatusDownloading,
-	}
-
 	var acount int
-	for _, s := range active {
+	for _, s := range InFlightDeploymentStatuses() {
 		acount += d.Stats[s]
 	}
 
@@ -162,24 +347,76 @@ func (d *Deployment) IsInProgress() bool {
 	return false
 }
 
+// IsAborted checks if the deployment was aborted by the user
 func (d *Deployment) IsAborted() bool {
-	// check if there are pending devices
-	if d.Stats[DeviceDeploymentStatusAborted] != 0 {
-		return true
+	return d.Aborted
+}
+
+func (d *Deployment) IsFinished() bool {
+	for _, s := range ActiveDeploymentStatuses() {
+		if d.Stats[s] != 0 {
+			return false
+		}
 	}
 
-	return false
+	return true
 }
 
-func (d *Deployment) IsFinished() bool {
-	if d.Stats[DeviceDeploymentStatusPending] == 0 &&
-		d.Stats[DeviceDeploymentStatusDownloading] == 0 &&
-		d.Stats[DeviceDeploymentStatusInstalling] == 0 &&
-		d.Stats[DeviceDeploymentStatusRebooting] == 0 {
-		return true
+// SuccessRatio returns the ratio of devices which finished successfully
+// (or already had the artifact installed) to all devices still targeted
+// by the deployment; decommissioned devices are not taken into account.
+func (d *Deployment) SuccessRatio() float64 {
+	var total int
+	for status, count := range d.Stats {
+		if status != DeviceDeploymentStatusDecommissioned {
+			total += count
+		}
 	}
 
-	return false
+	if total == 0 {
+		return 0
+	}
+
+	success := d.Stats[DeviceDeploymentStatusSuccess] +
+		d.Stats[DeviceDeploymentStatusAlreadyInst]
+
+	return float64(success) / float64(total)
+}
+
+// GetFinishedStatus returns the outcome of the deployment: aborted if the
+// user aborted it, otherwise based on its statistics succeeded if no device
+// failed, failed if no device succeeded and partial if both happened.
+// Devices without a matching artifact count as succeeded; decommissioned
+// devices and devices aborted on their own (superseded, or with
+// a dependency which can't be met) are not taken into account.
+func (d *Deployment) GetFinishedStatus() string {
+	if d.IsAborted() {
+		return DeploymentStatusAborted
+	}
+
+	failed := d.Stats[DeviceDeploymentStatusFailure]
+	succeeded := d.Stats[DeviceDeploymentStatusSuccess] +
+		d.Stats[DeviceDeploymentStatusAlreadyInst] +
+		d.Stats[DeviceDeploymentStatusNoArtifact]
+
+	switch {
+	case failed == 0:
+		return DeploymentStatusSucceeded
+	case succeeded == 0:
+		return DeploymentStatusFailed
+	default:
+		return DeploymentStatusPartial
+	}
+}
+
+// IsDependencySatisfied checks if predecessor has finished with
+// the success ratio required by this deployment
+func (d *Deployment) IsDependencySatisfied(predecessor *Deployment) bool {
+	if predecessor.Finished == nil {
+		return false
+	}
+
+	return predecessor.SuccessRatio() >= d.GetMinSuccessRatio()
 }
 
 func (d *Deployment) IsPending() bool {
@@ -188,6 +425,9 @@ func (d *Deployment) IsPending() bool {
 		d.Stats[DeviceDeploymentStatusDownloading] == 0 &&
 		d.Stats[DeviceDeploymentStatusInstalling] == 0 &&
 		d.Stats[DeviceDeploymentStatusRebooting] == 0 &&
+		d.Stats[DeviceDeploymentStatusPauseBeforeInstall] == 0 &&
+		d.Stats[DeviceDeploymentStatusPauseBeforeReboot] == 0 &&
+		d.Stats[DeviceDeploymentStatusPauseBeforeCommit] == 0 &&
 		d.Stats[DeviceDeploymentStatusSuccess] == 0 &&
 		d.Stats[DeviceDeploymentStatusAlreadyInst] == 0 &&
 		d.Stats[DeviceDeploymentStatusFailure] == 0 &&
@@ -199,8 +439,26 @@ func (d *Deployment) IsPending() bool {
 	return false
 }
 
+// PausesAt checks if devices of the deployment wait at the pause point
+func (d *Deployment) PausesAt(pausePoint string) bool {
+	return containsString(pausePoint, d.PauseBefore)
+}
+
+// IsContinued checks if all devices were continued at the pause point
+func (d *Deployment) IsContinued(pausePoint string) bool {
+	return containsString(pausePoint, d.Continued)
+}
+
+// IsAwaitingApproval checks if devices are held back until the deployment
+// is approved
+func (d *Deployment) IsAwaitingApproval() bool {
+	return d.Approval != nil && d.Approval.Status == ApprovalStatusAwaiting
+}
+
 func (d *Deployment) GetStatus() string {
-	if d.IsPending() {
+	if d.IsAwaitingApproval() && !d.IsFinished() {
+		return ApprovalStatusAwaiting
+	} else if d.IsPending() {
 		return "pending"
 	} else if d.IsFinished() {
 		return "finished"
@@ -217,6 +475,10 @@ const (
 	StatusQueryInProgress
 	StatusQueryFinished
 	StatusQueryAborted
+	StatusQuerySucceeded
+	StatusQueryFailed
+	StatusQueryPartial
+	StatusQueryAwaitingApproval
 )
 
 // Deployment lookup query
@@ -231,4 +493,19 @@ type Query struct {
 	// only return deployments between timestamp range
 	CreatedAfter  *time.Time
 	CreatedBefore *time.Time
+
+	// one of DeploymentsSort*, newest first if empty
+	SortBy         string
+	SortDescending bool
+
+	// match deployments having all of the labels, labels with empty value
+	// match any value of the key
+	Labels []DeploymentLabel
 }
+
+// Sort keys of deployments
+const (
+	DeploymentsSortCreated = "created"
+	DeploymentsSortName    = "name"
+	DeploymentsSortStatus  = "status"
+)
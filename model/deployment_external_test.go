@@ -29,10 +29,15 @@ func TestDeploymentConstructorValidate(t *testing.T) {
 	t.Parallel()
 
 	testCases := []struct {
-		InputName         *string
-		InputArtifactName *string
-		InputDevices      []string
-		IsValid           bool
+		InputName               *string
+		InputArtifactName       *string
+		InputDevices            []string
+		InputMaxDevicesInFlight int
+		InputDependsOn          []string
+		InputMinSuccessRatio    *float64
+		InputTimeouts           *DeploymentTimeouts
+		InputPauseBefore        []string
+		IsValid                 bool
 	}{
 		{
 			InputName:         nil,
@@ -82,6 +87,71 @@ func TestDeploymentConstructorValidate(t *testing.T) {
 			InputDevices:      []string{"f826484e-1157-4109-af21-304e6d711560"},
 			IsValid:           true,
 		},
+		{
+			InputName:               StringToPointer("f826484e-1157-4109-af21-304e6d711560"),
+			InputArtifactName:       StringToPointer("f826484e-1157-4109-af21-304e6d711560"),
+			InputDevices:            []string{"f826484e-1157-4109-af21-304e6d711560"},
+			InputMaxDevicesInFlight: 10,
+			IsValid:                 true,
+		},
+		{
+			InputName:               StringToPointer("f826484e-1157-4109-af21-304e6d711560"),
+			InputArtifactName:       StringToPointer("f826484e-1157-4109-af21-304e6d711560"),
+			InputDevices:            []string{"f826484e-1157-4109-af21-304e6d711560"},
+			InputMaxDevicesInFlight: -1,
+			IsValid:                 false,
+		},
+		{
+			InputName:            StringToPointer("f826484e-1157-4109-af21-304e6d711560"),
+			InputArtifactName:    StringToPointer("f826484e-1157-4109-af21-304e6d711560"),
+			InputDevices:         []string{"f826484e-1157-4109-af21-304e6d711560"},
+			InputDependsOn:       []string{"a108ae14-bb4e-455f-9b40-2ef4bab97bb7"},
+			InputMinSuccessRatio: Float64ToPointer(0.9),
+			IsValid:              true,
+		},
+		{
+			InputName:         StringToPointer("f826484e-1157-4109-af21-304e6d711560"),
+			InputArtifactName: StringToPointer("f826484e-1157-4109-af21-304e6d711560"),
+			InputDevices:      []string{"f826484e-1157-4109-af21-304e6d711560"},
+			InputDependsOn:    []string{"lala"},
+			IsValid:           false,
+		},
+		{
+			InputName:            StringToPointer("f826484e-1157-4109-af21-304e6d711560"),
+			InputArtifactName:    StringToPointer("f826484e-1157-4109-af21-304e6d711560"),
+			InputDevices:         []string{"f826484e-1157-4109-af21-304e6d711560"},
+			InputDependsOn:       []string{"a108ae14-bb4e-455f-9b40-2ef4bab97bb7"},
+			InputMinSuccessRatio: Float64ToPointer(1.5),
+			IsValid:              false,
+		},
+		{
+			InputName:         StringToPointer("f826484e-1157-4109-af21-304e6d711560"),
+			InputArtifactName: StringToPointer("f826484e-1157-4109-af21-304e6d711560"),
+			InputDevices:      []string{"f826484e-1157-4109-af21-304e6d711560"},
+			InputTimeouts:     &DeploymentTimeouts{Downloading: 3600, Rebooting: 600},
+			IsValid:           true,
+		},
+		{
+			InputName:         StringToPointer("f826484e-1157-4109-af21-304e6d711560"),
+			InputArtifactName: StringToPointer("f826484e-1157-4109-af21-304e6d711560"),
+			InputDevices:      []string{"f826484e-1157-4109-af21-304e6d711560"},
+			InputTimeouts:     &DeploymentTimeouts{Installing: -1},
+			IsValid:           false,
+		},
+		{
+			InputName:         StringToPointer("f826484e-1157-4109-af21-304e6d711560"),
+			InputArtifactName: StringToPointer("f826484e-1157-4109-af21-304e6d711560"),
+			InputDevices:      []string{"f826484e-1157-4109-af21-304e6d711560"},
+			InputPauseBefore:  []string{PausePointReboot, PausePointCommit},
+			IsValid:           true,
+		},
+		{
+			InputName:         StringToPointer("f826484e-1157-4109-af21-304e6d711560"),
+			InputArtifactName: StringToPointer("f826484e-1157-4109-af21-304e6d711560"),
+			InputDevices:      []string{"f826484e-1157-4109-af21-304e6d711560"},
+			InputPauseBefore:  []string{"download"},
+			IsValid:           false,
+		},
 	}
 
 	for _, test := range testCases {
@@ -90,6 +160,11 @@ func TestDeploymentConstructorValidate(t *testing.T) {
 		dep.Name = test.InputName
 		dep.ArtifactName = test.InputArtifactName
 		dep.Devices = test.InputDevices
+		dep.MaxDevicesInFlight = test.InputMaxDevicesInFlight
+		dep.DependsOn = test.InputDependsOn
+		dep.MinSuccessRatio = test.InputMinSuccessRatio
+		dep.Timeouts = test.InputTimeouts
+		dep.PauseBefore = test.InputPauseBefore
 
 		err := dep.Validate()
 
@@ -102,6 +177,48 @@ func TestDeploymentConstructorValidate(t *testing.T) {
 
 }
 
+func TestDeploymentDevicesConstructorValidate(t *testing.T) {
+
+	t.Parallel()
+
+	testCases := []struct {
+		InputDevices []string
+		IsValid      bool
+	}{
+		{
+			InputDevices: nil,
+			IsValid:      false,
+		},
+		{
+			InputDevices: []string{},
+			IsValid:      false,
+		},
+		{
+			InputDevices: []string{"f826484e-1157-4109-af21-304e6d711560", ""},
+			IsValid:      false,
+		},
+		{
+			InputDevices: []string{"f826484e-1157-4109-af21-304e6d711560"},
+			IsValid:      true,
+		},
+	}
+
+	for _, test := range testCases {
+
+		con := &DeploymentDevicesConstructor{
+			Devices: test.InputDevices,
+		}
+
+		err := con.Validate()
+
+		if !test.IsValid {
+			assert.Error(t, err)
+		} else {
+			assert.NoError(t, err)
+		}
+	}
+}
+
 func TestNewDeploymentFromConstructor(t *testing.T) {
 
 	t.Parallel()
@@ -227,6 +344,9 @@ func TestDeploymentIs(t *testing.T) {
 		DeviceDeploymentStatusRebooting,
 		DeviceDeploymentStatusInstalling,
 		DeviceDeploymentStatusDownloading,
+		DeviceDeploymentStatusPauseBeforeInstall,
+		DeviceDeploymentStatusPauseBeforeReboot,
+		DeviceDeploymentStatusPauseBeforeCommit,
 	}
 	for _, as := range active {
 		t.Logf("checking in-progress deployment stat %s", as)
@@ -266,10 +386,178 @@ func TestDeploymentIs(t *testing.T) {
 	}
 }
 
+func TestDeploymentTimeoutsForStatus(t *testing.T) {
+
+	t.Parallel()
+
+	timeouts := &DeploymentTimeouts{
+		Downloading: 3600,
+		Installing:  600,
+	}
+
+	assert.Equal(t, time.Hour, timeouts.ForStatus(DeviceDeploymentStatusDownloading))
+	assert.Equal(t, 10*time.Minute, timeouts.ForStatus(DeviceDeploymentStatusInstalling))
+	assert.Equal(t, time.Duration(0), timeouts.ForStatus(DeviceDeploymentStatusRebooting))
+	assert.Equal(t, time.Duration(0), timeouts.ForStatus(DeviceDeploymentStatusPending))
+}
+
+func TestDeploymentIsDependencySatisfied(t *testing.T) {
+
+	t.Parallel()
+
+	now := time.Now()
+
+	tests := map[string]struct {
+		Stats           map[string]int
+		Finished        *time.Time
+		MinSuccessRatio *float64
+
+		OutputSuccessRatio float64
+		OutputSatisfied    bool
+	}{
+		"all successful": {
+			Stats: map[string]int{
+				DeviceDeploymentStatusSuccess:     3,
+				DeviceDeploymentStatusAlreadyInst: 1,
+			},
+			Finished:           &now,
+			OutputSuccessRatio: 1,
+			OutputSatisfied:    true,
+		},
+		"not finished": {
+			Stats: map[string]int{
+				DeviceDeploymentStatusSuccess: 3,
+				DeviceDeploymentStatusPending: 1,
+			},
+			OutputSuccessRatio: 0.75,
+			OutputSatisfied:    false,
+		},
+		"failures, default ratio": {
+			Stats: map[string]int{
+				DeviceDeploymentStatusSuccess: 3,
+				DeviceDeploymentStatusFailure: 1,
+			},
+			Finished:           &now,
+			OutputSuccessRatio: 0.75,
+			OutputSatisfied:    false,
+		},
+		"failures, ratio met": {
+			Stats: map[string]int{
+				DeviceDeploymentStatusSuccess:        3,
+				DeviceDeploymentStatusFailure:        1,
+				DeviceDeploymentStatusDecommissioned: 4,
+			},
+			Finished:           &now,
+			MinSuccessRatio:    Float64ToPointer(0.75),
+			OutputSuccessRatio: 0.75,
+			OutputSatisfied:    true,
+		},
+		"aborted": {
+			Stats: map[string]int{
+				DeviceDeploymentStatusSuccess: 1,
+				DeviceDeploymentStatusAborted: 3,
+			},
+			Finished:           &now,
+			MinSuccessRatio:    Float64ToPointer(0.5),
+			OutputSuccessRatio: 0.25,
+			OutputSatisfied:    false,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			predecessor, err := NewDeployment()
+			assert.NoError(t, err)
+			predecessor.Stats = test.Stats
+			predecessor.Finished = test.Finished
+
+			dependent, err := NewDeployment()
+			assert.NoError(t, err)
+			dependent.DependsOn = []string{*predecessor.Id}
+			dependent.MinSuccessRatio = test.MinSuccessRatio
+
+			assert.Equal(t, test.OutputSuccessRatio, predecessor.SuccessRatio())
+			assert.Equal(t, test.OutputSatisfied,
+				dependent.IsDependencySatisfied(predecessor))
+		})
+	}
+}
+
+func TestDeploymentGetFinishedStatus(t *testing.T) {
+
+	t.Parallel()
+
+	tests := map[string]struct {
+		Stats        map[string]int
+		Aborted      bool
+		OutputStatus string
+	}{
+		"succeeded": {
+			Stats: map[string]int{
+				DeviceDeploymentStatusSuccess:        2,
+				DeviceDeploymentStatusAlreadyInst:    1,
+				DeviceDeploymentStatusNoArtifact:     1,
+				DeviceDeploymentStatusDecommissioned: 1,
+			},
+			OutputStatus: DeploymentStatusSucceeded,
+		},
+		"succeeded, no devices": {
+			Stats:        map[string]int{},
+			OutputStatus: DeploymentStatusSucceeded,
+		},
+		"failed": {
+			Stats: map[string]int{
+				DeviceDeploymentStatusFailure:        3,
+				DeviceDeploymentStatusDecommissioned: 1,
+			},
+			OutputStatus: DeploymentStatusFailed,
+		},
+		"partial": {
+			Stats: map[string]int{
+				DeviceDeploymentStatusSuccess: 2,
+				DeviceDeploymentStatusFailure: 1,
+			},
+			OutputStatus: DeploymentStatusPartial,
+		},
+		"succeeded, superseded device": {
+			Stats: map[string]int{
+				DeviceDeploymentStatusSuccess: 3,
+				DeviceDeploymentStatusAborted: 1,
+			},
+			OutputStatus: DeploymentStatusSucceeded,
+		},
+		"partial, device with unsatisfied dependency": {
+			Stats: map[string]int{
+				DeviceDeploymentStatusSuccess: 1,
+				DeviceDeploymentStatusFailure: 1,
+				DeviceDeploymentStatusAborted: 1,
+			},
+			OutputStatus: DeploymentStatusPartial,
+		},
+		"aborted": {
+			Stats: map[string]int{
+				DeviceDeploymentStatusSuccess: 2,
+				DeviceDeploymentStatusFailure: 1,
+				DeviceDeploymentStatusAborted: 1,
+			},
+			Aborted:      true,
+			OutputStatus: DeploymentStatusAborted,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			d := &Deployment{Stats: test.Stats, Aborted: test.Aborted}
+			assert.Equal(t, test.OutputStatus, d.GetFinishedStatus())
+		})
+	}
+}
+
 func TestDeploymentGetStatus(t *testing.T) {
 
 	tests := map[string]struct {
 		Stats        map[string]int
+		Approval     *DeploymentApproval
 		OutputStatus string
 	}{
 		"Single NoArtifact": {
@@ -358,6 +646,27 @@ func TestDeploymentGetStatus(t *testing.T) {
 			},
 			OutputStatus: "finished",
 		},
+		"awaiting approval": {
+			Stats: map[string]int{
+				DeviceDeploymentStatusPending: 1,
+			},
+			Approval:     &DeploymentApproval{Status: ApprovalStatusAwaiting},
+			OutputStatus: "awaiting_approval",
+		},
+		"approved": {
+			Stats: map[string]int{
+				DeviceDeploymentStatusPending: 1,
+			},
+			Approval:     &DeploymentApproval{Status: ApprovalStatusApproved},
+			OutputStatus: "pending",
+		},
+		"rejected": {
+			Stats: map[string]int{
+				DeviceDeploymentStatusAborted: 1,
+			},
+			Approval:     &DeploymentApproval{Status: ApprovalStatusRejected},
+			OutputStatus: "finished",
+		},
 	}
 
 	for name, test := range tests {
@@ -368,6 +677,7 @@ func TestDeploymentGetStatus(t *testing.T) {
 		assert.NoError(t, err)
 
 		dep.Stats = test.Stats
+		dep.Approval = test.Approval
 
 		assert.Equal(t, test.OutputStatus, dep.GetStatus())
 	}
@@ -18,9 +18,17 @@ type ArtifactDeploymentInstructions struct {
 	ArtifactName          string   `json:"artifact_name"`
 	Source                Link     `json:"source"`
 	DeviceTypesCompatible []string `json:"device_types_compatible"`
+
+	// Sources to download the artifact from in order of preference:
+	// the mirrors matching the device followed by Source
+	Sources []Link `json:"sources,omitempty"`
 }
 
 type DeploymentInstructions struct {
 	ID       string                         `json:"id"`
 	Artifact ArtifactDeploymentInstructions `json:"artifact"`
+
+	// Points the device has to wait at until it is continued,
+	// see PauseState
+	PauseBefore []string `json:"pause_before,omitempty"`
 }
@@ -34,6 +34,16 @@ const (
 	DeviceDeploymentStatusAlreadyInst    = "already-installed"
 	DeviceDeploymentStatusAborted        = "aborted"
 	DeviceDeploymentStatusDecommissioned = "decommissioned"
+
+	// devices waiting at the pause points of the deployment
+	DeviceDeploymentStatusPauseBeforeInstall = "pause-before-installing"
+	DeviceDeploymentStatusPauseBeforeReboot  = "pause-before-rebooting"
+	DeviceDeploymentStatusPauseBeforeCommit  = "pause-before-committing"
+)
+
+const (
+	// Substate of device deployments failed by exceeding the state timeout
+	DeviceDeploymentSubStateTimeout = "timeout"
 )
 
 // DeviceDeploymentStatus is a helper type for reporting status changes through
@@ -77,6 +87,40 @@ type DeviceDeployment struct {
 
 	// Device reported substate
 	SubState *string `json:"substate,omitempty" valid:"-" bson:"substate"`
+
+	// Category of the failure, assigned from the substate and the log
+	FailureCategory string `json:"failure_category,omitempty" valid:"-" bson:"failurecategory,omitempty"`
+
+	// Priority of the deployment, copied for ordering device's deployments
+	Priority int `json:"-" valid:"-" bson:"priority"`
+
+	// Creation time of the deployment, copied for ordering device's deployments
+	DeploymentCreated *time.Time `json:"-" valid:"-" bson:"deploymentcreated"`
+
+	// Time of the last status change
+	StatusChanged *time.Time `json:"-" valid:"-" bson:"statuschanged"`
+
+	// Status transitions, oldest first
+	History []DeviceDeploymentStatusChange `json:"history,omitempty" valid:"-" bson:"history,omitempty"`
+
+	// Last download progress reported by device
+	Progress *DownloadProgress `json:"progress,omitempty" valid:"-" bson:"progress,omitempty"`
+
+	// Pause points the device was continued at
+	Continued []string `json:"continued,omitempty" valid:"-" bson:"continued,omitempty"`
+}
+
+// DeviceDeploymentStatusChange is a single entry of the device deployment
+// status history
+type DeviceDeploymentStatusChange struct {
+	Status    string    `json:"status" bson:"status"`
+	SubState  *string   `json:"substate,omitempty" bson:"substate,omitempty"`
+	Timestamp time.Time `json:"timestamp" bson:"timestamp"`
+}
+
+// IsContinued checks if the device was continued at the pause point
+func (d *DeviceDeployment) IsContinued(pausePoint string) bool {
+	return containsString(pausePoint, d.Continued)
 }
 
 func NewDeviceDeployment(deviceId, deploymentId string) (*DeviceDeployment, error) {
@@ -98,6 +142,12 @@ func NewDeviceDeployment(deviceId, deploymentId string) (*DeviceDeployment, erro
 		Id:             &id,
 		Created:        &now,
 		IsLogAvailable: false,
+		History: []DeviceDeploymentStatusChange{
+			{
+				Status:    initStatus,
+				Timestamp: now,
+			},
+		},
 	}, nil
 }
 
@@ -122,6 +172,9 @@ func NewDeviceDeploymentStats() Stats {
 		DeviceDeploymentStatusAlreadyInst,
 		DeviceDeploymentStatusAborted,
 		DeviceDeploymentStatusDecommissioned,
+		DeviceDeploymentStatusPauseBeforeInstall,
+		DeviceDeploymentStatusPauseBeforeReboot,
+		DeviceDeploymentStatusPauseBeforeCommit,
 	}
 
 	s := make(Stats)
@@ -143,21 +196,133 @@ func IsDeviceDeploymentStatusFinished(status string) bool {
 	return false
 }
 
-// ActiveDeploymentStatuses lists statuses that represent deployment in active state (not finished).
-func ActiveDeploymentStatuses() []string {
+// InFlightDeploymentStatuses lists statuses of devices working on the update.
+func InFlightDeploymentStatuses() []string {
 	return []string{
-		DeviceDeploymentStatusPending,
 		DeviceDeploymentStatusDownloading,
 		DeviceDeploymentStatusInstalling,
 		DeviceDeploymentStatusRebooting,
+		DeviceDeploymentStatusPauseBeforeInstall,
+		DeviceDeploymentStatusPauseBeforeReboot,
+		DeviceDeploymentStatusPauseBeforeCommit,
 	}
 }
 
+// ActiveDeploymentStatuses lists statuses that represent deployment in active state (not finished).
+func ActiveDeploymentStatuses() []string {
+	return append([]string{DeviceDeploymentStatusPending},
+		InFlightDeploymentStatuses()...)
+}
+
+// IsValidDeviceDeploymentStatus checks if status is a known device deployment status
+func IsValidDeviceDeploymentStatus(status string) bool {
+	_, ok := NewDeviceDeploymentStats()[status]
+	return ok
+}
+
+// DeviceDeploymentsQuery selects deployments of a single device
+type DeviceDeploymentsQuery struct {
+	DeviceID string
+
+	// only return device deployments in one of the statuses, all if empty
+	Statuses []string
+
+	Limit int
+	Skip  int
+}
+
+// Sort keys of the devices of a deployment
+const (
+	DeploymentDevicesSortCreated  = "created"
+	DeploymentDevicesSortFinished = "finished"
+)
+
+// DeploymentDevicesQuery selects devices of a single deployment
+type DeploymentDevicesQuery struct {
+	DeploymentID string
+
+	// filters, ignored if empty
+	Status          string
+	SubState        string
+	FailureCategory string
+
+	// match devices with ID containing the text
+	DeviceID string
+
+	// one of DeploymentDevicesSort*, creation time if empty
+	SortBy         string
+	SortDescending bool
+
+	Limit int
+	Skip  int
+}
+
+// DeviceDeploymentHistoryEntry describes a deployment the device took part in
+type DeviceDeploymentHistoryEntry struct {
+	DeploymentID   string `json:"deployment_id"`
+	DeploymentName string `json:"deployment_name"`
+
+	// Name of the artifact assigned to the device, or of the deployment's
+	// artifact if none was assigned yet
+	ArtifactName string  `json:"artifact_name"`
+	ArtifactID   *string `json:"artifact_id,omitempty"`
+
+	Status   string  `json:"status"`
+	SubState *string `json:"substate,omitempty"`
+
+	Created       *time.Time `json:"created"`
+	StatusChanged *time.Time `json:"status_changed,omitempty"`
+	Finished      *time.Time `json:"finished,omitempty"`
+
+	IsLogAvailable bool `json:"log"`
+}
+
+// NewDeviceDeploymentHistoryEntry combines the device deployment with
+// information from its deployment, which may be nil if it no longer exists
+func NewDeviceDeploymentHistoryEntry(dd *DeviceDeployment,
+	deployment *Deployment) DeviceDeploymentHistoryEntry {
+
+	entry := DeviceDeploymentHistoryEntry{
+		SubState:       dd.SubState,
+		Created:        dd.Created,
+		StatusChanged:  dd.StatusChanged,
+		Finished:       dd.Finished,
+		IsLogAvailable: dd.IsLogAvailable,
+	}
+
+	if dd.DeploymentId != nil {
+		entry.DeploymentID = *dd.DeploymentId
+	}
+
+	if dd.Status != nil {
+		entry.Status = *dd.Status
+	}
+
+	if deployment != nil && deployment.DeploymentConstructor != nil {
+		if deployment.Name != nil {
+			entry.DeploymentName = *deployment.Name
+		}
+		if deployment.ArtifactName != nil {
+			entry.ArtifactName = *deployment.ArtifactName
+		}
+	}
+
+	if dd.Image != nil {
+		entry.ArtifactName = dd.Image.Name
+		entry.ArtifactID = &dd.Image.Id
+	}
+
+	return entry
+}
+
 // InstalledDeviceDeployment describes a deployment currently installed on the
 // device, usually reported by a device
 type InstalledDeviceDeployment struct {
 	Artifact   string `valid:"required"`
 	DeviceType string `valid:"required"`
+
+	// Address the device connects from, empty if unknown
+	IP string `valid:"-"`
 }
 
 func (i *InstalledDeviceDeployment) Validate() error {
@@ -170,3 +170,67 @@ func TestDeviceDeploymentIsFinished(t *testing.T) {
 		}
 	}
 }
+
+func TestNewDeviceDeploymentHistoryEntry(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+
+	deployment := &Deployment{
+		DeploymentConstructor: &DeploymentConstructor{
+			Name:         StringToPointer("foo"),
+			ArtifactName: StringToPointer("bar"),
+		},
+		Id: StringToPointer("a108ae14-bb4e-455f-9b40-2ef4bab97bb7"),
+	}
+
+	dd := &DeviceDeployment{
+		Created:        &now,
+		Status:         StringToPointer(DeviceDeploymentStatusPending),
+		DeviceId:       StringToPointer("device0001"),
+		DeploymentId:   deployment.Id,
+		IsLogAvailable: true,
+	}
+
+	assert.Equal(t, DeviceDeploymentHistoryEntry{
+		DeploymentID:   *deployment.Id,
+		DeploymentName: "foo",
+		ArtifactName:   "bar",
+		Status:         DeviceDeploymentStatusPending,
+		Created:        &now,
+		IsLogAvailable: true,
+	}, NewDeviceDeploymentHistoryEntry(dd, deployment))
+
+	// assigned artifact takes precedence
+	dd.Image = &SoftwareImage{Id: "artifact-id"}
+	dd.Image.Name = "bar-v2"
+	dd.Status = StringToPointer(DeviceDeploymentStatusFailure)
+	dd.SubState = StringToPointer("timeout")
+	dd.Finished = &now
+
+	assert.Equal(t, DeviceDeploymentHistoryEntry{
+		DeploymentID:   *deployment.Id,
+		DeploymentName: "foo",
+		ArtifactName:   "bar-v2",
+		ArtifactID:     StringToPointer("artifact-id"),
+		Status:         DeviceDeploymentStatusFailure,
+		SubState:       StringToPointer("timeout"),
+		Created:        &now,
+		Finished:       &now,
+		IsLogAvailable: true,
+	}, NewDeviceDeploymentHistoryEntry(dd, deployment))
+
+	// deployment no longer exists
+	entry := NewDeviceDeploymentHistoryEntry(dd, nil)
+	assert.Equal(t, "", entry.DeploymentName)
+	assert.Equal(t, "bar-v2", entry.ArtifactName)
+}
+
+func TestIsValidDeviceDeploymentStatus(t *testing.T) {
+	t.Parallel()
+
+	assert.True(t, IsValidDeviceDeploymentStatus(DeviceDeploymentStatusPending))
+	assert.True(t, IsValidDeviceDeploymentStatus(DeviceDeploymentStatusDecommissioned))
+	assert.False(t, IsValidDeviceDeploymentStatus("inprogress"))
+	assert.False(t, IsValidDeviceDeploymentStatus(""))
+}
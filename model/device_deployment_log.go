@@ -37,11 +37,91 @@ type DeploymentLog struct {
 	Messages []LogMessage `json:"messages" valid:"required"`
 }
 
+// MaxDeploymentLogMessages is the maximum number of messages kept in
+// a deployment log appended to, the oldest messages are dropped first
+const MaxDeploymentLogMessages = 10000
+
+// Formats of the deployment log
+const (
+	DeploymentLogFormatText  = "text"
+	DeploymentLogFormatJSON  = "json"
+	DeploymentLogFormatJSONL = "jsonl"
+)
+
+// ArchivedDeploymentLog is the deployment log as stored in the archive
+// when it's removed by the retention policy
+type ArchivedDeploymentLog struct {
+	DeviceID     string       `json:"device_id"`
+	DeploymentID string       `json:"deployment_id"`
+	Messages     []LogMessage `json:"messages"`
+}
+
 var (
 	ErrInvalidDeploymentLog = errors.New("invalid deployment log")
 	ErrInvalidLogMessage    = errors.New("invalid log message")
+	ErrInvalidLogFilter     = errors.New("invalid log filter")
 )
 
+// DeploymentLogFilter selects messages of a deployment log
+type DeploymentLogFilter struct {
+	// filters, ignored if empty
+	Level string
+	From  *time.Time
+	To    *time.Time
+
+	// only the last Tail messages passing the filters, all if 0
+	Tail int
+}
+
+// Validate checks the time range and the number of messages
+func (f DeploymentLogFilter) Validate() error {
+	if f.Tail < 0 {
+		return errors.Wrap(ErrInvalidLogFilter, "negative tail")
+	}
+	if f.From != nil && f.To != nil && f.To.Before(*f.From) {
+		return errors.Wrap(ErrInvalidLogFilter, "time range ends before it starts")
+	}
+	return nil
+}
+
+// Match checks the level and time of the message
+func (f DeploymentLogFilter) Match(m LogMessage) bool {
+	if f.Level != "" && m.Level != f.Level {
+		return false
+	}
+
+	if f.From == nil && f.To == nil {
+		return true
+	}
+	if m.Timestamp == nil {
+		return false
+	}
+	if f.From != nil && m.Timestamp.Before(*f.From) {
+		return false
+	}
+	if f.To != nil && m.Timestamp.After(*f.To) {
+		return false
+	}
+
+	return true
+}
+
+// Apply returns the messages passing the filter
+func (f DeploymentLogFilter) Apply(messages []LogMessage) []LogMessage {
+	result := make([]LogMessage, 0, len(messages))
+	for _, m := range messages {
+		if f.Match(m) {
+			result = append(result, m)
+		}
+	}
+
+	if f.Tail > 0 && len(result) > f.Tail {
+		result = result[len(result)-f.Tail:]
+	}
+
+	return result
+}
+
 func (l *LogMessage) UnmarshalJSON(raw []byte) error {
 	type AuxLogMessage LogMessage
 
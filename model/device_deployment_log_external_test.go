@@ -196,3 +196,67 @@ func TestUnmarshalLogMessage(t *testing.T) {
 	}
 
 }
+
+func TestDeploymentLogFilter(t *testing.T) {
+	t1 := time.Date(2019, 1, 1, 10, 0, 0, 0, time.UTC)
+	t2 := t1.Add(time.Hour)
+	t3 := t2.Add(time.Hour)
+
+	messages := []LogMessage{
+		{Timestamp: &t1, Level: "info", Message: "installing"},
+		{Timestamp: &t2, Level: "error", Message: "no space left"},
+		{Timestamp: &t2, Level: "info", Message: "rolling back"},
+		{Timestamp: &t3, Level: "error", Message: "rollback failed"},
+	}
+
+	testCases := map[string]struct {
+		filter DeploymentLogFilter
+
+		messages []LogMessage
+		err      error
+	}{
+		"all": {
+			messages: messages,
+		},
+		"level": {
+			filter:   DeploymentLogFilter{Level: "error"},
+			messages: []LogMessage{messages[1], messages[3]},
+		},
+		"time range": {
+			filter:   DeploymentLogFilter{From: &t2, To: &t2},
+			messages: []LogMessage{messages[1], messages[2]},
+		},
+		"tail": {
+			filter:   DeploymentLogFilter{Tail: 2},
+			messages: []LogMessage{messages[2], messages[3]},
+		},
+		"tail after filters": {
+			filter:   DeploymentLogFilter{Level: "info", To: &t3, Tail: 1},
+			messages: []LogMessage{messages[2]},
+		},
+		"tail longer than log": {
+			filter:   DeploymentLogFilter{Tail: 10},
+			messages: messages,
+		},
+		"error, negative tail": {
+			filter: DeploymentLogFilter{Tail: -1},
+			err:    errors.Wrap(ErrInvalidLogFilter, "negative tail"),
+		},
+		"error, time range": {
+			filter: DeploymentLogFilter{From: &t3, To: &t1},
+			err:    errors.Wrap(ErrInvalidLogFilter, "time range ends before it starts"),
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			err := tc.filter.Validate()
+			if tc.err != nil {
+				assert.EqualError(t, err, tc.err.Error())
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.messages, tc.filter.Apply(messages))
+		})
+	}
+}
@@ -0,0 +1,29 @@
+// Copyright 2019 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package model
+
+// MaxDeviceNotificationDevices limits the number of devices
+// in a single notification
+const MaxDeviceNotificationDevices = 1000
+
+// DeviceNotification tells all instances of the service that new
+// deployments are available for the devices
+type DeviceNotification struct {
+	// Identifier of the service instance which sent the notification
+	Origin string `bson:"origin"`
+
+	Tenant    string   `bson:"tenant"`
+	DeviceIDs []string `bson:"devices"`
+}
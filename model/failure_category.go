@@ -0,0 +1,195 @@
+// Copyright 2019 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package model
+
+import (
+	"regexp"
+
+	"github.com/pkg/errors"
+)
+
+// Failure categories assigned by the default rules
+const (
+	FailureCategoryDownload    = "download_error"
+	FailureCategoryChecksum    = "checksum_mismatch"
+	FailureCategoryDiskFull    = "disk_full"
+	FailureCategoryStateScript = "state_script_error"
+	FailureCategoryRollback    = "rollback_after_reboot"
+	FailureCategoryTimeout     = "timeout"
+
+	// no rule matched
+	FailureCategoryOther = "other"
+)
+
+const (
+	// MaxFailureRules limits the number of custom rules of a tenant
+	MaxFailureRules = 50
+
+	// MaxFailureRulePatternLength limits the length of the rule pattern
+	MaxFailureRulePatternLength = 256
+)
+
+var (
+	ErrInvalidFailureRule = errors.New("invalid failure rule")
+)
+
+var failureCategoryRegex = regexp.MustCompile("^[a-z0-9_]{1,64}$")
+
+// log levels of the messages considered by the failure rules
+var failureLogLevels = map[string]bool{
+	"error": true,
+	"fatal": true,
+	"panic": true,
+}
+
+// FailureRule assigns the category to failed device deployments with
+// the substate or an error message of the deployment log matching the pattern
+type FailureRule struct {
+	Category string `json:"category" bson:"category"`
+	Pattern  string `json:"pattern" bson:"pattern"`
+}
+
+// Validate checks the category name and the pattern
+func (r FailureRule) Validate() error {
+	if !failureCategoryRegex.MatchString(r.Category) {
+		return errors.Wrap(ErrInvalidFailureRule,
+			"category must be 1 to 64 lowercase letters, digits or underscores")
+	}
+
+	if r.Pattern == "" || len(r.Pattern) > MaxFailureRulePatternLength {
+		return errors.Wrapf(ErrInvalidFailureRule,
+			"pattern must be 1 to %d characters", MaxFailureRulePatternLength)
+	}
+
+	if _, err := regexp.Compile(r.Pattern); err != nil {
+		return errors.Wrap(ErrInvalidFailureRule, err.Error())
+	}
+
+	return nil
+}
+
+// DefaultFailureRules are applied after the rules of the tenant,
+// more specific causes go first
+var DefaultFailureRules = []FailureRule{
+	{
+		Category: FailureCategoryTimeout,
+		Pattern:  "^" + DeviceDeploymentSubStateTimeout + "$",
+	},
+	{
+		Category: FailureCategoryChecksum,
+		Pattern:  `(?i)(checksum|sha-?256|hash)\b.*(mismatch|does not match|doesn't match|invalid)`,
+	},
+	{
+		Category: FailureCategoryDiskFull,
+		Pattern:  `(?i)no space left on device|disk (is )?full|not enough (disk )?space|ENOSPC`,
+	},
+	{
+		Category: FailureCategoryStateScript,
+		Pattern:  `(?i)state ?script|executing script`,
+	},
+	{
+		Category: FailureCategoryDownload,
+		Pattern:  `(?i)download|connection (refused|reset|timed out)|unexpected EOF|i/o timeout`,
+	},
+	{
+		Category: FailureCategoryRollback,
+		Pattern:  `(?i)roll(ed|ing)? ?back|after reboot`,
+	},
+}
+
+var defaultFailureRules = mustCompileFailureRules(DefaultFailureRules)
+
+type compiledFailureRule struct {
+	category string
+	re       *regexp.Regexp
+}
+
+func compileFailureRules(rules []FailureRule) ([]compiledFailureRule, error) {
+	compiled := make([]compiledFailureRule, 0, len(rules))
+	for _, rule := range rules {
+		if err := rule.Validate(); err != nil {
+			return nil, err
+		}
+		compiled = append(compiled, compiledFailureRule{
+			category: rule.Category,
+			re:       regexp.MustCompile(rule.Pattern),
+		})
+	}
+	return compiled, nil
+}
+
+func mustCompileFailureRules(rules []FailureRule) []compiledFailureRule {
+	compiled, err := compileFailureRules(rules)
+	if err != nil {
+		panic(err)
+	}
+	return compiled
+}
+
+// ValidateFailureRules checks the custom failure rules of a tenant
+func ValidateFailureRules(rules []FailureRule) error {
+	if len(rules) > MaxFailureRules {
+		return errors.Wrapf(ErrInvalidFailureRule,
+			"at most %d rules are allowed", MaxFailureRules)
+	}
+
+	_, err := compileFailureRules(rules)
+	return err
+}
+
+// FailureClassifier assigns failure categories to failed device deployments
+type FailureClassifier struct {
+	rules []compiledFailureRule
+}
+
+// NewFailureClassifier creates the classifier applying the custom rules
+// followed by the default ones
+func NewFailureClassifier(rules []FailureRule) (*FailureClassifier, error) {
+	if len(rules) > MaxFailureRules {
+		return nil, errors.Wrapf(ErrInvalidFailureRule,
+			"at most %d rules are allowed", MaxFailureRules)
+	}
+
+	custom, err := compileFailureRules(rules)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FailureClassifier{
+		rules: append(custom, defaultFailureRules...),
+	}, nil
+}
+
+// Classify returns the category of the first rule matching the substate
+// or an error message of the log (any of them may be nil),
+// FailureCategoryOther if none does
+func (c *FailureClassifier) Classify(subState *string, log *DeploymentLog) string {
+	for _, rule := range c.rules {
+		if subState != nil && rule.re.MatchString(*subState) {
+			return rule.category
+		}
+
+		if log == nil {
+			continue
+		}
+		for _, m := range log.Messages {
+			if failureLogLevels[m.Level] && rule.re.MatchString(m.Message) {
+				return rule.category
+			}
+		}
+	}
+
+	return FailureCategoryOther
+}
@@ -0,0 +1,133 @@
+// Copyright 2019 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package model
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFailureRuleValidate(t *testing.T) {
+	testCases := map[string]struct {
+		rule FailureRule
+		err  string
+	}{
+		"ok": {
+			rule: FailureRule{Category: "flash_error", Pattern: "(?i)flash"},
+		},
+		"error, category": {
+			rule: FailureRule{Category: "Flash Error", Pattern: "flash"},
+			err: "category must be 1 to 64 lowercase letters, digits or underscores: " +
+				ErrInvalidFailureRule.Error(),
+		},
+		"error, no pattern": {
+			rule: FailureRule{Category: "flash"},
+			err:  "pattern must be 1 to 256 characters: " + ErrInvalidFailureRule.Error(),
+		},
+		"error, pattern": {
+			rule: FailureRule{Category: "flash", Pattern: "flash("},
+			err: "error parsing regexp: missing closing ): `flash(`: " +
+				ErrInvalidFailureRule.Error(),
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			err := tc.rule.Validate()
+			if tc.err != "" {
+				assert.EqualError(t, err, tc.err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestFailureClassifier(t *testing.T) {
+	now := time.Now()
+	newLog := func(level, message string) *DeploymentLog {
+		return &DeploymentLog{
+			Messages: []LogMessage{
+				{Timestamp: &now, Level: "info", Message: "Downloading artifact"},
+				{Timestamp: &now, Level: level, Message: message},
+			},
+		}
+	}
+
+	testCases := map[string]struct {
+		rules    []FailureRule
+		subState string
+		log      *DeploymentLog
+
+		category string
+	}{
+		"no details": {
+			category: FailureCategoryOther,
+		},
+		"timeout": {
+			subState: DeviceDeploymentSubStateTimeout,
+			category: FailureCategoryTimeout,
+		},
+		"download": {
+			log:      newLog("error", "Download connection broken: unexpected EOF"),
+			category: FailureCategoryDownload,
+		},
+		"checksum": {
+			log:      newLog("error", "Update download failed: sha256 checksum mismatch"),
+			category: FailureCategoryChecksum,
+		},
+		"disk full": {
+			subState: "write /dev/mmcblk0p3: no space left on device",
+			category: FailureCategoryDiskFull,
+		},
+		"state script": {
+			log:      newLog("error", "Statescript: ArtifactInstall_Enter_01 returned 1"),
+			category: FailureCategoryStateScript,
+		},
+		"rollback": {
+			log:      newLog("error", "Committing update failed, rolling back"),
+			category: FailureCategoryRollback,
+		},
+		"info messages are ignored": {
+			log:      newLog("info", "Rolling back"),
+			category: FailureCategoryOther,
+		},
+		"custom rule first": {
+			rules: []FailureRule{
+				{Category: "flash_error", Pattern: "(?i)flash"},
+			},
+			log:      newLog("error", "Flash write failed: no space left on device"),
+			category: "flash_error",
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			c, err := NewFailureClassifier(tc.rules)
+			assert.NoError(t, err)
+
+			var subState *string
+			if tc.subState != "" {
+				subState = &tc.subState
+			}
+			assert.Equal(t, tc.category, c.Classify(subState, tc.log))
+		})
+	}
+
+	_, err := NewFailureClassifier([]FailureRule{{Category: "x", Pattern: "("}})
+	assert.Error(t, err)
+}
@@ -0,0 +1,68 @@
+// Copyright 2019 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package model
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrInstalledBaseArtifactNameRequired = errors.New("artifact_name is required")
+)
+
+// InstalledArtifact is the latest known artifact installed on the device
+type InstalledArtifact struct {
+	DeviceID     string `json:"device_id" bson:"_id"`
+	ArtifactName string `json:"artifact_name" bson:"artifact_name"`
+	DeviceType   string `json:"device_type" bson:"device_type"`
+
+	// Time the artifact was installed, or first reported by the device
+	Updated time.Time `json:"updated" bson:"updated"`
+}
+
+// InstalledBaseArtifact counts devices of a single device type running
+// the artifact of a release
+type InstalledBaseArtifact struct {
+	DeviceType string `json:"device_type" bson:"device_type"`
+	Count      int    `json:"count" bson:"count"`
+}
+
+// InstalledBaseRelease counts devices running the artifacts of a release
+type InstalledBaseRelease struct {
+	ArtifactName string                  `json:"artifact_name" bson:"_id"`
+	Count        int                     `json:"count" bson:"count"`
+	Artifacts    []InstalledBaseArtifact `json:"artifacts" bson:"artifacts"`
+}
+
+// InstalledBaseQuery selects devices running an artifact
+type InstalledBaseQuery struct {
+	ArtifactName string
+
+	// filter, ignored if empty
+	DeviceType string
+
+	Limit int
+	Skip  int
+}
+
+// Validate checks the artifact is given
+func (q InstalledBaseQuery) Validate() error {
+	if q.ArtifactName == "" {
+		return ErrInstalledBaseArtifactNameRequired
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+// Copyright 2019 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInstalledBaseQueryValidate(t *testing.T) {
+	testCases := map[string]struct {
+		query InstalledBaseQuery
+		err   error
+	}{
+		"ok": {
+			query: InstalledBaseQuery{ArtifactName: "release-1"},
+		},
+		"ok, device type": {
+			query: InstalledBaseQuery{ArtifactName: "release-1", DeviceType: "hammer"},
+		},
+		"error, no artifact name": {
+			query: InstalledBaseQuery{DeviceType: "hammer"},
+			err:   ErrInstalledBaseArtifactNameRequired,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tc.err, tc.query.Validate())
+		})
+	}
+}
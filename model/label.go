@@ -0,0 +1,108 @@
+// Copyright 2019 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package model
+
+import (
+	"strings"
+
+	"github.com/asaskevich/govalidator"
+	"github.com/pkg/errors"
+)
+
+// Limits of deployment labels
+const (
+	MaxDeploymentLabels           = 50
+	MaxDeploymentLabelKeyLength   = 256
+	MaxDeploymentLabelValueLength = 1024
+)
+
+// Errors
+var (
+	ErrInvalidLabel      = errors.New("Invalid label")
+	ErrDuplicateLabel    = errors.New("Duplicate label")
+	ErrTooManyLabels     = errors.New("Too many labels")
+	ErrInvalidLabelQuery = errors.New("Invalid label query")
+)
+
+// DeploymentLabel is a user defined key/value pair attached to a deployment
+type DeploymentLabel struct {
+	Key   string `json:"key" bson:"key"`
+	Value string `json:"value" bson:"value"`
+}
+
+// Validate checks that the key is set and does not contain ':', which
+// separates key and value in label queries
+func (l DeploymentLabel) Validate() error {
+	if govalidator.IsNull(l.Key) ||
+		strings.Contains(l.Key, ":") ||
+		len(l.Key) > MaxDeploymentLabelKeyLength ||
+		len(l.Value) > MaxDeploymentLabelValueLength {
+		return ErrInvalidLabel
+	}
+	return nil
+}
+
+// ValidateDeploymentLabels checks the labels and that keys are unique
+func ValidateDeploymentLabels(labels []DeploymentLabel) error {
+	if len(labels) > MaxDeploymentLabels {
+		return ErrTooManyLabels
+	}
+
+	keys := make(map[string]bool, len(labels))
+	for _, l := range labels {
+		if err := l.Validate(); err != nil {
+			return err
+		}
+		if keys[l.Key] {
+			return ErrDuplicateLabel
+		}
+		keys[l.Key] = true
+	}
+
+	return nil
+}
+
+// ParseDeploymentLabelQuery parses label filter in the form of
+// <key>[:<value>]; an empty value matches any value of the key
+func ParseDeploymentLabelQuery(q string) (DeploymentLabel, error) {
+	parts := strings.SplitN(q, ":", 2)
+
+	label := DeploymentLabel{Key: parts[0]}
+	if len(parts) == 2 {
+		label.Value = parts[1]
+	}
+
+	if govalidator.IsNull(label.Key) {
+		return label, ErrInvalidLabelQuery
+	}
+
+	return label, nil
+}
+
+// DeploymentMetadataConstructor represents the user editable metadata
+// of an existing deployment
+type DeploymentMetadataConstructor struct {
+	Description string            `json:"description,omitempty" valid:"length(1|4096),optional"`
+	Labels      []DeploymentLabel `json:"labels,omitempty" valid:"-"`
+}
+
+// Validate checkes structure according to valid tags
+func (c *DeploymentMetadataConstructor) Validate() error {
+	if _, err := govalidator.ValidateStruct(c); err != nil {
+		return err
+	}
+
+	return ValidateDeploymentLabels(c.Labels)
+}
@@ -0,0 +1,130 @@
+// Copyright 2019 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package model
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateDeploymentLabels(t *testing.T) {
+	t.Parallel()
+
+	tooMany := make([]DeploymentLabel, MaxDeploymentLabels+1)
+	for i := range tooMany {
+		tooMany[i] = DeploymentLabel{Key: strings.Repeat("k", i+1)}
+	}
+
+	testCases := map[string]struct {
+		labels []DeploymentLabel
+		err    error
+	}{
+		"ok": {
+			labels: []DeploymentLabel{
+				{Key: "ticket", Value: "OPS-123"},
+				{Key: "team", Value: "a:b"},
+				{Key: "flag"},
+			},
+		},
+		"ok, none": {},
+		"empty key": {
+			labels: []DeploymentLabel{{Value: "OPS-123"}},
+			err:    ErrInvalidLabel,
+		},
+		"colon in key": {
+			labels: []DeploymentLabel{{Key: "a:b"}},
+			err:    ErrInvalidLabel,
+		},
+		"value too long": {
+			labels: []DeploymentLabel{{
+				Key:   "ticket",
+				Value: strings.Repeat("x", MaxDeploymentLabelValueLength+1),
+			}},
+			err: ErrInvalidLabel,
+		},
+		"duplicate": {
+			labels: []DeploymentLabel{
+				{Key: "ticket", Value: "OPS-123"},
+				{Key: "ticket", Value: "OPS-124"},
+			},
+			err: ErrDuplicateLabel,
+		},
+		"too many": {
+			labels: tooMany,
+			err:    ErrTooManyLabels,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tc.err, ValidateDeploymentLabels(tc.labels))
+		})
+	}
+}
+
+func TestParseDeploymentLabelQuery(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		query string
+		label DeploymentLabel
+		err   error
+	}{
+		"key and value": {
+			query: "ticket:OPS-123",
+			label: DeploymentLabel{Key: "ticket", Value: "OPS-123"},
+		},
+		"value with colon": {
+			query: "url:http://example.com",
+			label: DeploymentLabel{Key: "url", Value: "http://example.com"},
+		},
+		"key only": {
+			query: "ticket",
+			label: DeploymentLabel{Key: "ticket"},
+		},
+		"empty key": {
+			query: ":OPS-123",
+			err:   ErrInvalidLabelQuery,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			label, err := ParseDeploymentLabelQuery(tc.query)
+			if tc.err != nil {
+				assert.Equal(t, tc.err, err)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.label, label)
+			}
+		})
+	}
+}
+
+func TestDeploymentMetadataConstructorValidate(t *testing.T) {
+	t.Parallel()
+
+	c := &DeploymentMetadataConstructor{}
+	assert.NoError(t, c.Validate())
+
+	c.Description = strings.Repeat("x", 4097)
+	assert.Error(t, c.Validate())
+
+	c.Description = "ticket OPS-123"
+	c.Labels = []DeploymentLabel{{Key: ""}}
+	assert.Equal(t, ErrInvalidLabel, c.Validate())
+}
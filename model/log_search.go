@@ -0,0 +1,158 @@
+// Copyright 2019 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package model
+
+import (
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// MaxLogSearchRegexLength limits the length of the regular expression
+// of a log search
+const MaxLogSearchRegexLength = 256
+
+var (
+	ErrLogSearchNoScope      = errors.New("either deployment ID or time range is required")
+	ErrLogSearchTextAndRegex = errors.New("text and regex search cannot be combined")
+	ErrLogSearchInvalidRegex = errors.New("invalid regular expression")
+	ErrLogSearchInvalidRange = errors.New("invalid time range")
+)
+
+// DeploymentLogQuery selects messages of device deployment logs
+type DeploymentLogQuery struct {
+	// search in the logs of a single deployment, all deployments if empty
+	DeploymentID string
+
+	// words separated by spaces, messages containing any of them match;
+	// words prefixed with '-' exclude messages containing them
+	Text string
+
+	// regular expression matching the message
+	Regex string
+
+	// filters, ignored if empty
+	Level string
+	From  *time.Time
+	To    *time.Time
+
+	Limit int
+	Skip  int
+}
+
+// Validate checks the query is limited to a deployment or a time range
+// and the search criteria can be combined
+func (q DeploymentLogQuery) Validate() error {
+	if q.DeploymentID == "" && q.From == nil && q.To == nil {
+		return ErrLogSearchNoScope
+	}
+
+	if q.Text != "" && q.Regex != "" {
+		return ErrLogSearchTextAndRegex
+	}
+
+	if q.From != nil && q.To != nil && q.To.Before(*q.From) {
+		return ErrLogSearchInvalidRange
+	}
+
+	if q.Regex != "" {
+		if len(q.Regex) > MaxLogSearchRegexLength {
+			return errors.Wrapf(ErrLogSearchInvalidRegex,
+				"longer than %d characters", MaxLogSearchRegexLength)
+		}
+		if _, err := regexp.Compile(q.Regex); err != nil {
+			return errors.Wrap(ErrLogSearchInvalidRegex, err.Error())
+		}
+	}
+
+	return nil
+}
+
+// TextTerms splits the text search into words the message has to contain
+// (any of them) and words it must not contain
+func (q DeploymentLogQuery) TextTerms() (include, exclude []string) {
+	for _, term := range strings.Fields(strings.ToLower(q.Text)) {
+		term = strings.Trim(term, "\"")
+		if strings.HasPrefix(term, "-") {
+			if term = strings.TrimPrefix(term, "-"); term != "" {
+				exclude = append(exclude, term)
+			}
+		} else if term != "" {
+			include = append(include, term)
+		}
+	}
+	return include, exclude
+}
+
+// Matcher returns the function selecting log messages matching the query
+func (q DeploymentLogQuery) Matcher() (func(LogMessage) bool, error) {
+	var re *regexp.Regexp
+	if q.Regex != "" {
+		var err error
+		re, err = regexp.Compile(q.Regex)
+		if err != nil {
+			return nil, errors.Wrap(ErrLogSearchInvalidRegex, err.Error())
+		}
+	}
+
+	include, exclude := q.TextTerms()
+	filter := DeploymentLogFilter{
+		Level: q.Level,
+		From:  q.From,
+		To:    q.To,
+	}
+
+	return func(m LogMessage) bool {
+		if !filter.Match(m) {
+			return false
+		}
+
+		if re != nil && !re.MatchString(m.Message) {
+			return false
+		}
+
+		if len(include) > 0 || len(exclude) > 0 {
+			message := strings.ToLower(m.Message)
+			for _, term := range exclude {
+				if strings.Contains(message, term) {
+					return false
+				}
+			}
+
+			found := len(include) == 0
+			for _, term := range include {
+				if strings.Contains(message, term) {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return false
+			}
+		}
+
+		return true
+	}, nil
+}
+
+// DeploymentLogMatch holds the messages matching a log search
+// from the log of a single device
+type DeploymentLogMatch struct {
+	DeviceID     string       `json:"device_id"`
+	DeploymentID string       `json:"deployment_id"`
+	Messages     []LogMessage `json:"messages"`
+}
@@ -0,0 +1,126 @@
+// Copyright 2019 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package model
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeploymentLogQueryValidate(t *testing.T) {
+	now := time.Now()
+	before := now.Add(-time.Hour)
+
+	testCases := map[string]struct {
+		query DeploymentLogQuery
+		err   string
+	}{
+		"ok, deployment": {
+			query: DeploymentLogQuery{DeploymentID: "foo", Text: "error"},
+		},
+		"ok, time range": {
+			query: DeploymentLogQuery{From: &before, To: &now, Regex: "^fail.*"},
+		},
+		"error, no scope": {
+			query: DeploymentLogQuery{Text: "error"},
+			err:   ErrLogSearchNoScope.Error(),
+		},
+		"error, text and regex": {
+			query: DeploymentLogQuery{DeploymentID: "foo", Text: "error", Regex: "error"},
+			err:   ErrLogSearchTextAndRegex.Error(),
+		},
+		"error, range": {
+			query: DeploymentLogQuery{From: &now, To: &before},
+			err:   ErrLogSearchInvalidRange.Error(),
+		},
+		"error, regex": {
+			query: DeploymentLogQuery{DeploymentID: "foo", Regex: "fail("},
+			err: "error parsing regexp: missing closing ): `fail(`: " +
+				ErrLogSearchInvalidRegex.Error(),
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			err := tc.query.Validate()
+			if tc.err != "" {
+				assert.EqualError(t, err, tc.err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestDeploymentLogQueryMatcher(t *testing.T) {
+	t1 := time.Date(2019, 1, 1, 10, 0, 0, 0, time.UTC)
+	t2 := t1.Add(time.Hour)
+
+	messages := []LogMessage{
+		{Timestamp: &t1, Level: "info", Message: "Installing update"},
+		{Timestamp: &t1, Level: "error", Message: "Install failed: no space left on device"},
+		{Timestamp: &t2, Level: "error", Message: "Rollback failed"},
+	}
+
+	testCases := map[string]struct {
+		query   DeploymentLogQuery
+		matches []int
+	}{
+		"all": {
+			matches: []int{0, 1, 2},
+		},
+		"level": {
+			query:   DeploymentLogQuery{Level: "error"},
+			matches: []int{1, 2},
+		},
+		"text, any word": {
+			query:   DeploymentLogQuery{Text: "SPACE rollback"},
+			matches: []int{1, 2},
+		},
+		"text, excluded word": {
+			query:   DeploymentLogQuery{Text: "failed -rollback"},
+			matches: []int{1},
+		},
+		"regex": {
+			query:   DeploymentLogQuery{Regex: "^Install(ing)? u"},
+			matches: []int{0},
+		},
+		"time range": {
+			query:   DeploymentLogQuery{From: &t2},
+			matches: []int{2},
+		},
+		"time range and level": {
+			query:   DeploymentLogQuery{To: &t1, Level: "error"},
+			matches: []int{1},
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			match, err := tc.query.Matcher()
+			assert.NoError(t, err)
+
+			matches := []int{}
+			for i, m := range messages {
+				if match(m) {
+					matches = append(matches, i)
+				}
+			}
+			assert.Equal(t, tc.matches, matches)
+		})
+	}
+}
@@ -0,0 +1,162 @@
+// Copyright 2019 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package model
+
+import (
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// MaxMirrors limits the number of mirrors of a tenant
+	MaxMirrors = 20
+
+	// MaxMirrorIPRanges limits the number of IP ranges of a mirror
+	MaxMirrorIPRanges = 100
+
+	// MirrorURIArtifactID is replaced with the ID of the artifact
+	// in the URI of the mirror
+	MirrorURIArtifactID = "{artifact_id}"
+
+	// device attributes mirrors can be matched by
+	MirrorAttributeArtifactName = "artifact_name"
+	MirrorAttributeDeviceType   = "device_type"
+)
+
+var (
+	ErrInvalidMirror = errors.New("invalid mirror")
+)
+
+// Mirror is an additional source of artifacts, such as an on-site cache
+// or a second bucket, offered to the matching devices before the primary one
+type Mirror struct {
+	Name string `json:"name" bson:"name"`
+
+	// URI of the artifact on the mirror, see MirrorURIArtifactID
+	URI string `json:"uri" bson:"uri"`
+
+	// The device has to connect from one of the ranges (CIDR notation),
+	// any address matches if empty
+	IPRanges []string `json:"ip_ranges,omitempty" bson:"ipranges,omitempty"`
+
+	// The device has to report all attributes with the given values,
+	// see MirrorAttributeArtifactName and MirrorAttributeDeviceType
+	Attributes map[string]string `json:"attributes,omitempty" bson:"attributes,omitempty"`
+}
+
+// Validate checks the name, URI and matching conditions of the mirror
+func (m Mirror) Validate() error {
+	if m.Name == "" || len(m.Name) > 64 {
+		return errors.Wrap(ErrInvalidMirror, "name must be 1 to 64 characters")
+	}
+
+	if !strings.Contains(m.URI, MirrorURIArtifactID) {
+		return errors.Wrapf(ErrInvalidMirror,
+			"uri of mirror %s must contain %s", m.Name, MirrorURIArtifactID)
+	}
+	u, err := url.Parse(m.link("id"))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.Wrapf(ErrInvalidMirror,
+			"uri of mirror %s must be an absolute http or https URI", m.Name)
+	}
+
+	if len(m.IPRanges) > MaxMirrorIPRanges {
+		return errors.Wrapf(ErrInvalidMirror,
+			"mirror %s has more than %d ip ranges", m.Name, MaxMirrorIPRanges)
+	}
+	for _, r := range m.IPRanges {
+		if _, _, err := net.ParseCIDR(r); err != nil {
+			return errors.Wrapf(ErrInvalidMirror,
+				"invalid ip range %s of mirror %s", r, m.Name)
+		}
+	}
+
+	for name := range m.Attributes {
+		if name != MirrorAttributeArtifactName && name != MirrorAttributeDeviceType {
+			return errors.Wrapf(ErrInvalidMirror,
+				"unsupported attribute %s of mirror %s, supported: %s, %s",
+				name, m.Name, MirrorAttributeArtifactName, MirrorAttributeDeviceType)
+		}
+	}
+
+	return nil
+}
+
+// ValidateMirrors checks the mirrors of a tenant
+func ValidateMirrors(mirrors []Mirror) error {
+	if len(mirrors) > MaxMirrors {
+		return errors.Wrapf(ErrInvalidMirror,
+			"at most %d mirrors are allowed", MaxMirrors)
+	}
+
+	names := make(map[string]bool, len(mirrors))
+	for _, m := range mirrors {
+		if err := m.Validate(); err != nil {
+			return err
+		}
+		if names[m.Name] {
+			return errors.Wrapf(ErrInvalidMirror, "duplicate mirror %s", m.Name)
+		}
+		names[m.Name] = true
+	}
+
+	return nil
+}
+
+// Matches checks whether the device reporting the installed deployment
+// may use the mirror
+func (m Mirror) Matches(installed InstalledDeviceDeployment) bool {
+	for name, value := range m.Attributes {
+		switch name {
+		case MirrorAttributeArtifactName:
+			if installed.Artifact != value {
+				return false
+			}
+		case MirrorAttributeDeviceType:
+			if installed.DeviceType != value {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+
+	if len(m.IPRanges) == 0 {
+		return true
+	}
+	ip := net.ParseIP(installed.IP)
+	if ip == nil {
+		return false
+	}
+	for _, r := range m.IPRanges {
+		if _, ipNet, err := net.ParseCIDR(r); err == nil && ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Link returns the link to the artifact on the mirror
+func (m Mirror) Link(artifactID string, expire time.Time) *Link {
+	return NewLink(m.link(artifactID), expire)
+}
+
+func (m Mirror) link(artifactID string) string {
+	return strings.Replace(m.URI, MirrorURIArtifactID, url.PathEscape(artifactID), -1)
+}
@@ -0,0 +1,157 @@
+// Copyright 2019 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package model
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateMirrors(t *testing.T) {
+	valid := Mirror{
+		Name:       "factory",
+		URI:        "http://cache.local/artifacts/{artifact_id}",
+		IPRanges:   []string{"10.0.0.0/8", "fd00::/8"},
+		Attributes: map[string]string{"device_type": "hammer"},
+	}
+
+	testCases := map[string]struct {
+		mirrors []Mirror
+		err     string
+	}{
+		"ok": {
+			mirrors: []Mirror{valid, {
+				Name: "bucket",
+				URI:  "https://bucket.example.com/{artifact_id}",
+			}},
+		},
+		"ok, none": {},
+		"error, no name": {
+			mirrors: []Mirror{{URI: valid.URI}},
+			err:     "name must be 1 to 64 characters: " + ErrInvalidMirror.Error(),
+		},
+		"error, duplicate name": {
+			mirrors: []Mirror{valid, valid},
+			err:     "duplicate mirror factory: " + ErrInvalidMirror.Error(),
+		},
+		"error, no artifact id": {
+			mirrors: []Mirror{{Name: "factory", URI: "http://cache.local/"}},
+			err: "uri of mirror factory must contain {artifact_id}: " +
+				ErrInvalidMirror.Error(),
+		},
+		"error, relative uri": {
+			mirrors: []Mirror{{Name: "factory", URI: "/artifacts/{artifact_id}"}},
+			err: "uri of mirror factory must be an absolute http or https URI: " +
+				ErrInvalidMirror.Error(),
+		},
+		"error, invalid ip range": {
+			mirrors: []Mirror{{
+				Name:     "factory",
+				URI:      valid.URI,
+				IPRanges: []string{"10.0.0.1"},
+			}},
+			err: "invalid ip range 10.0.0.1 of mirror factory: " +
+				ErrInvalidMirror.Error(),
+		},
+		"error, unsupported attribute": {
+			mirrors: []Mirror{{
+				Name:       "factory",
+				URI:        valid.URI,
+				Attributes: map[string]string{"site": "oslo"},
+			}},
+			err: "unsupported attribute site of mirror factory, " +
+				"supported: artifact_name, device_type: " + ErrInvalidMirror.Error(),
+		},
+		"error, too many": {
+			mirrors: make([]Mirror, MaxMirrors+1),
+			err:     "at most 20 mirrors are allowed: " + ErrInvalidMirror.Error(),
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			err := ValidateMirrors(tc.mirrors)
+			if tc.err != "" {
+				assert.EqualError(t, err, tc.err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestMirrorMatches(t *testing.T) {
+	mirror := Mirror{
+		Name:       "factory",
+		URI:        "http://cache.local/artifacts/{artifact_id}",
+		IPRanges:   []string{"10.1.0.0/16", "fd00::/8"},
+		Attributes: map[string]string{"device_type": "hammer"},
+	}
+
+	testCases := map[string]struct {
+		mirror    Mirror
+		installed InstalledDeviceDeployment
+
+		matches bool
+	}{
+		"ok, ipv4": {
+			mirror:    mirror,
+			installed: InstalledDeviceDeployment{DeviceType: "hammer", IP: "10.1.2.3"},
+			matches:   true,
+		},
+		"ok, ipv6": {
+			mirror:    mirror,
+			installed: InstalledDeviceDeployment{DeviceType: "hammer", IP: "fd00::1"},
+			matches:   true,
+		},
+		"ok, no conditions": {
+			mirror:  Mirror{Name: "bucket"},
+			matches: true,
+		},
+		"other range": {
+			mirror:    mirror,
+			installed: InstalledDeviceDeployment{DeviceType: "hammer", IP: "10.2.2.3"},
+		},
+		"unknown address": {
+			mirror:    mirror,
+			installed: InstalledDeviceDeployment{DeviceType: "hammer"},
+		},
+		"other device type": {
+			mirror:    mirror,
+			installed: InstalledDeviceDeployment{DeviceType: "drill", IP: "10.1.2.3"},
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tc.matches, tc.mirror.Matches(tc.installed))
+		})
+	}
+}
+
+func TestMirrorLink(t *testing.T) {
+	expire := time.Now()
+	mirror := Mirror{
+		Name: "factory",
+		URI:  "http://cache.local/artifacts/{artifact_id}?v=1",
+	}
+
+	assert.Equal(t, &Link{
+		Uri:    "http://cache.local/artifacts/a%2Fb?v=1",
+		Expire: expire,
+	}, mirror.Link("a/b", expire))
+}
@@ -0,0 +1,79 @@
+// Copyright 2019 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package model
+
+import (
+	"github.com/pkg/errors"
+)
+
+// Pause points, devices wait before the step until they are continued
+const (
+	PausePointInstall = "install"
+	PausePointReboot  = "reboot"
+	PausePointCommit  = "commit"
+)
+
+var (
+	ErrInvalidPausePoint = errors.New("Invalid pause point, supported: install, reboot, commit")
+)
+
+// statuses devices report while waiting at the pause points
+var pausePointStatuses = map[string]string{
+	PausePointInstall: DeviceDeploymentStatusPauseBeforeInstall,
+	PausePointReboot:  DeviceDeploymentStatusPauseBeforeReboot,
+	PausePointCommit:  DeviceDeploymentStatusPauseBeforeCommit,
+}
+
+// ValidatePausePoints checks the pause points are known and unique
+func ValidatePausePoints(points []string) error {
+	seen := make(map[string]bool, len(points))
+	for _, p := range points {
+		if _, ok := pausePointStatuses[p]; !ok || seen[p] {
+			return ErrInvalidPausePoint
+		}
+		seen[p] = true
+	}
+	return nil
+}
+
+// PausePointForStatus returns the pause point the device reporting
+// the status waits at, false if the status isn't a pause status
+func PausePointForStatus(status string) (string, bool) {
+	for point, s := range pausePointStatuses {
+		if s == status {
+			return point, true
+		}
+	}
+	return "", false
+}
+
+// PauseContinuation continues devices waiting at the pause point
+type PauseContinuation struct {
+	PausePoint string `json:"pause_point"`
+}
+
+// Validate checks the pause point is known
+func (c PauseContinuation) Validate() error {
+	if _, ok := pausePointStatuses[c.PausePoint]; !ok {
+		return ErrInvalidPausePoint
+	}
+	return nil
+}
+
+// PauseState is the answer to a device reporting it waits at a pause point
+type PauseState struct {
+	// Whether the device may go on with the next step
+	Continue bool `json:"continue"`
+}
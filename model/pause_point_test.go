@@ -0,0 +1,88 @@
+// Copyright 2019 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidatePausePoints(t *testing.T) {
+	testCases := map[string]struct {
+		points []string
+		err    error
+	}{
+		"ok": {
+			points: []string{PausePointInstall, PausePointReboot, PausePointCommit},
+		},
+		"ok, none": {},
+		"error, unknown": {
+			points: []string{"download"},
+			err:    ErrInvalidPausePoint,
+		},
+		"error, duplicate": {
+			points: []string{PausePointReboot, PausePointReboot},
+			err:    ErrInvalidPausePoint,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tc.err, ValidatePausePoints(tc.points))
+		})
+	}
+}
+
+func TestPausePointForStatus(t *testing.T) {
+	testCases := map[string]struct {
+		status string
+
+		point string
+		ok    bool
+	}{
+		"before install": {
+			status: DeviceDeploymentStatusPauseBeforeInstall,
+			point:  PausePointInstall,
+			ok:     true,
+		},
+		"before reboot": {
+			status: DeviceDeploymentStatusPauseBeforeReboot,
+			point:  PausePointReboot,
+			ok:     true,
+		},
+		"before commit": {
+			status: DeviceDeploymentStatusPauseBeforeCommit,
+			point:  PausePointCommit,
+			ok:     true,
+		},
+		"not paused": {
+			status: DeviceDeploymentStatusInstalling,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			point, ok := PausePointForStatus(tc.status)
+			assert.Equal(t, tc.point, point)
+			assert.Equal(t, tc.ok, ok)
+		})
+	}
+}
+
+func TestPauseContinuationValidate(t *testing.T) {
+	assert.NoError(t, PauseContinuation{PausePoint: PausePointReboot}.Validate())
+	assert.Equal(t, ErrInvalidPausePoint, PauseContinuation{}.Validate())
+}
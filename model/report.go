@@ -0,0 +1,156 @@
+// Copyright 2019 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package model
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Formats of the deployment report
+const (
+	DeploymentReportFormatCSV   = "csv"
+	DeploymentReportFormatJSONL = "jsonl"
+)
+
+// DeploymentReportRow describes the outcome of a deployment for a single device
+type DeploymentReportRow struct {
+	DeviceID     string     `json:"device_id"`
+	DeviceType   string     `json:"device_type"`
+	ArtifactName string     `json:"artifact_name"`
+	Status       string     `json:"status"`
+	SubState     string     `json:"substate"`
+	Created      *time.Time `json:"created"`
+	Finished     *time.Time `json:"finished,omitempty"`
+
+	// Seconds from the device picking up the deployment until it finished,
+	// nil if not finished yet or the status history is not available
+	Duration *float64 `json:"duration,omitempty"`
+
+	// Last lines of the deployment log, if requested
+	Log []string `json:"log,omitempty"`
+}
+
+// NewDeploymentReportRow creates the report row of the device deployment
+func NewDeploymentReportRow(dd *DeviceDeployment) *DeploymentReportRow {
+	row := &DeploymentReportRow{
+		Created:  dd.Created,
+		Finished: dd.Finished,
+	}
+
+	if dd.DeviceId != nil {
+		row.DeviceID = *dd.DeviceId
+	}
+	if dd.DeviceType != nil {
+		row.DeviceType = *dd.DeviceType
+	}
+	if dd.Image != nil {
+		row.ArtifactName = dd.Image.Name
+	}
+	if dd.Status != nil {
+		row.Status = *dd.Status
+	}
+	if dd.SubState != nil {
+		row.SubState = *dd.SubState
+	}
+
+	if started := dd.started(); started != nil && dd.Finished != nil {
+		duration := dd.Finished.Sub(*started).Seconds()
+		row.Duration = &duration
+	}
+
+	return row
+}
+
+// started returns the time of the first transition out of pending from the
+// status history, the time spent queued or offline is not included
+func (d *DeviceDeployment) started() *time.Time {
+	for i := range d.History {
+		if d.History[i].Status != DeviceDeploymentStatusPending {
+			return &d.History[i].Timestamp
+		}
+	}
+	return nil
+}
+
+// SetLog sets up to given number of last lines of the deployment log
+func (r *DeploymentReportRow) SetLog(log *DeploymentLog, lines int) {
+	if log == nil || lines <= 0 {
+		return
+	}
+
+	messages := log.Messages
+	if len(messages) > lines {
+		messages = messages[len(messages)-lines:]
+	}
+
+	r.Log = make([]string, 0, len(messages))
+	for _, m := range messages {
+		r.Log = append(r.Log, strings.TrimSuffix(m.String(), "\n"))
+	}
+}
+
+// DeploymentReportCSVHeader returns names of the CSV report columns
+func DeploymentReportCSVHeader(withLog bool) []string {
+	header := []string{
+		"device_id",
+		"device_type",
+		"artifact_name",
+		"status",
+		"substate",
+		"created",
+		"finished",
+		"duration",
+	}
+
+	if withLog {
+		header = append(header, "log")
+	}
+
+	return header
+}
+
+// CSVRecord returns the row as CSV record, matching DeploymentReportCSVHeader
+func (r *DeploymentReportRow) CSVRecord(withLog bool) []string {
+	formatTime := func(t *time.Time) string {
+		if t == nil {
+			return ""
+		}
+		return t.UTC().Format(time.RFC3339)
+	}
+
+	duration := ""
+	if r.Duration != nil {
+		duration = strconv.FormatFloat(*r.Duration, 'f', -1, 64)
+	}
+
+	record := []string{
+		r.DeviceID,
+		r.DeviceType,
+		r.ArtifactName,
+		r.Status,
+		r.SubState,
+		formatTime(r.Created),
+		formatTime(r.Finished),
+		duration,
+	}
+
+	if withLog {
+		record = append(record, strings.Join(r.Log, "\n"))
+	}
+
+	return record
+}
@@ -0,0 +1,103 @@
+// Copyright 2019 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package model
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/mendersoftware/deployments/utils/pointers"
+)
+
+func TestDeploymentReportRow(t *testing.T) {
+	t.Parallel()
+
+	created := time.Date(2019, 5, 1, 10, 0, 0, 0, time.UTC)
+	started := created.Add(30 * time.Second)
+	finished := created.Add(90 * time.Second)
+
+	dd := &DeviceDeployment{
+		Created:  &created,
+		Finished: &finished,
+		History: []DeviceDeploymentStatusChange{
+			{Status: DeviceDeploymentStatusPending, Timestamp: created},
+			{Status: DeviceDeploymentStatusDownloading, Timestamp: started},
+			{Status: DeviceDeploymentStatusFailure, Timestamp: finished},
+		},
+		Status:     StringToPointer(DeviceDeploymentStatusFailure),
+		SubState:   StringToPointer("timeout"),
+		DeviceId:   StringToPointer("device0001"),
+		DeviceType: StringToPointer("hammer"),
+		Image:      &SoftwareImage{},
+	}
+	dd.Image.Name = "release-1"
+
+	row := NewDeploymentReportRow(dd)
+	assert.Equal(t, &DeploymentReportRow{
+		DeviceID:     "device0001",
+		DeviceType:   "hammer",
+		ArtifactName: "release-1",
+		Status:       DeviceDeploymentStatusFailure,
+		SubState:     "timeout",
+		Created:      &created,
+		Finished:     &finished,
+		Duration:     Float64ToPointer(60),
+	}, row)
+
+	log := &DeploymentLog{
+		Messages: []LogMessage{
+			{Timestamp: &created, Level: "info", Message: "first"},
+			{Timestamp: &created, Level: "info", Message: "second"},
+			{Timestamp: &finished, Level: "error", Message: "third"},
+		},
+	}
+	row.SetLog(log, 2)
+	assert.Equal(t, []string{
+		log.Messages[1].String(),
+		log.Messages[2].String(),
+	}, row.Log)
+
+	assert.Equal(t, []string{
+		"device0001", "hammer", "release-1", "failure", "timeout",
+		"2019-05-01T10:00:00Z", "2019-05-01T10:01:30Z", "60",
+	}, row.CSVRecord(false))
+	assert.Len(t, DeploymentReportCSVHeader(false), 8)
+
+	record := row.CSVRecord(true)
+	assert.Equal(t, log.Messages[1].String()+"\n"+log.Messages[2].String(), record[8])
+	assert.Len(t, DeploymentReportCSVHeader(true), 9)
+
+	// finished device added before the status history was recorded
+	row = NewDeploymentReportRow(&DeviceDeployment{
+		Created:  &created,
+		Finished: &finished,
+		Status:   StringToPointer(DeviceDeploymentStatusSuccess),
+	})
+	assert.Nil(t, row.Duration)
+
+	// unfinished device without artifact
+	row = NewDeploymentReportRow(&DeviceDeployment{
+		Created:  &created,
+		Status:   StringToPointer(DeviceDeploymentStatusPending),
+		DeviceId: StringToPointer("device0002"),
+	})
+	assert.Nil(t, row.Duration)
+	assert.Equal(t, []string{
+		"device0002", "", "", "pending", "",
+		"2019-05-01T10:00:00Z", "", "",
+	}, row.CSVRecord(false))
+}
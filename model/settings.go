@@ -0,0 +1,80 @@
+// Copyright 2019 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package model
+
+import (
+	"github.com/pkg/errors"
+)
+
+// MaxRetentionDays is the longest retention period which can be set
+const MaxRetentionDays = 3650
+
+var (
+	ErrInvalidRetention = errors.New("invalid retention settings")
+)
+
+// Settings holds the per-tenant configuration of the service
+type Settings struct {
+	// New deployments have to be approved by a user different from
+	// the one who created them before devices are offered the update
+	RequireApproval bool `json:"require_approval" bson:"requireapproval"`
+
+	Retention RetentionSettings `json:"retention" bson:"retention"`
+
+	// Rules assigning failure categories to failed device deployments,
+	// applied before DefaultFailureRules
+	FailureRules []FailureRule `json:"failure_rules,omitempty" bson:"failurerules,omitempty"`
+
+	// Additional sources of artifacts offered to the matching devices
+	Mirrors []Mirror `json:"mirrors,omitempty" bson:"mirrors,omitempty"`
+}
+
+// Validate checks the settings
+func (s Settings) Validate() error {
+	if err := s.Retention.Validate(); err != nil {
+		return err
+	}
+	if err := ValidateFailureRules(s.FailureRules); err != nil {
+		return err
+	}
+	return ValidateMirrors(s.Mirrors)
+}
+
+// RetentionSettings configures removal of old deployment data;
+// data is kept forever if the number of days is 0
+type RetentionSettings struct {
+	// Days since the last upload of a device deployment log
+	LogDays int `json:"log_days" bson:"logdays"`
+
+	// Days since a deployment finished, for the device deployments
+	// of the deployment; the deployment and its statistics are kept
+	DeviceDeploymentDays int `json:"device_deployment_days" bson:"devicedeploymentdays"`
+
+	// Store the removed data as compressed JSON in the file storage
+	Archive bool `json:"archive" bson:"archive"`
+}
+
+// Validate checks the retention periods are in range
+func (r RetentionSettings) Validate() error {
+	if r.LogDays < 0 || r.LogDays > MaxRetentionDays {
+		return errors.Wrapf(ErrInvalidRetention,
+			"log_days must be between 0 and %d", MaxRetentionDays)
+	}
+	if r.DeviceDeploymentDays < 0 || r.DeviceDeploymentDays > MaxRetentionDays {
+		return errors.Wrapf(ErrInvalidRetention,
+			"device_deployment_days must be between 0 and %d", MaxRetentionDays)
+	}
+	return nil
+}
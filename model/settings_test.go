@@ -0,0 +1,70 @@
+// Copyright 2019 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSettingsValidate(t *testing.T) {
+	testCases := map[string]struct {
+		settings Settings
+		err      string
+	}{
+		"ok, defaults": {},
+		"ok, retention": {
+			settings: Settings{
+				Retention: RetentionSettings{
+					LogDays:              30,
+					DeviceDeploymentDays: MaxRetentionDays,
+					Archive:              true,
+				},
+			},
+		},
+		"error, negative log days": {
+			settings: Settings{
+				Retention: RetentionSettings{LogDays: -1},
+			},
+			err: "log_days must be between 0 and 3650: " + ErrInvalidRetention.Error(),
+		},
+		"error, too many device deployment days": {
+			settings: Settings{
+				Retention: RetentionSettings{DeviceDeploymentDays: MaxRetentionDays + 1},
+			},
+			err: "device_deployment_days must be between 0 and 3650: " +
+				ErrInvalidRetention.Error(),
+		},
+		"error, invalid mirror": {
+			settings: Settings{
+				Mirrors: []Mirror{{Name: "factory", URI: "http://cache.local/"}},
+			},
+			err: "uri of mirror factory must contain {artifact_id}: " +
+				ErrInvalidMirror.Error(),
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			err := tc.settings.Validate()
+			if tc.err != "" {
+				assert.EqualError(t, err, tc.err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
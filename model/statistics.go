@@ -0,0 +1,157 @@
+// Copyright 2019 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package model
+
+import (
+	"math"
+	"sort"
+)
+
+// DurationStats holds statistics of time (in seconds) devices spent
+// in a single state
+type DurationStats struct {
+	// Number of devices which left the state
+	Count int `json:"count"`
+
+	Min float64 `json:"min"`
+	Avg float64 `json:"avg"`
+	P50 float64 `json:"p50"`
+	P90 float64 `json:"p90"`
+	P95 float64 `json:"p95"`
+	P99 float64 `json:"p99"`
+	Max float64 `json:"max"`
+}
+
+// NewDurationStats computes nearest-rank percentiles of the durations
+// (in seconds), which have to be sorted
+func NewDurationStats(sorted []float64) DurationStats {
+	if len(sorted) == 0 {
+		return DurationStats{}
+	}
+
+	percentile := func(p float64) float64 {
+		rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+		return sorted[rank-1]
+	}
+
+	var sum float64
+	for _, d := range sorted {
+		sum += d
+	}
+
+	return DurationStats{
+		Count: len(sorted),
+		Min:   sorted[0],
+		Avg:   sum / float64(len(sorted)),
+		P50:   percentile(50),
+		P90:   percentile(90),
+		P95:   percentile(95),
+		P99:   percentile(99),
+		Max:   sorted[len(sorted)-1],
+	}
+}
+
+// StateDurationStats holds duration statistics of the download, install
+// and reboot states
+type StateDurationStats struct {
+	Download DurationStats `json:"download"`
+	Install  DurationStats `json:"install"`
+	Reboot   DurationStats `json:"reboot"`
+}
+
+// DeploymentDurationStats holds duration statistics of a deployment,
+// for all devices and per device type
+type DeploymentDurationStats struct {
+	Total       StateDurationStats            `json:"total"`
+	DeviceTypes map[string]StateDurationStats `json:"device_types"`
+}
+
+// StateDurationGroup holds the time (in seconds) devices of a device type
+// spent in a state they have already left, as aggregated by the store
+// from the status history of device deployments
+type StateDurationGroup struct {
+	// empty if the device type is not known yet
+	DeviceType string `bson:"devicetype"`
+	Status     string `bson:"status"`
+
+	// sorted
+	Durations []float64 `bson:"durations"`
+}
+
+type stateDurations map[string][]float64
+
+func (s stateDurations) stats() StateDurationStats {
+	return StateDurationStats{
+		Download: NewDurationStats(s[DeviceDeploymentStatusDownloading]),
+		Install:  NewDurationStats(s[DeviceDeploymentStatusInstalling]),
+		Reboot:   NewDurationStats(s[DeviceDeploymentStatusRebooting]),
+	}
+}
+
+// NewDeploymentDurationStats computes duration statistics from the state
+// durations of device types
+func NewDeploymentDurationStats(groups []StateDurationGroup) *DeploymentDurationStats {
+	total := stateDurations{}
+	perType := map[string]stateDurations{}
+
+	for _, g := range groups {
+		total[g.Status] = append(total[g.Status], g.Durations...)
+
+		if g.DeviceType != "" {
+			if _, ok := perType[g.DeviceType]; !ok {
+				perType[g.DeviceType] = stateDurations{}
+			}
+			perType[g.DeviceType][g.Status] = g.Durations
+		}
+	}
+
+	for _, durations := range total {
+		sort.Float64s(durations)
+	}
+
+	stats := &DeploymentDurationStats{
+		Total:       total.stats(),
+		DeviceTypes: make(map[string]StateDurationStats, len(perType)),
+	}
+
+	for deviceType, durations := range perType {
+		stats.DeviceTypes[deviceType] = durations.stats()
+	}
+
+	return stats
+}
+
+// DownloadProgressStats sums up the download progress reported by devices
+// of a deployment, as aggregated by the store
+type DownloadProgressStats struct {
+	// Sum of bytes downloaded, as last reported by devices
+	BytesTransferred int64 `json:"bytes_transferred" bson:"bytes_transferred"`
+
+	// Sum of artifact sizes of devices which reported progress
+	BytesTotal int64 `json:"bytes_total" bson:"bytes_total"`
+
+	// Estimated seconds until devices currently downloading finish,
+	// nil if not known
+	ETA *int64 `json:"eta,omitempty" bson:"eta,omitempty"`
+}
+
+// DeploymentProgressStats holds the download progress of the fleet and
+// the failures of a deployment
+type DeploymentProgressStats struct {
+	Download DownloadProgressStats `json:"download"`
+
+	// Number of failed devices per failure category
+	Failures map[string]int `json:"failures"`
+}
@@ -0,0 +1,110 @@
+// Copyright 2019 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewDurationStats(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, DurationStats{}, NewDurationStats(nil))
+
+	durations := []float64{}
+	for i := 1; i <= 100; i++ {
+		durations = append(durations, float64(i))
+	}
+
+	assert.Equal(t, DurationStats{
+		Count: 100,
+		Min:   1,
+		Avg:   50.5,
+		P50:   50,
+		P90:   90,
+		P95:   95,
+		P99:   99,
+		Max:   100,
+	}, NewDurationStats(durations))
+
+	assert.Equal(t, DurationStats{
+		Count: 1,
+		Min:   2,
+		Avg:   2,
+		P50:   2,
+		P90:   2,
+		P95:   2,
+		P99:   2,
+		Max:   2,
+	}, NewDurationStats([]float64{2}))
+}
+
+func TestNewDeploymentDurationStats(t *testing.T) {
+	t.Parallel()
+
+	groups := []StateDurationGroup{
+		{
+			DeviceType: "hammer",
+			Status:     DeviceDeploymentStatusDownloading,
+			Durations:  []float64{30},
+		},
+		{
+			DeviceType: "hammer",
+			Status:     DeviceDeploymentStatusInstalling,
+			Durations:  []float64{10},
+		},
+		{
+			DeviceType: "hammer",
+			Status:     DeviceDeploymentStatusRebooting,
+			Durations:  []float64{60},
+		},
+		{
+			DeviceType: "drill",
+			Status:     DeviceDeploymentStatusDownloading,
+			Durations:  []float64{10},
+		},
+		{
+			// no device type assigned
+			Status:    DeviceDeploymentStatusDownloading,
+			Durations: []float64{5, 40},
+		},
+	}
+
+	stats := NewDeploymentDurationStats(groups)
+
+	assert.Equal(t, DurationStats{
+		Count: 4, Min: 5, Avg: 21.25, P50: 10, P90: 40, P95: 40, P99: 40, Max: 40,
+	}, stats.Total.Download)
+	assert.Equal(t, DurationStats{
+		Count: 1, Min: 10, Avg: 10, P50: 10, P90: 10, P95: 10, P99: 10, Max: 10,
+	}, stats.Total.Install)
+	assert.Equal(t, DurationStats{
+		Count: 1, Min: 60, Avg: 60, P50: 60, P90: 60, P95: 60, P99: 60, Max: 60,
+	}, stats.Total.Reboot)
+
+	assert.Len(t, stats.DeviceTypes, 2)
+	assert.Equal(t, DurationStats{
+		Count: 1, Min: 30, Avg: 30, P50: 30, P90: 30, P95: 30, P99: 30, Max: 30,
+	}, stats.DeviceTypes["hammer"].Download)
+	assert.Equal(t, DurationStats{
+		Count: 1, Min: 10, Avg: 10, P50: 10, P90: 10, P95: 10, P99: 10, Max: 10,
+	}, stats.DeviceTypes["drill"].Download)
+	assert.Equal(t, DurationStats{}, stats.DeviceTypes["drill"].Install)
+
+	// the groups are left intact
+	assert.Equal(t, []float64{5, 40}, groups[4].Durations)
+}
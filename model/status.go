@@ -16,18 +16,42 @@ package model
 
 import (
 	"encoding/json"
+	"time"
 
 	"github.com/asaskevich/govalidator"
 	"github.com/pkg/errors"
 )
 
 var (
-	ErrBadStatus = errors.New("unknown status value")
+	ErrBadStatus   = errors.New("unknown status value")
+	ErrBadProgress = errors.New("invalid progress value")
 )
 
 type StatusReport struct {
 	Status   string
 	SubState *string `json:"substate" valid:"length(0|200)"`
+
+	// Download progress, optional
+	Progress *DownloadProgress `json:"progress,omitempty" valid:"-"`
+}
+
+// DownloadProgress is the artifact download progress reported by device
+type DownloadProgress struct {
+	BytesDownloaded int64   `json:"bytes_downloaded" bson:"bytes_downloaded"`
+	TotalBytes      int64   `json:"total_bytes" bson:"total_bytes"`
+	Percent         float64 `json:"percent" bson:"percent"`
+
+	// Time of the report, set by the service
+	Updated *time.Time `json:"updated,omitempty" bson:"updated,omitempty"`
+}
+
+func (p *DownloadProgress) Validate() error {
+	if p.BytesDownloaded < 0 || p.TotalBytes < 0 ||
+		(p.TotalBytes > 0 && p.BytesDownloaded > p.TotalBytes) ||
+		p.Percent < 0 || p.Percent > 100 {
+		return ErrBadProgress
+	}
+	return nil
 }
 
 func containsString(what string, in []string) bool {
@@ -57,6 +81,9 @@ func (s *StatusReport) UnmarshalJSON(raw []byte) error {
 		DeviceDeploymentStatusSuccess,
 		DeviceDeploymentStatusFailure,
 		DeviceDeploymentStatusAlreadyInst,
+		DeviceDeploymentStatusPauseBeforeInstall,
+		DeviceDeploymentStatusPauseBeforeReboot,
+		DeviceDeploymentStatusPauseBeforeCommit,
 	}
 
 	if !containsString(temp.Status, valid) {
@@ -67,9 +94,16 @@ func (s *StatusReport) UnmarshalJSON(raw []byte) error {
 		return err
 	}
 
+	if temp.Progress != nil {
+		if err := temp.Progress.Validate(); err != nil {
+			return err
+		}
+	}
+
 	// all good
 	s.Status = temp.Status
 	s.SubState = temp.SubState
+	s.Progress = temp.Progress
 
 	return nil
 }
@@ -35,6 +35,28 @@ func TestStatusUnmarshal(t *testing.T) {
 	assert.Equal(t,
 		StatusReport{Status: DeviceDeploymentStatusInstalling},
 		report)
+
+	err = json.Unmarshal([]byte(`{"status": "downloading",
+		"progress": {"bytes_downloaded": 512, "total_bytes": 2048, "percent": 25}}`), &report)
+	assert.NoError(t, err)
+	assert.Equal(t,
+		StatusReport{
+			Status: DeviceDeploymentStatusDownloading,
+			Progress: &DownloadProgress{
+				BytesDownloaded: 512,
+				TotalBytes:      2048,
+				Percent:         25,
+			},
+		},
+		report)
+
+	err = json.Unmarshal([]byte(`{"status": "downloading",
+		"progress": {"bytes_downloaded": 4096, "total_bytes": 2048}}`), &report)
+	assert.EqualError(t, err, ErrBadProgress.Error())
+
+	err = json.Unmarshal([]byte(`{"status": "downloading",
+		"progress": {"percent": 101}}`), &report)
+	assert.EqualError(t, err, ErrBadProgress.Error())
 }
 
 func TestContainsString(t *testing.T) {
@@ -27,8 +27,27 @@ type DataStore interface {
 	//limits
 	GetLimit(ctx context.Context, name string) (*model.Limit, error)
 
+	//settings
+	GetSettings(ctx context.Context) (*model.Settings, error)
+	SaveSettings(ctx context.Context, settings *model.Settings) error
+	SetRequireApproval(ctx context.Context, require bool) error
+
+	//deployment generation
+	GetDeploymentGeneration(ctx context.Context) (int64, error)
+	IncrementDeploymentGeneration(ctx context.Context) error
+
+	//installed base
+	UpsertInstalledArtifact(ctx context.Context, installed *model.InstalledArtifact) error
+	FindInstalledArtifact(ctx context.Context, deviceID string) (*model.InstalledArtifact, error)
+	DeleteInstalledArtifact(ctx context.Context, deviceID string) error
+	AggregateInstalledBase(ctx context.Context,
+		artifactName string) ([]model.InstalledBaseRelease, error)
+	GetInstalledArtifacts(ctx context.Context,
+		query model.InstalledBaseQuery) ([]model.InstalledArtifact, int, error)
+
 	//tenants
 	ProvisionTenant(ctx context.Context, tenantId string) error
+	ListTenants(ctx context.Context) ([]string, error)
 
 	//images
 	Exists(ctx context.Context, id string) (bool, error)
@@ -50,9 +69,25 @@ type DataStore interface {
 
 	//device deployment log
 	SaveDeviceDeploymentLog(ctx context.Context, log model.DeploymentLog) error
+	AppendDeviceDeploymentLog(ctx context.Context, log model.DeploymentLog) error
+	SearchDeviceDeploymentLogs(ctx context.Context,
+		query model.DeploymentLogQuery) ([]model.DeploymentLogMatch, error)
+	FindExpiredDeviceDeploymentLogs(ctx context.Context,
+		before time.Time, limit int) ([]model.DeploymentLog, error)
+	DeleteDeviceDeploymentLog(ctx context.Context, deviceID, deploymentID string) error
+	FindDeploymentsWithExpiredDevices(ctx context.Context,
+		before time.Time, limit int) ([]*model.Deployment, error)
+	DeleteDeviceDeploymentsForDeployment(ctx context.Context, deploymentID string) error
 	GetDeviceDeploymentLog(ctx context.Context,
 		deviceID, deploymentID string) (*model.DeploymentLog, error)
 
+	// device notifications
+	EnsureDeviceNotificationsCollection(ctx context.Context) error
+	InsertDeviceNotification(ctx context.Context,
+		notification model.DeviceNotification) error
+	WatchDeviceNotifications(ctx context.Context,
+		fn func(model.DeviceNotification)) error
+
 	// device deployemnts
 	InsertMany(ctx context.Context,
 		deployment ...*model.DeviceDeployment) error
@@ -62,22 +97,46 @@ type DataStore interface {
 		deviceID string, statuses ...string) (*model.DeviceDeployment, error)
 	FindAllDeploymentsForDeviceIDWithStatuses(ctx context.Context,
 		deviceID string, statuses ...string) ([]model.DeviceDeployment, error)
+	FindDeviceDeployments(ctx context.Context,
+		query model.DeviceDeploymentsQuery) ([]model.DeviceDeployment, error)
 	UpdateDeviceDeploymentStatus(ctx context.Context, deviceID string,
 		deploymentID string, status model.DeviceDeploymentStatus) (string, error)
+	UpdateDeviceDeploymentStatusFrom(ctx context.Context, deviceID string,
+		deploymentID string, from string, status model.DeviceDeploymentStatus) (bool, error)
 	UpdateDeviceDeploymentLogAvailability(ctx context.Context,
 		deviceID string, deploymentID string, log bool) error
+	UpdateDeviceDeploymentFailureCategory(ctx context.Context, deviceID string,
+		deploymentID string, category string) error
+	UpdateDeviceDeploymentProgress(ctx context.Context, deviceID string,
+		deploymentID string, progress model.DownloadProgress) error
 	AssignArtifact(ctx context.Context, deviceID string,
 		deploymentID string, artifact *model.SoftwareImage) error
 	AggregateDeviceDeploymentByStatus(ctx context.Context,
 		id string) (model.Stats, error)
+	AggregateDeviceDeploymentByFailureCategory(ctx context.Context,
+		id string) (map[string]int, error)
+	AggregateDeviceDeploymentProgress(ctx context.Context,
+		id string, now time.Time) (*model.DownloadProgressStats, error)
+	AggregateDeviceDeploymentStateDurations(ctx context.Context,
+		id string) ([]model.StateDurationGroup, error)
 	GetDeviceStatusesForDeployment(ctx context.Context,
 		deploymentID string) ([]model.DeviceDeployment, error)
+	GetDevicesListForDeployment(ctx context.Context,
+		query model.DeploymentDevicesQuery) ([]model.DeviceDeployment, int, error)
+	IterateDeviceDeploymentsForDeployment(ctx context.Context, deploymentID string,
+		fn func(dd *model.DeviceDeployment) error) error
 	HasDeploymentForDevice(ctx context.Context,
 		deploymentID string, deviceID string) (bool, error)
+	FindDeviceDeployment(ctx context.Context,
+		deploymentID string, deviceID string) (*model.DeviceDeployment, error)
 	GetDeviceDeploymentStatus(ctx context.Context,
 		deploymentID string, deviceID string) (string, error)
+	AddDeviceDeploymentContinued(ctx context.Context,
+		deploymentID string, deviceID string, pausePoint string) error
 	AbortDeviceDeployments(ctx context.Context, deploymentID string) error
 	DecommissionDeviceDeployments(ctx context.Context, deviceId string) error
+	FindStaleDeviceDeployments(ctx context.Context, deploymentID string,
+		status string, before time.Time) ([]model.DeviceDeployment, error)
 
 	// deployments
 	InsertDeployment(ctx context.Context, deployment *model.Deployment) error
@@ -85,11 +144,26 @@ type DataStore interface {
 	FindDeploymentByID(ctx context.Context, id string) (*model.Deployment, error)
 	FindUnfinishedByID(ctx context.Context,
 		id string) (*model.Deployment, error)
+	FindDependentDeployments(ctx context.Context,
+		id string) ([]*model.Deployment, error)
+	FindDeploymentsByIDs(ctx context.Context,
+		ids []string) ([]*model.Deployment, error)
+	FindUnfinishedWithTimeouts(ctx context.Context) ([]*model.Deployment, error)
 	UpdateStats(ctx context.Context, id string, state_from, state_to string) error
+	IncrementPendingStats(ctx context.Context, id string, count int) error
+	UpdateStatsWithinLimit(ctx context.Context, id string,
+		state_from, state_to string, limit int) (bool, error)
 	UpdateStatsAndFinishDeployment(ctx context.Context,
 		id string, stats model.Stats) error
 	Find(ctx context.Context,
 		query model.Query) ([]*model.Deployment, error)
+	CountDeployments(ctx context.Context, query model.Query) (int, error)
+	UpdateDeploymentMetadata(ctx context.Context, id string,
+		meta model.DeploymentMetadataConstructor) error
+	UpdateDeploymentApproval(ctx context.Context, id string,
+		approval model.DeploymentApproval) error
+	AddDeploymentContinued(ctx context.Context, id string, pausePoint string) error
+	SetDeploymentAborted(ctx context.Context, id string) error
 	Finish(ctx context.Context, id string, when time.Time) error
 	ExistUnfinishedByArtifactId(ctx context.Context, id string) (bool, error)
 	ExistByArtifactId(ctx context.Context, id string) (bool, error)
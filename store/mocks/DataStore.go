@@ -38,6 +38,57 @@ func (_m *DataStore) AbortDeviceDeployments(ctx context.Context, deploymentID st
 	return r0
 }
 
+// AddDeploymentContinued provides a mock function with given fields: ctx, id, pausePoint
+func (_m *DataStore) AddDeploymentContinued(ctx context.Context, id string, pausePoint string) error {
+	ret := _m.Called(ctx, id, pausePoint)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) error); ok {
+		r0 = rf(ctx, id, pausePoint)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// AddDeviceDeploymentContinued provides a mock function with given fields: ctx, deploymentID, deviceID, pausePoint
+func (_m *DataStore) AddDeviceDeploymentContinued(ctx context.Context, deploymentID string, deviceID string, pausePoint string) error {
+	ret := _m.Called(ctx, deploymentID, deviceID, pausePoint)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, string, string) error); ok {
+		r0 = rf(ctx, deploymentID, deviceID, pausePoint)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// AggregateDeviceDeploymentByFailureCategory provides a mock function with given fields: ctx, id
+func (_m *DataStore) AggregateDeviceDeploymentByFailureCategory(ctx context.Context, id string) (map[string]int, error) {
+	ret := _m.Called(ctx, id)
+
+	var r0 map[string]int
+	if rf, ok := ret.Get(0).(func(context.Context, string) map[string]int); ok {
+		r0 = rf(ctx, id)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(map[string]int)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, id)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // AggregateDeviceDeploymentByStatus provides a mock function with given fields: ctx, id
 func (_m *DataStore) AggregateDeviceDeploymentByStatus(ctx context.Context, id string) (model.Stats, error) {
 	ret := _m.Called(ctx, id)
@@ -61,6 +112,89 @@ func (_m *DataStore) AggregateDeviceDeploymentByStatus(ctx context.Context, id s
 	return r0, r1
 }
 
+// AggregateDeviceDeploymentProgress provides a mock function with given fields: ctx, id, now
+func (_m *DataStore) AggregateDeviceDeploymentProgress(ctx context.Context, id string, now time.Time) (*model.DownloadProgressStats, error) {
+	ret := _m.Called(ctx, id, now)
+
+	var r0 *model.DownloadProgressStats
+	if rf, ok := ret.Get(0).(func(context.Context, string, time.Time) *model.DownloadProgressStats); ok {
+		r0 = rf(ctx, id, now)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.DownloadProgressStats)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string, time.Time) error); ok {
+		r1 = rf(ctx, id, now)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// AggregateDeviceDeploymentStateDurations provides a mock function with given fields: ctx, id
+func (_m *DataStore) AggregateDeviceDeploymentStateDurations(ctx context.Context, id string) ([]model.StateDurationGroup, error) {
+	ret := _m.Called(ctx, id)
+
+	var r0 []model.StateDurationGroup
+	if rf, ok := ret.Get(0).(func(context.Context, string) []model.StateDurationGroup); ok {
+		r0 = rf(ctx, id)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]model.StateDurationGroup)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, id)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// AggregateInstalledBase provides a mock function with given fields: ctx, artifactName
+func (_m *DataStore) AggregateInstalledBase(ctx context.Context, artifactName string) ([]model.InstalledBaseRelease, error) {
+	ret := _m.Called(ctx, artifactName)
+
+	var r0 []model.InstalledBaseRelease
+	if rf, ok := ret.Get(0).(func(context.Context, string) []model.InstalledBaseRelease); ok {
+		r0 = rf(ctx, artifactName)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]model.InstalledBaseRelease)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, artifactName)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// AppendDeviceDeploymentLog provides a mock function with given fields: ctx, log
+func (_m *DataStore) AppendDeviceDeploymentLog(ctx context.Context, log model.DeploymentLog) error {
+	ret := _m.Called(ctx, log)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, model.DeploymentLog) error); ok {
+		r0 = rf(ctx, log)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // AssignArtifact provides a mock function with given fields: ctx, deviceID, deploymentID, artifact
 func (_m *DataStore) AssignArtifact(ctx context.Context, deviceID string, deploymentID string, artifact *model.SoftwareImage) error {
 	ret := _m.Called(ctx, deviceID, deploymentID, artifact)
@@ -75,6 +209,27 @@ func (_m *DataStore) AssignArtifact(ctx context.Context, deviceID string, deploy
 	return r0
 }
 
+// CountDeployments provides a mock function with given fields: ctx, query
+func (_m *DataStore) CountDeployments(ctx context.Context, query model.Query) (int, error) {
+	ret := _m.Called(ctx, query)
+
+	var r0 int
+	if rf, ok := ret.Get(0).(func(context.Context, model.Query) int); ok {
+		r0 = rf(ctx, query)
+	} else {
+		r0 = ret.Get(0).(int)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, model.Query) error); ok {
+		r1 = rf(ctx, query)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // DecommissionDeviceDeployments provides a mock function with given fields: ctx, deviceId
 func (_m *DataStore) DecommissionDeviceDeployments(ctx context.Context, deviceId string) error {
 	ret := _m.Called(ctx, deviceId)
@@ -103,6 +258,34 @@ func (_m *DataStore) DeleteDeployment(ctx context.Context, id string) error {
 	return r0
 }
 
+// DeleteDeviceDeploymentLog provides a mock function with given fields: ctx, deviceID, deploymentID
+func (_m *DataStore) DeleteDeviceDeploymentLog(ctx context.Context, deviceID string, deploymentID string) error {
+	ret := _m.Called(ctx, deviceID, deploymentID)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) error); ok {
+		r0 = rf(ctx, deviceID, deploymentID)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// DeleteDeviceDeploymentsForDeployment provides a mock function with given fields: ctx, deploymentID
+func (_m *DataStore) DeleteDeviceDeploymentsForDeployment(ctx context.Context, deploymentID string) error {
+	ret := _m.Called(ctx, deploymentID)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) error); ok {
+		r0 = rf(ctx, deploymentID)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // DeleteImage provides a mock function with given fields: ctx, id
 func (_m *DataStore) DeleteImage(ctx context.Context, id string) error {
 	ret := _m.Called(ctx, id)
@@ -117,6 +300,20 @@ func (_m *DataStore) DeleteImage(ctx context.Context, id string) error {
 	return r0
 }
 
+// DeleteInstalledArtifact provides a mock function with given fields: ctx, deviceID
+func (_m *DataStore) DeleteInstalledArtifact(ctx context.Context, deviceID string) error {
+	ret := _m.Called(ctx, deviceID)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) error); ok {
+		r0 = rf(ctx, deviceID)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // DeviceCountByDeployment provides a mock function with given fields: ctx, id
 func (_m *DataStore) DeviceCountByDeployment(ctx context.Context, id string) (int, error) {
 	ret := _m.Called(ctx, id)
@@ -138,6 +335,20 @@ func (_m *DataStore) DeviceCountByDeployment(ctx context.Context, id string) (in
 	return r0, r1
 }
 
+// EnsureDeviceNotificationsCollection provides a mock function with given fields: ctx
+func (_m *DataStore) EnsureDeviceNotificationsCollection(ctx context.Context) error {
+	ret := _m.Called(ctx)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context) error); ok {
+		r0 = rf(ctx)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // ExistAssignedImageWithIDAndStatuses provides a mock function with given fields: ctx, id, statuses
 func (_m *DataStore) ExistAssignedImageWithIDAndStatuses(ctx context.Context, id string, statuses ...string) (bool, error) {
 	ret := _m.Called(ctx, id, statuses)
@@ -291,6 +502,29 @@ func (_m *DataStore) FindAllDeploymentsForDeviceIDWithStatuses(ctx context.Conte
 	return r0, r1
 }
 
+// FindDependentDeployments provides a mock function with given fields: ctx, id
+func (_m *DataStore) FindDependentDeployments(ctx context.Context, id string) ([]*model.Deployment, error) {
+	ret := _m.Called(ctx, id)
+
+	var r0 []*model.Deployment
+	if rf, ok := ret.Get(0).(func(context.Context, string) []*model.Deployment); ok {
+		r0 = rf(ctx, id)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]*model.Deployment)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, id)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // FindDeploymentByID provides a mock function with given fields: ctx, id
 func (_m *DataStore) FindDeploymentByID(ctx context.Context, id string) (*model.Deployment, error) {
 	ret := _m.Called(ctx, id)
@@ -314,6 +548,121 @@ func (_m *DataStore) FindDeploymentByID(ctx context.Context, id string) (*model.
 	return r0, r1
 }
 
+// FindDeploymentsByIDs provides a mock function with given fields: ctx, ids
+func (_m *DataStore) FindDeploymentsByIDs(ctx context.Context, ids []string) ([]*model.Deployment, error) {
+	ret := _m.Called(ctx, ids)
+
+	var r0 []*model.Deployment
+	if rf, ok := ret.Get(0).(func(context.Context, []string) []*model.Deployment); ok {
+		r0 = rf(ctx, ids)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]*model.Deployment)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, []string) error); ok {
+		r1 = rf(ctx, ids)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// FindDeploymentsWithExpiredDevices provides a mock function with given fields: ctx, before, limit
+func (_m *DataStore) FindDeploymentsWithExpiredDevices(ctx context.Context, before time.Time, limit int) ([]*model.Deployment, error) {
+	ret := _m.Called(ctx, before, limit)
+
+	var r0 []*model.Deployment
+	if rf, ok := ret.Get(0).(func(context.Context, time.Time, int) []*model.Deployment); ok {
+		r0 = rf(ctx, before, limit)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]*model.Deployment)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, time.Time, int) error); ok {
+		r1 = rf(ctx, before, limit)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// FindDeviceDeployment provides a mock function with given fields: ctx, deploymentID, deviceID
+func (_m *DataStore) FindDeviceDeployment(ctx context.Context, deploymentID string, deviceID string) (*model.DeviceDeployment, error) {
+	ret := _m.Called(ctx, deploymentID, deviceID)
+
+	var r0 *model.DeviceDeployment
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) *model.DeviceDeployment); ok {
+		r0 = rf(ctx, deploymentID, deviceID)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.DeviceDeployment)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string, string) error); ok {
+		r1 = rf(ctx, deploymentID, deviceID)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// FindDeviceDeployments provides a mock function with given fields: ctx, query
+func (_m *DataStore) FindDeviceDeployments(ctx context.Context, query model.DeviceDeploymentsQuery) ([]model.DeviceDeployment, error) {
+	ret := _m.Called(ctx, query)
+
+	var r0 []model.DeviceDeployment
+	if rf, ok := ret.Get(0).(func(context.Context, model.DeviceDeploymentsQuery) []model.DeviceDeployment); ok {
+		r0 = rf(ctx, query)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]model.DeviceDeployment)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, model.DeviceDeploymentsQuery) error); ok {
+		r1 = rf(ctx, query)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// FindExpiredDeviceDeploymentLogs provides a mock function with given fields: ctx, before, limit
+func (_m *DataStore) FindExpiredDeviceDeploymentLogs(ctx context.Context, before time.Time, limit int) ([]model.DeploymentLog, error) {
+	ret := _m.Called(ctx, before, limit)
+
+	var r0 []model.DeploymentLog
+	if rf, ok := ret.Get(0).(func(context.Context, time.Time, int) []model.DeploymentLog); ok {
+		r0 = rf(ctx, before, limit)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]model.DeploymentLog)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, time.Time, int) error); ok {
+		r1 = rf(ctx, before, limit)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // FindImageByID provides a mock function with given fields: ctx, id
 func (_m *DataStore) FindImageByID(ctx context.Context, id string) (*model.SoftwareImage, error) {
 	ret := _m.Called(ctx, id)
@@ -337,6 +686,29 @@ func (_m *DataStore) FindImageByID(ctx context.Context, id string) (*model.Softw
 	return r0, r1
 }
 
+// FindInstalledArtifact provides a mock function with given fields: ctx, deviceID
+func (_m *DataStore) FindInstalledArtifact(ctx context.Context, deviceID string) (*model.InstalledArtifact, error) {
+	ret := _m.Called(ctx, deviceID)
+
+	var r0 *model.InstalledArtifact
+	if rf, ok := ret.Get(0).(func(context.Context, string) *model.InstalledArtifact); ok {
+		r0 = rf(ctx, deviceID)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.InstalledArtifact)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, deviceID)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // FindOldestDeploymentForDeviceIDWithStatuses provides a mock function with given fields: ctx, deviceID, statuses
 func (_m *DataStore) FindOldestDeploymentForDeviceIDWithStatuses(ctx context.Context, deviceID string, statuses ...string) (*model.DeviceDeployment, error) {
 	ret := _m.Called(ctx, deviceID, statuses)
@@ -360,6 +732,29 @@ func (_m *DataStore) FindOldestDeploymentForDeviceIDWithStatuses(ctx context.Con
 	return r0, r1
 }
 
+// FindStaleDeviceDeployments provides a mock function with given fields: ctx, deploymentID, status, before
+func (_m *DataStore) FindStaleDeviceDeployments(ctx context.Context, deploymentID string, status string, before time.Time) ([]model.DeviceDeployment, error) {
+	ret := _m.Called(ctx, deploymentID, status, before)
+
+	var r0 []model.DeviceDeployment
+	if rf, ok := ret.Get(0).(func(context.Context, string, string, time.Time) []model.DeviceDeployment); ok {
+		r0 = rf(ctx, deploymentID, status, before)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]model.DeviceDeployment)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string, string, time.Time) error); ok {
+		r1 = rf(ctx, deploymentID, status, before)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // FindUnfinishedByID provides a mock function with given fields: ctx, id
 func (_m *DataStore) FindUnfinishedByID(ctx context.Context, id string) (*model.Deployment, error) {
 	ret := _m.Called(ctx, id)
@@ -383,6 +778,29 @@ func (_m *DataStore) FindUnfinishedByID(ctx context.Context, id string) (*model.
 	return r0, r1
 }
 
+// FindUnfinishedWithTimeouts provides a mock function with given fields: ctx
+func (_m *DataStore) FindUnfinishedWithTimeouts(ctx context.Context) ([]*model.Deployment, error) {
+	ret := _m.Called(ctx)
+
+	var r0 []*model.Deployment
+	if rf, ok := ret.Get(0).(func(context.Context) []*model.Deployment); ok {
+		r0 = rf(ctx)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]*model.Deployment)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // Finish provides a mock function with given fields: ctx, id, when
 func (_m *DataStore) Finish(ctx context.Context, id string, when time.Time) error {
 	ret := _m.Called(ctx, id, when)
@@ -397,6 +815,27 @@ func (_m *DataStore) Finish(ctx context.Context, id string, when time.Time) erro
 	return r0
 }
 
+// GetDeploymentGeneration provides a mock function with given fields: ctx
+func (_m *DataStore) GetDeploymentGeneration(ctx context.Context) (int64, error) {
+	ret := _m.Called(ctx)
+
+	var r0 int64
+	if rf, ok := ret.Get(0).(func(context.Context) int64); ok {
+		r0 = rf(ctx)
+	} else {
+		r0 = ret.Get(0).(int64)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // GetDeviceDeploymentLog provides a mock function with given fields: ctx, deviceID, deploymentID
 func (_m *DataStore) GetDeviceDeploymentLog(ctx context.Context, deviceID string, deploymentID string) (*model.DeploymentLog, error) {
 	ret := _m.Called(ctx, deviceID, deploymentID)
@@ -464,6 +903,66 @@ func (_m *DataStore) GetDeviceStatusesForDeployment(ctx context.Context, deploym
 	return r0, r1
 }
 
+// GetDevicesListForDeployment provides a mock function with given fields: ctx, query
+func (_m *DataStore) GetDevicesListForDeployment(ctx context.Context, query model.DeploymentDevicesQuery) ([]model.DeviceDeployment, int, error) {
+	ret := _m.Called(ctx, query)
+
+	var r0 []model.DeviceDeployment
+	if rf, ok := ret.Get(0).(func(context.Context, model.DeploymentDevicesQuery) []model.DeviceDeployment); ok {
+		r0 = rf(ctx, query)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]model.DeviceDeployment)
+		}
+	}
+
+	var r1 int
+	if rf, ok := ret.Get(1).(func(context.Context, model.DeploymentDevicesQuery) int); ok {
+		r1 = rf(ctx, query)
+	} else {
+		r1 = ret.Get(1).(int)
+	}
+
+	var r2 error
+	if rf, ok := ret.Get(2).(func(context.Context, model.DeploymentDevicesQuery) error); ok {
+		r2 = rf(ctx, query)
+	} else {
+		r2 = ret.Error(2)
+	}
+
+	return r0, r1, r2
+}
+
+// GetInstalledArtifacts provides a mock function with given fields: ctx, query
+func (_m *DataStore) GetInstalledArtifacts(ctx context.Context, query model.InstalledBaseQuery) ([]model.InstalledArtifact, int, error) {
+	ret := _m.Called(ctx, query)
+
+	var r0 []model.InstalledArtifact
+	if rf, ok := ret.Get(0).(func(context.Context, model.InstalledBaseQuery) []model.InstalledArtifact); ok {
+		r0 = rf(ctx, query)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]model.InstalledArtifact)
+		}
+	}
+
+	var r1 int
+	if rf, ok := ret.Get(1).(func(context.Context, model.InstalledBaseQuery) int); ok {
+		r1 = rf(ctx, query)
+	} else {
+		r1 = ret.Get(1).(int)
+	}
+
+	var r2 error
+	if rf, ok := ret.Get(2).(func(context.Context, model.InstalledBaseQuery) error); ok {
+		r2 = rf(ctx, query)
+	} else {
+		r2 = ret.Error(2)
+	}
+
+	return r0, r1, r2
+}
+
 // GetLimit provides a mock function with given fields: ctx, name
 func (_m *DataStore) GetLimit(ctx context.Context, name string) (*model.Limit, error) {
 	ret := _m.Called(ctx, name)
@@ -510,6 +1009,29 @@ func (_m *DataStore) GetReleases(ctx context.Context, filt *model.ReleaseFilter)
 	return r0, r1
 }
 
+// GetSettings provides a mock function with given fields: ctx
+func (_m *DataStore) GetSettings(ctx context.Context) (*model.Settings, error) {
+	ret := _m.Called(ctx)
+
+	var r0 *model.Settings
+	if rf, ok := ret.Get(0).(func(context.Context) *model.Settings); ok {
+		r0 = rf(ctx)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.Settings)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // HasDeploymentForDevice provides a mock function with given fields: ctx, deploymentID, deviceID
 func (_m *DataStore) HasDeploymentForDevice(ctx context.Context, deploymentID string, deviceID string) (bool, error) {
 	ret := _m.Called(ctx, deploymentID, deviceID)
@@ -600,6 +1122,34 @@ func (_m *DataStore) ImagesByName(ctx context.Context, artifactName string) ([]*
 	return r0, r1
 }
 
+// IncrementDeploymentGeneration provides a mock function with given fields: ctx
+func (_m *DataStore) IncrementDeploymentGeneration(ctx context.Context) error {
+	ret := _m.Called(ctx)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context) error); ok {
+		r0 = rf(ctx)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// IncrementPendingStats provides a mock function with given fields: ctx, id, count
+func (_m *DataStore) IncrementPendingStats(ctx context.Context, id string, count int) error {
+	ret := _m.Called(ctx, id, count)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, int) error); ok {
+		r0 = rf(ctx, id, count)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // InsertDeployment provides a mock function with given fields: ctx, deployment
 func (_m *DataStore) InsertDeployment(ctx context.Context, deployment *model.Deployment) error {
 	ret := _m.Called(ctx, deployment)
@@ -614,6 +1164,20 @@ func (_m *DataStore) InsertDeployment(ctx context.Context, deployment *model.Dep
 	return r0
 }
 
+// InsertDeviceNotification provides a mock function with given fields: ctx, notification
+func (_m *DataStore) InsertDeviceNotification(ctx context.Context, notification model.DeviceNotification) error {
+	ret := _m.Called(ctx, notification)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, model.DeviceNotification) error); ok {
+		r0 = rf(ctx, notification)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // InsertImage provides a mock function with given fields: ctx, image
 func (_m *DataStore) InsertImage(ctx context.Context, image *model.SoftwareImage) error {
 	ret := _m.Called(ctx, image)
@@ -663,6 +1227,43 @@ func (_m *DataStore) IsArtifactUnique(ctx context.Context, artifactName string,
 	return r0, r1
 }
 
+// IterateDeviceDeploymentsForDeployment provides a mock function with given fields: ctx, deploymentID, fn
+func (_m *DataStore) IterateDeviceDeploymentsForDeployment(ctx context.Context, deploymentID string, fn func(*model.DeviceDeployment) error) error {
+	ret := _m.Called(ctx, deploymentID, fn)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, func(*model.DeviceDeployment) error) error); ok {
+		r0 = rf(ctx, deploymentID, fn)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// ListTenants provides a mock function with given fields: ctx
+func (_m *DataStore) ListTenants(ctx context.Context) ([]string, error) {
+	ret := _m.Called(ctx)
+
+	var r0 []string
+	if rf, ok := ret.Get(0).(func(context.Context) []string); ok {
+		r0 = rf(ctx)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]string)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // ProvisionTenant provides a mock function with given fields: ctx, tenantId
 func (_m *DataStore) ProvisionTenant(ctx context.Context, tenantId string) error {
 	ret := _m.Called(ctx, tenantId)
@@ -691,6 +1292,71 @@ func (_m *DataStore) SaveDeviceDeploymentLog(ctx context.Context, log model.Depl
 	return r0
 }
 
+// SaveSettings provides a mock function with given fields: ctx, settings
+func (_m *DataStore) SaveSettings(ctx context.Context, settings *model.Settings) error {
+	ret := _m.Called(ctx, settings)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, *model.Settings) error); ok {
+		r0 = rf(ctx, settings)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// SearchDeviceDeploymentLogs provides a mock function with given fields: ctx, query
+func (_m *DataStore) SearchDeviceDeploymentLogs(ctx context.Context, query model.DeploymentLogQuery) ([]model.DeploymentLogMatch, error) {
+	ret := _m.Called(ctx, query)
+
+	var r0 []model.DeploymentLogMatch
+	if rf, ok := ret.Get(0).(func(context.Context, model.DeploymentLogQuery) []model.DeploymentLogMatch); ok {
+		r0 = rf(ctx, query)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]model.DeploymentLogMatch)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, model.DeploymentLogQuery) error); ok {
+		r1 = rf(ctx, query)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// SetDeploymentAborted provides a mock function with given fields: ctx, id
+func (_m *DataStore) SetDeploymentAborted(ctx context.Context, id string) error {
+	ret := _m.Called(ctx, id)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) error); ok {
+		r0 = rf(ctx, id)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// SetRequireApproval provides a mock function with given fields: ctx, require
+func (_m *DataStore) SetRequireApproval(ctx context.Context, require bool) error {
+	ret := _m.Called(ctx, require)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, bool) error); ok {
+		r0 = rf(ctx, require)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // Update provides a mock function with given fields: ctx, image
 func (_m *DataStore) Update(ctx context.Context, image *model.SoftwareImage) (bool, error) {
 	ret := _m.Called(ctx, image)
@@ -712,6 +1378,48 @@ func (_m *DataStore) Update(ctx context.Context, image *model.SoftwareImage) (bo
 	return r0, r1
 }
 
+// UpdateDeploymentApproval provides a mock function with given fields: ctx, id, approval
+func (_m *DataStore) UpdateDeploymentApproval(ctx context.Context, id string, approval model.DeploymentApproval) error {
+	ret := _m.Called(ctx, id, approval)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, model.DeploymentApproval) error); ok {
+		r0 = rf(ctx, id, approval)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// UpdateDeploymentMetadata provides a mock function with given fields: ctx, id, meta
+func (_m *DataStore) UpdateDeploymentMetadata(ctx context.Context, id string, meta model.DeploymentMetadataConstructor) error {
+	ret := _m.Called(ctx, id, meta)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, model.DeploymentMetadataConstructor) error); ok {
+		r0 = rf(ctx, id, meta)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// UpdateDeviceDeploymentFailureCategory provides a mock function with given fields: ctx, deviceID, deploymentID, category
+func (_m *DataStore) UpdateDeviceDeploymentFailureCategory(ctx context.Context, deviceID string, deploymentID string, category string) error {
+	ret := _m.Called(ctx, deviceID, deploymentID, category)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, string, string) error); ok {
+		r0 = rf(ctx, deviceID, deploymentID, category)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // UpdateDeviceDeploymentLogAvailability provides a mock function with given fields: ctx, deviceID, deploymentID, log
 func (_m *DataStore) UpdateDeviceDeploymentLogAvailability(ctx context.Context, deviceID string, deploymentID string, log bool) error {
 	ret := _m.Called(ctx, deviceID, deploymentID, log)
@@ -726,6 +1434,20 @@ func (_m *DataStore) UpdateDeviceDeploymentLogAvailability(ctx context.Context,
 	return r0
 }
 
+// UpdateDeviceDeploymentProgress provides a mock function with given fields: ctx, deviceID, deploymentID, progress
+func (_m *DataStore) UpdateDeviceDeploymentProgress(ctx context.Context, deviceID string, deploymentID string, progress model.DownloadProgress) error {
+	ret := _m.Called(ctx, deviceID, deploymentID, progress)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, string, model.DownloadProgress) error); ok {
+		r0 = rf(ctx, deviceID, deploymentID, progress)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // UpdateDeviceDeploymentStatus provides a mock function with given fields: ctx, deviceID, deploymentID, status
 func (_m *DataStore) UpdateDeviceDeploymentStatus(ctx context.Context, deviceID string, deploymentID string, status model.DeviceDeploymentStatus) (string, error) {
 	ret := _m.Called(ctx, deviceID, deploymentID, status)
@@ -747,6 +1469,27 @@ func (_m *DataStore) UpdateDeviceDeploymentStatus(ctx context.Context, deviceID
 	return r0, r1
 }
 
+// UpdateDeviceDeploymentStatusFrom provides a mock function with given fields: ctx, deviceID, deploymentID, from, status
+func (_m *DataStore) UpdateDeviceDeploymentStatusFrom(ctx context.Context, deviceID string, deploymentID string, from string, status model.DeviceDeploymentStatus) (bool, error) {
+	ret := _m.Called(ctx, deviceID, deploymentID, from, status)
+
+	var r0 bool
+	if rf, ok := ret.Get(0).(func(context.Context, string, string, string, model.DeviceDeploymentStatus) bool); ok {
+		r0 = rf(ctx, deviceID, deploymentID, from, status)
+	} else {
+		r0 = ret.Get(0).(bool)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string, string, string, model.DeviceDeploymentStatus) error); ok {
+		r1 = rf(ctx, deviceID, deploymentID, from, status)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // UpdateStats provides a mock function with given fields: ctx, id, state_from, state_to
 func (_m *DataStore) UpdateStats(ctx context.Context, id string, state_from string, state_to string) error {
 	ret := _m.Called(ctx, id, state_from, state_to)
@@ -774,3 +1517,52 @@ func (_m *DataStore) UpdateStatsAndFinishDeployment(ctx context.Context, id stri
 
 	return r0
 }
+
+// UpdateStatsWithinLimit provides a mock function with given fields: ctx, id, state_from, state_to, limit
+func (_m *DataStore) UpdateStatsWithinLimit(ctx context.Context, id string, state_from string, state_to string, limit int) (bool, error) {
+	ret := _m.Called(ctx, id, state_from, state_to, limit)
+
+	var r0 bool
+	if rf, ok := ret.Get(0).(func(context.Context, string, string, string, int) bool); ok {
+		r0 = rf(ctx, id, state_from, state_to, limit)
+	} else {
+		r0 = ret.Get(0).(bool)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string, string, string, int) error); ok {
+		r1 = rf(ctx, id, state_from, state_to, limit)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// UpsertInstalledArtifact provides a mock function with given fields: ctx, installed
+func (_m *DataStore) UpsertInstalledArtifact(ctx context.Context, installed *model.InstalledArtifact) error {
+	ret := _m.Called(ctx, installed)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, *model.InstalledArtifact) error); ok {
+		r0 = rf(ctx, installed)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// WatchDeviceNotifications provides a mock function with given fields: ctx, fn
+func (_m *DataStore) WatchDeviceNotifications(ctx context.Context, fn func(model.DeviceNotification)) error {
+	ret := _m.Called(ctx, fn)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, func(model.DeviceNotification)) error); ok {
+		r0 = rf(ctx, fn)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
@@ -17,12 +17,14 @@ import (
 	"context"
 	"crypto/tls"
 	"net"
+	"regexp"
 	"time"
 
 	"github.com/asaskevich/govalidator"
 	"github.com/globalsign/mgo"
 	"github.com/globalsign/mgo/bson"
 	"github.com/mendersoftware/go-lib-micro/config"
+	"github.com/mendersoftware/go-lib-micro/mongo/migrate"
 	mstore "github.com/mendersoftware/go-lib-micro/store"
 	"github.com/pkg/errors"
 
@@ -37,6 +39,12 @@ const (
 	CollectionDeployments          = "deployments"
 	CollectionDeviceDeploymentLogs = "devices.logs"
 	CollectionDevices              = "devices"
+	CollectionSettings             = "settings"
+	CollectionGenerations          = "generations"
+	CollectionInstalledBase        = "installed_base"
+
+	// capped collection in the main database, shared by all tenants
+	CollectionDeviceNotifications = "devices.notifications"
 )
 
 // Indexes
@@ -53,6 +61,17 @@ const (
 	IndexDeploymentDeviceStatusPendingStr    = "deploymentsDeviceStatusPending"
 	IndexDeploymentDeviceStatusInstallingStr = "deploymentsDeviceStatusInstalling"
 	IndexDeploymentDeviceStatusFinishedStr   = "deploymentsFinished"
+	IndexDeploymentDeviceCreatedStr          = "devicesDeploymentIdCreated"
+	IndexDeploymentDeviceFinishedStr         = "devicesDeploymentIdFinished"
+	IndexDeploymentNameStr                   = "deploymentName"
+	IndexDeploymentFinishedStatusStr         = "deploymentFinishedStatus"
+	IndexDeploymentLabelsStr                 = "deploymentLabels"
+	IndexDeviceDeploymentLogsMessagesStr     = "deviceDeploymentLogsMessages"
+	IndexDeviceDeploymentLogsDeploymentStr   = "deviceDeploymentLogsDeployment"
+	IndexDeviceDeploymentLogsUpdatedStr      = "deviceDeploymentLogsUpdated"
+	IndexInstalledBaseArtifactStr            = "installedBaseArtifact"
+	IndexDeploymentDeviceStatusPriorityStr   = "deviceIdWithStatusByPriority"
+	IndexDeploymentDependsOnStr              = "deploymentDependsOn"
 )
 
 var (
@@ -91,6 +110,25 @@ var (
 	DeploymentDeviceStatusPendingIndex    = []string{"stats.pending"}    //IndexDeploymentDeviceStatusPendingStr
 	DeploymentDeviceStatusInstallingIndex = []string{"stats.installing"} //IndexDeploymentDeviceStatusInstallingStr
 	DeploymentDeviceStatusFinishedIndex   = []string{"finished"}         //IndexDeploymentDeviceStatusFinishedStr
+
+	DeploymentDeviceCreatedIndex  = []string{"deploymentid", "created", "deviceid"}  //IndexDeploymentDeviceCreatedStr
+	DeploymentDeviceFinishedIndex = []string{"deploymentid", "finished", "deviceid"} //IndexDeploymentDeviceFinishedStr
+
+	DeploymentNameIndex = []string{"deploymentconstructor.name", "_id"} //IndexDeploymentNameStr
+
+	DeploymentFinishedStatusIndex = []string{"finishedstatus", "-created"} //IndexDeploymentFinishedStatusStr
+
+	DeploymentLabelsIndex = []string{"deploymentconstructor.labels.key", "deploymentconstructor.labels.value"} //IndexDeploymentLabelsStr
+
+	DeviceDeploymentLogsMessagesIndex   = []string{"$text:messages.message"}   //IndexDeviceDeploymentLogsMessagesStr
+	DeviceDeploymentLogsDeploymentIndex = []string{"deploymentid", "deviceid"} //IndexDeviceDeploymentLogsDeploymentStr
+	DeviceDeploymentLogsUpdatedIndex    = []string{"updated"}                  //IndexDeviceDeploymentLogsUpdatedStr
+
+	InstalledBaseArtifactIndex = []string{"artifact_name", "device_type", "_id"} //IndexInstalledBaseArtifactStr
+
+	DeploymentDeviceStatusPriorityIndex = []string{"deviceid", "status", "-priority", "deploymentcreated"} //IndexDeploymentDeviceStatusPriorityStr
+
+	DeploymentDependsOnIndex = []string{"deploymentconstructor.dependson", "created"} //IndexDeploymentDependsOnStr
 )
 
 // Errors
@@ -121,23 +159,66 @@ const (
 	StorageKeySoftwareImageId          = "_id"
 
 	StorageKeyDeviceDeploymentLogMessages = "messages"
-
-	StorageKeyDeviceDeploymentAssignedImage   = "image"
-	StorageKeyDeviceDeploymentAssignedImageId = StorageKeyDeviceDeploymentAssignedImage + "." + StorageKeySoftwareImageId
-	StorageKeyDeviceDeploymentDeviceId        = "deviceid"
-	StorageKeyDeviceDeploymentStatus          = "status"
-	StorageKeyDeviceDeploymentSubState        = "substate"
-	StorageKeyDeviceDeploymentDeploymentID    = "deploymentid"
-	StorageKeyDeviceDeploymentFinished        = "finished"
-	StorageKeyDeviceDeploymentIsLogAvailable  = "log"
-	StorageKeyDeviceDeploymentArtifact        = "image"
-
+	StorageKeyDeviceDeploymentLogUpdated  = "updated"
+	StorageKeyLogMessageTimestamp         = "timestamp"
+	StorageKeyLogMessageLevel             = "level"
+	StorageKeyLogMessageMessage           = "message"
+
+	StorageKeyDeviceDeploymentAssignedImage     = "image"
+	StorageKeyDeviceDeploymentAssignedImageId   = StorageKeyDeviceDeploymentAssignedImage + "." + StorageKeySoftwareImageId
+	StorageKeyDeviceDeploymentDeviceId          = "deviceid"
+	StorageKeyDeviceDeploymentDeviceType        = "devicetype"
+	StorageKeyDeviceDeploymentStatus            = "status"
+	StorageKeyDeviceDeploymentSubState          = "substate"
+	StorageKeyDeviceDeploymentDeploymentID      = "deploymentid"
+	StorageKeyDeviceDeploymentFinished          = "finished"
+	StorageKeyDeviceDeploymentIsLogAvailable    = "log"
+	StorageKeyDeviceDeploymentArtifact          = "image"
+	StorageKeyDeviceDeploymentPriority          = "priority"
+	StorageKeyDeviceDeploymentCreated           = "created"
+	StorageKeyDeviceDeploymentDeploymentCreated = "deploymentcreated"
+	StorageKeyDeviceDeploymentStatusChanged     = "statuschanged"
+	StorageKeyDeviceDeploymentHistory           = "history"
+	StorageKeyDeviceDeploymentProgress          = "progress"
+	StorageKeyDeviceDeploymentFailureCategory   = "failurecategory"
+	StorageKeyDeviceDeploymentContinued         = "continued"
+	StorageKeyDeviceDeploymentId                = "_id"
+
+	StorageKeyDeploymentId           = "_id"
 	StorageKeyDeploymentName         = "deploymentconstructor.name"
 	StorageKeyDeploymentArtifactName = "deploymentconstructor.artifactname"
 	StorageKeyDeploymentStats        = "stats"
 	StorageKeyDeploymentStatsCreated = "created"
 	StorageKeyDeploymentFinished     = "finished"
 	StorageKeyDeploymentArtifacts    = "artifacts"
+	StorageKeyDeploymentDependsOn    = "deploymentconstructor.dependson"
+	StorageKeyDeploymentTimeouts     = "deploymentconstructor.timeouts"
+	StorageKeyDeploymentDeviceCount  = "devicecount"
+
+	StorageKeyDeploymentFinishedStatus = "finishedstatus"
+	StorageKeyDeploymentDescription    = "deploymentconstructor.description"
+	StorageKeyDeploymentLabels         = "deploymentconstructor.labels"
+	StorageKeyDeploymentApproval       = "approval"
+	StorageKeyDeploymentApprovalStatus = "approval.status"
+	StorageKeyDeploymentDevicesRemoved = "devicesremoved"
+	StorageKeyDeploymentContinued      = "continued"
+	StorageKeyDeploymentAborted        = "aborted"
+
+	// ID of the single settings document
+	settingsID                        = "settings"
+	StorageKeySettingsRequireApproval = "requireapproval"
+
+	// ID of the generation counter of deployments, see GetDeploymentGeneration
+	deploymentGenerationID = "deployments"
+	StorageKeyGeneration   = "generation"
+
+	StorageKeyInstalledArtifactDeviceID   = "_id"
+	StorageKeyInstalledArtifactName       = "artifact_name"
+	StorageKeyInstalledArtifactDeviceType = "device_type"
+	StorageKeyInstalledArtifactUpdated    = "updated"
+
+	// computed when sorting deployments by status
+	storageKeyDeploymentStatusRank = "statusrank"
 )
 
 type DataStoreMongo struct {
@@ -267,7 +348,6 @@ func (db *DataStoreMongo) matchFromFilt(f *model.ReleaseFilter) bson.M {
 }
 
 // limits
-//
 func (db *DataStoreMongo) GetLimit(ctx context.Context, name string) (*model.Limit, error) {
 
 	session := db.session.Copy()
@@ -285,6 +365,267 @@ func (db *DataStoreMongo) GetLimit(ctx context.Context, name string) (*model.Lim
 	return &limit, nil
 }
 
+// settings
+//
+
+// GetSettings returns the settings, defaults if they were never saved
+func (db *DataStoreMongo) GetSettings(ctx context.Context) (*model.Settings, error) {
+	session := db.session.Copy()
+	defer session.Close()
+
+	var settings model.Settings
+	err := session.DB(mstore.DbFromContext(ctx, DatabaseName)).
+		C(CollectionSettings).FindId(settingsID).One(&settings)
+	if err == mgo.ErrNotFound {
+		return &model.Settings{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return &settings, nil
+}
+
+// SaveSettings replaces the settings
+func (db *DataStoreMongo) SaveSettings(ctx context.Context, settings *model.Settings) error {
+	if settings == nil {
+		return ErrStorageInvalidInput
+	}
+
+	session := db.session.Copy()
+	defer session.Close()
+
+	_, err := session.DB(mstore.DbFromContext(ctx, DatabaseName)).
+		C(CollectionSettings).UpsertId(settingsID, settings)
+
+	return err
+}
+
+// SetRequireApproval changes only the approval requirement of the settings
+func (db *DataStoreMongo) SetRequireApproval(ctx context.Context, require bool) error {
+	session := db.session.Copy()
+	defer session.Close()
+
+	_, err := session.DB(mstore.DbFromContext(ctx, DatabaseName)).
+		C(CollectionSettings).UpsertId(settingsID, bson.M{
+		"$set": bson.M{StorageKeySettingsRequireApproval: require},
+	})
+
+	return err
+}
+
+// GetDeploymentGeneration returns the generation of deployments,
+// which changes whenever deployments offered to devices might have changed;
+// 0 if it was never incremented
+func (db *DataStoreMongo) GetDeploymentGeneration(ctx context.Context) (int64, error) {
+	session := db.session.Copy()
+	defer session.Close()
+
+	var doc struct {
+		Generation int64 `bson:"generation"`
+	}
+	err := session.DB(mstore.DbFromContext(ctx, DatabaseName)).
+		C(CollectionGenerations).FindId(deploymentGenerationID).One(&doc)
+	if err == mgo.ErrNotFound {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+
+	return doc.Generation, nil
+}
+
+// IncrementDeploymentGeneration increments the generation of deployments
+func (db *DataStoreMongo) IncrementDeploymentGeneration(ctx context.Context) error {
+	session := db.session.Copy()
+	defer session.Close()
+
+	_, err := session.DB(mstore.DbFromContext(ctx, DatabaseName)).
+		C(CollectionGenerations).UpsertId(deploymentGenerationID, bson.M{
+		"$inc": bson.M{StorageKeyGeneration: 1},
+	})
+
+	return err
+}
+
+// installed base
+//
+
+// UpsertInstalledArtifact stores the artifact installed on the device,
+// replacing the one known before
+func (db *DataStoreMongo) UpsertInstalledArtifact(ctx context.Context,
+	installed *model.InstalledArtifact) error {
+
+	if installed == nil || govalidator.IsNull(installed.DeviceID) {
+		return ErrStorageInvalidID
+	}
+
+	session := db.session.Copy()
+	defer session.Close()
+
+	_, err := session.DB(mstore.DbFromContext(ctx, DatabaseName)).
+		C(CollectionInstalledBase).UpsertId(installed.DeviceID, bson.M{
+		"$set": bson.M{
+			StorageKeyInstalledArtifactName:       installed.ArtifactName,
+			StorageKeyInstalledArtifactDeviceType: installed.DeviceType,
+			StorageKeyInstalledArtifactUpdated:    installed.Updated,
+		},
+	})
+
+	return err
+}
+
+// FindInstalledArtifact returns the artifact known to be installed on the
+// device, nil if there is none
+func (db *DataStoreMongo) FindInstalledArtifact(ctx context.Context,
+	deviceID string) (*model.InstalledArtifact, error) {
+
+	if govalidator.IsNull(deviceID) {
+		return nil, ErrStorageInvalidID
+	}
+
+	session := db.session.Copy()
+	defer session.Close()
+
+	var installed model.InstalledArtifact
+	err := session.DB(mstore.DbFromContext(ctx, DatabaseName)).
+		C(CollectionInstalledBase).FindId(deviceID).One(&installed)
+	if err == mgo.ErrNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return &installed, nil
+}
+
+// DeleteInstalledArtifact forgets the artifact installed on the device
+func (db *DataStoreMongo) DeleteInstalledArtifact(ctx context.Context, deviceID string) error {
+	if govalidator.IsNull(deviceID) {
+		return ErrStorageInvalidID
+	}
+
+	session := db.session.Copy()
+	defer session.Close()
+
+	err := session.DB(mstore.DbFromContext(ctx, DatabaseName)).
+		C(CollectionInstalledBase).RemoveId(deviceID)
+	if err == mgo.ErrNotFound {
+		return nil
+	}
+
+	return err
+}
+
+// AggregateInstalledBase counts devices per release and per device type
+// within the release, only the given release if the name is not empty.
+// Releases run by most devices go first.
+func (db *DataStoreMongo) AggregateInstalledBase(ctx context.Context,
+	artifactName string) ([]model.InstalledBaseRelease, error) {
+
+	session := db.session.Copy()
+	defer session.Close()
+
+	var pipe []bson.M
+
+	if artifactName != "" {
+		pipe = append(pipe, bson.M{
+			"$match": bson.M{
+				StorageKeyInstalledArtifactName: artifactName,
+			},
+		})
+	}
+
+	pipe = append(pipe,
+		bson.M{
+			"$group": bson.M{
+				"_id": bson.M{
+					"name": "$" + StorageKeyInstalledArtifactName,
+					"type": "$" + StorageKeyInstalledArtifactDeviceType,
+				},
+				"count": bson.M{"$sum": 1},
+			},
+		},
+		bson.M{
+			"$sort": bson.D{
+				{Name: "_id.type", Value: 1},
+			},
+		},
+		bson.M{
+			"$group": bson.M{
+				"_id":   "$_id.name",
+				"count": bson.M{"$sum": "$count"},
+				"artifacts": bson.M{
+					"$push": bson.M{
+						"device_type": "$_id.type",
+						"count":       "$count",
+					},
+				},
+			},
+		},
+		bson.M{
+			"$sort": bson.D{
+				{Name: "count", Value: -1},
+				{Name: "_id", Value: 1},
+			},
+		},
+	)
+
+	results := []model.InstalledBaseRelease{}
+
+	err := session.DB(mstore.DbFromContext(ctx, DatabaseName)).
+		C(CollectionInstalledBase).Pipe(&pipe).All(&results)
+	if err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// GetInstalledArtifacts returns a page of devices running the artifact
+// selected by the query, along with the number of all such devices
+func (db *DataStoreMongo) GetInstalledArtifacts(ctx context.Context,
+	query model.InstalledBaseQuery) ([]model.InstalledArtifact, int, error) {
+
+	if err := query.Validate(); err != nil {
+		return nil, 0, ErrStorageInvalidInput
+	}
+
+	session := db.session.Copy()
+	defer session.Close()
+
+	filter := bson.M{
+		StorageKeyInstalledArtifactName: query.ArtifactName,
+	}
+
+	if query.DeviceType != "" {
+		filter[StorageKeyInstalledArtifactDeviceType] = query.DeviceType
+	}
+
+	c := session.DB(mstore.DbFromContext(ctx, DatabaseName)).C(CollectionInstalledBase)
+
+	total, err := c.Find(filter).Count()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	q := c.Find(filter).Sort(StorageKeyInstalledArtifactDeviceID)
+
+	if query.Skip > 0 {
+		q = q.Skip(query.Skip)
+	}
+
+	if query.Limit > 0 {
+		q = q.Limit(query.Limit)
+	}
+
+	installed := []model.InstalledArtifact{}
+	if err := q.All(&installed); err != nil {
+		return nil, 0, err
+	}
+
+	return installed, total, nil
+}
+
 func (db *DataStoreMongo) ProvisionTenant(ctx context.Context, tenantId string) error {
 	session := db.session.Copy()
 	defer session.Close()
@@ -294,6 +635,25 @@ func (db *DataStoreMongo) ProvisionTenant(ctx context.Context, tenantId string)
 	return MigrateSingle(ctx, dbname, DbVersion, session, true)
 }
 
+// ListTenants returns IDs of all tenants with a database;
+// empty if the service is not running in multi-tenant mode
+func (db *DataStoreMongo) ListTenants(ctx context.Context) ([]string, error) {
+	session := db.session.Copy()
+	defer session.Close()
+
+	dbs, err := migrate.GetTenantDbs(session, mstore.IsTenantDb(DbName))
+	if err != nil {
+		return nil, err
+	}
+
+	tenants := make([]string, 0, len(dbs))
+	for _, d := range dbs {
+		tenants = append(tenants, mstore.TenantFromDbName(d, DbName))
+	}
+
+	return tenants, nil
+}
+
 //images
 
 // Ensure required indexes exists; create if not.
@@ -597,6 +957,7 @@ func (db *DataStoreMongo) SaveDeviceDeploymentLog(ctx context.Context,
 	update := bson.M{
 		"$set": bson.M{
 			StorageKeyDeviceDeploymentLogMessages: log.Messages,
+			StorageKeyDeviceDeploymentLogUpdated:  time.Now(),
 		},
 	}
 	if _, err := session.DB(mstore.DbFromContext(ctx, DatabaseName)).
@@ -607,6 +968,81 @@ func (db *DataStoreMongo) SaveDeviceDeploymentLog(ctx context.Context,
 	return nil
 }
 
+// AppendDeviceDeploymentLog adds messages to the deployment log. Messages
+// are identified by their timestamp and text: a message with the same
+// timestamp and text as one already in the log is skipped, whatever its
+// level. Messages are kept sorted by timestamp, the oldest are dropped when
+// the log grows over model.MaxDeploymentLogMessages.
+func (db *DataStoreMongo) AppendDeviceDeploymentLog(ctx context.Context,
+	log model.DeploymentLog) error {
+
+	if err := log.Validate(); err != nil {
+		return err
+	}
+
+	session := db.session.Copy()
+	defer session.Close()
+
+	collLogs := session.DB(mstore.DbFromContext(ctx, DatabaseName)).
+		C(CollectionDeviceDeploymentLogs)
+
+	query := bson.M{
+		StorageKeyDeviceDeploymentDeviceId:     log.DeviceID,
+		StorageKeyDeviceDeploymentDeploymentID: log.DeploymentID,
+	}
+
+	update := bson.M{
+		"$set": bson.M{
+			StorageKeyDeviceDeploymentLogUpdated: time.Now(),
+		},
+	}
+	if _, err := collLogs.Upsert(query, update); err != nil {
+		return err
+	}
+
+	// each message is pushed only if the log has no message with the same
+	// key yet, which is atomic also when the same messages are uploaded
+	// concurrently; the updates are applied in order, so duplicates within
+	// the upload are skipped too
+	bulk := collLogs.Bulk()
+	for _, m := range log.Messages {
+		bulk.Update(bson.M{
+			StorageKeyDeviceDeploymentDeviceId:     log.DeviceID,
+			StorageKeyDeviceDeploymentDeploymentID: log.DeploymentID,
+			StorageKeyDeviceDeploymentLogMessages: bson.M{
+				"$not": bson.M{
+					"$elemMatch": bson.M{
+						StorageKeyLogMessageTimestamp: m.Timestamp,
+						StorageKeyLogMessageMessage:   m.Message,
+					},
+				},
+			},
+		}, bson.M{
+			"$push": bson.M{StorageKeyDeviceDeploymentLogMessages: m},
+		})
+	}
+	if _, err := bulk.Run(); err != nil {
+		return err
+	}
+
+	// sort and trim without adding anything; concurrent appends only
+	// repeat the same work
+	trim := bson.M{
+		"$push": bson.M{
+			StorageKeyDeviceDeploymentLogMessages: bson.M{
+				"$each":  []model.LogMessage{},
+				"$sort":  bson.M{StorageKeyLogMessageTimestamp: 1},
+				"$slice": -model.MaxDeploymentLogMessages,
+			},
+		},
+	}
+	if err := collLogs.Update(query, trim); err != nil && err != mgo.ErrNotFound {
+		return err
+	}
+
+	return nil
+}
+
 func (db *DataStoreMongo) GetDeviceDeploymentLog(ctx context.Context,
 	deviceID, deploymentID string) (*model.DeploymentLog, error) {
 
@@ -630,53 +1066,189 @@ func (db *DataStoreMongo) GetDeviceDeploymentLog(ctx context.Context,
 	return &depl, nil
 }
 
-// device deployments
+// FindExpiredDeviceDeploymentLogs returns up to limit deployment logs
+// last uploaded before the given time, the oldest first
+func (db *DataStoreMongo) FindExpiredDeviceDeploymentLogs(ctx context.Context,
+	before time.Time, limit int) ([]model.DeploymentLog, error) {
 
-// InsertMany stores multiple device deployment objects.
-// TODO: Handle error cleanup, multi insert is not atomic, loop into two-phase commits
-func (db *DataStoreMongo) InsertMany(ctx context.Context,
-	deployments ...*model.DeviceDeployment) error {
+	session := db.session.Copy()
+	defer session.Close()
 
-	if len(deployments) == 0 {
-		return nil
+	query := bson.M{
+		StorageKeyDeviceDeploymentLogUpdated: bson.M{"$lt": before},
 	}
 
-	// Writing to another interface list addresses golang gatcha interface{} == []interface{}
-	var list []interface{}
-	for _, deployment := range deployments {
+	logs := []model.DeploymentLog{}
+	err := session.DB(mstore.DbFromContext(ctx, DatabaseName)).
+		C(CollectionDeviceDeploymentLogs).Find(query).
+		Sort(StorageKeyDeviceDeploymentLogUpdated).
+		Limit(limit).All(&logs)
+	if err != nil {
+		return nil, err
+	}
 
-		if deployment == nil {
-			return ErrStorageInvalidDeviceDeployment
-		}
+	return logs, nil
+}
 
-		if err := deployment.Validate(); err != nil {
-			return errors.Wrap(err, "Validating device deployment")
-		}
+// DeleteDeviceDeploymentLog removes the deployment log of the device,
+// noop if there's no log
+func (db *DataStoreMongo) DeleteDeviceDeploymentLog(ctx context.Context,
+	deviceID, deploymentID string) error {
 
-		list = append(list, deployment)
+	if govalidator.IsNull(deviceID) || govalidator.IsNull(deploymentID) {
+		return ErrStorageInvalidID
 	}
 
 	session := db.session.Copy()
 	defer session.Close()
 
-	if err := session.DB(mstore.DbFromContext(ctx, DatabaseName)).
-		C(CollectionDevices).Insert(list...); err != nil {
+	query := bson.M{
+		StorageKeyDeviceDeploymentDeviceId:     deviceID,
+		StorageKeyDeviceDeploymentDeploymentID: deploymentID,
+	}
+
+	err := session.DB(mstore.DbFromContext(ctx, DatabaseName)).
+		C(CollectionDeviceDeploymentLogs).Remove(query)
+	if err != nil && err != mgo.ErrNotFound {
 		return err
 	}
 
 	return nil
 }
 
-// ExistAssignedImageWithIDAndStatuses checks if image is used by deplyment with specified status.
-func (db *DataStoreMongo) ExistAssignedImageWithIDAndStatuses(ctx context.Context,
-	imageID string, statuses ...string) (bool, error) {
+// SearchDeviceDeploymentLogs finds device deployment logs with messages
+// matching the query, only the matching messages are returned.
+// Text search uses the text index to select the logs.
+func (db *DataStoreMongo) SearchDeviceDeploymentLogs(ctx context.Context,
+	query model.DeploymentLogQuery) ([]model.DeploymentLogMatch, error) {
 
-	// Verify ID formatting
-	if govalidator.IsNull(imageID) {
-		return false, ErrStorageInvalidID
+	if err := query.Validate(); err != nil {
+		return nil, err
 	}
 
-	query := bson.M{StorageKeyDeviceDeploymentAssignedImageId: imageID}
+	match, err := query.Matcher()
+	if err != nil {
+		return nil, err
+	}
+
+	session := db.session.Copy()
+	defer session.Close()
+
+	filter := bson.M{}
+	if query.DeploymentID != "" {
+		filter[StorageKeyDeviceDeploymentDeploymentID] = query.DeploymentID
+	}
+	if query.Text != "" {
+		filter["$text"] = bson.M{"$search": query.Text}
+	}
+
+	// all criteria have to be met by the same message
+	messageFilter := bson.M{}
+	if query.Level != "" {
+		messageFilter[StorageKeyLogMessageLevel] = query.Level
+	}
+	if query.From != nil || query.To != nil {
+		timeFilter := bson.M{}
+		if query.From != nil {
+			timeFilter["$gte"] = *query.From
+		}
+		if query.To != nil {
+			timeFilter["$lte"] = *query.To
+		}
+		messageFilter[StorageKeyLogMessageTimestamp] = timeFilter
+	}
+	if query.Regex != "" {
+		messageFilter[StorageKeyLogMessageMessage] = bson.RegEx{Pattern: query.Regex}
+	}
+	if len(messageFilter) > 0 {
+		filter[StorageKeyDeviceDeploymentLogMessages] = bson.M{"$elemMatch": messageFilter}
+	}
+
+	q := session.DB(mstore.DbFromContext(ctx, DatabaseName)).
+		C(CollectionDeviceDeploymentLogs).Find(filter).
+		Sort(StorageKeyDeviceDeploymentDeploymentID, StorageKeyDeviceDeploymentDeviceId)
+	if query.Skip > 0 {
+		q = q.Skip(query.Skip)
+	}
+	if query.Limit > 0 {
+		q = q.Limit(query.Limit)
+	}
+
+	results := []model.DeploymentLogMatch{}
+
+	var dlog model.DeploymentLog
+	iter := q.Iter()
+	for iter.Next(&dlog) {
+		result := model.DeploymentLogMatch{
+			DeviceID:     dlog.DeviceID,
+			DeploymentID: dlog.DeploymentID,
+		}
+		for _, m := range dlog.Messages {
+			if match(m) {
+				result.Messages = append(result.Messages, m)
+			}
+		}
+		// the text search and the other criteria may be met
+		// by different messages of the log
+		if len(result.Messages) > 0 {
+			results = append(results, result)
+		}
+		dlog = model.DeploymentLog{}
+	}
+	if err := iter.Close(); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// device deployments
+
+// InsertMany stores multiple device deployment objects.
+// TODO: Handle error cleanup, multi insert is not atomic, loop into two-phase commits
+func (db *DataStoreMongo) InsertMany(ctx context.Context,
+	deployments ...*model.DeviceDeployment) error {
+
+	if len(deployments) == 0 {
+		return nil
+	}
+
+	// Writing to another interface list addresses golang gatcha interface{} == []interface{}
+	var list []interface{}
+	for _, deployment := range deployments {
+
+		if deployment == nil {
+			return ErrStorageInvalidDeviceDeployment
+		}
+
+		if err := deployment.Validate(); err != nil {
+			return errors.Wrap(err, "Validating device deployment")
+		}
+
+		list = append(list, deployment)
+	}
+
+	session := db.session.Copy()
+	defer session.Close()
+
+	if err := session.DB(mstore.DbFromContext(ctx, DatabaseName)).
+		C(CollectionDevices).Insert(list...); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// ExistAssignedImageWithIDAndStatuses checks if image is used by deplyment with specified status.
+func (db *DataStoreMongo) ExistAssignedImageWithIDAndStatuses(ctx context.Context,
+	imageID string, statuses ...string) (bool, error) {
+
+	// Verify ID formatting
+	if govalidator.IsNull(imageID) {
+		return false, ErrStorageInvalidID
+	}
+
+	query := bson.M{StorageKeyDeviceDeploymentAssignedImageId: imageID}
 
 	if len(statuses) > 0 {
 		query[StorageKeyDeviceDeploymentStatus] = bson.M{
@@ -701,6 +1273,7 @@ func (db *DataStoreMongo) ExistAssignedImageWithIDAndStatuses(ctx context.Contex
 }
 
 // FindOldestDeploymentForDeviceIDWithStatuses find oldest deployment matching device id and one of specified statuses.
+// Deployments with higher priority take precedence over older ones.
 func (db *DataStoreMongo) FindOldestDeploymentForDeviceIDWithStatuses(ctx context.Context,
 	deviceID string, statuses ...string) (*model.DeviceDeployment, error) {
 
@@ -712,26 +1285,47 @@ func (db *DataStoreMongo) FindOldestDeploymentForDeviceIDWithStatuses(ctx contex
 	session := db.session.Copy()
 	defer session.Close()
 
-	// Device should know only about deployments that are not finished
-	query := bson.M{
-		StorageKeyDeviceDeploymentDeviceId: deviceID,
-		StorageKeyDeviceDeploymentStatus:   bson.M{"$in": statuses},
+	// The device works on a single deployment at a time: the one it has
+	// started goes first regardless of the priority, pending ones follow
+	// by priority and age.
+	var inFlight, pending []string
+	for _, status := range statuses {
+		if status == model.DeviceDeploymentStatusPending {
+			pending = append(pending, status)
+		} else {
+			inFlight = append(inFlight, status)
+		}
 	}
 
-	// Select only the oldest one that have not been finished yet.
-	var deployment *model.DeviceDeployment
-	if err := session.DB(mstore.DbFromContext(ctx, DatabaseName)).
-		C(CollectionDevices).Find(query).Sort("created").One(&deployment); err != nil {
-		if err.Error() == mgo.ErrNotFound.Error() {
-			return nil, nil
+	for _, group := range [][]string{inFlight, pending} {
+		if len(group) == 0 {
+			continue
+		}
+
+		// Device should know only about deployments that are not finished
+		query := bson.M{
+			StorageKeyDeviceDeploymentDeviceId: deviceID,
+			StorageKeyDeviceDeploymentStatus:   bson.M{"$in": group},
+		}
+
+		var deployment *model.DeviceDeployment
+		err := session.DB(mstore.DbFromContext(ctx, DatabaseName)).
+			C(CollectionDevices).Find(query).
+			Sort("-"+StorageKeyDeviceDeploymentPriority, StorageKeyDeviceDeploymentDeploymentCreated).
+			One(&deployment)
+		if err == nil {
+			return deployment, nil
+		}
+		if err.Error() != mgo.ErrNotFound.Error() {
+			return nil, err
 		}
-		return nil, err
 	}
 
-	return deployment, nil
+	return nil, nil
 }
 
-// FindAllDeploymentsForDeviceIDWithStatuses finds all deployments matching device id and one of specified statuses.
+// FindAllDeploymentsForDeviceIDWithStatuses finds all deployments matching device id and one of specified statuses,
+// ordered by priority and age like FindOldestDeploymentForDeviceIDWithStatuses.
 func (db *DataStoreMongo) FindAllDeploymentsForDeviceIDWithStatuses(ctx context.Context,
 	deviceID string, statuses ...string) ([]model.DeviceDeployment, error) {
 
@@ -753,7 +1347,9 @@ func (db *DataStoreMongo) FindAllDeploymentsForDeviceIDWithStatuses(ctx context.
 
 	var deployments []model.DeviceDeployment
 	if err := session.DB(mstore.DbFromContext(ctx, DatabaseName)).
-		C(CollectionDevices).Find(query).All(&deployments); err != nil {
+		C(CollectionDevices).Find(query).
+		Sort("-"+StorageKeyDeviceDeploymentPriority, StorageKeyDeviceDeploymentDeploymentCreated).
+		All(&deployments); err != nil {
 		if err.Error() == mgo.ErrNotFound.Error() {
 			return nil, nil
 		}
@@ -763,6 +1359,89 @@ func (db *DataStoreMongo) FindAllDeploymentsForDeviceIDWithStatuses(ctx context.
 	return deployments, nil
 }
 
+// FindDeviceDeployments returns deployments of a single device,
+// newest first
+func (db *DataStoreMongo) FindDeviceDeployments(ctx context.Context,
+	query model.DeviceDeploymentsQuery) ([]model.DeviceDeployment, error) {
+
+	// Verify ID formatting
+	if govalidator.IsNull(query.DeviceID) {
+		return nil, ErrStorageInvalidID
+	}
+
+	session := db.session.Copy()
+	defer session.Close()
+
+	filter := bson.M{
+		StorageKeyDeviceDeploymentDeviceId: query.DeviceID,
+	}
+
+	if len(query.Statuses) > 0 {
+		filter[StorageKeyDeviceDeploymentStatus] = bson.M{
+			"$in": query.Statuses,
+		}
+	}
+
+	q := session.DB(mstore.DbFromContext(ctx, DatabaseName)).
+		C(CollectionDevices).Find(filter).
+		Sort("-"+StorageKeyDeviceDeploymentCreated, "-"+StorageKeyDeviceDeploymentId)
+
+	if query.Skip > 0 {
+		q = q.Skip(query.Skip)
+	}
+
+	if query.Limit > 0 {
+		q = q.Limit(query.Limit)
+	}
+
+	var deployments []model.DeviceDeployment
+	if err := q.All(&deployments); err != nil {
+		return nil, err
+	}
+
+	return deployments, nil
+}
+
+// FindStaleDeviceDeployments finds device deployments of given deployment
+// which have been in given status since before the given time.
+// Device deployments which never changed status are matched by creation time.
+func (db *DataStoreMongo) FindStaleDeviceDeployments(ctx context.Context,
+	deploymentID string, status string, before time.Time) ([]model.DeviceDeployment, error) {
+
+	if govalidator.IsNull(deploymentID) {
+		return nil, ErrStorageInvalidID
+	}
+
+	if govalidator.IsNull(status) {
+		return nil, ErrStorageInvalidInput
+	}
+
+	session := db.session.Copy()
+	defer session.Close()
+
+	query := bson.M{
+		StorageKeyDeviceDeploymentDeploymentID: deploymentID,
+		StorageKeyDeviceDeploymentStatus:       status,
+		"$or": []bson.M{
+			{
+				StorageKeyDeviceDeploymentStatusChanged: bson.M{"$lt": before},
+			},
+			{
+				StorageKeyDeviceDeploymentStatusChanged: nil,
+				StorageKeyDeviceDeploymentCreated:       bson.M{"$lt": before},
+			},
+		},
+	}
+
+	var deployments []model.DeviceDeployment
+	if err := session.DB(mstore.DbFromContext(ctx, DatabaseName)).
+		C(CollectionDevices).Find(query).All(&deployments); err != nil {
+		return nil, err
+	}
+
+	return deployments, nil
+}
+
 func (db *DataStoreMongo) UpdateDeviceDeploymentStatus(ctx context.Context,
 	deviceID string, deploymentID string, ddStatus model.DeviceDeploymentStatus) (string, error) {
 
@@ -785,9 +1464,76 @@ func (db *DataStoreMongo) UpdateDeviceDeploymentStatus(ctx context.Context,
 		StorageKeyDeviceDeploymentDeploymentID: deploymentID,
 	}
 
-	// update status field
+	var old model.DeviceDeployment
+
+	// update and return the old status in one go
+	change := mgo.Change{
+		Update: deviceDeploymentStatusUpdate(ddStatus),
+	}
+
+	chi, err := session.DB(mstore.DbFromContext(ctx, DatabaseName)).
+		C(CollectionDevices).Find(query).Apply(change, &old)
+
+	if err != nil {
+		if err == mgo.ErrNotFound {
+			return "", ErrStorageNotFound
+		}
+		return "", err
+
+	}
+
+	if chi.Updated == 0 {
+		return "", ErrStorageNotFound
+	}
+
+	return *old.Status, nil
+}
+
+// UpdateDeviceDeploymentStatusFrom updates the status of the device deployment
+// only if its current status is the given one; returns false if it isn't,
+// or the device deployment doesn't exist
+func (db *DataStoreMongo) UpdateDeviceDeploymentStatusFrom(ctx context.Context,
+	deviceID string, deploymentID string, from string,
+	ddStatus model.DeviceDeploymentStatus) (bool, error) {
+
+	if govalidator.IsNull(deviceID) ||
+		govalidator.IsNull(deploymentID) {
+		return false, ErrStorageInvalidID
+	}
+
+	if ok, _ := govalidator.ValidateStruct(ddStatus); !ok || govalidator.IsNull(from) {
+		return false, ErrStorageInvalidInput
+	}
+
+	session := db.session.Copy()
+	defer session.Close()
+
+	query := bson.M{
+		StorageKeyDeviceDeploymentDeviceId:     deviceID,
+		StorageKeyDeviceDeploymentDeploymentID: deploymentID,
+		StorageKeyDeviceDeploymentStatus:       from,
+	}
+
+	err := session.DB(mstore.DbFromContext(ctx, DatabaseName)).
+		C(CollectionDevices).Update(query, deviceDeploymentStatusUpdate(ddStatus))
+	if err == mgo.ErrNotFound {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// deviceDeploymentStatusUpdate sets the status and records the transition
+// in the history of the device deployment
+func deviceDeploymentStatusUpdate(ddStatus model.DeviceDeploymentStatus) bson.M {
+	now := time.Now()
+
+	// update status field and the time of the change
 	set := bson.M{
-		StorageKeyDeviceDeploymentStatus: ddStatus.Status,
+		StorageKeyDeviceDeploymentStatus:        ddStatus.Status,
+		StorageKeyDeviceDeploymentStatusChanged: now,
 	}
 	// and finish time if provided
 	if ddStatus.FinishTime != nil {
@@ -798,199 +1544,754 @@ func (db *DataStoreMongo) UpdateDeviceDeploymentStatus(ctx context.Context,
 		set[StorageKeyDeviceDeploymentSubState] = *ddStatus.SubState
 	}
 
-	update := bson.M{
+	// and record the transition
+	return bson.M{
 		"$set": set,
+		"$push": bson.M{
+			StorageKeyDeviceDeploymentHistory: model.DeviceDeploymentStatusChange{
+				Status:    ddStatus.Status,
+				SubState:  ddStatus.SubState,
+				Timestamp: now,
+			},
+		},
 	}
+}
 
-	var old model.DeviceDeployment
+func (db *DataStoreMongo) UpdateDeviceDeploymentLogAvailability(ctx context.Context,
+	deviceID string, deploymentID string, log bool) error {
 
-	// update and return the old status in one go
-	change := mgo.Change{
-		Update: update,
+	// Verify ID formatting
+	if govalidator.IsNull(deviceID) ||
+		govalidator.IsNull(deploymentID) {
+		return ErrStorageInvalidID
 	}
 
-	chi, err := session.DB(mstore.DbFromContext(ctx, DatabaseName)).
-		C(CollectionDevices).Find(query).Apply(change, &old)
+	session := db.session.Copy()
+	defer session.Close()
 
-	if err != nil {
+	selector := bson.M{
+		StorageKeyDeviceDeploymentDeviceId:     deviceID,
+		StorageKeyDeviceDeploymentDeploymentID: deploymentID,
+	}
+
+	update := bson.M{
+		"$set": bson.M{
+			StorageKeyDeviceDeploymentIsLogAvailable: log,
+		},
+	}
+
+	if err := session.DB(mstore.DbFromContext(ctx, DatabaseName)).
+		C(CollectionDevices).Update(selector, update); err != nil {
 		if err == mgo.ErrNotFound {
-			return "", ErrStorageNotFound
+			return ErrStorageNotFound
 		}
-		return "", err
+		return err
+	}
+
+	return nil
+}
+
+// UpdateDeviceDeploymentFailureCategory sets the failure category
+// of the device deployment
+func (db *DataStoreMongo) UpdateDeviceDeploymentFailureCategory(ctx context.Context,
+	deviceID string, deploymentID string, category string) error {
+
+	// Verify ID formatting
+	if govalidator.IsNull(deviceID) ||
+		govalidator.IsNull(deploymentID) {
+		return ErrStorageInvalidID
+	}
+
+	session := db.session.Copy()
+	defer session.Close()
+
+	selector := bson.M{
+		StorageKeyDeviceDeploymentDeviceId:     deviceID,
+		StorageKeyDeviceDeploymentDeploymentID: deploymentID,
+	}
+
+	update := bson.M{
+		"$set": bson.M{
+			StorageKeyDeviceDeploymentFailureCategory: category,
+		},
+	}
+
+	if err := session.DB(mstore.DbFromContext(ctx, DatabaseName)).
+		C(CollectionDevices).Update(selector, update); err != nil {
+		if err == mgo.ErrNotFound {
+			return ErrStorageNotFound
+		}
+		return err
+	}
+
+	return nil
+}
+
+// UpdateDeviceDeploymentProgress stores the download progress reported by
+// the device; finished device deployments are not updated.
+func (db *DataStoreMongo) UpdateDeviceDeploymentProgress(ctx context.Context,
+	deviceID string, deploymentID string, progress model.DownloadProgress) error {
+
+	// Verify ID formatting
+	if govalidator.IsNull(deviceID) ||
+		govalidator.IsNull(deploymentID) {
+		return ErrStorageInvalidID
+	}
+
+	if err := progress.Validate(); err != nil {
+		return ErrStorageInvalidInput
+	}
+
+	session := db.session.Copy()
+	defer session.Close()
+
+	selector := bson.M{
+		StorageKeyDeviceDeploymentDeviceId:     deviceID,
+		StorageKeyDeviceDeploymentDeploymentID: deploymentID,
+		StorageKeyDeviceDeploymentStatus: bson.M{
+			"$in": model.ActiveDeploymentStatuses(),
+		},
+	}
+
+	update := bson.M{
+		"$set": bson.M{
+			StorageKeyDeviceDeploymentProgress: progress,
+		},
+	}
+
+	if err := session.DB(mstore.DbFromContext(ctx, DatabaseName)).
+		C(CollectionDevices).Update(selector, update); err != nil {
+		if err == mgo.ErrNotFound {
+			return ErrStorageNotFound
+		}
+		return err
+	}
+
+	return nil
+}
+
+// AssignArtifact assignes artifact to the device deployment
+func (db *DataStoreMongo) AssignArtifact(ctx context.Context,
+	deviceID string, deploymentID string, artifact *model.SoftwareImage) error {
+
+	// Verify ID formatting
+	if govalidator.IsNull(deviceID) ||
+		govalidator.IsNull(deploymentID) {
+		return ErrStorageInvalidID
+	}
+
+	session := db.session.Copy()
+	defer session.Close()
+
+	selector := bson.M{
+		StorageKeyDeviceDeploymentDeviceId:     deviceID,
+		StorageKeyDeviceDeploymentDeploymentID: deploymentID,
+	}
+
+	update := bson.M{
+		"$set": bson.M{
+			StorageKeyDeviceDeploymentArtifact: artifact,
+		},
+	}
+
+	if err := session.DB(mstore.DbFromContext(ctx, DatabaseName)).
+		C(CollectionDevices).Update(selector, update); err != nil {
+		if err == mgo.ErrNotFound {
+			return ErrStorageNotFound
+		}
+		return err
+	}
+
+	return nil
+}
+
+func (db *DataStoreMongo) AggregateDeviceDeploymentByStatus(ctx context.Context,
+	id string) (model.Stats, error) {
+
+	if govalidator.IsNull(id) {
+		return nil, ErrStorageInvalidID
+	}
+
+	session := db.session.Copy()
+	defer session.Close()
+
+	match := bson.M{
+		"$match": bson.M{
+			StorageKeyDeviceDeploymentDeploymentID: id,
+		},
+	}
+	group := bson.M{
+		"$group": bson.M{
+			"_id": "$" + StorageKeyDeviceDeploymentStatus,
+			"count": bson.M{
+				"$sum": 1,
+			},
+		},
+	}
+	pipe := []bson.M{
+		match,
+		group,
+	}
+	var results []struct {
+		Name  string `bson:"_id"`
+		Count int
+	}
+	err := session.DB(mstore.DbFromContext(ctx, DatabaseName)).
+		C(CollectionDevices).Pipe(&pipe).All(&results)
+	if err != nil {
+		if err.Error() == mgo.ErrNotFound.Error() {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	raw := model.NewDeviceDeploymentStats()
+	for _, res := range results {
+		raw[res.Name] = res.Count
+	}
+	return raw, nil
+}
+
+// AggregateDeviceDeploymentStateDurations collects the time (in seconds)
+// devices of the deployment spent in the download, install and reboot states
+// they have already left, sorted, per device type and state. A state lasts
+// until the next status change in the history of the device deployment,
+// substate changes don't end it.
+func (db *DataStoreMongo) AggregateDeviceDeploymentStateDurations(ctx context.Context,
+	id string) ([]model.StateDurationGroup, error) {
+
+	if govalidator.IsNull(id) {
+		return nil, ErrStorageInvalidID
+	}
+
+	session := db.session.Copy()
+	defer session.Close()
+
+	states := []string{
+		model.DeviceDeploymentStatusDownloading,
+		model.DeviceDeploymentStatusInstalling,
+		model.DeviceDeploymentStatusRebooting,
+	}
+
+	// walk the history keeping the change which started the current
+	// state, and add up the time spent in each of the states (in ms)
+	in := bson.M{
+		"start": bson.M{
+			"$cond": []interface{}{
+				bson.M{"$eq": []interface{}{"$$value.start.status", "$$this.status"}},
+				"$$value.start",
+				"$$this",
+			},
+		},
+	}
+	durations := []bson.M{}
+	for _, state := range states {
+		in[state] = bson.M{
+			"$cond": []interface{}{
+				bson.M{"$and": []interface{}{
+					bson.M{"$eq": []interface{}{"$$value.start.status", state}},
+					bson.M{"$ne": []interface{}{"$$this.status", state}},
+				}},
+				bson.M{"$add": []interface{}{
+					bson.M{"$ifNull": []interface{}{"$$value." + state, 0}},
+					bson.M{"$subtract": []interface{}{
+						"$$this.timestamp", "$$value.start.timestamp",
+					}},
+				}},
+				"$$value." + state,
+			},
+		}
+		durations = append(durations, bson.M{
+			"status":   state,
+			"duration": "$durations." + state,
+		})
+	}
+
+	pipe := []bson.M{
+		{
+			"$match": bson.M{
+				StorageKeyDeviceDeploymentDeploymentID: id,
+			},
+		},
+		{
+			"$project": bson.M{
+				StorageKeyDeviceDeploymentDeviceType: 1,
+				"durations": bson.M{
+					"$reduce": bson.M{
+						"input":        "$" + StorageKeyDeviceDeploymentHistory,
+						"initialValue": bson.M{"start": nil},
+						"in":           in,
+					},
+				},
+			},
+		},
+		{
+			"$project": bson.M{
+				StorageKeyDeviceDeploymentDeviceType: 1,
+				"states":                             durations,
+			},
+		},
+		{
+			"$unwind": "$states",
+		},
+		{
+			// states the device hasn't left (or entered)
+			"$match": bson.M{
+				"states.duration": bson.M{"$exists": true},
+			},
+		},
+		{
+			"$sort": bson.M{
+				"states.duration": 1,
+			},
+		},
+		{
+			"$group": bson.M{
+				"_id": bson.M{
+					"type":   "$" + StorageKeyDeviceDeploymentDeviceType,
+					"status": "$states.status",
+				},
+				"durations": bson.M{
+					"$push": bson.M{"$divide": []interface{}{"$states.duration", 1000}},
+				},
+			},
+		},
+		{
+			"$project": bson.M{
+				"_id":                                0,
+				StorageKeyDeviceDeploymentDeviceType: "$_id.type",
+				"status":                             "$_id.status",
+				"durations":                          1,
+			},
+		},
+	}
+
+	groups := []model.StateDurationGroup{}
+	err := session.DB(mstore.DbFromContext(ctx, DatabaseName)).
+		C(CollectionDevices).Pipe(&pipe).AllowDiskUse().All(&groups)
+	if err != nil {
+		return nil, err
+	}
+
+	return groups, nil
+}
+
+// AggregateDeviceDeploymentByFailureCategory counts failed devices
+// of the deployment per failure category
+func (db *DataStoreMongo) AggregateDeviceDeploymentByFailureCategory(ctx context.Context,
+	id string) (map[string]int, error) {
+
+	if govalidator.IsNull(id) {
+		return nil, ErrStorageInvalidID
+	}
+
+	session := db.session.Copy()
+	defer session.Close()
+
+	match := bson.M{
+		"$match": bson.M{
+			StorageKeyDeviceDeploymentDeploymentID: id,
+			StorageKeyDeviceDeploymentStatus:       model.DeviceDeploymentStatusFailure,
+		},
+	}
+	group := bson.M{
+		"$group": bson.M{
+			"_id": "$" + StorageKeyDeviceDeploymentFailureCategory,
+			"count": bson.M{
+				"$sum": 1,
+			},
+		},
+	}
+	pipe := []bson.M{
+		match,
+		group,
+	}
+	var results []struct {
+		Name  string `bson:"_id"`
+		Count int
+	}
+	err := session.DB(mstore.DbFromContext(ctx, DatabaseName)).
+		C(CollectionDevices).Pipe(&pipe).All(&results)
+	if err != nil {
+		return nil, err
+	}
+
+	failures := make(map[string]int, len(results))
+	for _, res := range results {
+		// failed before the categories were introduced
+		if res.Name == "" {
+			res.Name = model.FailureCategoryOther
+		}
+		failures[res.Name] += res.Count
+	}
+	return failures, nil
+}
+
+// AggregateDeviceDeploymentProgress sums up the download progress reported
+// by devices of the deployment. The ETA is the longest time left, at the given
+// time, for devices still downloading, estimated from the average download
+// rate since each device started downloading.
+func (db *DataStoreMongo) AggregateDeviceDeploymentProgress(ctx context.Context,
+	id string, now time.Time) (*model.DownloadProgressStats, error) {
+
+	if govalidator.IsNull(id) {
+		return nil, ErrStorageInvalidID
+	}
+
+	session := db.session.Copy()
+	defer session.Close()
+
+	downloaded := "$" + StorageKeyDeviceDeploymentProgress + ".bytes_downloaded"
+	total := "$" + StorageKeyDeviceDeploymentProgress + ".total_bytes"
+	updated := "$" + StorageKeyDeviceDeploymentProgress + ".updated"
+	statusChanged := "$" + StorageKeyDeviceDeploymentStatusChanged
+
+	// milliseconds left: bytes left divided by the rate, less the time
+	// passed since the last report
+	remaining := bson.M{
+		"$subtract": []interface{}{
+			bson.M{
+				"$divide": []interface{}{
+					bson.M{
+						"$multiply": []interface{}{
+							bson.M{"$subtract": []interface{}{total, downloaded}},
+							bson.M{"$subtract": []interface{}{updated, statusChanged}},
+						},
+					},
+					downloaded,
+				},
+			},
+			bson.M{"$subtract": []interface{}{now, updated}},
+		},
+	}
+	estimable := bson.M{
+		"$and": []interface{}{
+			bson.M{"$eq": []interface{}{
+				"$" + StorageKeyDeviceDeploymentStatus,
+				model.DeviceDeploymentStatusDownloading,
+			}},
+			bson.M{"$gt": []interface{}{downloaded, 0}},
+			bson.M{"$gt": []interface{}{total, 0}},
+			bson.M{"$gt": []interface{}{statusChanged, nil}},
+			bson.M{"$gt": []interface{}{updated, statusChanged}},
+		},
+	}
+
+	match := bson.M{
+		"$match": bson.M{
+			StorageKeyDeviceDeploymentDeploymentID: id,
+			StorageKeyDeviceDeploymentProgress:     bson.M{"$exists": true},
+		},
+	}
+	project := bson.M{
+		"$project": bson.M{
+			"downloaded": downloaded,
+			"total":      total,
+			"remaining": bson.M{
+				"$cond": []interface{}{
+					estimable,
+					bson.M{"$max": []interface{}{0, remaining}},
+					nil,
+				},
+			},
+		},
+	}
+	group := bson.M{
+		"$group": bson.M{
+			"_id":        nil,
+			"downloaded": bson.M{"$sum": "$downloaded"},
+			"total":      bson.M{"$sum": "$total"},
+			"remaining":  bson.M{"$max": "$remaining"},
+		},
+	}
+	pipe := []bson.M{
+		match,
+		project,
+		group,
+	}
+
+	var results []struct {
+		Downloaded int64    `bson:"downloaded"`
+		Total      int64    `bson:"total"`
+		Remaining  *float64 `bson:"remaining"`
+	}
+	err := session.DB(mstore.DbFromContext(ctx, DatabaseName)).
+		C(CollectionDevices).Pipe(&pipe).All(&results)
+	if err != nil {
+		return nil, err
+	}
+
+	progress := &model.DownloadProgressStats{}
+	if len(results) == 0 {
+		return progress, nil
+	}
+
+	progress.BytesTransferred = results[0].Downloaded
+	progress.BytesTotal = results[0].Total
+	if results[0].Remaining != nil {
+		eta := int64(*results[0].Remaining / 1000)
+		progress.ETA = &eta
+	}
+
+	return progress, nil
+}
+
+// GetDeviceStatusesForDeployment retrieve device deployment statuses for a given deployment.
+func (db *DataStoreMongo) GetDeviceStatusesForDeployment(ctx context.Context,
+	deploymentID string) ([]model.DeviceDeployment, error) {
+
+	session := db.session.Copy()
+	defer session.Close()
+
+	query := bson.M{
+		StorageKeyDeviceDeploymentDeploymentID: deploymentID,
+	}
+
+	var statuses []model.DeviceDeployment
+
+	err := session.DB(mstore.DbFromContext(ctx, DatabaseName)).
+		C(CollectionDevices).Find(query).All(&statuses)
+	if err != nil {
+		return nil, err
+	}
+
+	return statuses, nil
+}
+
+// GetDevicesListForDeployment returns a page of devices of a deployment
+// matching the query, along with the number of all matching devices
+func (db *DataStoreMongo) GetDevicesListForDeployment(ctx context.Context,
+	query model.DeploymentDevicesQuery) ([]model.DeviceDeployment, int, error) {
+
+	if govalidator.IsNull(query.DeploymentID) {
+		return nil, 0, ErrStorageInvalidID
+	}
+
+	session := db.session.Copy()
+	defer session.Close()
+
+	filter := bson.M{
+		StorageKeyDeviceDeploymentDeploymentID: query.DeploymentID,
+	}
+
+	if query.Status != "" {
+		filter[StorageKeyDeviceDeploymentStatus] = query.Status
+	}
+
+	if query.SubState != "" {
+		filter[StorageKeyDeviceDeploymentSubState] = query.SubState
+	}
+
+	if query.FailureCategory != "" {
+		filter[StorageKeyDeviceDeploymentFailureCategory] = query.FailureCategory
+	}
+
+	if query.DeviceID != "" {
+		filter[StorageKeyDeviceDeploymentDeviceId] = bson.M{
+			"$regex": regexp.QuoteMeta(query.DeviceID),
+		}
+	}
+
+	var sortBy string
+	switch query.SortBy {
+	case "", model.DeploymentDevicesSortCreated:
+		sortBy = StorageKeyDeviceDeploymentCreated
+	case model.DeploymentDevicesSortFinished:
+		sortBy = StorageKeyDeviceDeploymentFinished
+	default:
+		return nil, 0, ErrStorageInvalidInput
+	}
+
+	// device ID breaks ties, devices of a deployment share creation time
+	sortKeys := []string{sortBy, StorageKeyDeviceDeploymentDeviceId}
+	if query.SortDescending {
+		for i := range sortKeys {
+			sortKeys[i] = "-" + sortKeys[i]
+		}
+	}
+
+	c := session.DB(mstore.DbFromContext(ctx, DatabaseName)).C(CollectionDevices)
+
+	total, err := c.Find(filter).Count()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	q := c.Find(filter).Sort(sortKeys...)
+
+	if query.Skip > 0 {
+		q = q.Skip(query.Skip)
+	}
 
+	if query.Limit > 0 {
+		q = q.Limit(query.Limit)
 	}
 
-	if chi.Updated == 0 {
-		return "", ErrStorageNotFound
+	var statuses []model.DeviceDeployment
+	if err := q.All(&statuses); err != nil {
+		return nil, 0, err
 	}
 
-	return *old.Status, nil
+	return statuses, total, nil
 }
 
-func (db *DataStoreMongo) UpdateDeviceDeploymentLogAvailability(ctx context.Context,
-	deviceID string, deploymentID string, log bool) error {
+// IterateDeviceDeploymentsForDeployment calls fn for each device deployment
+// of the deployment, in order of creation, without loading all of them
+// into memory. Iteration stops at the first error returned by fn.
+func (db *DataStoreMongo) IterateDeviceDeploymentsForDeployment(ctx context.Context,
+	deploymentID string, fn func(dd *model.DeviceDeployment) error) error {
 
-	// Verify ID formatting
-	if govalidator.IsNull(deviceID) ||
-		govalidator.IsNull(deploymentID) {
+	if govalidator.IsNull(deploymentID) {
 		return ErrStorageInvalidID
 	}
 
 	session := db.session.Copy()
 	defer session.Close()
 
-	selector := bson.M{
-		StorageKeyDeviceDeploymentDeviceId:     deviceID,
+	filter := bson.M{
 		StorageKeyDeviceDeploymentDeploymentID: deploymentID,
 	}
 
-	update := bson.M{
-		"$set": bson.M{
-			StorageKeyDeviceDeploymentIsLogAvailable: log,
-		},
-	}
+	iter := session.DB(mstore.DbFromContext(ctx, DatabaseName)).
+		C(CollectionDevices).Find(filter).
+		Sort(StorageKeyDeviceDeploymentCreated, StorageKeyDeviceDeploymentDeviceId).
+		Iter()
 
-	if err := session.DB(mstore.DbFromContext(ctx, DatabaseName)).
-		C(CollectionDevices).Update(selector, update); err != nil {
-		if err == mgo.ErrNotFound {
-			return ErrStorageNotFound
+	var dd model.DeviceDeployment
+	for iter.Next(&dd) {
+		if err := fn(&dd); err != nil {
+			iter.Close()
+			return err
 		}
-		return err
+		dd = model.DeviceDeployment{}
 	}
 
-	return nil
+	return iter.Close()
 }
 
-// AssignArtifact assignes artifact to the device deployment
-func (db *DataStoreMongo) AssignArtifact(ctx context.Context,
-	deviceID string, deploymentID string, artifact *model.SoftwareImage) error {
+// DeleteDeviceDeploymentsForDeployment removes all device deployments
+// of the deployment and marks the deployment accordingly
+func (db *DataStoreMongo) DeleteDeviceDeploymentsForDeployment(ctx context.Context,
+	deploymentID string) error {
 
-	// Verify ID formatting
-	if govalidator.IsNull(deviceID) ||
-		govalidator.IsNull(deploymentID) {
+	if govalidator.IsNull(deploymentID) {
 		return ErrStorageInvalidID
 	}
 
 	session := db.session.Copy()
 	defer session.Close()
 
-	selector := bson.M{
-		StorageKeyDeviceDeploymentDeviceId:     deviceID,
+	database := session.DB(mstore.DbFromContext(ctx, DatabaseName))
+
+	_, err := database.C(CollectionDevices).RemoveAll(bson.M{
 		StorageKeyDeviceDeploymentDeploymentID: deploymentID,
+	})
+	if err != nil {
+		return err
 	}
 
-	update := bson.M{
+	err = database.C(CollectionDeployments).UpdateId(deploymentID, bson.M{
 		"$set": bson.M{
-			StorageKeyDeviceDeploymentArtifact: artifact,
+			StorageKeyDeploymentDevicesRemoved: true,
 		},
+	})
+	if err == mgo.ErrNotFound {
+		return ErrStorageNotFound
 	}
 
-	if err := session.DB(mstore.DbFromContext(ctx, DatabaseName)).
-		C(CollectionDevices).Update(selector, update); err != nil {
-		if err == mgo.ErrNotFound {
-			return ErrStorageNotFound
-		}
-		return err
-	}
-
-	return nil
+	return err
 }
 
-func (db *DataStoreMongo) AggregateDeviceDeploymentByStatus(ctx context.Context,
-	id string) (model.Stats, error) {
-
-	if govalidator.IsNull(id) {
-		return nil, ErrStorageInvalidID
-	}
+// Returns true if deployment of ID `deploymentID` is assigned to device with ID
+// `deviceID`, false otherwise. In case of errors returns false and an error
+// that occurred
+func (db *DataStoreMongo) HasDeploymentForDevice(ctx context.Context,
+	deploymentID string, deviceID string) (bool, error) {
 
 	session := db.session.Copy()
 	defer session.Close()
 
-	match := bson.M{
-		"$match": bson.M{
-			StorageKeyDeviceDeploymentDeploymentID: id,
-		},
-	}
-	group := bson.M{
-		"$group": bson.M{
-			"_id": "$" + StorageKeyDeviceDeploymentStatus,
-			"count": bson.M{
-				"$sum": 1,
-			},
-		},
-	}
-	pipe := []bson.M{
-		match,
-		group,
-	}
-	var results []struct {
-		Name  string `bson:"_id"`
-		Count int
+	query := bson.M{
+		StorageKeyDeviceDeploymentDeploymentID: deploymentID,
+		StorageKeyDeviceDeploymentDeviceId:     deviceID,
 	}
+
+	var dep model.DeviceDeployment
 	err := session.DB(mstore.DbFromContext(ctx, DatabaseName)).
-		C(CollectionDevices).Pipe(&pipe).All(&results)
+		C(CollectionDevices).Find(query).One(&dep)
 	if err != nil {
-		if err.Error() == mgo.ErrNotFound.Error() {
-			return nil, nil
+		if err == mgo.ErrNotFound {
+			return false, nil
+		} else {
+			return false, err
 		}
-		return nil, err
 	}
 
-	raw := model.NewDeviceDeploymentStats()
-	for _, res := range results {
-		raw[res.Name] = res.Count
-	}
-	return raw, nil
+	return true, nil
 }
 
-//GetDeviceStatusesForDeployment retrieve device deployment statuses for a given deployment.
-func (db *DataStoreMongo) GetDeviceStatusesForDeployment(ctx context.Context,
-	deploymentID string) ([]model.DeviceDeployment, error) {
+// FindDeviceDeployment returns the device deployment, nil if not found
+func (db *DataStoreMongo) FindDeviceDeployment(ctx context.Context,
+	deploymentID string, deviceID string) (*model.DeviceDeployment, error) {
+
+	if govalidator.IsNull(deploymentID) ||
+		govalidator.IsNull(deviceID) {
+		return nil, ErrStorageInvalidID
+	}
 
 	session := db.session.Copy()
 	defer session.Close()
 
 	query := bson.M{
 		StorageKeyDeviceDeploymentDeploymentID: deploymentID,
+		StorageKeyDeviceDeploymentDeviceId:     deviceID,
 	}
 
-	var statuses []model.DeviceDeployment
-
+	var dd model.DeviceDeployment
 	err := session.DB(mstore.DbFromContext(ctx, DatabaseName)).
-		C(CollectionDevices).Find(query).All(&statuses)
-	if err != nil {
+		C(CollectionDevices).Find(query).One(&dd)
+	if err == mgo.ErrNotFound {
+		return nil, nil
+	} else if err != nil {
 		return nil, err
 	}
 
-	return statuses, nil
+	return &dd, nil
 }
 
-// Returns true if deployment of ID `deploymentID` is assigned to device with ID
-// `deviceID`, false otherwise. In case of errors returns false and an error
-// that occurred
-func (db *DataStoreMongo) HasDeploymentForDevice(ctx context.Context,
-	deploymentID string, deviceID string) (bool, error) {
+// AddDeviceDeploymentContinued records the device was continued
+// at the pause point of the deployment
+func (db *DataStoreMongo) AddDeviceDeploymentContinued(ctx context.Context,
+	deploymentID string, deviceID string, pausePoint string) error {
+
+	if govalidator.IsNull(deploymentID) ||
+		govalidator.IsNull(deviceID) {
+		return ErrStorageInvalidID
+	}
+
+	if govalidator.IsNull(pausePoint) {
+		return ErrStorageInvalidInput
+	}
 
 	session := db.session.Copy()
 	defer session.Close()
 
-	query := bson.M{
+	selector := bson.M{
 		StorageKeyDeviceDeploymentDeploymentID: deploymentID,
 		StorageKeyDeviceDeploymentDeviceId:     deviceID,
 	}
 
-	var dep model.DeviceDeployment
 	err := session.DB(mstore.DbFromContext(ctx, DatabaseName)).
-		C(CollectionDevices).Find(query).One(&dep)
-	if err != nil {
-		if err == mgo.ErrNotFound {
-			return false, nil
-		} else {
-			return false, err
-		}
+		C(CollectionDevices).Update(selector, bson.M{
+		"$addToSet": bson.M{
+			StorageKeyDeviceDeploymentContinued: pausePoint,
+		},
+	})
+	if err == mgo.ErrNotFound {
+		return ErrStorageNotFound
 	}
 
-	return true, nil
+	return err
 }
 
 func (db *DataStoreMongo) GetDeviceDeploymentStatus(ctx context.Context,
@@ -1040,9 +2341,17 @@ func (db *DataStoreMongo) AbortDeviceDeployments(ctx context.Context,
 		},
 	}
 
+	now := time.Now()
 	update := bson.M{
 		"$set": bson.M{
-			StorageKeyDeviceDeploymentStatus: model.DeviceDeploymentStatusAborted,
+			StorageKeyDeviceDeploymentStatus:        model.DeviceDeploymentStatusAborted,
+			StorageKeyDeviceDeploymentStatusChanged: now,
+		},
+		"$push": bson.M{
+			StorageKeyDeviceDeploymentHistory: model.DeviceDeploymentStatusChange{
+				Status:    model.DeviceDeploymentStatusAborted,
+				Timestamp: now,
+			},
 		},
 	}
 
@@ -1078,9 +2387,17 @@ func (db *DataStoreMongo) DecommissionDeviceDeployments(ctx context.Context,
 		},
 	}
 
+	now := time.Now()
 	update := bson.M{
 		"$set": bson.M{
-			StorageKeyDeviceDeploymentStatus: model.DeviceDeploymentStatusDecommissioned,
+			StorageKeyDeviceDeploymentStatus:        model.DeviceDeploymentStatusDecommissioned,
+			StorageKeyDeviceDeploymentStatusChanged: now,
+		},
+		"$push": bson.M{
+			StorageKeyDeviceDeploymentHistory: model.DeviceDeploymentStatusChange{
+				Status:    model.DeviceDeploymentStatusDecommissioned,
+				Timestamp: now,
+			},
 		},
 	}
 
@@ -1203,86 +2520,480 @@ func (db *DataStoreMongo) DoEnsureAdditionalIndexing(dataBase string, session *m
 		return err
 	}
 
-	// IndexDeploymentCreatedStr = "deploymentCreated"
+	// IndexDeploymentCreatedStr = "deploymentCreated"
+	// created: -1
+	deploymentCreatedIndex := mgo.Index{
+		Key:        DeploymentCreatedIndex,
+		Name:       IndexDeploymentCreatedStr,
+		Background: false,
+	}
+
+	err = session.DB(dataBase).
+		C(CollectionDeployments).
+		EnsureIndex(deploymentCreatedIndex)
+
+	if err != nil {
+		return err
+	}
+
+	// IndexDeploymentDeviceStatusRebootingStr = "deploymentsDeviceStatusRebooting"
+	// stats.rebooting: 1
+	deploymentDeviceStatusRebootingIndex := mgo.Index{
+		Key:        DeploymentDeviceStatusRebootingIndex,
+		Name:       IndexDeploymentDeviceStatusRebootingStr,
+		Background: false,
+	}
+
+	err = session.DB(dataBase).
+		C(CollectionDeployments).
+		EnsureIndex(deploymentDeviceStatusRebootingIndex)
+
+	if err != nil {
+		return err
+	}
+
+	// IndexDeploymentDeviceStatusPendingStr = "deploymentsDeviceStatusPending"
+	// stats.pending: 1
+	deploymentDeviceStatusPendingIndex := mgo.Index{
+		Key:        DeploymentDeviceStatusPendingIndex,
+		Name:       IndexDeploymentDeviceStatusPendingStr,
+		Background: false,
+	}
+
+	err = session.DB(dataBase).
+		C(CollectionDeployments).
+		EnsureIndex(deploymentDeviceStatusPendingIndex)
+
+	if err != nil {
+		return err
+	}
+
+	// IndexDeploymentDeviceStatusInstallingStr = "deploymentsDeviceStatusInstalling"
+	// stats.installing: 1
+	deploymentDeviceStatusInstallingIndex := mgo.Index{
+		Key:        DeploymentDeviceStatusInstallingIndex,
+		Name:       IndexDeploymentDeviceStatusInstallingStr,
+		Background: false,
+	}
+
+	err = session.DB(dataBase).
+		C(CollectionDeployments).
+		EnsureIndex(deploymentDeviceStatusInstallingIndex)
+
+	if err != nil {
+		return err
+	}
+
+	// IndexDeploymentDeviceStatusFinishedStr = "deploymentsFinished"
+	// finished: 1
+	deploymentDeviceStatusFinishedIndex := mgo.Index{
+		Key:        DeploymentDeviceStatusFinishedIndex,
+		Name:       IndexDeploymentDeviceStatusFinishedStr,
+		Background: false,
+	}
+
+	err = session.DB(dataBase).
+		C(CollectionDeployments).
+		EnsureIndex(deploymentDeviceStatusFinishedIndex)
+
+	if err != nil {
+		return err
+	}
+
+	return err
+}
+
+// DoEnsureDeviceListIndexing creates indexes used for sorting devices
+// of a deployment
+func (db *DataStoreMongo) DoEnsureDeviceListIndexing(dataBase string, session *mgo.Session) error {
+	// IndexDeploymentDeviceCreatedStr = "devicesDeploymentIdCreated"
+	// deploymentid: 1
+	// created: 1
+	// deviceid: 1
+	deploymentDeviceCreatedIndex := mgo.Index{
+		Key:        DeploymentDeviceCreatedIndex,
+		Name:       IndexDeploymentDeviceCreatedStr,
+		Background: false,
+	}
+
+	err := session.DB(dataBase).
+		C(CollectionDevices).
+		EnsureIndex(deploymentDeviceCreatedIndex)
+
+	if err != nil {
+		return err
+	}
+
+	// IndexDeploymentDeviceFinishedStr = "devicesDeploymentIdFinished"
+	// deploymentid: 1
+	// finished: 1
+	// deviceid: 1
+	deploymentDeviceFinishedIndex := mgo.Index{
+		Key:        DeploymentDeviceFinishedIndex,
+		Name:       IndexDeploymentDeviceFinishedStr,
+		Background: false,
+	}
+
+	return session.DB(dataBase).
+		C(CollectionDevices).
+		EnsureIndex(deploymentDeviceFinishedIndex)
+}
+
+// DoEnsureDeploymentSortIndexing creates indexes used for sorting deployments
+func (db *DataStoreMongo) DoEnsureDeploymentSortIndexing(dataBase string, session *mgo.Session) error {
+	// IndexDeploymentNameStr = "deploymentName"
+	// deploymentconstructor.name: 1
+	// _id: 1
+	deploymentNameIndex := mgo.Index{
+		Key:        DeploymentNameIndex,
+		Name:       IndexDeploymentNameStr,
+		Background: false,
+	}
+
+	return session.DB(dataBase).
+		C(CollectionDeployments).
+		EnsureIndex(deploymentNameIndex)
+}
+
+// DoBackfillDeploymentDeviceCount sets the device count of deployments
+// created before it was stored, by counting their device deployments
+func (db *DataStoreMongo) DoBackfillDeploymentDeviceCount(dataBase string, session *mgo.Session) error {
+	deployments := session.DB(dataBase).C(CollectionDeployments)
+	devices := session.DB(dataBase).C(CollectionDevices)
+
+	var missing []struct {
+		Id string `bson:"_id"`
+	}
+	err := deployments.Find(bson.M{
+		StorageKeyDeploymentDeviceCount: bson.M{"$exists": false},
+	}).Select(bson.M{StorageKeyDeploymentId: 1}).All(&missing)
+	if err != nil {
+		return err
+	}
+
+	for _, d := range missing {
+		count, err := devices.Find(bson.M{
+			StorageKeyDeviceDeploymentDeploymentID: d.Id,
+		}).Count()
+		if err != nil {
+			return err
+		}
+
+		err = deployments.UpdateId(d.Id, bson.M{
+			"$set": bson.M{StorageKeyDeploymentDeviceCount: count},
+		})
+		if err != nil && err != mgo.ErrNotFound {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// DoEnsureFinishedStatusIndexing creates the index used for filtering
+// deployments by their finished status
+func (db *DataStoreMongo) DoEnsureFinishedStatusIndexing(dataBase string, session *mgo.Session) error {
+	// IndexDeploymentFinishedStatusStr = "deploymentFinishedStatus"
+	// finishedstatus: 1
 	// created: -1
-	deploymentCreatedIndex := mgo.Index{
-		Key:        DeploymentCreatedIndex,
-		Name:       IndexDeploymentCreatedStr,
+	finishedStatusIndex := mgo.Index{
+		Key:        DeploymentFinishedStatusIndex,
+		Name:       IndexDeploymentFinishedStatusStr,
 		Background: false,
 	}
 
-	err = session.DB(dataBase).
+	return session.DB(dataBase).
 		C(CollectionDeployments).
-		EnsureIndex(deploymentCreatedIndex)
+		EnsureIndex(finishedStatusIndex)
+}
 
-	if err != nil {
-		return err
+// DoBackfillDeploymentFinishedStatus sets the finished status of deployments
+// finished before it was stored
+func (db *DataStoreMongo) DoBackfillDeploymentFinishedStatus(dataBase string, session *mgo.Session) error {
+	c := session.DB(dataBase).C(CollectionDeployments)
+
+	iter := c.Find(bson.M{
+		StorageKeyDeploymentFinished:       bson.M{"$ne": nil},
+		StorageKeyDeploymentFinishedStatus: bson.M{"$exists": false},
+	}).Select(bson.M{
+		StorageKeyDeploymentId:    1,
+		StorageKeyDeploymentStats: 1,
+	}).Iter()
+
+	var deployment model.Deployment
+	for iter.Next(&deployment) {
+		err := c.UpdateId(*deployment.Id, bson.M{
+			"$set": bson.M{
+				StorageKeyDeploymentFinishedStatus: deployment.GetFinishedStatus(),
+			},
+		})
+		if err != nil && err != mgo.ErrNotFound {
+			iter.Close()
+			return err
+		}
+		deployment = model.Deployment{}
 	}
 
-	// IndexDeploymentDeviceStatusRebootingStr = "deploymentsDeviceStatusRebooting"
-	// stats.rebooting: 1
-	deploymentDeviceStatusRebootingIndex := mgo.Index{
-		Key:        DeploymentDeviceStatusRebootingIndex,
-		Name:       IndexDeploymentDeviceStatusRebootingStr,
+	return iter.Close()
+}
+
+// DoEnsureLabelsIndexing creates the index used for filtering deployments
+// by labels
+func (db *DataStoreMongo) DoEnsureLabelsIndexing(dataBase string, session *mgo.Session) error {
+	// IndexDeploymentLabelsStr = "deploymentLabels"
+	// deploymentconstructor.labels.key: 1
+	// deploymentconstructor.labels.value: 1
+	labelsIndex := mgo.Index{
+		Key:        DeploymentLabelsIndex,
+		Name:       IndexDeploymentLabelsStr,
 		Background: false,
 	}
 
-	err = session.DB(dataBase).
+	return session.DB(dataBase).
 		C(CollectionDeployments).
-		EnsureIndex(deploymentDeviceStatusRebootingIndex)
+		EnsureIndex(labelsIndex)
+}
 
-	if err != nil {
-		return err
+// DoEnsureDeviceDeploymentLogsIndexing creates the indexes used for
+// searching device deployment logs
+func (db *DataStoreMongo) DoEnsureDeviceDeploymentLogsIndexing(dataBase string,
+	session *mgo.Session) error {
+
+	// IndexDeviceDeploymentLogsMessagesStr = "deviceDeploymentLogsMessages"
+	// $text: messages.message
+	// no language, so words are matched as they are
+	messagesIndex := mgo.Index{
+		Key:             DeviceDeploymentLogsMessagesIndex,
+		Name:            IndexDeviceDeploymentLogsMessagesStr,
+		DefaultLanguage: "none",
+		Background:      false,
+	}
+
+	// IndexDeviceDeploymentLogsDeploymentStr = "deviceDeploymentLogsDeployment"
+	// deploymentid: 1
+	// deviceid: 1
+	deploymentIndex := mgo.Index{
+		Key:        DeviceDeploymentLogsDeploymentIndex,
+		Name:       IndexDeviceDeploymentLogsDeploymentStr,
+		Background: false,
 	}
 
-	// IndexDeploymentDeviceStatusPendingStr = "deploymentsDeviceStatusPending"
-	// stats.pending: 1
-	deploymentDeviceStatusPendingIndex := mgo.Index{
-		Key:        DeploymentDeviceStatusPendingIndex,
-		Name:       IndexDeploymentDeviceStatusPendingStr,
+	coll := session.DB(dataBase).C(CollectionDeviceDeploymentLogs)
+	for _, idx := range []mgo.Index{messagesIndex, deploymentIndex} {
+		if err := coll.EnsureIndex(idx); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// DoBackfillDeploymentStats sets the status counters missing in
+// the statistics of existing deployments to 0
+func (db *DataStoreMongo) DoBackfillDeploymentStats(dataBase string,
+	session *mgo.Session) error {
+
+	coll := session.DB(dataBase).C(CollectionDeployments)
+
+	for status := range model.NewDeviceDeploymentStats() {
+		_, err := coll.UpdateAll(bson.M{
+			buildStatusKey(status): bson.M{"$exists": false},
+		}, bson.M{
+			"$set": bson.M{buildStatusKey(status): 0},
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// DoEnsureRetentionIndexing creates the index used for finding device
+// deployment logs to be removed by the retention policy.
+// It is not a TTL index: the retention period is a tenant setting which
+// can change, logs may have to be archived before they are removed, and the
+// log availability of the device deployment is cleared with the log, so
+// the retention sweeper removes the logs instead.
+func (db *DataStoreMongo) DoEnsureRetentionIndexing(dataBase string, session *mgo.Session) error {
+	// IndexDeviceDeploymentLogsUpdatedStr = "deviceDeploymentLogsUpdated"
+	// updated: 1
+	updatedIndex := mgo.Index{
+		Key:        DeviceDeploymentLogsUpdatedIndex,
+		Name:       IndexDeviceDeploymentLogsUpdatedStr,
 		Background: false,
 	}
 
-	err = session.DB(dataBase).
-		C(CollectionDeployments).
-		EnsureIndex(deploymentDeviceStatusPendingIndex)
+	return session.DB(dataBase).
+		C(CollectionDeviceDeploymentLogs).
+		EnsureIndex(updatedIndex)
+}
 
-	if err != nil {
-		return err
+// DoBackfillDeviceDeploymentLogUpdated sets the last upload time of
+// deployment logs stored before it was tracked to the creation time
+// of the log
+func (db *DataStoreMongo) DoBackfillDeviceDeploymentLogUpdated(dataBase string,
+	session *mgo.Session) error {
+
+	coll := session.DB(dataBase).C(CollectionDeviceDeploymentLogs)
+
+	iter := coll.Find(bson.M{
+		StorageKeyDeviceDeploymentLogUpdated: bson.M{"$exists": false},
+	}).Select(bson.M{"_id": 1}).Iter()
+
+	var doc struct {
+		Id bson.ObjectId `bson:"_id"`
+	}
+	for iter.Next(&doc) {
+		err := coll.UpdateId(doc.Id, bson.M{
+			"$set": bson.M{
+				StorageKeyDeviceDeploymentLogUpdated: doc.Id.Time(),
+			},
+		})
+		if err != nil {
+			iter.Close()
+			return err
+		}
 	}
 
-	// IndexDeploymentDeviceStatusInstallingStr = "deploymentsDeviceStatusInstalling"
-	// stats.installing: 1
-	deploymentDeviceStatusInstallingIndex := mgo.Index{
-		Key:        DeploymentDeviceStatusInstallingIndex,
-		Name:       IndexDeploymentDeviceStatusInstallingStr,
+	return iter.Close()
+}
+
+// DoEnsureInstalledBaseIndexing creates the index used for counting
+// and listing devices by the installed artifact
+func (db *DataStoreMongo) DoEnsureInstalledBaseIndexing(dataBase string,
+	session *mgo.Session) error {
+
+	// IndexInstalledBaseArtifactStr = "installedBaseArtifact"
+	// artifact_name: 1
+	// device_type: 1
+	// _id: 1
+	artifactIndex := mgo.Index{
+		Key:        InstalledBaseArtifactIndex,
+		Name:       IndexInstalledBaseArtifactStr,
 		Background: false,
 	}
 
-	err = session.DB(dataBase).
-		C(CollectionDeployments).
-		EnsureIndex(deploymentDeviceStatusInstallingIndex)
+	return session.DB(dataBase).
+		C(CollectionInstalledBase).
+		EnsureIndex(artifactIndex)
+}
 
-	if err != nil {
-		return err
+// DoEnsureDeviceDeploymentPriorityIndexing creates the index for finding
+// the next deployment of the device
+func (db *DataStoreMongo) DoEnsureDeviceDeploymentPriorityIndexing(dataBase string,
+	session *mgo.Session) error {
+
+	// IndexDeploymentDeviceStatusPriorityStr = "deviceIdWithStatusByPriority"
+	// deviceid: 1
+	// status: 1
+	// priority: -1
+	// deploymentcreated: 1
+	priorityIndex := mgo.Index{
+		Key:        DeploymentDeviceStatusPriorityIndex,
+		Name:       IndexDeploymentDeviceStatusPriorityStr,
+		Background: false,
 	}
 
-	// IndexDeploymentDeviceStatusFinishedStr = "deploymentsFinished"
-	// finished: 1
-	deploymentDeviceStatusFinishedIndex := mgo.Index{
-		Key:        DeploymentDeviceStatusFinishedIndex,
-		Name:       IndexDeploymentDeviceStatusFinishedStr,
+	return session.DB(dataBase).
+		C(CollectionDevices).
+		EnsureIndex(priorityIndex)
+}
+
+// DoEnsureDeploymentDependsOnIndexing creates the index for finding the
+// deployments depending on a deployment
+func (db *DataStoreMongo) DoEnsureDeploymentDependsOnIndexing(dataBase string,
+	session *mgo.Session) error {
+
+	// IndexDeploymentDependsOnStr = "deploymentDependsOn"
+	// deploymentconstructor.dependson: 1
+	// created: 1
+	dependsOnIndex := mgo.Index{
+		Key:        DeploymentDependsOnIndex,
+		Name:       IndexDeploymentDependsOnStr,
 		Background: false,
 	}
 
-	err = session.DB(dataBase).
+	return session.DB(dataBase).
 		C(CollectionDeployments).
-		EnsureIndex(deploymentDeviceStatusFinishedIndex)
+		EnsureIndex(dependsOnIndex)
+}
+
+// DoBackfillDeviceDeploymentPriority sets the default priority on device
+// deployments created before deployments had a priority, a missing priority
+// would sort them after the ones with the default priority
+func (db *DataStoreMongo) DoBackfillDeviceDeploymentPriority(dataBase string,
+	session *mgo.Session) error {
 
+	_, err := session.DB(dataBase).C(CollectionDevices).UpdateAll(bson.M{
+		StorageKeyDeviceDeploymentPriority: bson.M{"$exists": false},
+	}, bson.M{
+		"$set": bson.M{StorageKeyDeviceDeploymentPriority: 0},
+	})
+
+	return err
+}
+
+// DoBackfillDeviceDeploymentDeploymentCreated copies the creation time of
+// device deployments created before the creation time of the deployment was
+// stored with them; until then both were the same
+func (db *DataStoreMongo) DoBackfillDeviceDeploymentDeploymentCreated(dataBase string,
+	session *mgo.Session) error {
+
+	c := session.DB(dataBase).C(CollectionDevices)
+
+	iter := c.Find(bson.M{
+		StorageKeyDeviceDeploymentDeploymentCreated: bson.M{"$exists": false},
+	}).Select(bson.M{
+		StorageKeyDeviceDeploymentId:      1,
+		StorageKeyDeviceDeploymentCreated: 1,
+	}).Iter()
+
+	var dd model.DeviceDeployment
+	for iter.Next(&dd) {
+		err := c.UpdateId(*dd.Id, bson.M{
+			"$set": bson.M{
+				StorageKeyDeviceDeploymentDeploymentCreated: dd.Created,
+			},
+		})
+		if err != nil && err != mgo.ErrNotFound {
+			iter.Close()
+			return err
+		}
+		dd = model.DeviceDeployment{}
+	}
+
+	return iter.Close()
+}
+
+// DoBackfillDeploymentAborted records deployments with aborted devices as
+// aborted by the user, which was the only way devices could be aborted
+// before it was recorded; the finished status of these deployments is
+// set to aborted as well
+func (db *DataStoreMongo) DoBackfillDeploymentAborted(dataBase string,
+	session *mgo.Session) error {
+
+	coll := session.DB(dataBase).C(CollectionDeployments)
+
+	_, err := coll.UpdateAll(bson.M{
+		buildStatusKey(model.DeviceDeploymentStatusAborted): bson.M{"$gt": 0},
+		StorageKeyDeploymentAborted:                         bson.M{"$exists": false},
+	}, bson.M{
+		"$set": bson.M{StorageKeyDeploymentAborted: true},
+	})
 	if err != nil {
 		return err
 	}
 
+	_, err = coll.UpdateAll(bson.M{
+		StorageKeyDeploymentAborted:  true,
+		StorageKeyDeploymentFinished: bson.M{"$ne": nil},
+	}, bson.M{
+		"$set": bson.M{
+			StorageKeyDeploymentFinishedStatus: model.DeploymentStatusAborted,
+		},
+	})
+
 	return err
 }
 
@@ -1380,6 +3091,106 @@ func (db *DataStoreMongo) FindDeploymentByID(ctx context.Context, id string) (*m
 	return deployment, nil
 }
 
+// FindDeploymentsWithExpiredDevices returns up to limit deployments
+// finished before the given time, which still have their device
+// deployments, the oldest first
+func (db *DataStoreMongo) FindDeploymentsWithExpiredDevices(ctx context.Context,
+	before time.Time, limit int) ([]*model.Deployment, error) {
+
+	session := db.session.Copy()
+	defer session.Close()
+
+	query := bson.M{
+		StorageKeyDeploymentFinished:       bson.M{"$lt": before},
+		StorageKeyDeploymentDevicesRemoved: bson.M{"$ne": true},
+	}
+
+	deployments := []*model.Deployment{}
+	err := session.DB(mstore.DbFromContext(ctx, DatabaseName)).
+		C(CollectionDeployments).Find(query).
+		Sort(StorageKeyDeploymentFinished).
+		Limit(limit).All(&deployments)
+	if err != nil {
+		return nil, err
+	}
+
+	return deployments, nil
+}
+
+// FindDependentDeployments returns deployments depending on the deployment
+// with given id, sorted by creation time
+func (db *DataStoreMongo) FindDependentDeployments(ctx context.Context,
+	id string) ([]*model.Deployment, error) {
+
+	if govalidator.IsNull(id) {
+		return nil, ErrStorageInvalidID
+	}
+
+	session := db.session.Copy()
+	defer session.Close()
+
+	filter := bson.M{
+		StorageKeyDeploymentDependsOn: id,
+	}
+
+	var deployments []*model.Deployment
+	if err := session.DB(mstore.DbFromContext(ctx, DatabaseName)).
+		C(CollectionDeployments).Find(filter).
+		Sort(StorageKeyDeploymentStatsCreated).All(&deployments); err != nil {
+		return nil, err
+	}
+
+	return deployments, nil
+}
+
+// FindDeploymentsByIDs returns deployments with the given IDs,
+// missing deployments are skipped
+func (db *DataStoreMongo) FindDeploymentsByIDs(ctx context.Context,
+	ids []string) ([]*model.Deployment, error) {
+
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	session := db.session.Copy()
+	defer session.Close()
+
+	filter := bson.M{
+		StorageKeyDeploymentId: bson.M{
+			"$in": ids,
+		},
+	}
+
+	var deployments []*model.Deployment
+	if err := session.DB(mstore.DbFromContext(ctx, DatabaseName)).
+		C(CollectionDeployments).Find(filter).All(&deployments); err != nil {
+		return nil, err
+	}
+
+	return deployments, nil
+}
+
+// FindUnfinishedWithTimeouts returns unfinished deployments
+// which have state timeouts set
+func (db *DataStoreMongo) FindUnfinishedWithTimeouts(ctx context.Context) ([]*model.Deployment, error) {
+
+	session := db.session.Copy()
+	defer session.Close()
+
+	filter := bson.M{
+		StorageKeyDeploymentFinished: nil,
+		StorageKeyDeploymentTimeouts: bson.M{"$ne": nil},
+	}
+
+	var deployments []*model.Deployment
+	if err := session.DB(mstore.DbFromContext(ctx, DatabaseName)).
+		C(CollectionDeployments).Find(filter).All(&deployments); err != nil {
+		return nil, err
+	}
+
+	return deployments, nil
+}
+
 func (db *DataStoreMongo) FindUnfinishedByID(ctx context.Context,
 	id string) (*model.Deployment, error) {
 
@@ -1436,6 +3247,9 @@ func (db *DataStoreMongo) UpdateStatsAndFinishDeployment(ctx context.Context,
 	session := db.session.Copy()
 	defer session.Close()
 
+	c := session.DB(mstore.DbFromContext(ctx, DatabaseName)).
+		C(CollectionDeployments)
+
 	deployment, err := model.NewDeployment()
 	if err != nil {
 		return errors.Wrap(err, "failed to create deployment")
@@ -1444,12 +3258,24 @@ func (db *DataStoreMongo) UpdateStatsAndFinishDeployment(ctx context.Context,
 	deployment.Stats = stats
 	var update bson.M
 	if deployment.IsFinished() {
+		// finished status depends on whether the user aborted the deployment
+		var aborted model.Deployment
+		err := c.FindId(id).Select(bson.M{StorageKeyDeploymentAborted: 1}).
+			One(&aborted)
+		if err == mgo.ErrNotFound {
+			return ErrStorageInvalidID
+		} else if err != nil {
+			return err
+		}
+		deployment.Aborted = aborted.Aborted
+
 		now := time.Now()
 
 		update = bson.M{
 			"$set": bson.M{
-				StorageKeyDeploymentStats:    stats,
-				StorageKeyDeploymentFinished: &now,
+				StorageKeyDeploymentStats:          stats,
+				StorageKeyDeploymentFinished:       &now,
+				StorageKeyDeploymentFinishedStatus: deployment.GetFinishedStatus(),
 			},
 		}
 	} else {
@@ -1460,55 +3286,146 @@ func (db *DataStoreMongo) UpdateStatsAndFinishDeployment(ctx context.Context,
 		}
 	}
 
-	err = session.DB(mstore.DbFromContext(ctx, DatabaseName)).
+	err = c.UpdateId(id, update)
+	if err == mgo.ErrNotFound {
+		return ErrStorageInvalidID
+	}
+
+	return err
+}
+
+func (db *DataStoreMongo) UpdateStats(ctx context.Context, id string,
+	state_from, state_to string) error {
+
+	if govalidator.IsNull(id) {
+		return ErrStorageInvalidID
+	}
+
+	if govalidator.IsNull(state_from) {
+		return ErrStorageInvalidInput
+	}
+
+	if govalidator.IsNull(state_to) {
+		return ErrStorageInvalidInput
+	}
+
+	// does not need any extra operations
+	// following query won't handle this case well and increase the state_to value
+	if state_from == state_to {
+		return nil
+	}
+
+	session := db.session.Copy()
+	defer session.Close()
+
+	// note dot notation on embedded document
+	update := bson.M{
+		"$inc": bson.M{
+			"stats." + state_from: -1,
+			"stats." + state_to:   1,
+		},
+	}
+
+	err := session.DB(mstore.DbFromContext(ctx, DatabaseName)).
 		C(CollectionDeployments).UpdateId(id, update)
+
+	if err == mgo.ErrNotFound {
+		return ErrStorageInvalidID
+	}
+
+	return err
+}
+
+// IncrementPendingStats increments the pending counter and the device count
+// of an unfinished deployment by count. Returns ErrStorageNotFound if there
+// is no such deployment or it has already been finished.
+func (db *DataStoreMongo) IncrementPendingStats(ctx context.Context, id string,
+	count int) error {
+
+	if govalidator.IsNull(id) {
+		return ErrStorageInvalidID
+	}
+
+	session := db.session.Copy()
+	defer session.Close()
+
+	// finished deployments must not get new devices, check it in the same
+	// query to avoid races with concurrent status updates
+	selector := bson.M{
+		"_id":                        id,
+		StorageKeyDeploymentFinished: nil,
+	}
+
+	update := bson.M{
+		"$inc": bson.M{
+			buildStatusKey(model.DeviceDeploymentStatusPending): count,
+			StorageKeyDeploymentDeviceCount:                     count,
+		},
+	}
+
+	err := session.DB(mstore.DbFromContext(ctx, DatabaseName)).
+		C(CollectionDeployments).Update(selector, update)
+
 	if err == mgo.ErrNotFound {
-		return ErrStorageInvalidID
+		return ErrStorageNotFound
 	}
 
 	return err
 }
 
-func (db *DataStoreMongo) UpdateStats(ctx context.Context, id string,
-	state_from, state_to string) error {
+// UpdateStatsWithinLimit moves a single device from state_from to state_to in
+// the deployment statistics, but only if fewer than limit devices are
+// downloading, installing or rebooting. The condition is evaluated in the same
+// write operation so it holds across concurrent service instances.
+// Returns false if the limit has been reached.
+func (db *DataStoreMongo) UpdateStatsWithinLimit(ctx context.Context, id string,
+	state_from, state_to string, limit int) (bool, error) {
 
 	if govalidator.IsNull(id) {
-		return ErrStorageInvalidID
+		return false, ErrStorageInvalidID
 	}
 
-	if govalidator.IsNull(state_from) {
-		return ErrStorageInvalidInput
+	if govalidator.IsNull(state_from) || govalidator.IsNull(state_to) {
+		return false, ErrStorageInvalidInput
 	}
 
-	if govalidator.IsNull(state_to) {
-		return ErrStorageInvalidInput
-	}
+	session := db.session.Copy()
+	defer session.Close()
 
-	// does not need any extra operations
-	// following query won't handle this case well and increase the state_to value
-	if state_from == state_to {
-		return nil
+	inFlight := []bson.M{}
+	for _, status := range model.InFlightDeploymentStatuses() {
+		inFlight = append(inFlight, bson.M{
+			"$ifNull": []interface{}{"$" + buildStatusKey(status), 0},
+		})
 	}
 
-	session := db.session.Copy()
-	defer session.Close()
+	selector := bson.M{
+		"_id": id,
+		"$expr": bson.M{
+			"$lt": []interface{}{
+				bson.M{"$add": inFlight},
+				limit,
+			},
+		},
+	}
 
-	// note dot notation on embedded document
 	update := bson.M{
 		"$inc": bson.M{
-			"stats." + state_from: -1,
-			"stats." + state_to:   1,
+			buildStatusKey(state_from): -1,
+			buildStatusKey(state_to):   1,
 		},
 	}
 
 	err := session.DB(mstore.DbFromContext(ctx, DatabaseName)).
-		C(CollectionDeployments).UpdateId(id, update)
+		C(CollectionDeployments).Update(selector, update)
 
 	if err == mgo.ErrNotFound {
-		return ErrStorageInvalidID
+		return false, nil
+	} else if err != nil {
+		return false, err
 	}
 
-	return err
+	return true, nil
 }
 
 func buildStatusKey(status string) string {
@@ -1527,7 +3444,7 @@ func buildStatusQuery(status model.StatusQuery) bson.M {
 	switch status {
 	case model.StatusQueryInProgress:
 		{
-			// downloading, installing or rebooting are non 0, or
+			// downloading, installing, rebooting or paused are non 0, or
 			// already-installed/success/failure/noimage >0 and pending > 0
 			stq = bson.M{
 				"$or": []bson.M{
@@ -1540,6 +3457,15 @@ func buildStatusQuery(status model.StatusQuery) bson.M {
 					{
 						buildStatusKey(model.DeviceDeploymentStatusRebooting): gt0,
 					},
+					{
+						buildStatusKey(model.DeviceDeploymentStatusPauseBeforeInstall): gt0,
+					},
+					{
+						buildStatusKey(model.DeviceDeploymentStatusPauseBeforeReboot): gt0,
+					},
+					{
+						buildStatusKey(model.DeviceDeploymentStatusPauseBeforeCommit): gt0,
+					},
 					{
 						"$and": []bson.M{
 							{
@@ -1581,6 +3507,15 @@ func buildStatusQuery(status model.StatusQuery) bson.M {
 					{
 						buildStatusKey(model.DeviceDeploymentStatusRebooting): eq0,
 					},
+					{
+						buildStatusKey(model.DeviceDeploymentStatusPauseBeforeInstall): eq0,
+					},
+					{
+						buildStatusKey(model.DeviceDeploymentStatusPauseBeforeReboot): eq0,
+					},
+					{
+						buildStatusKey(model.DeviceDeploymentStatusPauseBeforeCommit): eq0,
+					},
 					{
 						buildStatusKey(model.DeviceDeploymentStatusSuccess): eq0,
 					},
@@ -1602,6 +3537,11 @@ func buildStatusQuery(status model.StatusQuery) bson.M {
 					{
 						buildStatusKey(model.DeviceDeploymentStatusPending): gt0,
 					},
+					{
+						StorageKeyDeploymentApprovalStatus: bson.M{
+							"$ne": model.ApprovalStatusAwaiting,
+						},
+					},
 				},
 			}
 		}
@@ -1609,6 +3549,29 @@ func buildStatusQuery(status model.StatusQuery) bson.M {
 		{
 			stq = bson.M{StorageKeyDeploymentFinished: notNull}
 		}
+	case model.StatusQueryAborted:
+		{
+			stq = bson.M{StorageKeyDeploymentFinishedStatus: model.DeploymentStatusAborted}
+		}
+	case model.StatusQuerySucceeded:
+		{
+			stq = bson.M{StorageKeyDeploymentFinishedStatus: model.DeploymentStatusSucceeded}
+		}
+	case model.StatusQueryFailed:
+		{
+			stq = bson.M{StorageKeyDeploymentFinishedStatus: model.DeploymentStatusFailed}
+		}
+	case model.StatusQueryPartial:
+		{
+			stq = bson.M{StorageKeyDeploymentFinishedStatus: model.DeploymentStatusPartial}
+		}
+	case model.StatusQueryAwaitingApproval:
+		{
+			stq = bson.M{
+				StorageKeyDeploymentApprovalStatus: model.ApprovalStatusAwaiting,
+				StorageKeyDeploymentFinished:       nil,
+			}
+		}
 	}
 
 	return stq
@@ -1620,6 +3583,58 @@ func (db *DataStoreMongo) Find(ctx context.Context,
 	session := db.session.Copy()
 	defer session.Close()
 
+	query, err := db.buildLookupQuery(ctx, session, match)
+	if err != nil {
+		return nil, err
+	}
+
+	c := session.DB(mstore.DbFromContext(ctx, DatabaseName)).
+		C(CollectionDeployments)
+
+	var deployment []*model.Deployment
+
+	if match.SortBy == model.DeploymentsSortStatus {
+		// status is not stored, but derived from the stats
+		err = c.Pipe(buildStatusSortPipeline(query, match)).
+			AllowDiskUse().All(&deployment)
+	} else {
+		var sortKeys []string
+		sortKeys, err = buildLookupSort(match)
+		if err != nil {
+			return nil, err
+		}
+		err = c.Find(&query).Sort(sortKeys...).
+			Skip(match.Skip).Limit(match.Limit).
+			All(&deployment)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return deployment, nil
+}
+
+// CountDeployments returns the number of deployments matching the query,
+// ignoring limit and skip
+func (db *DataStoreMongo) CountDeployments(ctx context.Context,
+	match model.Query) (int, error) {
+
+	session := db.session.Copy()
+	defer session.Close()
+
+	query, err := db.buildLookupQuery(ctx, session, match)
+	if err != nil {
+		return 0, err
+	}
+
+	return session.DB(mstore.DbFromContext(ctx, DatabaseName)).
+		C(CollectionDeployments).Find(&query).Count()
+}
+
+func (db *DataStoreMongo) buildLookupQuery(ctx context.Context, session *mgo.Session,
+	match model.Query) (bson.M, error) {
+
 	andq := []bson.M{}
 
 	// build deployment by name part of the query
@@ -1644,6 +3659,18 @@ func (db *DataStoreMongo) Find(ctx context.Context,
 		andq = append(andq, stq)
 	}
 
+	// build deployment by labels part of the query
+	for _, label := range match.Labels {
+		lq := bson.M{"key": label.Key}
+		if label.Value != "" {
+			lq["value"] = label.Value
+		}
+
+		andq = append(andq, bson.M{
+			StorageKeyDeploymentLabels: bson.M{"$elemMatch": lq},
+		})
+	}
+
 	query := bson.M{}
 	if len(andq) != 0 {
 		// use search criteria if any
@@ -1667,18 +3694,211 @@ func (db *DataStoreMongo) Find(ctx context.Context,
 		}
 	}
 
-	var deployment []*model.Deployment
+	return query, nil
+}
+
+// buildLookupSort returns sort keys of the query, deployment ID breaks ties
+// to keep pages stable
+func buildLookupSort(match model.Query) ([]string, error) {
+	var keys []string
+
+	switch match.SortBy {
+	case "":
+		return []string{"-" + StorageKeyDeploymentStatsCreated, "-" + StorageKeyDeploymentId}, nil
+	case model.DeploymentsSortCreated:
+		keys = []string{StorageKeyDeploymentStatsCreated, StorageKeyDeploymentId}
+	case model.DeploymentsSortName:
+		keys = []string{StorageKeyDeploymentName, StorageKeyDeploymentId}
+	default:
+		return nil, ErrStorageInvalidInput
+	}
+
+	if match.SortDescending {
+		for i := range keys {
+			keys[i] = "-" + keys[i]
+		}
+	}
+
+	return keys, nil
+}
+
+// buildStatusSortPipeline builds aggregation sorting deployments by status,
+// in order pending, inprogress, finished (see model.Deployment.GetStatus),
+// newest first within the same status
+func buildStatusSortPipeline(query bson.M, match model.Query) []bson.M {
+	stat := func(status string) bson.M {
+		return bson.M{"$ifNull": []interface{}{"$" + buildStatusKey(status), 0}}
+	}
+	eq0 := func(status string) bson.M {
+		return bson.M{"$eq": []interface{}{stat(status), 0}}
+	}
+
+	pending := bson.M{
+		"$and": []interface{}{
+			bson.M{"$gt": []interface{}{stat(model.DeviceDeploymentStatusPending), 0}},
+			eq0(model.DeviceDeploymentStatusDownloading),
+			eq0(model.DeviceDeploymentStatusInstalling),
+			eq0(model.DeviceDeploymentStatusRebooting),
+			eq0(model.DeviceDeploymentStatusPauseBeforeInstall),
+			eq0(model.DeviceDeploymentStatusPauseBeforeReboot),
+			eq0(model.DeviceDeploymentStatusPauseBeforeCommit),
+			eq0(model.DeviceDeploymentStatusSuccess),
+			eq0(model.DeviceDeploymentStatusAlreadyInst),
+			eq0(model.DeviceDeploymentStatusFailure),
+			eq0(model.DeviceDeploymentStatusNoArtifact),
+		},
+	}
+
+	active := []interface{}{}
+	for _, status := range model.ActiveDeploymentStatuses() {
+		active = append(active, eq0(status))
+	}
+	finished := bson.M{"$and": active}
+
+	rank := bson.M{
+		"$cond": []interface{}{
+			pending,
+			0,
+			bson.M{"$cond": []interface{}{finished, 2, 1}},
+		},
+	}
+
+	order := 1
+	if match.SortDescending {
+		order = -1
+	}
+
+	pipeline := []bson.M{
+		{"$match": query},
+		{"$addFields": bson.M{storageKeyDeploymentStatusRank: rank}},
+		{"$sort": bson.D{
+			{Name: storageKeyDeploymentStatusRank, Value: order},
+			{Name: StorageKeyDeploymentStatsCreated, Value: -1},
+			{Name: StorageKeyDeploymentId, Value: -1},
+		}},
+	}
+
+	if match.Skip > 0 {
+		pipeline = append(pipeline, bson.M{"$skip": match.Skip})
+	}
+
+	if match.Limit > 0 {
+		pipeline = append(pipeline, bson.M{"$limit": match.Limit})
+	}
+
+	return append(pipeline, bson.M{"$project": bson.M{storageKeyDeploymentStatusRank: 0}})
+}
+
+// UpdateDeploymentMetadata replaces the description and labels
+// of the deployment
+func (db *DataStoreMongo) UpdateDeploymentMetadata(ctx context.Context, id string,
+	meta model.DeploymentMetadataConstructor) error {
+
+	if govalidator.IsNull(id) {
+		return ErrStorageInvalidID
+	}
+
+	session := db.session.Copy()
+	defer session.Close()
+
+	update := bson.M{
+		"$set": bson.M{
+			StorageKeyDeploymentDescription: meta.Description,
+			StorageKeyDeploymentLabels:      meta.Labels,
+		},
+	}
+
 	err := session.DB(mstore.DbFromContext(ctx, DatabaseName)).
-		C(CollectionDeployments).
-		Find(&query).Sort("-created").
-		Skip(match.Skip).Limit(match.Limit).
-		All(&deployment)
+		C(CollectionDeployments).UpdateId(id, update)
+	if err == mgo.ErrNotFound {
+		return ErrStorageNotFound
+	}
 
-	if err != nil {
-		return nil, err
+	return err
+}
+
+// UpdateDeploymentApproval records the decision on a deployment awaiting
+// approval. Returns ErrStorageNotFound if there is no such deployment
+// or it is not awaiting approval anymore.
+func (db *DataStoreMongo) UpdateDeploymentApproval(ctx context.Context, id string,
+	approval model.DeploymentApproval) error {
+
+	if govalidator.IsNull(id) {
+		return ErrStorageInvalidID
 	}
 
-	return deployment, nil
+	session := db.session.Copy()
+	defer session.Close()
+
+	selector := bson.M{
+		StorageKeyDeploymentId:             id,
+		StorageKeyDeploymentApprovalStatus: model.ApprovalStatusAwaiting,
+	}
+
+	update := bson.M{
+		"$set": bson.M{
+			StorageKeyDeploymentApproval: approval,
+		},
+	}
+
+	err := session.DB(mstore.DbFromContext(ctx, DatabaseName)).
+		C(CollectionDeployments).Update(selector, update)
+	if err == mgo.ErrNotFound {
+		return ErrStorageNotFound
+	}
+
+	return err
+}
+
+// SetDeploymentAborted records the deployment was aborted by the user
+func (db *DataStoreMongo) SetDeploymentAborted(ctx context.Context, id string) error {
+	if govalidator.IsNull(id) {
+		return ErrStorageInvalidID
+	}
+
+	session := db.session.Copy()
+	defer session.Close()
+
+	err := session.DB(mstore.DbFromContext(ctx, DatabaseName)).
+		C(CollectionDeployments).UpdateId(id, bson.M{
+		"$set": bson.M{
+			StorageKeyDeploymentAborted: true,
+		},
+	})
+	if err == mgo.ErrNotFound {
+		return ErrStorageNotFound
+	}
+
+	return err
+}
+
+// AddDeploymentContinued records all devices of the deployment
+// were continued at the pause point
+func (db *DataStoreMongo) AddDeploymentContinued(ctx context.Context, id string,
+	pausePoint string) error {
+
+	if govalidator.IsNull(id) {
+		return ErrStorageInvalidID
+	}
+
+	if govalidator.IsNull(pausePoint) {
+		return ErrStorageInvalidInput
+	}
+
+	session := db.session.Copy()
+	defer session.Close()
+
+	err := session.DB(mstore.DbFromContext(ctx, DatabaseName)).
+		C(CollectionDeployments).UpdateId(id, bson.M{
+		"$addToSet": bson.M{
+			StorageKeyDeploymentContinued: pausePoint,
+		},
+	})
+	if err == mgo.ErrNotFound {
+		return ErrStorageNotFound
+	}
+
+	return err
 }
 
 func (db *DataStoreMongo) Finish(ctx context.Context, id string, when time.Time) error {
@@ -1689,15 +3909,30 @@ func (db *DataStoreMongo) Finish(ctx context.Context, id string, when time.Time)
 	session := db.session.Copy()
 	defer session.Close()
 
+	c := session.DB(mstore.DbFromContext(ctx, DatabaseName)).
+		C(CollectionDeployments)
+
+	// finished status is derived from the stats
+	var deployment model.Deployment
+	err := c.FindId(id).Select(bson.M{
+		StorageKeyDeploymentStats:   1,
+		StorageKeyDeploymentAborted: 1,
+	}).One(&deployment)
+	if err == mgo.ErrNotFound {
+		return ErrStorageInvalidID
+	} else if err != nil {
+		return err
+	}
+
 	// note dot notation on embedded document
 	update := bson.M{
 		"$set": bson.M{
-			StorageKeyDeploymentFinished: &when,
+			StorageKeyDeploymentFinished:       &when,
+			StorageKeyDeploymentFinishedStatus: deployment.GetFinishedStatus(),
 		},
 	}
 
-	err := session.DB(mstore.DbFromContext(ctx, DatabaseName)).
-		C(CollectionDeployments).UpdateId(id, update)
+	err = c.UpdateId(id, update)
 
 	if err == mgo.ErrNotFound {
 		return ErrStorageInvalidID
@@ -1759,3 +3994,148 @@ func (db *DataStoreMongo) ExistByArtifactId(ctx context.Context,
 
 	return true, nil
 }
+
+// size of the capped collection of device notifications
+const deviceNotificationsSize = 16 * 1024 * 1024
+
+// time of waiting for new device notifications before checking
+// if the watch is cancelled
+const deviceNotificationsTailTimeout = 5 * time.Second
+
+// EnsureDeviceNotificationsCollection creates the capped collection
+// of device notifications if it doesn't exist
+func (db *DataStoreMongo) EnsureDeviceNotificationsCollection(ctx context.Context) error {
+	session := db.session.Copy()
+	defer session.Close()
+
+	names, err := session.DB(DatabaseName).CollectionNames()
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if name == CollectionDeviceNotifications {
+			return nil
+		}
+	}
+
+	err = session.DB(DatabaseName).C(CollectionDeviceNotifications).
+		Create(&mgo.CollectionInfo{
+			Capped:   true,
+			MaxBytes: deviceNotificationsSize,
+		})
+	// created by another instance in the meantime
+	if qerr, ok := err.(*mgo.QueryError); ok && qerr.Code == 48 {
+		return nil
+	}
+
+	return err
+}
+
+type deviceNotificationDoc struct {
+	ID                       bson.ObjectId `bson:"_id"`
+	model.DeviceNotification `bson:",inline"`
+}
+
+// InsertDeviceNotification publishes the notification to all instances
+// watching the device notifications
+func (db *DataStoreMongo) InsertDeviceNotification(ctx context.Context,
+	notification model.DeviceNotification) error {
+
+	if len(notification.DeviceIDs) == 0 {
+		return ErrStorageInvalidInput
+	}
+
+	session := db.session.Copy()
+	defer session.Close()
+
+	return session.DB(DatabaseName).C(CollectionDeviceNotifications).
+		Insert(deviceNotificationDoc{
+			ID:                 bson.NewObjectId(),
+			DeviceNotification: notification,
+		})
+}
+
+// WatchDeviceNotifications calls fn for each device notification inserted
+// after the watch started, until the context is cancelled.
+// The tailable cursor returns the notifications in the order the server
+// inserted them; it is resumed after timeouts. The ids are generated by each
+// instance and can't be ordered, so a cursor which died is reopened from the
+// oldest notification and the notifications are skipped up to the last one
+// seen. If that one was removed from the capped collection in the meantime,
+// the skipped ones are delivered after all, as notifying twice is harmless.
+func (db *DataStoreMongo) WatchDeviceNotifications(ctx context.Context,
+	fn func(model.DeviceNotification)) error {
+
+	session := db.session.Copy()
+	defer session.Close()
+
+	c := session.DB(DatabaseName).C(CollectionDeviceNotifications)
+
+	// skip the notifications sent before
+	var last deviceNotificationDoc
+	err := c.Find(nil).Sort("-$natural").One(&last)
+	if err != nil && err != mgo.ErrNotFound {
+		return err
+	}
+	lastID := last.ID
+
+	for {
+		iter := c.Find(nil).Sort("$natural").Tail(deviceNotificationsTailTimeout)
+
+		skipping := lastID != ""
+		var skipped []deviceNotificationDoc
+
+		for {
+			var doc deviceNotificationDoc
+			for iter.Next(&doc) {
+				switch {
+				case !skipping:
+					lastID = doc.ID
+					fn(doc.DeviceNotification)
+				case doc.ID == lastID:
+					skipping = false
+					skipped = nil
+				default:
+					skipped = append(skipped, doc)
+				}
+				doc = deviceNotificationDoc{}
+			}
+
+			if err := iter.Err(); err != nil {
+				iter.Close()
+				return err
+			}
+
+			// all notifications present were read without finding
+			// the last one seen
+			if skipping {
+				for _, doc := range skipped {
+					lastID = doc.ID
+					fn(doc.DeviceNotification)
+				}
+				skipping = false
+				skipped = nil
+			}
+
+			select {
+			case <-ctx.Done():
+				iter.Close()
+				return nil
+			default:
+			}
+
+			if !iter.Timeout() {
+				break
+			}
+		}
+
+		// the cursor died: the collection was empty, or the cursor
+		// fell behind the capped collection
+		iter.Close()
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(deviceNotificationsTailTimeout):
+		}
+	}
+}
@@ -16,7 +16,10 @@ package mongo
 import (
 	"context"
 	"testing"
+	"time"
 
+	"github.com/globalsign/mgo/bson"
+	"github.com/mendersoftware/go-lib-micro/identity"
 	"github.com/stretchr/testify/assert"
 
 	"github.com/mendersoftware/deployments/model"
@@ -240,3 +243,286 @@ func TestGetReleases(t *testing.T) {
 		})
 	}
 }
+
+func TestSettings(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping TestSettings in short mode.")
+	}
+
+	db.Wipe()
+	s := NewDataStoreMongoWithSession(db.Session())
+	ctx := context.Background()
+
+	settings, err := s.GetSettings(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, &model.Settings{}, settings)
+
+	assert.NoError(t, s.SaveSettings(ctx, &model.Settings{RequireApproval: true}))
+	settings, err = s.GetSettings(ctx)
+	assert.NoError(t, err)
+	assert.True(t, settings.RequireApproval)
+
+	assert.NoError(t, s.SaveSettings(ctx, &model.Settings{RequireApproval: false}))
+	settings, err = s.GetSettings(ctx)
+	assert.NoError(t, err)
+	assert.False(t, settings.RequireApproval)
+
+	assert.EqualError(t, s.SaveSettings(ctx, nil), ErrStorageInvalidInput.Error())
+
+	// other settings are kept
+	assert.NoError(t, s.SaveSettings(ctx, &model.Settings{
+		Retention: model.RetentionSettings{LogDays: 30},
+	}))
+	assert.NoError(t, s.SetRequireApproval(ctx, true))
+	settings, err = s.GetSettings(ctx)
+	assert.NoError(t, err)
+	assert.True(t, settings.RequireApproval)
+	assert.Equal(t, 30, settings.Retention.LogDays)
+}
+
+func TestDeviceNotifications(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping TestDeviceNotifications in short mode.")
+	}
+
+	db.Wipe()
+	s := NewDataStoreMongoWithSession(db.Session())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	assert.NoError(t, s.EnsureDeviceNotificationsCollection(ctx))
+	// noop if the collection exists
+	assert.NoError(t, s.EnsureDeviceNotificationsCollection(ctx))
+
+	before := model.DeviceNotification{
+		Origin:    "instance-1",
+		Tenant:    "acme",
+		DeviceIDs: []string{"device-0"},
+	}
+	assert.NoError(t, s.InsertDeviceNotification(ctx, before))
+
+	received := make(chan model.DeviceNotification, 10)
+	done := make(chan error)
+	go func() {
+		done <- s.WatchDeviceNotifications(ctx, func(n model.DeviceNotification) {
+			received <- n
+		})
+	}()
+
+	// give the watch time to skip the existing notifications
+	time.Sleep(500 * time.Millisecond)
+
+	after := model.DeviceNotification{
+		Origin:    "instance-1",
+		Tenant:    "acme",
+		DeviceIDs: []string{"device-1", "device-2"},
+	}
+	assert.NoError(t, s.InsertDeviceNotification(ctx, after))
+
+	select {
+	case n := <-received:
+		assert.Equal(t, after, n)
+	case <-time.After(10 * time.Second):
+		t.Fatal("notification not received")
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(2 * deviceNotificationsTailTimeout):
+		t.Fatal("watch not cancelled")
+	}
+
+	err := s.InsertDeviceNotification(context.Background(), model.DeviceNotification{})
+	assert.EqualError(t, err, ErrStorageInvalidInput.Error())
+}
+
+func TestDeviceNotificationsUnorderedIDs(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping TestDeviceNotificationsUnorderedIDs in short mode.")
+	}
+
+	db.Wipe()
+	s := NewDataStoreMongoWithSession(db.Session())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	assert.NoError(t, s.EnsureDeviceNotificationsCollection(ctx))
+	c := s.session.DB(DatabaseName).C(CollectionDeviceNotifications)
+
+	// sent before the watch, by an instance with the clock ahead
+	assert.NoError(t, c.Insert(deviceNotificationDoc{
+		ID: bson.NewObjectIdWithTime(time.Now().Add(time.Hour)),
+		DeviceNotification: model.DeviceNotification{
+			Origin:    "instance-1",
+			DeviceIDs: []string{"device-0"},
+		},
+	}))
+
+	received := make(chan model.DeviceNotification, 10)
+	done := make(chan error)
+	go func() {
+		done <- s.WatchDeviceNotifications(ctx, func(n model.DeviceNotification) {
+			received <- n
+		})
+	}()
+
+	// give the watch time to skip the existing notifications
+	time.Sleep(500 * time.Millisecond)
+
+	// sent by instances with the clock behind, and ahead
+	sent := []model.DeviceNotification{
+		{Origin: "instance-2", DeviceIDs: []string{"device-1"}},
+		{Origin: "instance-1", DeviceIDs: []string{"device-2"}},
+	}
+	ids := []bson.ObjectId{
+		bson.NewObjectIdWithTime(time.Now().Add(-time.Hour)),
+		bson.NewObjectIdWithTime(time.Now().Add(2 * time.Hour)),
+	}
+	for i := range sent {
+		assert.NoError(t, c.Insert(deviceNotificationDoc{
+			ID:                 ids[i],
+			DeviceNotification: sent[i],
+		}))
+	}
+
+	for _, expected := range sent {
+		select {
+		case n := <-received:
+			assert.Equal(t, expected, n)
+		case <-time.After(10 * time.Second):
+			t.Fatal("notification not received")
+		}
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(2 * deviceNotificationsTailTimeout):
+		t.Fatal("watch not cancelled")
+	}
+}
+
+func TestDeploymentGeneration(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping TestDeploymentGeneration in short mode.")
+	}
+
+	db.Wipe()
+	s := NewDataStoreMongoWithSession(db.Session())
+	ctx := context.Background()
+	tenantCtx := identity.WithContext(ctx, &identity.Identity{Tenant: "acme"})
+
+	generation, err := s.GetDeploymentGeneration(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), generation)
+
+	assert.NoError(t, s.IncrementDeploymentGeneration(ctx))
+	assert.NoError(t, s.IncrementDeploymentGeneration(ctx))
+	generation, err = s.GetDeploymentGeneration(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), generation)
+
+	// generations of tenants are independent
+	generation, err = s.GetDeploymentGeneration(tenantCtx)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), generation)
+}
+
+func TestInstalledBase(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping TestInstalledBase in short mode.")
+	}
+
+	db.Wipe()
+	s := NewDataStoreMongoWithSession(db.Session())
+	ctx := context.Background()
+	now := time.Now().UTC().Round(time.Millisecond)
+
+	for _, installed := range []model.InstalledArtifact{
+		{DeviceID: "device-1", ArtifactName: "release-1", DeviceType: "hammer"},
+		{DeviceID: "device-2", ArtifactName: "release-1", DeviceType: "hammer"},
+		{DeviceID: "device-3", ArtifactName: "release-1", DeviceType: "drill"},
+		{DeviceID: "device-4", ArtifactName: "release-2", DeviceType: "hammer"},
+		// device-1 was updated to release-2 afterwards
+		{DeviceID: "device-1", ArtifactName: "release-2", DeviceType: "hammer", Updated: now},
+	} {
+		installed := installed
+		assert.NoError(t, s.UpsertInstalledArtifact(ctx, &installed))
+	}
+
+	assert.Equal(t, ErrStorageInvalidID,
+		s.UpsertInstalledArtifact(ctx, &model.InstalledArtifact{ArtifactName: "release-1"}))
+
+	known, err := s.FindInstalledArtifact(ctx, "device-1")
+	assert.NoError(t, err)
+	assert.Equal(t, &model.InstalledArtifact{
+		DeviceID: "device-1", ArtifactName: "release-2", DeviceType: "hammer", Updated: now,
+	}, known)
+
+	known, err = s.FindInstalledArtifact(ctx, "device-5")
+	assert.NoError(t, err)
+	assert.Nil(t, known)
+
+	releases, err := s.AggregateInstalledBase(ctx, "")
+	assert.NoError(t, err)
+	assert.Equal(t, []model.InstalledBaseRelease{
+		{
+			ArtifactName: "release-1",
+			Count:        2,
+			Artifacts: []model.InstalledBaseArtifact{
+				{DeviceType: "drill", Count: 1},
+				{DeviceType: "hammer", Count: 1},
+			},
+		},
+		{
+			ArtifactName: "release-2",
+			Count:        2,
+			Artifacts: []model.InstalledBaseArtifact{
+				{DeviceType: "hammer", Count: 2},
+			},
+		},
+	}, releases)
+
+	releases, err = s.AggregateInstalledBase(ctx, "release-2")
+	assert.NoError(t, err)
+	assert.Len(t, releases, 1)
+	assert.Equal(t, "release-2", releases[0].ArtifactName)
+
+	releases, err = s.AggregateInstalledBase(ctx, "release-3")
+	assert.NoError(t, err)
+	assert.Empty(t, releases)
+
+	installed, total, err := s.GetInstalledArtifacts(ctx, model.InstalledBaseQuery{
+		ArtifactName: "release-2",
+		Limit:        1,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, total)
+	assert.Equal(t, []model.InstalledArtifact{
+		{DeviceID: "device-1", ArtifactName: "release-2", DeviceType: "hammer", Updated: now},
+	}, installed)
+
+	installed, total, err = s.GetInstalledArtifacts(ctx, model.InstalledBaseQuery{
+		ArtifactName: "release-1",
+		DeviceType:   "drill",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, total)
+	assert.Len(t, installed, 1)
+	assert.Equal(t, "device-3", installed[0].DeviceID)
+
+	_, _, err = s.GetInstalledArtifacts(ctx, model.InstalledBaseQuery{})
+	assert.Equal(t, ErrStorageInvalidInput, err)
+
+	// removing is idempotent
+	assert.NoError(t, s.DeleteInstalledArtifact(ctx, "device-1"))
+	assert.NoError(t, s.DeleteInstalledArtifact(ctx, "device-1"))
+	_, total, err = s.GetInstalledArtifacts(ctx, model.InstalledBaseQuery{
+		ArtifactName: "release-2",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, total)
+}
@@ -873,7 +873,8 @@ func TestDeploymentStorageUpdateStatsAndFinishDeployment(t *testing.T) {
 		InputStats      map[string]int
 		InputTenant     string
 
-		OutputError error
+		OutputError          error
+		OutputFinishedStatus string
 	}{
 		"all correct": {
 			InputID: "a108ae14-bb4e-455f-9b40-2ef4bab97bb7",
@@ -905,6 +906,57 @@ func TestDeploymentStorageUpdateStatsAndFinishDeployment(t *testing.T) {
 
 			OutputError: nil,
 		},
+		"finished, aborted": {
+			InputID: "a108ae14-bb4e-455f-9b40-2ef4bab97bb7",
+			InputDeployment: &model.Deployment{
+				Id: StringToPointer("a108ae14-bb4e-455f-9b40-2ef4bab97bb7"),
+				Stats: newTestStats(model.Stats{
+					model.DeviceDeploymentStatusDownloading: 2,
+					model.DeviceDeploymentStatusSuccess:     1,
+				}),
+				Aborted: true,
+			},
+			InputStats: newTestStats(model.Stats{
+				model.DeviceDeploymentStatusAborted: 2,
+				model.DeviceDeploymentStatusSuccess: 1,
+			}),
+
+			OutputError:          nil,
+			OutputFinishedStatus: model.DeploymentStatusAborted,
+		},
+		"finished, single device aborted": {
+			InputID: "a108ae14-bb4e-455f-9b40-2ef4bab97bb7",
+			InputDeployment: &model.Deployment{
+				Id: StringToPointer("a108ae14-bb4e-455f-9b40-2ef4bab97bb7"),
+				Stats: newTestStats(model.Stats{
+					model.DeviceDeploymentStatusDownloading: 1,
+					model.DeviceDeploymentStatusSuccess:     1,
+				}),
+			},
+			InputStats: newTestStats(model.Stats{
+				model.DeviceDeploymentStatusAborted: 1,
+				model.DeviceDeploymentStatusSuccess: 1,
+			}),
+
+			OutputError:          nil,
+			OutputFinishedStatus: model.DeploymentStatusSucceeded,
+		},
+		"finished, failed": {
+			InputID: "a108ae14-bb4e-455f-9b40-2ef4bab97bb7",
+			InputDeployment: &model.Deployment{
+				Id: StringToPointer("a108ae14-bb4e-455f-9b40-2ef4bab97bb7"),
+				Stats: newTestStats(model.Stats{
+					model.DeviceDeploymentStatusDownloading: 2,
+				}),
+			},
+			InputStats: newTestStats(model.Stats{
+				model.DeviceDeploymentStatusFailure:        1,
+				model.DeviceDeploymentStatusDecommissioned: 1,
+			}),
+
+			OutputError:          nil,
+			OutputFinishedStatus: model.DeploymentStatusFailed,
+		},
 		"invalid deployment id": {
 			InputID:         "",
 			InputDeployment: nil,
@@ -968,6 +1020,7 @@ func TestDeploymentStorageUpdateStatsAndFinishDeployment(t *testing.T) {
 					FindId(tc.InputID).One(&deployment)
 				assert.NoError(t, err)
 				assert.Equal(t, tc.InputStats, deployment.Stats)
+				assert.Equal(t, tc.OutputFinishedStatus, deployment.FinishedStatus)
 			}
 
 			if tc.InputTenant != "" && tc.InputDeployment != nil {
@@ -1481,14 +1534,28 @@ func TestDeploymentFinish(t *testing.T) {
 		InputDeployment *model.Deployment
 		InputTenant     string
 
-		OutputError error
+		OutputError          error
+		OutputFinishedStatus string
 	}{
 		"finished": {
 			InputID: "a108ae14-bb4e-455f-9b40-2ef4bab97bb7",
 			InputDeployment: &model.Deployment{
 				Id: StringToPointer("a108ae14-bb4e-455f-9b40-2ef4bab97bb7"),
 			},
-			OutputError: nil,
+			OutputError:          nil,
+			OutputFinishedStatus: model.DeploymentStatusSucceeded,
+		},
+		"finished, partial": {
+			InputID: "a108ae14-bb4e-455f-9b40-2ef4bab97bb7",
+			InputDeployment: &model.Deployment{
+				Id: StringToPointer("a108ae14-bb4e-455f-9b40-2ef4bab97bb7"),
+				Stats: newTestStats(model.Stats{
+					model.DeviceDeploymentStatusSuccess: 1,
+					model.DeviceDeploymentStatusFailure: 1,
+				}),
+			},
+			OutputError:          nil,
+			OutputFinishedStatus: model.DeploymentStatusPartial,
 		},
 		"nonexistent": {
 			InputID:     "a108ae14-bb4e-455f-9b40-2ef4bab97bb7",
@@ -1499,8 +1566,9 @@ func TestDeploymentFinish(t *testing.T) {
 			InputDeployment: &model.Deployment{
 				Id: StringToPointer("a108ae14-bb4e-455f-9b40-2ef4bab97bb7"),
 			},
-			InputTenant: "acme",
-			OutputError: nil,
+			InputTenant:          "acme",
+			OutputError:          nil,
+			OutputFinishedStatus: model.DeploymentStatusSucceeded,
 		},
 	}
 
@@ -1542,6 +1610,7 @@ func TestDeploymentFinish(t *testing.T) {
 					// 1s range
 					assert.WithinDuration(t, now, *deployment.Finished, time.Second)
 				}
+				assert.Equal(t, tc.OutputFinishedStatus, deployment.FinishedStatus)
 			}
 
 			if tc.InputTenant != "" {
@@ -1690,6 +1759,267 @@ func TestDeploymentFiltering(t *testing.T) {
 	}
 }
 
+func TestDeploymentFilteringByFinishedStatus(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping TestDeploymentFilteringByFinishedStatus in short mode.")
+	}
+
+	deployments := map[string]model.Stats{
+		"a108ae14-bb4e-455f-9b40-000000000001": {
+			model.DeviceDeploymentStatusSuccess: 2,
+		},
+		"a108ae14-bb4e-455f-9b40-000000000002": {
+			model.DeviceDeploymentStatusFailure: 2,
+		},
+		"a108ae14-bb4e-455f-9b40-000000000003": {
+			model.DeviceDeploymentStatusSuccess: 1,
+			model.DeviceDeploymentStatusFailure: 1,
+		},
+		"a108ae14-bb4e-455f-9b40-000000000004": {
+			model.DeviceDeploymentStatusSuccess: 1,
+			model.DeviceDeploymentStatusAborted: 1,
+		},
+	}
+
+	testCases := map[model.StatusQuery]string{
+		model.StatusQuerySucceeded: "a108ae14-bb4e-455f-9b40-000000000001",
+		model.StatusQueryFailed:    "a108ae14-bb4e-455f-9b40-000000000002",
+		model.StatusQueryPartial:   "a108ae14-bb4e-455f-9b40-000000000003",
+		model.StatusQueryAborted:   "a108ae14-bb4e-455f-9b40-000000000004",
+	}
+
+	db.Wipe()
+	session := db.Session()
+	defer session.Close()
+	store := NewDataStoreMongoWithSession(session)
+
+	ctx := context.Background()
+
+	for id, stats := range deployments {
+		assert.NoError(t, session.DB(ctxstore.DbFromContext(ctx, DatabaseName)).
+			C(CollectionDeployments).Insert(&model.Deployment{
+			Id:      StringToPointer(id),
+			Created: TimePtr(time.Now()),
+			Stats:   newTestStats(stats),
+		}))
+		assert.NoError(t, store.Finish(ctx, id, time.Now()))
+	}
+
+	for status, id := range testCases {
+		t.Run(fmt.Sprintf("status %d", status), func(t *testing.T) {
+			deps, err := store.Find(ctx, model.Query{Status: status})
+			assert.NoError(t, err)
+			if assert.Len(t, deps, 1) {
+				assert.Equal(t, id, *deps[0].Id)
+			}
+		})
+	}
+}
+
+func TestDeploymentLabels(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping TestDeploymentLabels in short mode.")
+	}
+
+	db.Wipe()
+	session := db.Session()
+	defer session.Close()
+	store := NewDataStoreMongoWithSession(session)
+
+	ctx := context.Background()
+
+	for _, id := range []string{
+		"a108ae14-bb4e-455f-9b40-000000000001",
+		"a108ae14-bb4e-455f-9b40-000000000002",
+	} {
+		assert.NoError(t, session.DB(ctxstore.DbFromContext(ctx, DatabaseName)).
+			C(CollectionDeployments).Insert(&model.Deployment{
+			DeploymentConstructor: &model.DeploymentConstructor{
+				Name:         StringToPointer("foo"),
+				ArtifactName: StringToPointer("bar"),
+			},
+			Id:      StringToPointer(id),
+			Created: TimePtr(time.Now()),
+		}))
+	}
+
+	err := store.UpdateDeploymentMetadata(ctx, "a108ae14-bb4e-455f-9b40-000000000001",
+		model.DeploymentMetadataConstructor{
+			Description: "monthly update",
+			Labels: []model.DeploymentLabel{
+				{Key: "ticket", Value: "OPS-123"},
+				{Key: "team", Value: "core"},
+			},
+		})
+	assert.NoError(t, err)
+
+	err = store.UpdateDeploymentMetadata(ctx, "a108ae14-bb4e-455f-9b40-000000000002",
+		model.DeploymentMetadataConstructor{
+			Labels: []model.DeploymentLabel{
+				{Key: "ticket", Value: "OPS-124"},
+			},
+		})
+	assert.NoError(t, err)
+
+	err = store.UpdateDeploymentMetadata(ctx, "a108ae14-bb4e-455f-9b40-000000000003",
+		model.DeploymentMetadataConstructor{})
+	assert.EqualError(t, err, ErrStorageNotFound.Error())
+
+	dep, err := store.FindDeploymentByID(ctx, "a108ae14-bb4e-455f-9b40-000000000001")
+	assert.NoError(t, err)
+	assert.Equal(t, "monthly update", dep.Description)
+	assert.Equal(t, "foo", *dep.Name)
+
+	testCases := map[string]struct {
+		labels []model.DeploymentLabel
+		count  int
+	}{
+		"key and value": {
+			labels: []model.DeploymentLabel{{Key: "ticket", Value: "OPS-123"}},
+			count:  1,
+		},
+		"key only": {
+			labels: []model.DeploymentLabel{{Key: "ticket"}},
+			count:  2,
+		},
+		"all labels must match": {
+			labels: []model.DeploymentLabel{
+				{Key: "ticket", Value: "OPS-124"},
+				{Key: "team", Value: "core"},
+			},
+			count: 0,
+		},
+		"value of other key": {
+			labels: []model.DeploymentLabel{{Key: "team", Value: "OPS-123"}},
+			count:  0,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			deps, err := store.Find(ctx, model.Query{Labels: tc.labels})
+			assert.NoError(t, err)
+			assert.Len(t, deps, tc.count)
+		})
+	}
+}
+
+func TestDeploymentSortingAndCounting(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping TestDeploymentSortingAndCounting in short mode.")
+	}
+
+	now := time.Now().UTC()
+
+	// finished, inprogress, pending
+	deployments := []*model.Deployment{
+		{
+			DeploymentConstructor: &model.DeploymentConstructor{
+				Name:         StringToPointer("b"),
+				ArtifactName: StringToPointer("App 123"),
+			},
+			Id:      StringToPointer("a108ae14-bb4e-455f-9b40-000000000001"),
+			Created: TimePtr(now.Add(-3 * time.Hour)),
+			Stats: newTestStats(model.Stats{
+				model.DeviceDeploymentStatusSuccess: 1,
+			}),
+		},
+		{
+			DeploymentConstructor: &model.DeploymentConstructor{
+				Name:         StringToPointer("c"),
+				ArtifactName: StringToPointer("App 123"),
+			},
+			Id:      StringToPointer("a108ae14-bb4e-455f-9b40-000000000002"),
+			Created: TimePtr(now.Add(-2 * time.Hour)),
+			Stats: newTestStats(model.Stats{
+				model.DeviceDeploymentStatusPending:     1,
+				model.DeviceDeploymentStatusDownloading: 1,
+			}),
+		},
+		{
+			DeploymentConstructor: &model.DeploymentConstructor{
+				Name:         StringToPointer("a"),
+				ArtifactName: StringToPointer("App 123"),
+			},
+			Id:      StringToPointer("a108ae14-bb4e-455f-9b40-000000000003"),
+			Created: TimePtr(now.Add(-1 * time.Hour)),
+			Stats: newTestStats(model.Stats{
+				model.DeviceDeploymentStatusPending: 2,
+			}),
+		},
+	}
+
+	testCases := map[string]struct {
+		query model.Query
+
+		ids   []string
+		total int
+	}{
+		"default": {
+			ids:   []string{"3", "2", "1"},
+			total: 3,
+		},
+		"created": {
+			query: model.Query{SortBy: model.DeploymentsSortCreated},
+			ids:   []string{"1", "2", "3"},
+			total: 3,
+		},
+		"name, descending, paged": {
+			query: model.Query{
+				SortBy:         model.DeploymentsSortName,
+				SortDescending: true,
+				Skip:           1,
+				Limit:          1,
+			},
+			ids:   []string{"1"},
+			total: 3,
+		},
+		"status": {
+			query: model.Query{SortBy: model.DeploymentsSortStatus},
+			ids:   []string{"3", "2", "1"},
+			total: 3,
+		},
+		"status, descending, filtered": {
+			query: model.Query{
+				SortBy:         model.DeploymentsSortStatus,
+				SortDescending: true,
+				CreatedAfter:   TimePtr(now.Add(-150 * time.Minute)),
+			},
+			ids:   []string{"2", "3"},
+			total: 2,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			db.Wipe()
+			session := db.Session()
+			defer session.Close()
+			store := NewDataStoreMongoWithSession(session)
+
+			ctx := context.Background()
+
+			for _, d := range deployments {
+				assert.NoError(t, session.DB(ctxstore.DbFromContext(ctx, DatabaseName)).
+					C(CollectionDeployments).Insert(d))
+			}
+
+			deps, err := store.Find(ctx, tc.query)
+			assert.NoError(t, err)
+
+			ids := make([]string, 0, len(deps))
+			for _, d := range deps {
+				ids = append(ids, (*d.Id)[len(*d.Id)-1:])
+			}
+			assert.Equal(t, tc.ids, ids)
+
+			total, err := store.CountDeployments(ctx, tc.query)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.total, total)
+		})
+	}
+}
+
 func TestDeviceDeploymentCounting(t *testing.T) {
 	testCases := []struct {
 		InputDeploymentID     string
@@ -1756,3 +2086,601 @@ func TestDeviceDeploymentCounting(t *testing.T) {
 		})
 	}
 }
+
+func TestDeploymentStorageIncrementPendingStats(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping TestDeploymentStorageIncrementPendingStats in short mode.")
+	}
+
+	testCases := map[string]struct {
+		InputID         string
+		InputDeployment *model.Deployment
+		InputCount      int
+
+		OutputError       error
+		OutputPending     int
+		OutputDeviceCount int
+	}{
+		"ok": {
+			InputID: "a108ae14-bb4e-455f-9b40-2ef4bab97bb7",
+			InputDeployment: &model.Deployment{
+				Id: StringToPointer("a108ae14-bb4e-455f-9b40-2ef4bab97bb7"),
+				Stats: map[string]int{
+					model.DeviceDeploymentStatusPending: 10,
+				},
+				DeviceCount: 10,
+			},
+			InputCount: 5,
+
+			OutputPending:     15,
+			OutputDeviceCount: 15,
+		},
+		"finished": {
+			InputID: "a108ae14-bb4e-455f-9b40-2ef4bab97bb7",
+			InputDeployment: &model.Deployment{
+				Id:       StringToPointer("a108ae14-bb4e-455f-9b40-2ef4bab97bb7"),
+				Finished: TimePtr(time.Now()),
+				Stats: map[string]int{
+					model.DeviceDeploymentStatusPending: 0,
+				},
+			},
+			InputCount: 5,
+
+			OutputError: ErrStorageNotFound,
+		},
+		"nonexistent": {
+			InputID:    "a108ae14-bb4e-455f-9b40-2ef4bab97bb7",
+			InputCount: 5,
+
+			OutputError: ErrStorageNotFound,
+		},
+		"invalid deployment id": {
+			InputID:    "",
+			InputCount: 5,
+
+			OutputError: ErrStorageInvalidID,
+		},
+	}
+
+	for testCaseName, tc := range testCases {
+		t.Run(fmt.Sprintf("test case %s", testCaseName), func(t *testing.T) {
+
+			db.Wipe()
+
+			session := db.Session()
+			store := NewDataStoreMongoWithSession(session)
+			defer session.Close()
+
+			ctx := context.Background()
+
+			if tc.InputDeployment != nil {
+				assert.NoError(t, session.DB(ctxstore.DbFromContext(ctx, DatabaseName)).
+					C(CollectionDeployments).Insert(tc.InputDeployment))
+			}
+
+			err := store.IncrementPendingStats(ctx, tc.InputID, tc.InputCount)
+
+			if tc.OutputError != nil {
+				assert.EqualError(t, err, tc.OutputError.Error())
+			} else {
+				assert.NoError(t, err)
+
+				var deployment *model.Deployment
+				err := session.DB(ctxstore.DbFromContext(ctx, DatabaseName)).
+					C(CollectionDeployments).
+					FindId(tc.InputID).One(&deployment)
+				assert.NoError(t, err)
+				assert.Equal(t, tc.OutputPending,
+					deployment.Stats[model.DeviceDeploymentStatusPending])
+				assert.Equal(t, tc.OutputDeviceCount, deployment.DeviceCount)
+			}
+		})
+	}
+}
+
+func TestDeploymentStorageUpdateStatsWithinLimit(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping TestDeploymentStorageUpdateStatsWithinLimit in short mode.")
+	}
+
+	testCases := map[string]struct {
+		InputID         string
+		InputDeployment *model.Deployment
+		InputStateFrom  string
+		InputStateTo    string
+		InputLimit      int
+
+		OutputError   error
+		OutputClaimed bool
+		OutputStats   map[string]int
+	}{
+		"ok": {
+			InputID: "a108ae14-bb4e-455f-9b40-2ef4bab97bb7",
+			InputDeployment: &model.Deployment{
+				Id: StringToPointer("a108ae14-bb4e-455f-9b40-2ef4bab97bb7"),
+				Stats: map[string]int{
+					model.DeviceDeploymentStatusPending:     5,
+					model.DeviceDeploymentStatusDownloading: 1,
+					model.DeviceDeploymentStatusInstalling:  0,
+					model.DeviceDeploymentStatusRebooting:   0,
+				},
+			},
+			InputStateFrom: model.DeviceDeploymentStatusPending,
+			InputStateTo:   model.DeviceDeploymentStatusDownloading,
+			InputLimit:     2,
+
+			OutputClaimed: true,
+			OutputStats: map[string]int{
+				model.DeviceDeploymentStatusPending:     4,
+				model.DeviceDeploymentStatusDownloading: 2,
+			},
+		},
+		"limit reached": {
+			InputID: "a108ae14-bb4e-455f-9b40-2ef4bab97bb7",
+			InputDeployment: &model.Deployment{
+				Id: StringToPointer("a108ae14-bb4e-455f-9b40-2ef4bab97bb7"),
+				Stats: map[string]int{
+					model.DeviceDeploymentStatusPending:     5,
+					model.DeviceDeploymentStatusDownloading: 1,
+					model.DeviceDeploymentStatusInstalling:  0,
+					model.DeviceDeploymentStatusRebooting:   1,
+				},
+			},
+			InputStateFrom: model.DeviceDeploymentStatusPending,
+			InputStateTo:   model.DeviceDeploymentStatusDownloading,
+			InputLimit:     2,
+
+			OutputClaimed: false,
+			OutputStats: map[string]int{
+				model.DeviceDeploymentStatusPending:     5,
+				model.DeviceDeploymentStatusDownloading: 1,
+			},
+		},
+		"limit reached, paused": {
+			InputID: "a108ae14-bb4e-455f-9b40-2ef4bab97bb7",
+			InputDeployment: &model.Deployment{
+				Id: StringToPointer("a108ae14-bb4e-455f-9b40-2ef4bab97bb7"),
+				Stats: map[string]int{
+					model.DeviceDeploymentStatusPending:           5,
+					model.DeviceDeploymentStatusDownloading:       1,
+					model.DeviceDeploymentStatusPauseBeforeReboot: 1,
+				},
+			},
+			InputStateFrom: model.DeviceDeploymentStatusPending,
+			InputStateTo:   model.DeviceDeploymentStatusDownloading,
+			InputLimit:     2,
+
+			OutputClaimed: false,
+			OutputStats: map[string]int{
+				model.DeviceDeploymentStatusPending:     5,
+				model.DeviceDeploymentStatusDownloading: 1,
+			},
+		},
+		"nonexistent": {
+			InputID:        "a108ae14-bb4e-455f-9b40-2ef4bab97bb7",
+			InputStateFrom: model.DeviceDeploymentStatusPending,
+			InputStateTo:   model.DeviceDeploymentStatusDownloading,
+			InputLimit:     2,
+
+			OutputClaimed: false,
+		},
+		"invalid deployment id": {
+			InputID:        "",
+			InputStateFrom: model.DeviceDeploymentStatusPending,
+			InputStateTo:   model.DeviceDeploymentStatusDownloading,
+			InputLimit:     2,
+
+			OutputError: ErrStorageInvalidID,
+		},
+		"invalid state": {
+			InputID:        "a108ae14-bb4e-455f-9b40-2ef4bab97bb7",
+			InputStateFrom: "",
+			InputStateTo:   model.DeviceDeploymentStatusDownloading,
+			InputLimit:     2,
+
+			OutputError: ErrStorageInvalidInput,
+		},
+	}
+
+	for testCaseName, tc := range testCases {
+		t.Run(fmt.Sprintf("test case %s", testCaseName), func(t *testing.T) {
+
+			db.Wipe()
+
+			session := db.Session()
+			store := NewDataStoreMongoWithSession(session)
+			defer session.Close()
+
+			ctx := context.Background()
+
+			if tc.InputDeployment != nil {
+				assert.NoError(t, session.DB(ctxstore.DbFromContext(ctx, DatabaseName)).
+					C(CollectionDeployments).Insert(tc.InputDeployment))
+			}
+
+			claimed, err := store.UpdateStatsWithinLimit(ctx, tc.InputID,
+				tc.InputStateFrom, tc.InputStateTo, tc.InputLimit)
+
+			if tc.OutputError != nil {
+				assert.EqualError(t, err, tc.OutputError.Error())
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.OutputClaimed, claimed)
+
+				if tc.OutputStats != nil {
+					var deployment *model.Deployment
+					err := session.DB(ctxstore.DbFromContext(ctx, DatabaseName)).
+						C(CollectionDeployments).
+						FindId(tc.InputID).One(&deployment)
+					assert.NoError(t, err)
+					for status, count := range tc.OutputStats {
+						assert.Equal(t, count, deployment.Stats[status])
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestDeploymentStorageFindDependentDeployments(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping TestDeploymentStorageFindDependentDeployments in short mode.")
+	}
+
+	now := time.Now()
+	predecessorID := "a108ae14-bb4e-455f-9b40-2ef4bab97bb7"
+
+	deployments := []*model.Deployment{
+		{
+			Id:      StringToPointer(predecessorID),
+			Created: TimePtr(now),
+			DeploymentConstructor: &model.DeploymentConstructor{
+				Name: StringToPointer("bootloader"),
+			},
+		},
+		{
+			Id:      StringToPointer("9ec6a9d2-6c5c-4d8e-9d3e-50c1b0b2fbd3"),
+			Created: TimePtr(now.Add(2 * time.Minute)),
+			DeploymentConstructor: &model.DeploymentConstructor{
+				Name:      StringToPointer("application"),
+				DependsOn: []string{predecessorID, "5b5b1a5e-b2e9-4b8c-8b4f-0bc1ef0b9d0f"},
+			},
+		},
+		{
+			Id:      StringToPointer("5b5b1a5e-b2e9-4b8c-8b4f-0bc1ef0b9d0f"),
+			Created: TimePtr(now.Add(time.Minute)),
+			DeploymentConstructor: &model.DeploymentConstructor{
+				Name:      StringToPointer("os"),
+				DependsOn: []string{predecessorID},
+			},
+		},
+	}
+
+	testCases := map[string]struct {
+		InputID string
+
+		OutputError error
+		OutputNames []string
+	}{
+		"two dependents": {
+			InputID:     predecessorID,
+			OutputNames: []string{"os", "application"},
+		},
+		"one dependent": {
+			InputID:     "5b5b1a5e-b2e9-4b8c-8b4f-0bc1ef0b9d0f",
+			OutputNames: []string{"application"},
+		},
+		"no dependents": {
+			InputID: "9ec6a9d2-6c5c-4d8e-9d3e-50c1b0b2fbd3",
+		},
+		"invalid deployment id": {
+			InputID:     "",
+			OutputError: ErrStorageInvalidID,
+		},
+	}
+
+	for testCaseName, tc := range testCases {
+		t.Run(fmt.Sprintf("test case %s", testCaseName), func(t *testing.T) {
+
+			db.Wipe()
+
+			session := db.Session()
+			store := NewDataStoreMongoWithSession(session)
+			defer session.Close()
+
+			ctx := context.Background()
+
+			for _, d := range deployments {
+				assert.NoError(t, session.DB(ctxstore.DbFromContext(ctx, DatabaseName)).
+					C(CollectionDeployments).Insert(d))
+			}
+
+			dependents, err := store.FindDependentDeployments(ctx, tc.InputID)
+
+			if tc.OutputError != nil {
+				assert.EqualError(t, err, tc.OutputError.Error())
+			} else {
+				assert.NoError(t, err)
+
+				var names []string
+				for _, d := range dependents {
+					names = append(names, *d.Name)
+				}
+				assert.Equal(t, tc.OutputNames, names)
+			}
+		})
+	}
+}
+
+func TestDeploymentStorageFindUnfinishedWithTimeouts(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping TestDeploymentStorageFindUnfinishedWithTimeouts in short mode.")
+	}
+
+	now := time.Now()
+
+	deployments := []*model.Deployment{
+		{
+			Id:      StringToPointer("a108ae14-bb4e-455f-9b40-2ef4bab97bb7"),
+			Created: TimePtr(now),
+			DeploymentConstructor: &model.DeploymentConstructor{
+				Name:     StringToPointer("with timeouts"),
+				Timeouts: &model.DeploymentTimeouts{Downloading: 3600},
+			},
+		},
+		{
+			Id:       StringToPointer("9ec6a9d2-6c5c-4d8e-9d3e-50c1b0b2fbd3"),
+			Created:  TimePtr(now),
+			Finished: TimePtr(now),
+			DeploymentConstructor: &model.DeploymentConstructor{
+				Name:     StringToPointer("finished with timeouts"),
+				Timeouts: &model.DeploymentTimeouts{Downloading: 3600},
+			},
+		},
+		{
+			Id:      StringToPointer("5b5b1a5e-b2e9-4b8c-8b4f-0bc1ef0b9d0f"),
+			Created: TimePtr(now),
+			DeploymentConstructor: &model.DeploymentConstructor{
+				Name: StringToPointer("without timeouts"),
+			},
+		},
+	}
+
+	db.Wipe()
+
+	session := db.Session()
+	store := NewDataStoreMongoWithSession(session)
+	defer session.Close()
+
+	ctx := context.Background()
+
+	for _, d := range deployments {
+		assert.NoError(t, session.DB(ctxstore.DbFromContext(ctx, DatabaseName)).
+			C(CollectionDeployments).Insert(d))
+	}
+
+	found, err := store.FindUnfinishedWithTimeouts(ctx)
+	assert.NoError(t, err)
+	if assert.Len(t, found, 1) {
+		assert.Equal(t, "with timeouts", *found[0].Name)
+		assert.Equal(t, 3600, found[0].Timeouts.Downloading)
+	}
+}
+
+func TestUpdateDeploymentApproval(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping TestUpdateDeploymentApproval in short mode.")
+	}
+
+	db.Wipe()
+	session := db.Session()
+	defer session.Close()
+	store := NewDataStoreMongoWithSession(session)
+
+	ctx := context.Background()
+
+	id := "a108ae14-bb4e-455f-9b40-000000000001"
+	assert.NoError(t, session.DB(ctxstore.DbFromContext(ctx, DatabaseName)).
+		C(CollectionDeployments).Insert(&model.Deployment{
+		DeploymentConstructor: &model.DeploymentConstructor{
+			Name:         StringToPointer("foo"),
+			ArtifactName: StringToPointer("bar"),
+		},
+		Id:      StringToPointer(id),
+		Created: TimePtr(time.Now()),
+		Approval: &model.DeploymentApproval{
+			Status:      model.ApprovalStatusAwaiting,
+			RequestedBy: "user-1",
+		},
+	}))
+
+	query := model.Query{Status: model.StatusQueryAwaitingApproval}
+	deps, err := store.Find(ctx, query)
+	assert.NoError(t, err)
+	assert.Len(t, deps, 1)
+
+	deps, err = store.Find(ctx, model.Query{Status: model.StatusQueryPending})
+	assert.NoError(t, err)
+	assert.Len(t, deps, 0)
+
+	now := time.Now()
+	approval := model.DeploymentApproval{
+		Status:      model.ApprovalStatusApproved,
+		RequestedBy: "user-1",
+		DecidedBy:   "user-2",
+		Decided:     &now,
+	}
+	assert.NoError(t, store.UpdateDeploymentApproval(ctx, id, approval))
+
+	dep, err := store.FindDeploymentByID(ctx, id)
+	assert.NoError(t, err)
+	assert.Equal(t, model.ApprovalStatusApproved, dep.Approval.Status)
+	assert.Equal(t, "user-2", dep.Approval.DecidedBy)
+
+	deps, err = store.Find(ctx, query)
+	assert.NoError(t, err)
+	assert.Len(t, deps, 0)
+
+	// the decision can be made only once
+	err = store.UpdateDeploymentApproval(ctx, id, approval)
+	assert.EqualError(t, err, ErrStorageNotFound.Error())
+
+	err = store.UpdateDeploymentApproval(ctx, "", approval)
+	assert.EqualError(t, err, ErrStorageInvalidID.Error())
+}
+
+func TestAddDeploymentContinued(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping TestAddDeploymentContinued in short mode.")
+	}
+
+	db.Wipe()
+	session := db.Session()
+	defer session.Close()
+	store := NewDataStoreMongoWithSession(session)
+
+	ctx := context.Background()
+
+	id := "a108ae14-bb4e-455f-9b40-000000000001"
+	assert.NoError(t, session.DB(ctxstore.DbFromContext(ctx, DatabaseName)).
+		C(CollectionDeployments).Insert(&model.Deployment{
+		DeploymentConstructor: &model.DeploymentConstructor{
+			Name:         StringToPointer("foo"),
+			ArtifactName: StringToPointer("bar"),
+			PauseBefore:  []string{model.PausePointInstall, model.PausePointReboot},
+		},
+		Id:      StringToPointer(id),
+		Created: TimePtr(time.Now()),
+	}))
+
+	assert.NoError(t, store.AddDeploymentContinued(ctx, id, model.PausePointReboot))
+	// continuing twice is noop
+	assert.NoError(t, store.AddDeploymentContinued(ctx, id, model.PausePointReboot))
+	assert.NoError(t, store.AddDeploymentContinued(ctx, id, model.PausePointInstall))
+
+	dep, err := store.FindDeploymentByID(ctx, id)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{model.PausePointReboot, model.PausePointInstall}, dep.Continued)
+	assert.Equal(t, []string{model.PausePointInstall, model.PausePointReboot}, dep.PauseBefore)
+
+	err = store.AddDeploymentContinued(ctx, "a108ae14-bb4e-455f-9b40-000000000002",
+		model.PausePointReboot)
+	assert.EqualError(t, err, ErrStorageNotFound.Error())
+
+	err = store.AddDeploymentContinued(ctx, "", model.PausePointReboot)
+	assert.EqualError(t, err, ErrStorageInvalidID.Error())
+
+	err = store.AddDeploymentContinued(ctx, id, "")
+	assert.EqualError(t, err, ErrStorageInvalidInput.Error())
+}
+
+func TestSetDeploymentAborted(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping TestSetDeploymentAborted in short mode.")
+	}
+
+	db.Wipe()
+	session := db.Session()
+	defer session.Close()
+	store := NewDataStoreMongoWithSession(session)
+
+	ctx := context.Background()
+
+	id := "a108ae14-bb4e-455f-9b40-000000000001"
+	assert.NoError(t, session.DB(ctxstore.DbFromContext(ctx, DatabaseName)).
+		C(CollectionDeployments).Insert(&model.Deployment{
+		DeploymentConstructor: &model.DeploymentConstructor{
+			Name:         StringToPointer("foo"),
+			ArtifactName: StringToPointer("bar"),
+		},
+		Id:      StringToPointer(id),
+		Created: TimePtr(time.Now()),
+		Stats:   newTestStats(model.Stats{model.DeviceDeploymentStatusPending: 1}),
+	}))
+
+	dep, err := store.FindDeploymentByID(ctx, id)
+	assert.NoError(t, err)
+	assert.False(t, dep.IsAborted())
+
+	assert.NoError(t, store.SetDeploymentAborted(ctx, id))
+
+	dep, err = store.FindDeploymentByID(ctx, id)
+	assert.NoError(t, err)
+	assert.True(t, dep.IsAborted())
+
+	// finishing keeps the deployment aborted
+	assert.NoError(t, store.Finish(ctx, id, time.Now()))
+	dep, err = store.FindDeploymentByID(ctx, id)
+	assert.NoError(t, err)
+	assert.Equal(t, model.DeploymentStatusAborted, dep.FinishedStatus)
+
+	err = store.SetDeploymentAborted(ctx, "a108ae14-bb4e-455f-9b40-000000000002")
+	assert.EqualError(t, err, ErrStorageNotFound.Error())
+
+	err = store.SetDeploymentAborted(ctx, "")
+	assert.EqualError(t, err, ErrStorageInvalidID.Error())
+}
+
+func TestDeploymentsWithExpiredDevices(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping TestDeploymentsWithExpiredDevices in short mode.")
+	}
+
+	db.Wipe()
+	session := db.Session()
+	defer session.Close()
+	store := NewDataStoreMongoWithSession(session)
+
+	ctx := context.Background()
+
+	now := time.Now()
+	oldID := "a108ae14-bb4e-455f-9b40-000000000001"
+	newID := "a108ae14-bb4e-455f-9b40-000000000002"
+	for id, finished := range map[string]time.Time{
+		oldID: now.AddDate(0, -3, 0),
+		newID: now,
+	} {
+		assert.NoError(t, session.DB(ctxstore.DbFromContext(ctx, DatabaseName)).
+			C(CollectionDeployments).Insert(&model.Deployment{
+			DeploymentConstructor: &model.DeploymentConstructor{
+				Name:         StringToPointer("foo"),
+				ArtifactName: StringToPointer("bar"),
+			},
+			Id:       StringToPointer(id),
+			Created:  TimePtr(finished.Add(-time.Hour)),
+			Finished: TimePtr(finished),
+		}))
+
+		dd, err := model.NewDeviceDeployment("device-1", id)
+		assert.NoError(t, err)
+		assert.NoError(t, store.InsertMany(ctx, dd))
+	}
+
+	deps, err := store.FindDeploymentsWithExpiredDevices(ctx, now.AddDate(0, -1, 0), 10)
+	assert.NoError(t, err)
+	if assert.Len(t, deps, 1) {
+		assert.Equal(t, oldID, *deps[0].Id)
+	}
+
+	assert.NoError(t, store.DeleteDeviceDeploymentsForDeployment(ctx, oldID))
+
+	dep, err := store.FindDeploymentByID(ctx, oldID)
+	assert.NoError(t, err)
+	assert.True(t, dep.DevicesRemoved)
+
+	devices, err := store.GetDeviceStatusesForDeployment(ctx, oldID)
+	assert.NoError(t, err)
+	assert.Len(t, devices, 0)
+
+	devices, err = store.GetDeviceStatusesForDeployment(ctx, newID)
+	assert.NoError(t, err)
+	assert.Len(t, devices, 1)
+
+	deps, err = store.FindDeploymentsWithExpiredDevices(ctx, now.AddDate(0, -1, 0), 10)
+	assert.NoError(t, err)
+	assert.Len(t, deps, 0)
+
+	err = store.DeleteDeviceDeploymentsForDeployment(ctx,
+		"a108ae14-bb4e-455f-9b40-000000000003")
+	assert.EqualError(t, err, ErrStorageNotFound.Error())
+}
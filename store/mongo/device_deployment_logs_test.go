@@ -293,3 +293,272 @@ func TestGetDeviceDeploymentLog(t *testing.T) {
 
 	db.Wipe()
 }
+
+func TestAppendDeviceDeploymentLog(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping TestAppendDeviceDeploymentLog in short mode.")
+	}
+
+	db.Wipe()
+	session := db.Session()
+	defer session.Close()
+	store := NewDataStoreMongoWithSession(session)
+
+	ctx := context.Background()
+
+	deviceID := "123"
+	deploymentID := "30b3e62c-9ec2-4312-a7fa-cff24cc7397a"
+	newLog := func(messages ...model.LogMessage) model.DeploymentLog {
+		return model.DeploymentLog{
+			DeviceID:     deviceID,
+			DeploymentID: deploymentID,
+			Messages:     messages,
+		}
+	}
+
+	t1 := parseTime(t, "2006-01-02T15:04:05-07:00")
+	t2 := parseTime(t, "2006-01-02T15:04:06-07:00")
+	t3 := parseTime(t, "2006-01-02T15:04:07-07:00")
+	t4 := parseTime(t, "2006-01-02T15:04:08-07:00")
+
+	// first upload creates the log
+	err := store.AppendDeviceDeploymentLog(ctx, newLog(
+		model.LogMessage{Timestamp: t2, Level: "info", Message: "installing"},
+	))
+	assert.NoError(t, err)
+
+	// retried upload with an earlier line and a duplicate
+	err = store.AppendDeviceDeploymentLog(ctx, newLog(
+		model.LogMessage{Timestamp: t1, Level: "info", Message: "downloading"},
+		model.LogMessage{Timestamp: t2, Level: "info", Message: "installing"},
+		model.LogMessage{Timestamp: t3, Level: "error", Message: "install failed"},
+	))
+	assert.NoError(t, err)
+
+	// nothing new: messages are identified by timestamp and text only,
+	// also within the upload
+	err = store.AppendDeviceDeploymentLog(ctx, newLog(
+		model.LogMessage{Timestamp: t3, Level: "error", Message: "install failed"},
+		model.LogMessage{Timestamp: t3, Level: "info", Message: "install failed"},
+	))
+	assert.NoError(t, err)
+
+	// new message, repeated within the upload
+	err = store.AppendDeviceDeploymentLog(ctx, newLog(
+		model.LogMessage{Timestamp: t4, Level: "info", Message: "retrying"},
+		model.LogMessage{Timestamp: t4, Level: "info", Message: "retrying"},
+	))
+	assert.NoError(t, err)
+
+	dlog, err := store.GetDeviceDeploymentLog(ctx, deviceID, deploymentID)
+	assert.NoError(t, err)
+	if assert.NotNil(t, dlog) && assert.Len(t, dlog.Messages, 4) {
+		assert.Equal(t, "downloading", dlog.Messages[0].Message)
+		assert.Equal(t, "installing", dlog.Messages[1].Message)
+		assert.Equal(t, "install failed", dlog.Messages[2].Message)
+		assert.Equal(t, "error", dlog.Messages[2].Level)
+		assert.Equal(t, "retrying", dlog.Messages[3].Message)
+	}
+
+	// the oldest messages are dropped over the size limit
+	messages := make([]model.LogMessage, model.MaxDeploymentLogMessages)
+	for i := range messages {
+		ts := t4.Add(time.Duration(i+1) * time.Second)
+		messages[i] = model.LogMessage{Timestamp: &ts, Level: "info", Message: "line"}
+	}
+	err = store.AppendDeviceDeploymentLog(ctx, newLog(messages...))
+	assert.NoError(t, err)
+
+	dlog, err = store.GetDeviceDeploymentLog(ctx, deviceID, deploymentID)
+	assert.NoError(t, err)
+	if assert.NotNil(t, dlog) && assert.Len(t, dlog.Messages, model.MaxDeploymentLogMessages) {
+		assert.True(t, dlog.Messages[0].Timestamp.Equal(*messages[0].Timestamp))
+	}
+
+	err = store.AppendDeviceDeploymentLog(ctx, newLog())
+	assert.Error(t, err)
+}
+
+func TestSearchDeviceDeploymentLogs(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping TestSearchDeviceDeploymentLogs in short mode.")
+	}
+
+	db.Wipe()
+	session := db.Session()
+	defer session.Close()
+	store := NewDataStoreMongoWithSession(session)
+
+	assert.NoError(t, store.DoEnsureDeviceDeploymentLogsIndexing(DatabaseName, session))
+
+	ctx := context.Background()
+
+	dep1 := "30b3e62c-9ec2-4312-a7fa-cff24cc7397a"
+	dep2 := "30b3e62c-9ec2-4312-a7fa-cff24cc7397b"
+	t1 := parseTime(t, "2019-01-01T10:00:00Z")
+	t2 := parseTime(t, "2019-01-02T10:00:00Z")
+
+	logs := []model.DeploymentLog{
+		{
+			DeviceID:     "device-1",
+			DeploymentID: dep1,
+			Messages: []model.LogMessage{
+				{Timestamp: t1, Level: "info", Message: "installing update"},
+				{Timestamp: t1, Level: "error", Message: "no space left on device"},
+			},
+		},
+		{
+			DeviceID:     "device-2",
+			DeploymentID: dep1,
+			Messages: []model.LogMessage{
+				{Timestamp: t1, Level: "info", Message: "installing update"},
+				{Timestamp: t1, Level: "error", Message: "checksum mismatch"},
+			},
+		},
+		{
+			DeviceID:     "device-1",
+			DeploymentID: dep2,
+			Messages: []model.LogMessage{
+				{Timestamp: t2, Level: "error", Message: "no space left on device"},
+			},
+		},
+	}
+	for _, dl := range logs {
+		assert.NoError(t, store.SaveDeviceDeploymentLog(ctx, dl))
+	}
+
+	type result struct {
+		device     string
+		deployment string
+		messages   int
+	}
+
+	testCases := map[string]struct {
+		query model.DeploymentLogQuery
+
+		results []result
+		err     error
+	}{
+		"text, deployment": {
+			query: model.DeploymentLogQuery{DeploymentID: dep1, Text: "space"},
+			results: []result{
+				{device: "device-1", deployment: dep1, messages: 1},
+			},
+		},
+		"text, time range": {
+			query: model.DeploymentLogQuery{From: t1, Text: "space"},
+			results: []result{
+				{device: "device-1", deployment: dep1, messages: 1},
+				{device: "device-1", deployment: dep2, messages: 1},
+			},
+		},
+		"level and time range": {
+			query: model.DeploymentLogQuery{From: t2, Level: "error"},
+			results: []result{
+				{device: "device-1", deployment: dep2, messages: 1},
+			},
+		},
+		"regex": {
+			query: model.DeploymentLogQuery{DeploymentID: dep1, Regex: "^(checksum|installing)"},
+			results: []result{
+				{device: "device-1", deployment: dep1, messages: 1},
+				{device: "device-2", deployment: dep1, messages: 2},
+			},
+		},
+		"regex, paging": {
+			query: model.DeploymentLogQuery{
+				DeploymentID: dep1,
+				Regex:        "^(checksum|installing)",
+				Skip:         1,
+				Limit:        1,
+			},
+			results: []result{
+				{device: "device-2", deployment: dep1, messages: 2},
+			},
+		},
+		"no match": {
+			query:   model.DeploymentLogQuery{DeploymentID: dep2, Level: "info"},
+			results: []result{},
+		},
+		"error, no scope": {
+			query: model.DeploymentLogQuery{Text: "space"},
+			err:   model.ErrLogSearchNoScope,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			matches, err := store.SearchDeviceDeploymentLogs(ctx, tc.query)
+			if tc.err != nil {
+				assert.EqualError(t, err, tc.err.Error())
+				return
+			}
+			assert.NoError(t, err)
+
+			results := []result{}
+			for _, m := range matches {
+				results = append(results, result{
+					device:     m.DeviceID,
+					deployment: m.DeploymentID,
+					messages:   len(m.Messages),
+				})
+			}
+			assert.Equal(t, tc.results, results)
+		})
+	}
+}
+
+func TestExpiredDeviceDeploymentLogs(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping TestExpiredDeviceDeploymentLogs in short mode.")
+	}
+
+	db.Wipe()
+	session := db.Session()
+	defer session.Close()
+	store := NewDataStoreMongoWithSession(session)
+
+	ctx := context.Background()
+
+	deploymentID := "30b3e62c-9ec2-4312-a7fa-cff24cc7397a"
+	now := time.Now()
+	for _, deviceID := range []string{"old", "new"} {
+		err := store.SaveDeviceDeploymentLog(ctx, model.DeploymentLog{
+			DeviceID:     deviceID,
+			DeploymentID: deploymentID,
+			Messages: []model.LogMessage{
+				{Timestamp: &now, Level: "info", Message: "foo"},
+			},
+		})
+		assert.NoError(t, err)
+	}
+
+	// make one of the logs look uploaded a month ago
+	err := session.DB(ctxstore.DbFromContext(ctx, DatabaseName)).
+		C(CollectionDeviceDeploymentLogs).Update(
+		bson.M{StorageKeyDeviceDeploymentDeviceId: "old"},
+		bson.M{"$set": bson.M{
+			StorageKeyDeviceDeploymentLogUpdated: now.AddDate(0, -1, 0),
+		}})
+	assert.NoError(t, err)
+
+	logs, err := store.FindExpiredDeviceDeploymentLogs(ctx, now.AddDate(0, 0, -7), 10)
+	assert.NoError(t, err)
+	if assert.Len(t, logs, 1) {
+		assert.Equal(t, "old", logs[0].DeviceID)
+	}
+
+	assert.NoError(t, store.DeleteDeviceDeploymentLog(ctx, "old", deploymentID))
+	// removing a missing log is fine
+	assert.NoError(t, store.DeleteDeviceDeploymentLog(ctx, "old", deploymentID))
+	assert.EqualError(t, store.DeleteDeviceDeploymentLog(ctx, "", deploymentID),
+		ErrStorageInvalidID.Error())
+
+	logs, err = store.FindExpiredDeviceDeploymentLogs(ctx, now.AddDate(0, 0, -7), 10)
+	assert.NoError(t, err)
+	assert.Len(t, logs, 0)
+
+	dlog, err := store.GetDeviceDeploymentLog(ctx, "new", deploymentID)
+	assert.NoError(t, err)
+	assert.NotNil(t, dlog)
+}
@@ -336,6 +336,21 @@ func TestUpdateDeviceDeploymentStatus(t *testing.T) {
 					if testCase.InputSubState != nil {
 						assert.Equal(t, *testCase.InputSubState, *deployment.SubState)
 					}
+
+					if assert.NotNil(t, deployment.StatusChanged) {
+						assert.WithinDuration(t, time.Now(),
+							*deployment.StatusChanged, 5*time.Second)
+					}
+
+					// initial pending status and the update are recorded
+					if assert.Len(t, deployment.History, 2) {
+						assert.Equal(t, model.DeviceDeploymentStatusPending,
+							deployment.History[0].Status)
+						assert.Equal(t, testCase.InputStatus,
+							deployment.History[1].Status)
+						assert.Equal(t, testCase.InputSubState,
+							deployment.History[1].SubState)
+					}
 				}
 			}
 		})
@@ -1052,3 +1067,1050 @@ func TestDecommissionDeviceDeployments(t *testing.T) {
 		})
 	}
 }
+
+func TestFindOldestDeploymentForDeviceIDWithStatuses(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping TestFindOldestDeploymentForDeviceIDWithStatuses in short mode.")
+	}
+
+	now := time.Now()
+
+	dds := []struct {
+		did      string
+		depid    string
+		created  time.Time
+		priority int
+		status   string
+	}{
+		{"device0001", "30b3e62c-9ec2-4312-a7fa-cff24cc7397a", now, 0, model.DeviceDeploymentStatusPending},
+		{"device0001", "30b3e62c-9ec2-4312-a7fa-cff24cc7397b", now.Add(time.Minute), 0, model.DeviceDeploymentStatusPending},
+		{"device0002", "30b3e62c-9ec2-4312-a7fa-cff24cc7397a", now, 0, model.DeviceDeploymentStatusPending},
+		{"device0002", "30b3e62c-9ec2-4312-a7fa-cff24cc7397b", now.Add(time.Minute), 10, model.DeviceDeploymentStatusPending},
+		{"device0002", "30b3e62c-9ec2-4312-a7fa-cff24cc7397c", now.Add(2 * time.Minute), 10, model.DeviceDeploymentStatusPending},
+		{"device0003", "30b3e62c-9ec2-4312-a7fa-cff24cc7397a", now, 0, model.DeviceDeploymentStatusPending},
+		{"device0003", "30b3e62c-9ec2-4312-a7fa-cff24cc7397b", now.Add(time.Minute), 10, model.DeviceDeploymentStatusSuccess},
+		{"device0005", "30b3e62c-9ec2-4312-a7fa-cff24cc7397a", now, 0, model.DeviceDeploymentStatusInstalling},
+		{"device0005", "30b3e62c-9ec2-4312-a7fa-cff24cc7397b", now.Add(time.Minute), 10, model.DeviceDeploymentStatusPending},
+	}
+
+	input := []*model.DeviceDeployment{}
+	for _, dd := range dds {
+		newdd, err := model.NewDeviceDeployment(dd.did, dd.depid)
+		assert.NoError(t, err)
+		created := dd.created
+		newdd.Created = &created
+		newdd.DeploymentCreated = &created
+		newdd.Priority = dd.priority
+		status := dd.status
+		newdd.Status = &status
+		input = append(input, newdd)
+	}
+
+	// added to the older deployment after the newer one was created
+	for _, depid := range []string{
+		"30b3e62c-9ec2-4312-a7fa-cff24cc7397a",
+		"30b3e62c-9ec2-4312-a7fa-cff24cc7397b",
+	} {
+		newdd, err := model.NewDeviceDeployment("device0006", depid)
+		assert.NoError(t, err)
+		input = append(input, newdd)
+	}
+	added := now.Add(2 * time.Minute)
+	input[len(input)-2].Created = &added
+	input[len(input)-2].DeploymentCreated = &now
+	created := now.Add(time.Minute)
+	input[len(input)-1].Created = &created
+	input[len(input)-1].DeploymentCreated = &created
+
+	testCases := map[string]struct {
+		deviceID string
+
+		deploymentID string
+		err          error
+	}{
+		"oldest": {
+			deviceID:     "device0001",
+			deploymentID: "30b3e62c-9ec2-4312-a7fa-cff24cc7397a",
+		},
+		"highest priority, oldest": {
+			deviceID:     "device0002",
+			deploymentID: "30b3e62c-9ec2-4312-a7fa-cff24cc7397b",
+		},
+		"finished higher priority": {
+			deviceID:     "device0003",
+			deploymentID: "30b3e62c-9ec2-4312-a7fa-cff24cc7397a",
+		},
+		"in flight, lower priority": {
+			deviceID:     "device0005",
+			deploymentID: "30b3e62c-9ec2-4312-a7fa-cff24cc7397a",
+		},
+		"device added later to older deployment": {
+			deviceID:     "device0006",
+			deploymentID: "30b3e62c-9ec2-4312-a7fa-cff24cc7397a",
+		},
+		"no deployments": {
+			deviceID: "device0004",
+		},
+		"invalid device id": {
+			err: ErrStorageInvalidID,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+
+			db.Wipe()
+
+			session := db.Session()
+			store := NewDataStoreMongoWithSession(session)
+			defer session.Close()
+
+			ctx := context.Background()
+
+			err := store.InsertMany(ctx, input...)
+			assert.NoError(t, err)
+
+			dd, err := store.FindOldestDeploymentForDeviceIDWithStatuses(ctx,
+				tc.deviceID, model.ActiveDeploymentStatuses()...)
+			if tc.err != nil {
+				assert.EqualError(t, err, tc.err.Error())
+			} else {
+				assert.NoError(t, err)
+				if tc.deploymentID == "" {
+					assert.Nil(t, dd)
+				} else {
+					assert.NotNil(t, dd)
+					assert.Equal(t, tc.deploymentID, *dd.DeploymentId)
+				}
+			}
+		})
+	}
+}
+
+func TestFindOldestDeploymentForDeviceIDAfterPriorityBackfill(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping TestFindOldestDeploymentForDeviceIDAfterPriorityBackfill in short mode.")
+	}
+
+	db.Wipe()
+	session := db.Session()
+	defer session.Close()
+	store := NewDataStoreMongoWithSession(session)
+
+	ctx := context.Background()
+	now := time.Now()
+
+	// queued before the upgrade, without a priority
+	err := session.DB(DatabaseName).C(CollectionDevices).Insert(bson.M{
+		"_id":                                  "30b3e62c-9ec2-4312-a7fa-000000000001",
+		StorageKeyDeviceDeploymentDeviceId:     "device0001",
+		StorageKeyDeviceDeploymentDeploymentID: "30b3e62c-9ec2-4312-a7fa-cff24cc7397a",
+		StorageKeyDeviceDeploymentStatus:       model.DeviceDeploymentStatusPending,
+		StorageKeyDeviceDeploymentCreated:      now.Add(-time.Hour),
+	})
+	assert.NoError(t, err)
+
+	// queued after the upgrade, with the default priority
+	dd, err := model.NewDeviceDeployment("device0001", "30b3e62c-9ec2-4312-a7fa-cff24cc7397b")
+	assert.NoError(t, err)
+	dd.Created = &now
+	dd.DeploymentCreated = &now
+	assert.NoError(t, store.InsertMany(ctx, dd))
+
+	assert.NoError(t, store.DoBackfillDeviceDeploymentPriority(DatabaseName, session))
+	assert.NoError(t, store.DoBackfillDeviceDeploymentDeploymentCreated(DatabaseName, session))
+
+	next, err := store.FindOldestDeploymentForDeviceIDWithStatuses(ctx,
+		"device0001", model.DeviceDeploymentStatusPending)
+	assert.NoError(t, err)
+	if assert.NotNil(t, next) {
+		assert.Equal(t, "30b3e62c-9ec2-4312-a7fa-cff24cc7397a", *next.DeploymentId)
+	}
+}
+
+func TestFindStaleDeviceDeployments(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping TestFindStaleDeviceDeployments in short mode.")
+	}
+
+	now := time.Now()
+	deploymentID := "30b3e62c-9ec2-4312-a7fa-cff24cc7397a"
+
+	dds := []struct {
+		did           string
+		depid         string
+		status        string
+		created       time.Time
+		statusChanged *time.Time
+	}{
+		// stale, changed status long ago
+		{"device0001", deploymentID, model.DeviceDeploymentStatusDownloading,
+			now.Add(-3 * time.Hour), pointers.TimeToPointer(now.Add(-2 * time.Hour))},
+		// changed status recently
+		{"device0002", deploymentID, model.DeviceDeploymentStatusDownloading,
+			now.Add(-3 * time.Hour), pointers.TimeToPointer(now.Add(-time.Minute))},
+		// stale, never changed status
+		{"device0003", deploymentID, model.DeviceDeploymentStatusDownloading,
+			now.Add(-3 * time.Hour), nil},
+		// different status
+		{"device0004", deploymentID, model.DeviceDeploymentStatusInstalling,
+			now.Add(-3 * time.Hour), pointers.TimeToPointer(now.Add(-2 * time.Hour))},
+		// different deployment
+		{"device0005", "30b3e62c-9ec2-4312-a7fa-cff24cc7397b", model.DeviceDeploymentStatusDownloading,
+			now.Add(-3 * time.Hour), pointers.TimeToPointer(now.Add(-2 * time.Hour))},
+	}
+
+	input := []*model.DeviceDeployment{}
+	for _, dd := range dds {
+		newdd, err := model.NewDeviceDeployment(dd.did, dd.depid)
+		assert.NoError(t, err)
+		status := dd.status
+		newdd.Status = &status
+		created := dd.created
+		newdd.Created = &created
+		newdd.StatusChanged = dd.statusChanged
+		input = append(input, newdd)
+	}
+
+	testCases := map[string]struct {
+		deploymentID string
+		status       string
+
+		devices []string
+		err     error
+	}{
+		"downloading": {
+			deploymentID: deploymentID,
+			status:       model.DeviceDeploymentStatusDownloading,
+			devices:      []string{"device0001", "device0003"},
+		},
+		"rebooting": {
+			deploymentID: deploymentID,
+			status:       model.DeviceDeploymentStatusRebooting,
+		},
+		"invalid deployment id": {
+			status: model.DeviceDeploymentStatusDownloading,
+			err:    ErrStorageInvalidID,
+		},
+		"invalid status": {
+			deploymentID: deploymentID,
+			err:          ErrStorageInvalidInput,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+
+			db.Wipe()
+
+			session := db.Session()
+			store := NewDataStoreMongoWithSession(session)
+			defer session.Close()
+
+			ctx := context.Background()
+
+			err := store.InsertMany(ctx, input...)
+			assert.NoError(t, err)
+
+			stale, err := store.FindStaleDeviceDeployments(ctx,
+				tc.deploymentID, tc.status, now.Add(-time.Hour))
+			if tc.err != nil {
+				assert.EqualError(t, err, tc.err.Error())
+			} else {
+				assert.NoError(t, err)
+
+				var devices []string
+				for _, dd := range stale {
+					devices = append(devices, *dd.DeviceId)
+				}
+				assert.ElementsMatch(t, tc.devices, devices)
+			}
+		})
+	}
+}
+
+func TestUpdateDeviceDeploymentProgress(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping TestUpdateDeviceDeploymentProgress in short mode.")
+	}
+
+	deploymentID := "30b3e62c-9ec2-4312-a7fa-cff24cc7397a"
+
+	dds := []struct {
+		did    string
+		status string
+	}{
+		{"device0001", model.DeviceDeploymentStatusDownloading},
+		{"device0002", model.DeviceDeploymentStatusSuccess},
+		{"device0003", model.DeviceDeploymentStatusPending},
+	}
+
+	input := []*model.DeviceDeployment{}
+	for _, dd := range dds {
+		newdd, err := model.NewDeviceDeployment(dd.did, deploymentID)
+		assert.NoError(t, err)
+		status := dd.status
+		newdd.Status = &status
+		input = append(input, newdd)
+	}
+
+	updated := time.Now().Round(time.Millisecond).UTC()
+
+	testCases := map[string]struct {
+		deviceID     string
+		deploymentID string
+		progress     model.DownloadProgress
+
+		err error
+	}{
+		"ok": {
+			deviceID:     "device0001",
+			deploymentID: deploymentID,
+			progress: model.DownloadProgress{
+				BytesDownloaded: 10,
+				TotalBytes:      100,
+				Percent:         10,
+				Updated:         &updated,
+			},
+		},
+		"finished": {
+			deviceID:     "device0002",
+			deploymentID: deploymentID,
+			progress: model.DownloadProgress{
+				BytesDownloaded: 10,
+				TotalBytes:      100,
+			},
+			err: ErrStorageNotFound,
+		},
+		"invalid progress": {
+			deviceID:     "device0001",
+			deploymentID: deploymentID,
+			progress: model.DownloadProgress{
+				BytesDownloaded: -1,
+			},
+			err: ErrStorageInvalidInput,
+		},
+		"invalid id": {
+			deviceID: "device0001",
+			err:      ErrStorageInvalidID,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+
+			db.Wipe()
+
+			session := db.Session()
+			store := NewDataStoreMongoWithSession(session)
+			defer session.Close()
+
+			ctx := context.Background()
+
+			err := store.InsertMany(ctx, input...)
+			assert.NoError(t, err)
+
+			err = store.UpdateDeviceDeploymentProgress(ctx,
+				tc.deviceID, tc.deploymentID, tc.progress)
+			if tc.err != nil {
+				assert.EqualError(t, err, tc.err.Error())
+				return
+			}
+			assert.NoError(t, err)
+
+			var progress []model.DeviceDeployment
+			err = session.DB(DatabaseName).C(CollectionDevices).
+				Find(bson.M{
+					StorageKeyDeviceDeploymentDeploymentID: deploymentID,
+					StorageKeyDeviceDeploymentProgress:     bson.M{"$exists": true},
+				}).All(&progress)
+			assert.NoError(t, err)
+			if assert.Len(t, progress, 1) {
+				assert.Equal(t, tc.progress, *progress[0].Progress)
+				assert.Equal(t, model.DeviceDeploymentStatusDownloading,
+					*progress[0].Status)
+			}
+		})
+	}
+}
+
+func TestFindDeviceDeployments(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping TestFindDeviceDeployments in short mode.")
+	}
+
+	now := time.Now()
+
+	dds := []struct {
+		did     string
+		depid   string
+		status  string
+		created time.Time
+	}{
+		{"device0001", "30b3e62c-9ec2-4312-a7fa-cff24cc7397a",
+			model.DeviceDeploymentStatusSuccess, now.Add(-3 * time.Hour)},
+		{"device0001", "30b3e62c-9ec2-4312-a7fa-cff24cc7397b",
+			model.DeviceDeploymentStatusFailure, now.Add(-2 * time.Hour)},
+		{"device0001", "30b3e62c-9ec2-4312-a7fa-cff24cc7397c",
+			model.DeviceDeploymentStatusPending, now.Add(-time.Hour)},
+		{"device0002", "30b3e62c-9ec2-4312-a7fa-cff24cc7397a",
+			model.DeviceDeploymentStatusSuccess, now.Add(-3 * time.Hour)},
+	}
+
+	input := []*model.DeviceDeployment{}
+	for _, dd := range dds {
+		newdd, err := model.NewDeviceDeployment(dd.did, dd.depid)
+		assert.NoError(t, err)
+		status := dd.status
+		newdd.Status = &status
+		created := dd.created
+		newdd.Created = &created
+		input = append(input, newdd)
+	}
+
+	testCases := map[string]struct {
+		query model.DeviceDeploymentsQuery
+
+		deployments []string
+		err         error
+	}{
+		"all, newest first": {
+			query: model.DeviceDeploymentsQuery{DeviceID: "device0001"},
+			deployments: []string{
+				"30b3e62c-9ec2-4312-a7fa-cff24cc7397c",
+				"30b3e62c-9ec2-4312-a7fa-cff24cc7397b",
+				"30b3e62c-9ec2-4312-a7fa-cff24cc7397a",
+			},
+		},
+		"statuses": {
+			query: model.DeviceDeploymentsQuery{
+				DeviceID: "device0001",
+				Statuses: []string{
+					model.DeviceDeploymentStatusSuccess,
+					model.DeviceDeploymentStatusFailure,
+				},
+			},
+			deployments: []string{
+				"30b3e62c-9ec2-4312-a7fa-cff24cc7397b",
+				"30b3e62c-9ec2-4312-a7fa-cff24cc7397a",
+			},
+		},
+		"paging": {
+			query: model.DeviceDeploymentsQuery{
+				DeviceID: "device0001",
+				Skip:     1,
+				Limit:    1,
+			},
+			deployments: []string{
+				"30b3e62c-9ec2-4312-a7fa-cff24cc7397b",
+			},
+		},
+		"unknown device": {
+			query: model.DeviceDeploymentsQuery{DeviceID: "device0003"},
+		},
+		"invalid id": {
+			err: ErrStorageInvalidID,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+
+			db.Wipe()
+
+			session := db.Session()
+			store := NewDataStoreMongoWithSession(session)
+			defer session.Close()
+
+			ctx := context.Background()
+
+			err := store.InsertMany(ctx, input...)
+			assert.NoError(t, err)
+
+			found, err := store.FindDeviceDeployments(ctx, tc.query)
+			if tc.err != nil {
+				assert.EqualError(t, err, tc.err.Error())
+			} else {
+				assert.NoError(t, err)
+
+				var deployments []string
+				for _, dd := range found {
+					deployments = append(deployments, *dd.DeploymentId)
+				}
+				assert.Equal(t, tc.deployments, deployments)
+			}
+		})
+	}
+}
+
+func TestGetDevicesListForDeployment(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping TestGetDevicesListForDeployment in short mode.")
+	}
+
+	deploymentID := "30b3e62c-9ec2-4312-a7fa-cff24cc7397a"
+	now := time.Now()
+
+	dds := []struct {
+		did      string
+		depid    string
+		status   string
+		substate string
+		finished *time.Time
+	}{
+		{"device0001", deploymentID, model.DeviceDeploymentStatusSuccess, "",
+			pointers.TimeToPointer(now.Add(-time.Hour))},
+		{"device0002", deploymentID, model.DeviceDeploymentStatusFailure, "timeout",
+			pointers.TimeToPointer(now.Add(-2 * time.Hour))},
+		{"device0003", deploymentID, model.DeviceDeploymentStatusFailure, "",
+			pointers.TimeToPointer(now.Add(-3 * time.Hour))},
+		{"device0013", deploymentID, model.DeviceDeploymentStatusPending, "", nil},
+		{"device0004", "30b3e62c-9ec2-4312-a7fa-cff24cc7397b",
+			model.DeviceDeploymentStatusPending, "", nil},
+	}
+
+	input := []*model.DeviceDeployment{}
+	for _, dd := range dds {
+		newdd, err := model.NewDeviceDeployment(dd.did, dd.depid)
+		assert.NoError(t, err)
+		status := dd.status
+		newdd.Status = &status
+		if dd.substate != "" {
+			newdd.SubState = pointers.StringToPointer(dd.substate)
+		}
+		newdd.Finished = dd.finished
+		input = append(input, newdd)
+	}
+
+	testCases := map[string]struct {
+		query model.DeploymentDevicesQuery
+
+		devices []string
+		total   int
+		err     error
+	}{
+		"all": {
+			query: model.DeploymentDevicesQuery{DeploymentID: deploymentID},
+			devices: []string{
+				"device0001", "device0002", "device0003", "device0013",
+			},
+			total: 4,
+		},
+		"page, descending": {
+			query: model.DeploymentDevicesQuery{
+				DeploymentID:   deploymentID,
+				SortDescending: true,
+				Skip:           1,
+				Limit:          2,
+			},
+			devices: []string{"device0003", "device0002"},
+			total:   4,
+		},
+		"status, sorted by finished": {
+			query: model.DeploymentDevicesQuery{
+				DeploymentID: deploymentID,
+				Status:       model.DeviceDeploymentStatusFailure,
+				SortBy:       model.DeploymentDevicesSortFinished,
+			},
+			devices: []string{"device0003", "device0002"},
+			total:   2,
+		},
+		"substate": {
+			query: model.DeploymentDevicesQuery{
+				DeploymentID: deploymentID,
+				SubState:     "timeout",
+			},
+			devices: []string{"device0002"},
+			total:   1,
+		},
+		"device id search": {
+			query: model.DeploymentDevicesQuery{
+				DeploymentID: deploymentID,
+				DeviceID:     "001",
+				Limit:        1,
+			},
+			devices: []string{"device0001"},
+			total:   2,
+		},
+		"device id search, special characters": {
+			query: model.DeploymentDevicesQuery{
+				DeploymentID: deploymentID,
+				DeviceID:     ".*",
+			},
+		},
+		"invalid sort key": {
+			query: model.DeploymentDevicesQuery{
+				DeploymentID: deploymentID,
+				SortBy:       "name",
+			},
+			err: ErrStorageInvalidInput,
+		},
+		"invalid id": {
+			err: ErrStorageInvalidID,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+
+			db.Wipe()
+
+			session := db.Session()
+			store := NewDataStoreMongoWithSession(session)
+			defer session.Close()
+
+			ctx := context.Background()
+
+			err := store.InsertMany(ctx, input...)
+			assert.NoError(t, err)
+
+			found, total, err := store.GetDevicesListForDeployment(ctx, tc.query)
+			if tc.err != nil {
+				assert.EqualError(t, err, tc.err.Error())
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.total, total)
+
+				var devices []string
+				for _, dd := range found {
+					devices = append(devices, *dd.DeviceId)
+				}
+				assert.Equal(t, tc.devices, devices)
+			}
+		})
+	}
+}
+
+func TestIterateDeviceDeploymentsForDeployment(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping TestIterateDeviceDeploymentsForDeployment in short mode.")
+	}
+
+	deploymentID := "30b3e62c-9ec2-4312-a7fa-cff24cc7397a"
+
+	dds := []struct {
+		did   string
+		depid string
+	}{
+		{"device0001", deploymentID},
+		{"device0002", deploymentID},
+		{"device0003", deploymentID},
+		{"device0004", "30b3e62c-9ec2-4312-a7fa-cff24cc7397b"},
+	}
+
+	input := []*model.DeviceDeployment{}
+	for _, dd := range dds {
+		newdd, err := model.NewDeviceDeployment(dd.did, dd.depid)
+		assert.NoError(t, err)
+		input = append(input, newdd)
+	}
+	// fields missing in later documents must not leak from earlier ones
+	input[0].SubState = pointers.StringToPointer("foo")
+
+	stop := errors.New("stop")
+
+	testCases := map[string]struct {
+		deploymentID string
+		stopAfter    int
+
+		devices   []string
+		substates []string
+		err       error
+	}{
+		"all": {
+			deploymentID: deploymentID,
+			devices:      []string{"device0001", "device0002", "device0003"},
+			substates:    []string{"foo", "", ""},
+		},
+		"stopped": {
+			deploymentID: deploymentID,
+			stopAfter:    2,
+			devices:      []string{"device0001", "device0002"},
+			substates:    []string{"foo", ""},
+			err:          stop,
+		},
+		"invalid id": {
+			err: ErrStorageInvalidID,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+
+			db.Wipe()
+
+			session := db.Session()
+			store := NewDataStoreMongoWithSession(session)
+			defer session.Close()
+
+			ctx := context.Background()
+
+			err := store.InsertMany(ctx, input...)
+			assert.NoError(t, err)
+
+			var devices, substates []string
+			err = store.IterateDeviceDeploymentsForDeployment(ctx, tc.deploymentID,
+				func(dd *model.DeviceDeployment) error {
+					devices = append(devices, *dd.DeviceId)
+					substate := ""
+					if dd.SubState != nil {
+						substate = *dd.SubState
+					}
+					substates = append(substates, substate)
+
+					if tc.stopAfter > 0 && len(devices) == tc.stopAfter {
+						return stop
+					}
+					return nil
+				})
+			if tc.err != nil {
+				assert.EqualError(t, err, tc.err.Error())
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tc.devices, devices)
+			assert.Equal(t, tc.substates, substates)
+		})
+	}
+}
+
+func TestDeviceDeploymentFailureCategory(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping TestDeviceDeploymentFailureCategory in short mode.")
+	}
+
+	db.Wipe()
+	session := db.Session()
+	defer session.Close()
+	store := NewDataStoreMongoWithSession(session)
+
+	ctx := context.Background()
+
+	deploymentID := "30b3e62c-9ec2-4312-a7fa-cff24cc7397a"
+	categories := map[string]string{
+		"device-1": model.FailureCategoryDiskFull,
+		"device-2": model.FailureCategoryDiskFull,
+		"device-3": "",
+		"device-4": "",
+	}
+	for deviceID, category := range categories {
+		dd, err := model.NewDeviceDeployment(deviceID, deploymentID)
+		assert.NoError(t, err)
+		assert.NoError(t, store.InsertMany(ctx, dd))
+
+		if deviceID == "device-4" {
+			// still in progress
+			continue
+		}
+
+		_, err = store.UpdateDeviceDeploymentStatus(ctx, deviceID, deploymentID,
+			model.DeviceDeploymentStatus{Status: model.DeviceDeploymentStatusFailure})
+		assert.NoError(t, err)
+		if category != "" {
+			err = store.UpdateDeviceDeploymentFailureCategory(ctx,
+				deviceID, deploymentID, category)
+			assert.NoError(t, err)
+		}
+	}
+
+	dd, err := store.FindDeviceDeployment(ctx, deploymentID, "device-1")
+	assert.NoError(t, err)
+	if assert.NotNil(t, dd) {
+		assert.Equal(t, model.FailureCategoryDiskFull, dd.FailureCategory)
+	}
+
+	dd, err = store.FindDeviceDeployment(ctx, deploymentID, "device-5")
+	assert.NoError(t, err)
+	assert.Nil(t, dd)
+
+	failures, err := store.AggregateDeviceDeploymentByFailureCategory(ctx, deploymentID)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]int{
+		model.FailureCategoryDiskFull: 2,
+		model.FailureCategoryOther:    1,
+	}, failures)
+
+	devices, total, err := store.GetDevicesListForDeployment(ctx,
+		model.DeploymentDevicesQuery{
+			DeploymentID:    deploymentID,
+			FailureCategory: model.FailureCategoryDiskFull,
+		})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, total)
+	assert.Len(t, devices, 2)
+
+	err = store.UpdateDeviceDeploymentFailureCategory(ctx,
+		"device-5", deploymentID, model.FailureCategoryOther)
+	assert.EqualError(t, err, ErrStorageNotFound.Error())
+}
+
+func TestAddDeviceDeploymentContinued(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping TestAddDeviceDeploymentContinued in short mode.")
+	}
+
+	db.Wipe()
+	session := db.Session()
+	defer session.Close()
+	store := NewDataStoreMongoWithSession(session)
+
+	ctx := context.Background()
+
+	deploymentID := "30b3e62c-9ec2-4312-a7fa-cff24cc7397a"
+	for _, deviceID := range []string{"device-1", "device-2"} {
+		dd, err := model.NewDeviceDeployment(deviceID, deploymentID)
+		assert.NoError(t, err)
+		assert.NoError(t, store.InsertMany(ctx, dd))
+	}
+
+	err := store.AddDeviceDeploymentContinued(ctx, deploymentID, "device-1",
+		model.PausePointReboot)
+	assert.NoError(t, err)
+	// continuing twice is noop
+	err = store.AddDeviceDeploymentContinued(ctx, deploymentID, "device-1",
+		model.PausePointReboot)
+	assert.NoError(t, err)
+
+	dd, err := store.FindDeviceDeployment(ctx, deploymentID, "device-1")
+	assert.NoError(t, err)
+	if assert.NotNil(t, dd) {
+		assert.Equal(t, []string{model.PausePointReboot}, dd.Continued)
+		assert.True(t, dd.IsContinued(model.PausePointReboot))
+	}
+
+	dd, err = store.FindDeviceDeployment(ctx, deploymentID, "device-2")
+	assert.NoError(t, err)
+	if assert.NotNil(t, dd) {
+		assert.False(t, dd.IsContinued(model.PausePointReboot))
+	}
+
+	err = store.AddDeviceDeploymentContinued(ctx, deploymentID, "device-3",
+		model.PausePointReboot)
+	assert.EqualError(t, err, ErrStorageNotFound.Error())
+
+	err = store.AddDeviceDeploymentContinued(ctx, "", "device-1",
+		model.PausePointReboot)
+	assert.EqualError(t, err, ErrStorageInvalidID.Error())
+}
+
+func TestUpdateDeviceDeploymentStatusFrom(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping TestUpdateDeviceDeploymentStatusFrom in short mode.")
+	}
+
+	db.Wipe()
+	session := db.Session()
+	defer session.Close()
+	store := NewDataStoreMongoWithSession(session)
+
+	ctx := context.Background()
+
+	deploymentID := "30b3e62c-9ec2-4312-a7fa-cff24cc7397a"
+	dd, err := model.NewDeviceDeployment("device-1", deploymentID)
+	assert.NoError(t, err)
+	assert.NoError(t, store.InsertMany(ctx, dd))
+
+	downloading := model.DeviceDeploymentStatus{
+		Status: model.DeviceDeploymentStatusDownloading,
+	}
+
+	updated, err := store.UpdateDeviceDeploymentStatusFrom(ctx, "device-1", deploymentID,
+		model.DeviceDeploymentStatusPending, downloading)
+	assert.NoError(t, err)
+	assert.True(t, updated)
+
+	// the device isn't pending anymore
+	updated, err = store.UpdateDeviceDeploymentStatusFrom(ctx, "device-1", deploymentID,
+		model.DeviceDeploymentStatusPending, downloading)
+	assert.NoError(t, err)
+	assert.False(t, updated)
+
+	dd, err = store.FindDeviceDeployment(ctx, deploymentID, "device-1")
+	assert.NoError(t, err)
+	if assert.NotNil(t, dd) {
+		assert.Equal(t, model.DeviceDeploymentStatusDownloading, *dd.Status)
+		// the initial status and the one it was moved to
+		assert.Len(t, dd.History, 2)
+	}
+
+	updated, err = store.UpdateDeviceDeploymentStatusFrom(ctx, "device-2", deploymentID,
+		model.DeviceDeploymentStatusPending, downloading)
+	assert.NoError(t, err)
+	assert.False(t, updated)
+
+	_, err = store.UpdateDeviceDeploymentStatusFrom(ctx, "", deploymentID,
+		model.DeviceDeploymentStatusPending, downloading)
+	assert.EqualError(t, err, ErrStorageInvalidID.Error())
+}
+
+func TestAggregateDeviceDeploymentStateDurations(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping TestAggregateDeviceDeploymentStateDurations in short mode.")
+	}
+
+	db.Wipe()
+	session := db.Session()
+	defer session.Close()
+	store := NewDataStoreMongoWithSession(session)
+
+	ctx := context.Background()
+
+	deploymentID := "30b3e62c-9ec2-4312-a7fa-cff24cc7397a"
+	start := time.Now().UTC().Round(time.Millisecond)
+	at := func(seconds int) time.Time {
+		return start.Add(time.Duration(seconds) * time.Second)
+	}
+
+	devices := []struct {
+		deviceID     string
+		deploymentID string
+		deviceType   *string
+		history      []model.DeviceDeploymentStatusChange
+	}{
+		{"device-1", deploymentID, pointers.StringToPointer("hammer"), []model.DeviceDeploymentStatusChange{
+			{Status: model.DeviceDeploymentStatusPending, Timestamp: at(0)},
+			{Status: model.DeviceDeploymentStatusDownloading, Timestamp: at(10)},
+			// substate change only
+			{Status: model.DeviceDeploymentStatusDownloading, Timestamp: at(20),
+				SubState: pointers.StringToPointer("retrying")},
+			{Status: model.DeviceDeploymentStatusInstalling, Timestamp: at(40)},
+			{Status: model.DeviceDeploymentStatusRebooting, Timestamp: at(50)},
+			{Status: model.DeviceDeploymentStatusSuccess, Timestamp: at(110)},
+		}},
+		{"device-2", deploymentID, pointers.StringToPointer("drill"), []model.DeviceDeploymentStatusChange{
+			{Status: model.DeviceDeploymentStatusPending, Timestamp: at(0)},
+			{Status: model.DeviceDeploymentStatusDownloading, Timestamp: at(10)},
+			{Status: model.DeviceDeploymentStatusInstalling, Timestamp: at(20)},
+		}},
+		{"device-3", deploymentID, pointers.StringToPointer("hammer"), []model.DeviceDeploymentStatusChange{
+			{Status: model.DeviceDeploymentStatusPending, Timestamp: at(0)},
+			{Status: model.DeviceDeploymentStatusDownloading, Timestamp: at(5)},
+			{Status: model.DeviceDeploymentStatusFailure, Timestamp: at(10)},
+		}},
+		{"device-4", deploymentID, nil, []model.DeviceDeploymentStatusChange{
+			{Status: model.DeviceDeploymentStatusPending, Timestamp: at(0)},
+		}},
+		// other deployment
+		{"device-1", "30b3e62c-9ec2-4312-a7fa-cff24cc7397b", pointers.StringToPointer("hammer"),
+			[]model.DeviceDeploymentStatusChange{
+				{Status: model.DeviceDeploymentStatusDownloading, Timestamp: at(0)},
+				{Status: model.DeviceDeploymentStatusInstalling, Timestamp: at(100)},
+			}},
+	}
+	for _, device := range devices {
+		dd, err := model.NewDeviceDeployment(device.deviceID, device.deploymentID)
+		assert.NoError(t, err)
+		dd.DeviceType = device.deviceType
+		dd.History = device.history
+		assert.NoError(t, store.InsertMany(ctx, dd))
+	}
+
+	groups, err := store.AggregateDeviceDeploymentStateDurations(ctx, deploymentID)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []model.StateDurationGroup{
+		{
+			DeviceType: "hammer",
+			Status:     model.DeviceDeploymentStatusDownloading,
+			Durations:  []float64{5, 30},
+		},
+		{
+			DeviceType: "hammer",
+			Status:     model.DeviceDeploymentStatusInstalling,
+			Durations:  []float64{10},
+		},
+		{
+			DeviceType: "hammer",
+			Status:     model.DeviceDeploymentStatusRebooting,
+			Durations:  []float64{60},
+		},
+		{
+			DeviceType: "drill",
+			Status:     model.DeviceDeploymentStatusDownloading,
+			Durations:  []float64{10},
+		},
+	}, groups)
+
+	groups, err = store.AggregateDeviceDeploymentStateDurations(ctx,
+		"30b3e62c-9ec2-4312-a7fa-cff24cc7397c")
+	assert.NoError(t, err)
+	assert.Empty(t, groups)
+
+	_, err = store.AggregateDeviceDeploymentStateDurations(ctx, "")
+	assert.EqualError(t, err, ErrStorageInvalidID.Error())
+}
+
+func TestAggregateDeviceDeploymentProgress(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping TestAggregateDeviceDeploymentProgress in short mode.")
+	}
+
+	db.Wipe()
+	session := db.Session()
+	defer session.Close()
+	store := NewDataStoreMongoWithSession(session)
+
+	ctx := context.Background()
+
+	deploymentID := "30b3e62c-9ec2-4312-a7fa-cff24cc7397a"
+	now := time.Now().UTC().Round(time.Millisecond)
+	at := func(seconds int) *time.Time {
+		ts := now.Add(time.Duration(seconds) * time.Second)
+		return &ts
+	}
+
+	devices := []struct {
+		deviceID      string
+		deploymentID  string
+		status        string
+		statusChanged *time.Time
+		progress      *model.DownloadProgress
+	}{
+		// 100 bytes in 10s, 400 bytes left, reported 5s ago
+		{"device-1", deploymentID, model.DeviceDeploymentStatusDownloading, at(-15),
+			&model.DownloadProgress{BytesDownloaded: 100, TotalBytes: 500, Updated: at(-5)}},
+		// 300 bytes in 10s, 300 bytes left
+		{"device-2", deploymentID, model.DeviceDeploymentStatusDownloading, at(-10),
+			&model.DownloadProgress{BytesDownloaded: 300, TotalBytes: 600, Updated: at(0)}},
+		{"device-3", deploymentID, model.DeviceDeploymentStatusSuccess, at(-30),
+			&model.DownloadProgress{BytesDownloaded: 500, TotalBytes: 500, Updated: at(-60)}},
+		// no progress reported
+		{"device-4", deploymentID, model.DeviceDeploymentStatusDownloading, at(-10), nil},
+		// other deployment
+		{"device-1", "30b3e62c-9ec2-4312-a7fa-cff24cc7397b",
+			model.DeviceDeploymentStatusDownloading, at(-1000),
+			&model.DownloadProgress{BytesDownloaded: 1, TotalBytes: 1000, Updated: at(0)}},
+	}
+	for _, device := range devices {
+		dd, err := model.NewDeviceDeployment(device.deviceID, device.deploymentID)
+		assert.NoError(t, err)
+		status := device.status
+		dd.Status = &status
+		dd.StatusChanged = device.statusChanged
+		dd.Progress = device.progress
+		assert.NoError(t, store.InsertMany(ctx, dd))
+	}
+
+	progress, err := store.AggregateDeviceDeploymentProgress(ctx, deploymentID, now)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(900), progress.BytesTransferred)
+	assert.Equal(t, int64(1600), progress.BytesTotal)
+	if assert.NotNil(t, progress.ETA) {
+		assert.Equal(t, int64(35), *progress.ETA)
+	}
+
+	// the estimate doesn't go below zero once the time has passed
+	progress, err = store.AggregateDeviceDeploymentProgress(ctx, deploymentID,
+		now.Add(time.Hour))
+	assert.NoError(t, err)
+	if assert.NotNil(t, progress.ETA) {
+		assert.Equal(t, int64(0), *progress.ETA)
+	}
+
+	progress, err = store.AggregateDeviceDeploymentProgress(ctx,
+		"30b3e62c-9ec2-4312-a7fa-cff24cc7397c", now)
+	assert.NoError(t, err)
+	assert.Equal(t, &model.DownloadProgressStats{}, progress)
+
+	_, err = store.AggregateDeviceDeploymentProgress(ctx, "", now)
+	assert.EqualError(t, err, ErrStorageInvalidID.Error())
+}
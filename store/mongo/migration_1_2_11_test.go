@@ -0,0 +1,152 @@
+// Copyright 2019 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package mongo
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/mendersoftware/go-lib-micro/mongo/migrate"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mendersoftware/deployments/model"
+)
+
+func TestMigration_1_2_11(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping TestMigration_1_2_11 in short mode.")
+	}
+
+	testCases := map[string]struct {
+		// ST or MT naming convention
+		db    string
+		dbVer string
+	}{
+		"ST, 1.2.10": {
+			db:    "deployments_service",
+			dbVer: "1.2.10",
+		},
+		"MT, 0.0.0": {
+			db:    "deployments_service-59afdb71c704db002a86ad95",
+			dbVer: "",
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Logf("test case: %s", name)
+
+		db.Wipe()
+		s := db.Session()
+
+		// device deployment queued before deployments had a priority,
+		// followed by one with the default priority
+		err := s.DB(tc.db).C(CollectionDevices).Insert(
+			bson.M{
+				"_id":                                  "dd1",
+				StorageKeyDeviceDeploymentDeviceId:     "device-1",
+				StorageKeyDeviceDeploymentDeploymentID: "d1",
+				StorageKeyDeviceDeploymentStatus:       model.DeviceDeploymentStatusPending,
+				StorageKeyDeviceDeploymentCreated:      time.Now().Add(-time.Hour),
+			},
+			bson.M{
+				"_id":                                  "dd2",
+				StorageKeyDeviceDeploymentDeviceId:     "device-1",
+				StorageKeyDeviceDeploymentDeploymentID: "d2",
+				StorageKeyDeviceDeploymentStatus:       model.DeviceDeploymentStatusPending,
+				StorageKeyDeviceDeploymentCreated:      time.Now(),
+				StorageKeyDeviceDeploymentPriority:     0,
+			},
+		)
+		assert.NoError(t, err)
+
+		// setup existing migrations
+		if tc.dbVer != "" {
+			ver, err := migrate.NewVersion(tc.dbVer)
+			assert.NoError(t, err)
+			migrate.UpdateMigrationInfo(*ver, s, tc.db)
+		}
+
+		migrations := []migrate.Migration{
+			&migration_1_2_1{
+				session: s,
+				db:      tc.db,
+			},
+			&migration_1_2_2{
+				session: s,
+				db:      tc.db,
+			},
+			&migration_1_2_3{
+				session: s,
+				db:      tc.db,
+			},
+			&migration_1_2_4{
+				session: s,
+				db:      tc.db,
+			},
+			&migration_1_2_5{
+				session: s,
+				db:      tc.db,
+			},
+			&migration_1_2_6{
+				session: s,
+				db:      tc.db,
+			},
+			&migration_1_2_7{
+				session: s,
+				db:      tc.db,
+			},
+			&migration_1_2_8{
+				session: s,
+				db:      tc.db,
+			},
+			&migration_1_2_9{
+				session: s,
+				db:      tc.db,
+			},
+			&migration_1_2_10{
+				session: s,
+				db:      tc.db,
+			},
+			&migration_1_2_11{
+				session: s,
+				db:      tc.db,
+			},
+		}
+
+		m := migrate.SimpleMigrator{
+			Session:     s,
+			Db:          tc.db,
+			Automigrate: true,
+		}
+
+		err = m.Apply(context.Background(), migrate.MakeVersion(1, 2, 11), migrations)
+		assert.NoError(t, err)
+
+		// the default priority is set where missing
+		n, err := s.DB(tc.db).C(CollectionDevices).Find(bson.M{
+			StorageKeyDeviceDeploymentPriority: 0,
+		}).Count()
+		assert.NoError(t, err)
+		assert.Equal(t, 2, n)
+
+		// verify new index present
+		idxs, err := s.DB(tc.db).C(CollectionDevices).Indexes()
+		assert.NoError(t, err)
+		assert.True(t, hasIndex(IndexDeploymentDeviceStatusPriorityStr, idxs))
+
+		s.Close()
+	}
+}
@@ -0,0 +1,37 @@
+// Copyright 2019 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package mongo
+
+import (
+	"github.com/globalsign/mgo"
+	"github.com/mendersoftware/go-lib-micro/mongo/migrate"
+)
+
+type migration_1_2_12 struct {
+	session *mgo.Session
+	db      string
+}
+
+// Up records deployments with aborted devices as aborted by the user
+func (m *migration_1_2_12) Up(from migrate.Version) error {
+	s := m.session.Copy()
+	defer s.Close()
+
+	storage := NewDataStoreMongoWithSession(s)
+	return storage.DoBackfillDeploymentAborted(m.db, s)
+}
+
+func (m *migration_1_2_12) Version() migrate.Version {
+	return migrate.MakeVersion(1, 2, 12)
+}
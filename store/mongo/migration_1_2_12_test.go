@@ -0,0 +1,159 @@
+// Copyright 2019 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package mongo
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/mendersoftware/go-lib-micro/mongo/migrate"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mendersoftware/deployments/model"
+)
+
+func TestMigration_1_2_12(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping TestMigration_1_2_12 in short mode.")
+	}
+
+	testCases := map[string]struct {
+		// ST or MT naming convention
+		db    string
+		dbVer string
+	}{
+		"ST, 1.2.11": {
+			db:    "deployments_service",
+			dbVer: "1.2.11",
+		},
+		"MT, 0.0.0": {
+			db:    "deployments_service-59afdb71c704db002a86ad95",
+			dbVer: "",
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Logf("test case: %s", name)
+
+		db.Wipe()
+		s := db.Session()
+
+		// deployments created before user aborts were recorded
+		finished := time.Now()
+		err := s.DB(tc.db).C(CollectionDeployments).Insert(
+			bson.M{
+				"_id": "aborted",
+				StorageKeyDeploymentStats: bson.M{
+					model.DeviceDeploymentStatusAborted: 2,
+					model.DeviceDeploymentStatusSuccess: 1,
+				},
+				StorageKeyDeploymentFinished:       finished,
+				StorageKeyDeploymentFinishedStatus: model.DeploymentStatusSucceeded,
+			},
+			bson.M{
+				"_id": "succeeded",
+				StorageKeyDeploymentStats: bson.M{
+					model.DeviceDeploymentStatusAborted: 0,
+					model.DeviceDeploymentStatusSuccess: 1,
+				},
+				StorageKeyDeploymentFinished:       finished,
+				StorageKeyDeploymentFinishedStatus: model.DeploymentStatusSucceeded,
+			},
+		)
+		assert.NoError(t, err)
+
+		// setup existing migrations
+		if tc.dbVer != "" {
+			ver, err := migrate.NewVersion(tc.dbVer)
+			assert.NoError(t, err)
+			migrate.UpdateMigrationInfo(*ver, s, tc.db)
+		}
+
+		migrations := []migrate.Migration{
+			&migration_1_2_1{
+				session: s,
+				db:      tc.db,
+			},
+			&migration_1_2_2{
+				session: s,
+				db:      tc.db,
+			},
+			&migration_1_2_3{
+				session: s,
+				db:      tc.db,
+			},
+			&migration_1_2_4{
+				session: s,
+				db:      tc.db,
+			},
+			&migration_1_2_5{
+				session: s,
+				db:      tc.db,
+			},
+			&migration_1_2_6{
+				session: s,
+				db:      tc.db,
+			},
+			&migration_1_2_7{
+				session: s,
+				db:      tc.db,
+			},
+			&migration_1_2_8{
+				session: s,
+				db:      tc.db,
+			},
+			&migration_1_2_9{
+				session: s,
+				db:      tc.db,
+			},
+			&migration_1_2_10{
+				session: s,
+				db:      tc.db,
+			},
+			&migration_1_2_11{
+				session: s,
+				db:      tc.db,
+			},
+			&migration_1_2_12{
+				session: s,
+				db:      tc.db,
+			},
+		}
+
+		m := migrate.SimpleMigrator{
+			Session:     s,
+			Db:          tc.db,
+			Automigrate: true,
+		}
+
+		err = m.Apply(context.Background(), migrate.MakeVersion(1, 2, 12), migrations)
+		assert.NoError(t, err)
+
+		var deployment model.Deployment
+		err = s.DB(tc.db).C(CollectionDeployments).FindId("aborted").One(&deployment)
+		assert.NoError(t, err)
+		assert.True(t, deployment.Aborted)
+		assert.Equal(t, model.DeploymentStatusAborted, deployment.FinishedStatus)
+
+		deployment = model.Deployment{}
+		err = s.DB(tc.db).C(CollectionDeployments).FindId("succeeded").One(&deployment)
+		assert.NoError(t, err)
+		assert.False(t, deployment.Aborted)
+		assert.Equal(t, model.DeploymentStatusSucceeded, deployment.FinishedStatus)
+
+		s.Close()
+	}
+}
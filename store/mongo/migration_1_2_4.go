@@ -0,0 +1,42 @@
+// Copyright 2019 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package mongo
+
+import (
+	"github.com/globalsign/mgo"
+	"github.com/mendersoftware/go-lib-micro/mongo/migrate"
+)
+
+type migration_1_2_4 struct {
+	session *mgo.Session
+	db      string
+}
+
+// Up stores the device count of existing deployments and creates
+// the index for sorting deployments by name
+func (m *migration_1_2_4) Up(from migrate.Version) error {
+	s := m.session.Copy()
+	defer s.Close()
+
+	storage := NewDataStoreMongoWithSession(s)
+	if err := storage.DoBackfillDeploymentDeviceCount(m.db, s); err != nil {
+		return err
+	}
+
+	return storage.DoEnsureDeploymentSortIndexing(m.db, s)
+}
+
+func (m *migration_1_2_4) Version() migrate.Version {
+	return migrate.MakeVersion(1, 2, 4)
+}
@@ -0,0 +1,141 @@
+// Copyright 2019 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package mongo
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/mendersoftware/go-lib-micro/mongo/migrate"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMigration_1_2_8(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping TestMigration_1_2_8 in short mode.")
+	}
+
+	testCases := map[string]struct {
+		// ST or MT naming convention
+		db    string
+		dbVer string
+	}{
+		"ST, 1.2.7": {
+			db:    "deployments_service",
+			dbVer: "1.2.7",
+		},
+		"MT, 0.0.0": {
+			db:    "deployments_service-59afdb71c704db002a86ad95",
+			dbVer: "",
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Logf("test case: %s", name)
+
+		db.Wipe()
+		s := db.Session()
+
+		// logs stored before the upload time was tracked
+		created := time.Now().Add(-48 * time.Hour)
+		oldID := bson.NewObjectIdWithTime(created)
+		newID := bson.NewObjectId()
+		updated := time.Now().Add(-time.Hour).Round(time.Millisecond)
+		err := s.DB(tc.db).C(CollectionDeviceDeploymentLogs).Insert(
+			bson.M{
+				"_id":          oldID,
+				"deviceid":     "device-1",
+				"deploymentid": "d1",
+			},
+			bson.M{
+				"_id":          newID,
+				"deviceid":     "device-2",
+				"deploymentid": "d1",
+				"updated":      updated,
+			},
+		)
+		assert.NoError(t, err)
+
+		// setup existing migrations
+		if tc.dbVer != "" {
+			ver, err := migrate.NewVersion(tc.dbVer)
+			assert.NoError(t, err)
+			migrate.UpdateMigrationInfo(*ver, s, tc.db)
+		}
+
+		migrations := []migrate.Migration{
+			&migration_1_2_1{
+				session: s,
+				db:      tc.db,
+			},
+			&migration_1_2_2{
+				session: s,
+				db:      tc.db,
+			},
+			&migration_1_2_3{
+				session: s,
+				db:      tc.db,
+			},
+			&migration_1_2_4{
+				session: s,
+				db:      tc.db,
+			},
+			&migration_1_2_5{
+				session: s,
+				db:      tc.db,
+			},
+			&migration_1_2_6{
+				session: s,
+				db:      tc.db,
+			},
+			&migration_1_2_7{
+				session: s,
+				db:      tc.db,
+			},
+			&migration_1_2_8{
+				session: s,
+				db:      tc.db,
+			},
+		}
+
+		m := migrate.SimpleMigrator{
+			Session:     s,
+			Db:          tc.db,
+			Automigrate: true,
+		}
+
+		err = m.Apply(context.Background(), migrate.MakeVersion(1, 2, 8), migrations)
+		assert.NoError(t, err)
+
+		// verify new index present
+		idxs, err := s.DB(tc.db).C(CollectionDeviceDeploymentLogs).Indexes()
+		assert.NoError(t, err)
+		assert.True(t, hasIndex(IndexDeviceDeploymentLogsUpdatedStr, idxs))
+
+		// verify upload time set only where missing
+		var oldLog bson.M
+		err = s.DB(tc.db).C(CollectionDeviceDeploymentLogs).FindId(oldID).One(&oldLog)
+		assert.NoError(t, err)
+		assert.WithinDuration(t, created, oldLog[StorageKeyDeviceDeploymentLogUpdated].(time.Time), time.Second)
+
+		var newLog bson.M
+		err = s.DB(tc.db).C(CollectionDeviceDeploymentLogs).FindId(newID).One(&newLog)
+		assert.NoError(t, err)
+		assert.WithinDuration(t, updated, newLog[StorageKeyDeviceDeploymentLogUpdated].(time.Time), time.Millisecond)
+
+		s.Close()
+	}
+}
@@ -24,7 +24,7 @@ import (
 )
 
 const (
-	DbVersion = "1.2.2"
+	DbVersion = "1.2.14"
 	DbName    = "deployment_service"
 )
 
@@ -89,6 +89,54 @@ func MigrateSingle(ctx context.Context,
 			session: session,
 			db:      db,
 		},
+		&migration_1_2_3{
+			session: session,
+			db:      db,
+		},
+		&migration_1_2_4{
+			session: session,
+			db:      db,
+		},
+		&migration_1_2_5{
+			session: session,
+			db:      db,
+		},
+		&migration_1_2_6{
+			session: session,
+			db:      db,
+		},
+		&migration_1_2_7{
+			session: session,
+			db:      db,
+		},
+		&migration_1_2_8{
+			session: session,
+			db:      db,
+		},
+		&migration_1_2_9{
+			session: session,
+			db:      db,
+		},
+		&migration_1_2_10{
+			session: session,
+			db:      db,
+		},
+		&migration_1_2_11{
+			session: session,
+			db:      db,
+		},
+		&migration_1_2_12{
+			session: session,
+			db:      db,
+		},
+		&migration_1_2_13{
+			session: session,
+			db:      db,
+		},
+		&migration_1_2_14{
+			session: session,
+			db:      db,
+		},
 	}
 
 	err = m.Apply(ctx, *ver, migrations)
@@ -23,3 +23,7 @@ func StringToPointer(str string) *string {
 func TimeToPointer(time time.Time) *time.Time {
 	return &time
 }
+
+func Float64ToPointer(f float64) *float64 {
+	return &f
+}
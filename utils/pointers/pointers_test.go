@@ -30,3 +30,8 @@ func TestTimeToPointer(t *testing.T) {
 	expected := time.Now()
 	assert.Equal(t, &expected, TimeToPointer(expected))
 }
+
+func TestFloat64ToPointer(t *testing.T) {
+	expected := 0.5
+	assert.Equal(t, &expected, Float64ToPointer(expected))
+}
@@ -15,6 +15,7 @@
 package view
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
@@ -91,17 +92,41 @@ func (p *RESTView) RenderEmptySuccessResponse(w rest.ResponseWriter) {
 	w.WriteHeader(http.StatusNoContent)
 }
 
-func (p *RESTView) RenderDeploymentLog(w rest.ResponseWriter, dlog model.DeploymentLog) {
+var deploymentLogContentTypes = map[string]string{
+	model.DeploymentLogFormatText:  "text/plain",
+	model.DeploymentLogFormatJSON:  "application/json",
+	model.DeploymentLogFormatJSONL: "application/x-ndjson",
+}
+
+// RenderDeploymentLog writes the log in one of model.DeploymentLogFormat*,
+// plain text if the format is unknown.
+func (p *RESTView) RenderDeploymentLog(w rest.ResponseWriter, dlog model.DeploymentLog,
+	format string) {
+
 	h, _ := w.(http.ResponseWriter)
 
-	h.Header().Set("Content-Type", "text/plain")
+	if _, ok := deploymentLogContentTypes[format]; !ok {
+		format = model.DeploymentLogFormatText
+	}
+
+	h.Header().Set("Content-Type", deploymentLogContentTypes[format])
 	h.WriteHeader(http.StatusOK)
 
-	for _, m := range dlog.Messages {
-		as := m.String()
-		h.Write([]byte(as))
-		if !strings.HasSuffix(as, "\n") {
-			h.Write([]byte("\n"))
+	switch format {
+	case model.DeploymentLogFormatJSON:
+		json.NewEncoder(h).Encode(dlog)
+	case model.DeploymentLogFormatJSONL:
+		enc := json.NewEncoder(h)
+		for _, m := range dlog.Messages {
+			enc.Encode(m)
+		}
+	default:
+		for _, m := range dlog.Messages {
+			as := m.String()
+			h.Write([]byte(as))
+			if !strings.HasSuffix(as, "\n") {
+				h.Write([]byte("\n"))
+			}
 		}
 	}
 }
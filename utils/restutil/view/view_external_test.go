@@ -16,6 +16,7 @@ package view
 
 import (
 	"net/http"
+	"strings"
 	"testing"
 	"time"
 
@@ -184,39 +185,75 @@ func TestRenderDeploymentLog(t *testing.T) {
 		},
 	}
 
-	tcs := []struct {
-		Log  model.DeploymentLog
-		Body string
-	}{
-		{
-			// all correct
-			Log: model.DeploymentLog{
-				DeploymentID: "f826484e-1157-4109-af21-304e6d711560",
-				DeviceID:     "device-id-1",
-				Messages:     messages,
-			},
-			Body: `2006-01-02 22:04:05 +0000 UTC notice: foo
+	dlog := model.DeploymentLog{
+		DeploymentID: "f826484e-1157-4109-af21-304e6d711560",
+		DeviceID:     "device-id-1",
+		Messages:     messages,
+	}
+
+	textBody := `2006-01-02 22:04:05 +0000 UTC notice: foo
 2006-01-02 22:04:05 +0000 UTC debug: zed zed zed
 2006-01-02 22:04:05 +0000 UTC info: bar bar bar
-`,
+`
+	jsonlBody := `{"timestamp":"2006-01-02T15:04:05-07:00","level":"notice","message":"foo"}
+{"timestamp":"2006-01-02T15:04:05-07:00","level":"debug","message":"zed zed zed"}
+{"timestamp":"2006-01-02T15:04:05-07:00","level":"info","message":"bar bar bar"}
+`
+
+	tcs := map[string]struct {
+		Log    model.DeploymentLog
+		Format string
+
+		ContentType string
+		Body        string
+	}{
+		"text": {
+			Log:         dlog,
+			Format:      model.DeploymentLogFormatText,
+			ContentType: "text/plain",
+			Body:        textBody,
+		},
+		"unknown format": {
+			Log:         dlog,
+			Format:      "yaml",
+			ContentType: "text/plain",
+			Body:        textBody,
 		},
+		"json": {
+			Log:         dlog,
+			Format:      model.DeploymentLogFormatJSON,
+			ContentType: "application/json",
+			Body: `{"messages":[` +
+				strings.Replace(strings.TrimSpace(jsonlBody), "\n", ",", -1) + "]}\n",
+		},
+		"jsonl": {
+			Log:         dlog,
+			Format:      model.DeploymentLogFormatJSONL,
+			ContentType: "application/x-ndjson",
+			Body:        jsonlBody,
+		},
+
 	}
 
-	for _, tc := range tcs {
-		router, err := rest.MakeRouter(rest.Get("/test", func(w rest.ResponseWriter, r *rest.Request) {
-			view := &RESTView{}
-			view.RenderDeploymentLog(w, tc.Log)
-		}))
+	for name, tc := range tcs {
+		t.Run(name, func(t *testing.T) {
+			router, err := rest.MakeRouter(rest.Get("/test", func(w rest.ResponseWriter, r *rest.Request) {
+				view := &RESTView{}
+				view.RenderDeploymentLog(w, tc.Log, tc.Format)
+			}))
 
-		assert.NoError(t, err)
+			assert.NoError(t, err)
+
+			api := rest.NewApi()
+			api.SetApp(router)
 
-		api := rest.NewApi()
-		api.SetApp(router)
+			recorded := test.RunRequest(t, api.MakeHandler(),
+				test.MakeSimpleRequest("GET", "http://localhost/test", nil))
 
-		recorded := test.RunRequest(t, api.MakeHandler(),
-			test.MakeSimpleRequest("GET", "http://localhost/test", nil))
+			recorded.CodeIs(http.StatusOK)
+			assert.Equal(t, tc.ContentType, recorded.Recorder.HeaderMap.Get("Content-Type"))
 
-		recorded.CodeIs(http.StatusOK)
-		assert.Equal(t, tc.Body, recorded.Recorder.Body.String())
+			assert.Equal(t, tc.Body, recorded.Recorder.Body.String())
+		})
 	}
 }